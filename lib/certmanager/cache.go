@@ -0,0 +1,142 @@
+package certmanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// CredentialCache keeps loaded SigningCredentials per key, e.g. the config key
+// of a certificate. Credentials are reloaded when one of the files they were
+// loaded from changes, so a rotated certificate is picked up without a
+// restart. The freshness check is a stat of each file.
+type CredentialCache struct {
+	// MaxAge reloads credentials older than this, zero keeps them until a
+	// file changes. Use it with remote providers whose certificate is fetched.
+	MaxAge time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*credentialCacheEntry
+}
+
+type credentialCacheEntry struct {
+	mu          sync.Mutex
+	config      CertificateConfig
+	credentials *SigningCredentials
+	files       map[string]fileStamp
+	loadedAt    time.Time
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewCredentialCache() *CredentialCache {
+	return &CredentialCache{
+		entries: map[string]*credentialCacheEntry{},
+	}
+}
+
+// Get returns the credentials cached under key, loading them with
+// LoadSigningCredentials when missing, when certConfig differs from the config
+// they were loaded with, or when their files changed. If a reload fails the
+// previous credentials are kept while they are still valid, files replaced
+// one by one during a rotation may not match for a moment.
+func (c *CredentialCache) Get(ctx context.Context, key string, certConfig *CertificateConfig) (*SigningCredentials, error) {
+	if certConfig == nil {
+		return nil, fmt.Errorf("certificate config is required")
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &credentialCacheEntry{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	sameConfig := entry.credentials != nil && reflect.DeepEqual(entry.config, *certConfig)
+	if sameConfig && !c.stale(entry) {
+		return entry.credentials, nil
+	}
+
+	files := configFileStamps(certConfig)
+	credentials, err := LoadSigningCredentials(ctx, certConfig)
+	if err != nil {
+		if sameConfig && time.Now().Before(entry.credentials.Certificate.NotAfter) {
+			fmt.Printf("failed to reload credentials %s, using the previous ones: %v\n", key, err)
+			return entry.credentials, nil
+		}
+		return nil, err
+	}
+
+	if entry.credentials != nil {
+		fmt.Printf("reloaded credentials %s, certificate serial %X\n", key, credentials.Certificate.SerialNumber)
+	}
+
+	entry.config = *certConfig
+	entry.credentials = credentials
+	entry.files = files
+	entry.loadedAt = time.Now()
+
+	return credentials, nil
+}
+
+// Invalidate drops the credentials cached under key, the next Get loads them again.
+func (c *CredentialCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *CredentialCache) stale(entry *credentialCacheEntry) bool {
+	if c.MaxAge > 0 && time.Since(entry.loadedAt) > c.MaxAge {
+		return true
+	}
+
+	for path, stamp := range entry.files {
+		if statFile(path) != stamp {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configFileStamps stats every file certConfig loads credentials from. The
+// stamps are taken before loading so a change during the load is seen next time.
+func configFileStamps(certConfig *CertificateConfig) map[string]fileStamp {
+	paths := []string{certConfig.CertFilePath, certConfig.KeyFilePath, certConfig.PKCS12FilePath}
+	if certConfig.Remote != nil {
+		paths = append(paths, certConfig.Remote.CertFilePath)
+	}
+	paths = append(paths, certConfig.ChainFilePaths...)
+	paths = append(paths, certConfig.RootFilePaths...)
+
+	files := map[string]fileStamp{}
+	for _, path := range paths {
+		if path != "" {
+			files[path] = statFile(path)
+		}
+	}
+
+	return files
+}
+
+// statFile follows symlinks, so a mounted secret swapping its data directory counts as a change
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
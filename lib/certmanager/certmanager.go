@@ -1,7 +1,6 @@
 package certmanager
 
 import (
-	"bytes"
 	"context"
 	"crypto"
 	"crypto/ecdsa"
@@ -10,56 +9,93 @@ import (
 	"encoding/pem"
 	"fmt"
 	"os"
-	"os/exec"
+	"time"
 )
 
 // SigningCredentials holds the certificate and private key for PDF signing
 type SigningCredentials struct {
 	Certificate *x509.Certificate
 	PrivateKey  crypto.Signer
+	// CertificateChain starts with Certificate, followed by its issuers up to
+	// the root once loaded through LoadSigningCredentials
+	CertificateChain []*x509.Certificate
 }
 type CertificateConfig struct {
+	// ProviderType selects where the key lives, one of the KeyProviderType
+	// constants. Empty means PEM files.
+	ProviderType string
+
 	CertFilePath string
 	KeyFilePath  string
 	KeyPassword  string
+
+	// PKCS12FilePath and PKCS12Password configure a .p12/.pfx bundle
+	PKCS12FilePath string
+	PKCS12Password string
+
+	// Remote configures a signing service holding the private key
+	Remote *RemoteSignerConfig
+
+	// ChainFilePaths are PEM files with intermediate certificates. They can
+	// also be bundled after the signing certificate in CertFilePath.
+	ChainFilePaths []string
+	// RootFilePaths are PEM files with the trusted roots the chain must
+	// validate against. Without them the chain is not checked for trust.
+	RootFilePaths []string
+	// ExpiryWarning is how long before a certificate expires a warning is
+	// logged, 30 days when zero
+	ExpiryWarning time.Duration
 }
 
-// LoadSigningCredentials loads certificate and private key from configured paths
+// LoadSigningCredentials loads the certificate and signer through the key
+// provider selected by certConfig.ProviderType, checks that the key belongs
+// to the certificate, the certificate's validity and key usage, and builds
+// its chain.
 func LoadSigningCredentials(ctx context.Context, certConfig *CertificateConfig) (*SigningCredentials, error) {
-
-	cert, err := getCertificate(certConfig.CertFilePath)
+	provider, err := KeyProviderFactory(certConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get certificate: %v", err)
+		return nil, err
 	}
 
-	privateKey, err := getKey(certConfig.KeyFilePath, certConfig.KeyPassword)
+	credentials, err := provider.Credentials(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get private key: %v", err)
+		return nil, err
 	}
 
-	return &SigningCredentials{
-		Certificate: cert,
-		PrivateKey:  privateKey,
-	}, nil
-}
+	publicKey, ok := credentials.PrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(credentials.Certificate.PublicKey) {
+		return nil, fmt.Errorf("private key does not match certificate %q", credentials.Certificate.Subject.CommonName)
+	}
+
+	if err := buildCertificateChain(credentials, certConfig); err != nil {
+		return nil, err
+	}
 
-func getCertificate(certPath string) (*x509.Certificate, error) {
+	return credentials, nil
+}
 
-	certBytes, err := os.ReadFile(certPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read certificate file: %v", err)
+// loadPEMCredentials loads certificate and private key from configured paths.
+// A PKCS#12 bundle configured as key file provides the certificate as well.
+func loadPEMCredentials(certConfig *CertificateConfig) (*SigningCredentials, error) {
+	if keyBytes, err := os.ReadFile(certConfig.KeyFilePath); err == nil && isPKCS12(keyBytes) {
+		return decodePKCS12(keyBytes, certConfig.KeyPassword)
 	}
 
-	certBlock, _ := pem.Decode(certBytes)
-	if certBlock == nil {
-		return nil, fmt.Errorf("failed to decode certificate PEM")
+	certs, err := readCertificates(certConfig.CertFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate: %v", err)
 	}
 
-	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	privateKey, err := getKey(certConfig.KeyFilePath, certConfig.KeyPassword)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		return nil, fmt.Errorf("failed to get private key: %v", err)
 	}
-	return cert, nil
+
+	return &SigningCredentials{
+		Certificate:      certs[0],
+		PrivateKey:       privateKey,
+		CertificateChain: certs,
+	}, nil
 }
 
 func getKey(keyPath, password string) (crypto.Signer, error) {
@@ -77,99 +113,62 @@ func getKey(keyPath, password string) (crypto.Signer, error) {
 	return privateKey, nil
 }
 
+// loadPrivateKey parses a PEM private key: PKCS#8 (optionally PBES2 encrypted),
+// or legacy PKCS#1 "RSA PRIVATE KEY" and SEC 1 "EC PRIVATE KEY" blocks
+// (optionally encrypted with Proc-Type/DEK-Info headers).
 func loadPrivateKey(keyBytes []byte, password string) (crypto.Signer, error) {
-	block, _ := pem.Decode(keyBytes)
+	block, rest := pem.Decode(keyBytes)
+	// openssl ecparam -genkey writes the curve parameters before the key
+	for block != nil && block.Type == "EC PARAMETERS" {
+		block, rest = pem.Decode(rest)
+	}
 	if block == nil {
 		return nil, fmt.Errorf("failed to decode PEM block")
 	}
 
-	if block.Type == "ENCRYPTED PRIVATE KEY" {
-		// For PKCS#8 encrypted keys
-		key, err := parseEncryptedPKCS8PrivateKey(block.Bytes, []byte(password))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse encrypted PKCS#8 private key: %w", err)
-		}
-
-		switch k := key.(type) {
-		case *rsa.PrivateKey:
-			return k, nil
-		case *ecdsa.PrivateKey:
-			return k, nil
-		default:
-			return nil, fmt.Errorf("unsupported private key type")
+	der := block.Bytes
+	// Legacy PEM encryption is deprecated but still what openssl genrsa -aes256 writes
+	if x509.IsEncryptedPEMBlock(block) { //nolint:staticcheck // legacy encrypted PEM keys are supported on purpose
+		if password == "" {
+			return nil, fmt.Errorf("private key is encrypted but no password is configured")
 		}
-	} else if block.Type == "PRIVATE KEY" {
-		// For unencrypted PKCS#8 keys
-		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		var err error
+		der, err = x509.DecryptPEMBlock(block, []byte(password)) //nolint:staticcheck // legacy encrypted PEM keys are supported on purpose
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse PKCS#8 private key: %w", err)
+			return nil, fmt.Errorf("failed to decrypt %s: %w", block.Type, err)
 		}
+	}
 
-		switch k := key.(type) {
-		case *rsa.PrivateKey:
-			return k, nil
-		case *ecdsa.PrivateKey:
-			return k, nil
-		default:
-			return nil, fmt.Errorf("unsupported private key type")
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		// For PKCS#8 encrypted keys
+		der, err = decryptPKCS8PrivateKey(der, []byte(password))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt PKCS#8 private key: %w", err)
 		}
-	} else {
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	case "PRIVATE KEY":
+		// For unencrypted PKCS#8 keys
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+	default:
 		return nil, fmt.Errorf("unsupported private key format: %s", block.Type)
 	}
-}
-
-// Helper function to parse PKCS#8 encrypted private keys
-func parseEncryptedPKCS8PrivateKey(data, password []byte) (interface{}, error) {
-	var privKey interface{}
-
-	// First try direct parsing (for some implementations)
-	privKey, err := x509.ParsePKCS8PrivateKey(data)
-	if err == nil {
-		return privKey, nil
-	}
-
-	tmpFile, err := os.CreateTemp("", "encrypted_key_*.pem")
-	if err != nil {
-		return nil, err
-	}
-	tmpName := tmpFile.Name()
-	defer os.Remove(tmpName)
-
-	err = os.WriteFile(tmpName, pem.EncodeToMemory(&pem.Block{
-		Type:  "ENCRYPTED PRIVATE KEY",
-		Bytes: data,
-	}), 0600)
-	if err != nil {
-		return nil, err
-	}
-
-	// Create another temp file for the decrypted key
-	decryptedTmpFile, err := os.CreateTemp("", "decrypted_key_*.pem")
-	if err != nil {
-		return nil, err
-	}
-	decryptedName := decryptedTmpFile.Name()
-	defer os.Remove(decryptedName)
-
-	// Use OpenSSL to decrypt the key
-	cmd := exec.Command("openssl", "pkcs8", "-in", tmpName, "-out", decryptedName, "-passin", "pass:"+string(password))
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("OpenSSL error: %v, %s", err, stderr.String())
-	}
-
-	// Read the decrypted key
-	decryptedData, err := os.ReadFile(decryptedName)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to parse %s: %w", block.Type, err)
 	}
 
-	decryptedBlock, _ := pem.Decode(decryptedData)
-	if decryptedBlock == nil {
-		return nil, fmt.Errorf("failed to decode decrypted PEM block")
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type")
 	}
-
-	return x509.ParsePKCS8PrivateKey(decryptedBlock.Bytes)
 }
@@ -0,0 +1,202 @@
+package certmanager
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultExpiryWarning is how long before expiry LoadSigningCredentials starts warning
+const defaultExpiryWarning = 30 * 24 * time.Hour
+
+// Extended key usages accepted for document signing besides codeSigning and emailProtection
+var documentSigningEKUs = []asn1.ObjectIdentifier{
+	{1, 3, 6, 1, 5, 5, 7, 3, 36},          // id-kp-documentSigning (RFC 9336)
+	{1, 2, 840, 113583, 1, 1, 5},          // Adobe Authentic Documents Trust
+	{1, 3, 6, 1, 4, 1, 311, 10, 3, 12},    // Microsoft Document Signing
+	{1, 3, 6, 1, 4, 1, 311, 10, 3, 12, 1}, // Microsoft Lifetime Signing
+}
+
+// buildCertificateChain replaces credentials.CertificateChain, which holds the
+// signing certificate followed by any certificates that came with it, with the
+// ordered path from the signing certificate to its root. Certificates from
+// ChainFilePaths are added as intermediates. With RootFilePaths configured the
+// path has to verify against those roots, otherwise it is built from the
+// available certificates as far as they go.
+func buildCertificateChain(credentials *SigningCredentials, certConfig *CertificateConfig) error {
+	leaf := credentials.Certificate
+	now := time.Now()
+
+	if err := checkSigningCertificate(leaf, now); err != nil {
+		return err
+	}
+
+	candidates := credentials.CertificateChain
+	for _, path := range certConfig.ChainFilePaths {
+		certs, err := readCertificates(path)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate chain: %v", err)
+		}
+		candidates = append(candidates, certs...)
+	}
+
+	var chain []*x509.Certificate
+	if len(certConfig.RootFilePaths) > 0 {
+		roots := x509.NewCertPool()
+		for _, path := range certConfig.RootFilePaths {
+			certs, err := readCertificates(path)
+			if err != nil {
+				return fmt.Errorf("failed to load root certificates: %v", err)
+			}
+			for _, cert := range certs {
+				roots.AddCert(cert)
+			}
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range candidates {
+			intermediates.AddCert(cert)
+		}
+
+		chains, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			// the signing certificate's usage is checked above, x509 only knows TLS style usages
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("certificate chain validation failed: %v", err)
+		}
+
+		chain = chains[0]
+		for _, c := range chains[1:] {
+			if len(c) < len(chain) {
+				chain = c
+			}
+		}
+	} else {
+		chain = orderChain(leaf, candidates)
+		for _, cert := range chain[1:] {
+			if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+				return fmt.Errorf("chain certificate %q is expired or not yet valid (valid from %s to %s)", cert.Subject.CommonName, cert.NotBefore, cert.NotAfter)
+			}
+		}
+	}
+
+	warning := certConfig.ExpiryWarning
+	if warning == 0 {
+		warning = defaultExpiryWarning
+	}
+	for _, cert := range chain {
+		if cert.NotAfter.Sub(now) < warning {
+			fmt.Printf("warning: certificate %q expires at %s\n", cert.Subject.CommonName, cert.NotAfter)
+		}
+	}
+
+	credentials.CertificateChain = chain
+	return nil
+}
+
+// checkSigningCertificate checks the validity period and that the key usage
+// allows signing documents.
+func checkSigningCertificate(cert *x509.Certificate, now time.Time) error {
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("certificate %q is not valid before %s", cert.Subject.CommonName, cert.NotBefore)
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("certificate %q expired at %s", cert.Subject.CommonName, cert.NotAfter)
+	}
+
+	if cert.KeyUsage != 0 && cert.KeyUsage&(x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment) == 0 {
+		return fmt.Errorf("certificate %q key usage allows neither digitalSignature nor nonRepudiation", cert.Subject.CommonName)
+	}
+
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return nil
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageAny, x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageEmailProtection:
+			return nil
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		for _, documentSigning := range documentSigningEKUs {
+			if oid.Equal(documentSigning) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("certificate %q extended key usage does not allow document signing", cert.Subject.CommonName)
+}
+
+// orderChain follows the issuers of leaf through candidates, stopping at a
+// self-signed certificate or when the next issuer is not available.
+func orderChain(leaf *x509.Certificate, candidates []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{leaf}
+	used := map[*x509.Certificate]bool{leaf: true}
+
+	for current := leaf; ; {
+		if current.CheckSignatureFrom(current) == nil {
+			break
+		}
+
+		var issuer *x509.Certificate
+		for _, candidate := range candidates {
+			if !used[candidate] && !candidate.Equal(current) && current.CheckSignatureFrom(candidate) == nil {
+				issuer = candidate
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+
+		used[issuer] = true
+		chain = append(chain, issuer)
+		current = issuer
+	}
+
+	return chain
+}
+
+// readCertificates reads all certificates of a PEM file, e.g. a bundle with
+// the signing certificate followed by its intermediates.
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %v", err)
+	}
+
+	return parseCertificates(data)
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("failed to decode certificate PEM")
+	}
+	return certs, nil
+}
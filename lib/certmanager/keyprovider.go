@@ -0,0 +1,99 @@
+package certmanager
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	KeyProviderTypePEM    = "pem"
+	KeyProviderTypePKCS12 = "pkcs12"
+	KeyProviderTypeRemote = "remote"
+)
+
+// KeyProvider supplies the signing certificate together with a crypto.Signer
+// for its private key. The signer does not have to hold the key in memory,
+// it may forward digests to an HSM, a KMS or a remote signing service.
+type KeyProvider interface {
+	// Credentials returns the certificate and signer to sign PDFs with.
+	Credentials(ctx context.Context) (*SigningCredentials, error)
+}
+
+// KeyProviderFactory creates the key provider configured by conf.ProviderType.
+func KeyProviderFactory(conf *CertificateConfig) (KeyProvider, error) {
+	if conf == nil {
+		return nil, errors.New("certificate config is required")
+	}
+
+	switch conf.ProviderType {
+	case "", KeyProviderTypePEM:
+		return &PEMKeyProvider{config: conf}, nil
+	case KeyProviderTypePKCS12:
+		if conf.PKCS12FilePath == "" {
+			return nil, errors.New("PKCS#12 file path is required")
+		}
+		return &PKCS12KeyProvider{FilePath: conf.PKCS12FilePath, Password: conf.PKCS12Password}, nil
+	case KeyProviderTypeRemote:
+		if conf.Remote == nil {
+			return nil, errors.New("remote signer configuration is required")
+		}
+		return NewRemoteKeyProvider(conf.Remote)
+	default:
+		return nil, fmt.Errorf("unknown key provider type: %s", conf.ProviderType)
+	}
+}
+
+// PEMKeyProvider reads a PEM certificate and private key from disk.
+type PEMKeyProvider struct {
+	config *CertificateConfig
+}
+
+func (p *PEMKeyProvider) Credentials(ctx context.Context) (*SigningCredentials, error) {
+	return loadPEMCredentials(p.config)
+}
+
+// PKCS12KeyProvider reads the certificate and private key from a .p12/.pfx bundle.
+type PKCS12KeyProvider struct {
+	FilePath string
+	Password string
+}
+
+func (p *PKCS12KeyProvider) Credentials(ctx context.Context) (*SigningCredentials, error) {
+	pfxData, err := os.ReadFile(p.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PKCS#12 file: %v", err)
+	}
+
+	return decodePKCS12(pfxData, p.Password)
+}
+
+// isPKCS12 reports whether data looks like a DER PKCS#12 bundle rather than PEM
+func isPKCS12(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block == nil && len(data) > 0 && data[0] == 0x30
+}
+
+func decodePKCS12(pfxData []byte, password string) (*SigningCredentials, error) {
+	key, cert, caCerts, err := pkcs12.DecodeChain(pfxData, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PKCS#12 file: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return &SigningCredentials{
+		Certificate:      cert,
+		PrivateKey:       signer,
+		CertificateChain: append([]*x509.Certificate{cert}, caCerts...),
+	}, nil
+}
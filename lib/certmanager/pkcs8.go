@@ -0,0 +1,165 @@
+package certmanager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// errIncorrectPassword is returned when the decrypted key has invalid padding
+var errIncorrectPassword = errors.New("decryption failed, incorrect password?")
+
+// EncryptedPrivateKeyInfo from RFC 5208
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// PBES2-params from RFC 8018
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// PBKDF2-params from RFC 8018, the PRF defaults to hmacWithSHA1
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8PrivateKey decrypts a PBES2 encrypted PKCS#8 key, PBKDF2 with
+// an HMAC-SHA PRF and AES-CBC or 3DES-CBC, and returns the PKCS#8 DER.
+func decryptPKCS8PrivateKey(data, password []byte) ([]byte, error) {
+	var keyInfo encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(data, &keyInfo); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %v", err)
+	}
+
+	if !keyInfo.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption algorithm %s, only PBES2 is supported", keyInfo.EncryptionAlgorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(keyInfo.EncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse PBES2 parameters: %v", err)
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %s, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, fmt.Errorf("failed to parse PBKDF2 parameters: %v", err)
+	}
+
+	prf, err := pbkdf2PRF(kdfParams.PRF.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	newCipher, keyLength, err := pbes2Cipher(params.EncryptionScheme.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != keyLength {
+		return nil, fmt.Errorf("key length %d does not match the encryption scheme", kdfParams.KeyLength)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("failed to parse cipher IV: %v", err)
+	}
+
+	key := pbkdf2.Key(password, kdfParams.Salt, kdfParams.IterationCount, keyLength, prf)
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("invalid IV length %d", len(iv))
+	}
+	if len(keyInfo.EncryptedData) == 0 || len(keyInfo.EncryptedData)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("encrypted data is not a multiple of the block size")
+	}
+
+	decrypted := make([]byte, len(keyInfo.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, keyInfo.EncryptedData)
+
+	return unpad(decrypted, block.BlockSize())
+}
+
+func pbkdf2PRF(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case len(oid) == 0, oid.Equal(oidHMACWithSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidHMACWithSHA224):
+		return sha256.New224, nil
+	case oid.Equal(oidHMACWithSHA256):
+		return sha256.New, nil
+	case oid.Equal(oidHMACWithSHA384):
+		return sha512.New384, nil
+	case oid.Equal(oidHMACWithSHA512):
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 PRF %s", oid)
+	}
+}
+
+func pbes2Cipher(oid asn1.ObjectIdentifier) (func(key []byte) (cipher.Block, error), int, error) {
+	switch {
+	case oid.Equal(oidAES128CBC):
+		return aes.NewCipher, 16, nil
+	case oid.Equal(oidAES192CBC):
+		return aes.NewCipher, 24, nil
+	case oid.Equal(oidAES256CBC):
+		return aes.NewCipher, 32, nil
+	case oid.Equal(oidDESEDE3CBC):
+		return des.NewTripleDESCipher, 24, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported encryption scheme %s", oid)
+	}
+}
+
+// unpad removes PKCS#7 padding, a wrong password shows up as invalid padding
+func unpad(data []byte, blockSize int) ([]byte, error) {
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > blockSize || padding > len(data) {
+		return nil, errIncorrectPassword
+	}
+	if !bytes.Equal(data[len(data)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errIncorrectPassword
+	}
+
+	return data[:len(data)-padding], nil
+}
@@ -0,0 +1,218 @@
+package certmanager
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultRemoteSignerTimeout = 10 * time.Second
+
+// RemoteSignerConfig configures a signing service that keeps the private key.
+//
+// The service exposes two endpoints relative to URL:
+//
+//	GET  /certificate?key_id=<KeyID>  PEM signing certificate, followed by its chain
+//	POST /sign                        {"key_id", "hash_algorithm", "padding", "digest"} -> {"signature"}
+//
+// digest and signature are base64 encoded. For RSA keys padding is pkcs1v15
+// or pss, ECDSA signatures are returned ASN.1 encoded.
+type RemoteSignerConfig struct {
+	URL       string
+	KeyID     string
+	AuthToken string // sent as a bearer token when set
+	// CertFilePath is a local PEM copy of the signing certificate, when empty
+	// the certificate is fetched from the service.
+	CertFilePath string
+	Timeout      time.Duration
+}
+
+type remoteSignRequest struct {
+	KeyID         string `json:"key_id"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Padding       string `json:"padding,omitempty"`
+	Digest        string `json:"digest"`
+}
+
+type remoteSignResponse struct {
+	Signature string `json:"signature"`
+}
+
+// RemoteKeyProvider signs through a remote signing service, the private key
+// never leaves the service.
+type RemoteKeyProvider struct {
+	config *RemoteSignerConfig
+	client *http.Client
+}
+
+func NewRemoteKeyProvider(conf *RemoteSignerConfig) (*RemoteKeyProvider, error) {
+	if conf.URL == "" {
+		return nil, errors.New("remote signer URL is required")
+	}
+	if conf.KeyID == "" {
+		return nil, errors.New("remote signer key id is required")
+	}
+
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = defaultRemoteSignerTimeout
+	}
+
+	return &RemoteKeyProvider{
+		config: conf,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (p *RemoteKeyProvider) Credentials(ctx context.Context) (*SigningCredentials, error) {
+	var certs []*x509.Certificate
+	var err error
+	if p.config.CertFilePath != "" {
+		certs, err = readCertificates(p.config.CertFilePath)
+	} else {
+		certs, err = p.fetchCertificates(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate: %v", err)
+	}
+
+	return &SigningCredentials{
+		Certificate: certs[0],
+		PrivateKey: &remoteSigner{
+			provider:  p,
+			publicKey: certs[0].PublicKey,
+		},
+		CertificateChain: certs,
+	}, nil
+}
+
+// fetchCertificates returns the signing certificate followed by the chain the service sent along
+func (p *RemoteKeyProvider) fetchCertificates(ctx context.Context) ([]*x509.Certificate, error) {
+	certURL := strings.TrimRight(p.config.URL, "/") + "/certificate?key_id=" + url.QueryEscape(p.config.KeyID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCertificates(body)
+}
+
+func (p *RemoteKeyProvider) sign(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signReq := remoteSignRequest{
+		KeyID:         p.config.KeyID,
+		HashAlgorithm: strings.ReplaceAll(opts.HashFunc().String(), "-", ""),
+		Digest:        base64.StdEncoding.EncodeToString(digest),
+	}
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		signReq.Padding = "pss"
+	}
+
+	reqBody, err := json.Marshal(signReq)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(p.config.URL, "/")+"/sign", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	body, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var signResp remoteSignResponse
+	if err := json.Unmarshal(body, &signResp); err != nil {
+		return nil, fmt.Errorf("failed to decode sign response: %v", err)
+	}
+
+	return base64.StdEncoding.DecodeString(signResp.Signature)
+}
+
+func (p *RemoteKeyProvider) do(req *http.Request) ([]byte, error) {
+	if p.config.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.AuthToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote signer response: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("remote signer returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+// remoteSigner is the crypto.Signer handed to the PDF signer, Sign sends the
+// digest to the service and checks the result against the certificate.
+type remoteSigner struct {
+	provider  *RemoteKeyProvider
+	publicKey crypto.PublicKey
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+func (s *remoteSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if len(digest) != opts.HashFunc().Size() {
+		return nil, fmt.Errorf("digest length %d does not match %s", len(digest), opts.HashFunc())
+	}
+
+	signature, err := s.provider.sign(digest, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyDigestSignature(s.publicKey, digest, signature, opts); err != nil {
+		return nil, fmt.Errorf("remote signature does not match the certificate: %v", err)
+	}
+
+	return signature, nil
+}
+
+func verifyDigestSignature(publicKey crypto.PublicKey, digest, signature []byte, opts crypto.SignerOpts) error {
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			return rsa.VerifyPSS(pub, opts.HashFunc(), digest, signature, pssOpts)
+		}
+		return rsa.VerifyPKCS1v15(pub, opts.HashFunc(), digest, signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, signature) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
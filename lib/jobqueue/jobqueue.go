@@ -0,0 +1,257 @@
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rchougule/espresso/lib/utils"
+)
+
+// Status of a job
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+var (
+	// ErrNotFound is returned for unknown or expired job ids
+	ErrNotFound = errors.New("job not found")
+	// ErrQueueFull is returned when the backend cannot take more jobs
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrWebhookNotAllowed is returned for webhooks the WebhookConfig does
+	// not allow
+	ErrWebhookNotAllowed = errors.New("webhook not allowed")
+)
+
+// Job is a unit of work run by the handler of its Type. Payload is the
+// request, Result and Output are set by the handler: Result is a small JSON
+// document sent with the webhook, Output the generated file, if any.
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Status     Status          `json:"status"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Output     []byte          `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	WebhookURL string          `json:"webhook_url,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+}
+
+// Finished reports whether the job succeeded or failed
+func (j *Job) Finished() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed
+}
+
+// Backend stores jobs and hands queued jobs to the workers. Implementations
+// must be safe for concurrent use and return copies, see MemoryBackend for
+// an in-process one.
+type Backend interface {
+	// Enqueue stores a new job and queues it, ErrQueueFull when it cannot
+	Enqueue(ctx context.Context, job *Job) error
+	// Dequeue blocks until a queued job is available or ctx is done
+	Dequeue(ctx context.Context) (*Job, error)
+	// Update stores the changed state of a job
+	Update(ctx context.Context, job *Job) error
+	// Get returns the job with id, ErrNotFound when there is none
+	Get(ctx context.Context, id string) (*Job, error)
+}
+
+// webhookQueueSize is the number of finished jobs waiting for their webhook
+// delivery, workers wait when it is reached
+const webhookQueueSize = 1000
+
+// Handler runs a job, setting its Result and Output. A returned error fails
+// the job with the error as message.
+type Handler func(ctx context.Context, job *Job) error
+
+// Queue runs jobs of a Backend with the handlers registered for their type
+// and notifies the webhook of a job when it finishes. Webhooks are delivered
+// by their own goroutines, so slow receivers and retries do not hold up the
+// workers.
+type Queue struct {
+	// Workers is the number of jobs run at the same time, 1 when zero
+	Workers int
+	// JobTimeout cancels handlers running longer, zero means no limit
+	JobTimeout time.Duration
+	// Webhook delivers the notifications of finished jobs
+	Webhook WebhookConfig
+	// WebhookWorkers is the number of webhooks delivered at the same time,
+	// Workers when zero
+	WebhookWorkers int
+
+	backend  Backend
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	webhooks chan *Job
+}
+
+func NewQueue(backend Backend) *Queue {
+	return &Queue{
+		backend:  backend,
+		handlers: map[string]Handler{},
+		webhooks: make(chan *Job, webhookQueueSize),
+	}
+}
+
+// Handle registers the handler of a job type
+func (q *Queue) Handle(jobType string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = handler
+}
+
+func (q *Queue) handler(jobType string) (Handler, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	handler, ok := q.handlers[jobType]
+	return handler, ok
+}
+
+// Submit queues a job of jobType with payload and returns it. The webhook
+// URL is optional, it must be http or https, of one of the allowed hosts,
+// and needs a webhook secret, see WebhookConfig.
+func (q *Queue) Submit(ctx context.Context, jobType string, payload json.RawMessage, webhookURL string) (*Job, error) {
+	if _, ok := q.handler(jobType); !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+	if webhookURL != "" {
+		if err := q.Webhook.checkURL(webhookURL); err != nil {
+			return nil, err
+		}
+	}
+
+	job := &Job{
+		ID:         utils.GenerateUniqueID(ctx),
+		Type:       jobType,
+		Status:     StatusQueued,
+		Payload:    payload,
+		WebhookURL: webhookURL,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := q.backend.Enqueue(ctx, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Get returns the job with id
+func (q *Queue) Get(ctx context.Context, id string) (*Job, error) {
+	return q.backend.Get(ctx, id)
+}
+
+// Start runs the workers until ctx is done. It returns immediately.
+func (q *Queue) Start(ctx context.Context) {
+	workers := q.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go q.work(ctx)
+	}
+
+	webhookWorkers := q.WebhookWorkers
+	if webhookWorkers <= 0 {
+		webhookWorkers = workers
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		go q.notify(ctx)
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		job, err := q.backend.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("failed to dequeue job: %v\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		q.run(ctx, job)
+	}
+}
+
+// notify delivers the webhooks of finished jobs until ctx is done
+func (q *Queue) notify(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q.webhooks:
+			if err := q.Webhook.deliver(ctx, job); err != nil {
+				fmt.Printf("failed to deliver webhook of job %s: %v\n", job.ID, err)
+			}
+		}
+	}
+}
+
+// run runs job with its handler, stores the outcome and queues the webhook
+func (q *Queue) run(ctx context.Context, job *Job) {
+	job.Status = StatusRunning
+	job.StartedAt = time.Now().UTC()
+	if err := q.backend.Update(ctx, job); err != nil {
+		fmt.Printf("failed to update job %s: %v\n", job.ID, err)
+	}
+
+	err := q.runHandler(ctx, job)
+
+	job.FinishedAt = time.Now().UTC()
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		job.Result = nil
+		job.Output = nil
+	} else {
+		job.Status = StatusSucceeded
+	}
+	if err := q.backend.Update(ctx, job); err != nil {
+		fmt.Printf("failed to update job %s: %v\n", job.ID, err)
+	}
+
+	if job.WebhookURL != "" {
+		// The delivery needs neither the request nor the output
+		notification := *job
+		notification.Payload = nil
+		notification.Output = nil
+		select {
+		case q.webhooks <- &notification:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (q *Queue) runHandler(ctx context.Context, job *Job) (err error) {
+	handler, ok := q.handler(job.Type)
+	if !ok {
+		return fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	if q.JobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.JobTimeout)
+		defer cancel()
+	}
+
+	// A panicking handler fails its job instead of the worker
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	return handler(ctx, job)
+}
@@ -0,0 +1,331 @@
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+
+	tests := []struct {
+		name          string
+		handler       Handler
+		webhookStatus []int
+		wantStatus    Status
+		wantResult    string
+		wantOutput    string
+		wantError     string
+		wantAttempts  int
+	}{
+		{
+			name: "succeeded",
+			handler: func(ctx context.Context, job *Job) error {
+				job.Result = json.RawMessage(`{"pages":1}`)
+				job.Output = []byte("%PDF-" + string(job.Payload))
+				return nil
+			},
+			webhookStatus: []int{http.StatusOK},
+			wantStatus:    StatusSucceeded,
+			wantResult:    `{"pages":1}`,
+			wantOutput:    `%PDF-{"name":"test"}`,
+			wantAttempts:  1,
+		},
+		{
+			name: "failed",
+			handler: func(ctx context.Context, job *Job) error {
+				job.Output = []byte("partial")
+				return errors.New("template not found")
+			},
+			webhookStatus: []int{http.StatusOK},
+			wantStatus:    StatusFailed,
+			wantError:     "template not found",
+			wantAttempts:  1,
+		},
+		{
+			name: "panicked",
+			handler: func(ctx context.Context, job *Job) error {
+				panic("nil map")
+			},
+			webhookStatus: []int{http.StatusOK},
+			wantStatus:    StatusFailed,
+			wantError:     "job panicked: nil map",
+			wantAttempts:  1,
+		},
+		{
+			name: "timed_out",
+			handler: func(ctx context.Context, job *Job) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			webhookStatus: []int{http.StatusOK},
+			wantStatus:    StatusFailed,
+			wantError:     context.DeadlineExceeded.Error(),
+			wantAttempts:  1,
+		},
+		{
+			name: "webhook_retried",
+			handler: func(ctx context.Context, job *Job) error {
+				return nil
+			},
+			webhookStatus: []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK},
+			wantStatus:    StatusSucceeded,
+			wantAttempts:  3,
+		},
+		{
+			name: "webhook_rejected",
+			handler: func(ctx context.Context, job *Job) error {
+				return nil
+			},
+			webhookStatus: []int{http.StatusBadRequest, http.StatusOK},
+			wantStatus:    StatusSucceeded,
+			wantAttempts:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var attempts atomic.Int32
+			deliveries := make(chan delivery, len(tt.webhookStatus))
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				attempt := int(attempts.Add(1))
+				w.WriteHeader(tt.webhookStatus[attempt-1])
+				deliveries <- delivery{header: r.Header, body: body}
+			}))
+			defer server.Close()
+
+			queue := NewQueue(NewMemoryBackend(10))
+			queue.JobTimeout = 100 * time.Millisecond
+			queue.Webhook = WebhookConfig{Secret: "secret", AllowedHosts: []string{"127.0.0.1"}, RetryDelay: time.Millisecond}
+			queue.Handle("pdf", tt.handler)
+			queue.Start(ctx)
+
+			job, err := queue.Submit(ctx, "pdf", json.RawMessage(`{"name":"test"}`), server.URL)
+			require.NoError(t, err)
+			assert.Equal(t, StatusQueued, job.Status)
+
+			var last delivery
+			for i := 0; i < tt.wantAttempts; i++ {
+				select {
+				case last = <-deliveries:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("webhook attempt %d was not delivered", i+1)
+				}
+			}
+			// No further attempts after a success or a rejection
+			time.Sleep(20 * time.Millisecond)
+			assert.Equal(t, int32(tt.wantAttempts), attempts.Load())
+
+			stored, err := queue.Get(ctx, job.ID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, stored.Status)
+			assert.Equal(t, tt.wantError, stored.Error)
+			assert.Equal(t, tt.wantOutput, string(stored.Output))
+			assert.False(t, stored.StartedAt.IsZero())
+			assert.False(t, stored.FinishedAt.Before(stored.StartedAt))
+
+			assert.Equal(t, job.ID, last.header.Get(HeaderJobID))
+			assert.NoError(t, VerifyWebhook("secret", last.header.Get(HeaderTimestamp), last.header.Get(HeaderSignature), last.body, time.Minute))
+			assert.Error(t, VerifyWebhook("other", last.header.Get(HeaderTimestamp), last.header.Get(HeaderSignature), last.body, time.Minute))
+
+			var payload WebhookPayload
+			require.NoError(t, json.Unmarshal(last.body, &payload))
+			assert.Equal(t, job.ID, payload.JobID)
+			assert.Equal(t, tt.wantStatus, payload.Status)
+			assert.Equal(t, tt.wantError, payload.Error)
+			if tt.wantResult != "" {
+				assert.JSONEq(t, tt.wantResult, string(payload.Result))
+			} else {
+				assert.Empty(t, payload.Result)
+			}
+		})
+	}
+}
+
+func TestQueueSubmit(t *testing.T) {
+	ctx := context.Background()
+	queue := NewQueue(NewMemoryBackend(1))
+	queue.Handle("pdf", func(ctx context.Context, job *Job) error { return nil })
+
+	_, err := queue.Submit(ctx, "image", nil, "")
+	assert.Error(t, err, "unknown job type")
+
+	_, err = queue.Submit(ctx, "pdf", nil, "file:///etc/passwd")
+	assert.Error(t, err, "webhook must be http or https")
+
+	// Webhooks need a secret and an allowed host
+	_, err = queue.Submit(ctx, "pdf", nil, "https://hooks.example.com/done")
+	assert.ErrorIs(t, err, ErrWebhookNotAllowed)
+	queue.Webhook = WebhookConfig{Secret: "secret", AllowedHosts: []string{".example.com", "Receiver.internal"}}
+	for _, webhookURL := range []string{"http://169.254.169.254/latest/meta-data", "https://example.com.evil.io/", "https://badexample.com/", "http://localhost:8081/jobs"} {
+		_, err = queue.Submit(ctx, "pdf", nil, webhookURL)
+		assert.ErrorIs(t, err, ErrWebhookNotAllowed, webhookURL)
+	}
+	for _, webhookURL := range []string{"https://hooks.example.com/done", "http://receiver.internal:8080/done"} {
+		require.NoError(t, queue.Webhook.checkURL(webhookURL), webhookURL)
+	}
+
+	_, err = queue.Submit(ctx, "pdf", nil, "")
+	require.NoError(t, err)
+
+	// The workers are not started, the queue stays full
+	_, err = queue.Submit(ctx, "pdf", nil, "")
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	_, err = queue.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestQueueSlowWebhook(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	queue := NewQueue(NewMemoryBackend(10))
+	queue.Workers = 1
+	queue.Webhook = WebhookConfig{Secret: "secret", AllowedHosts: []string{"127.0.0.1"}}
+	queue.Handle("pdf", func(ctx context.Context, job *Job) error { return nil })
+	queue.Start(ctx)
+
+	_, err := queue.Submit(ctx, "pdf", nil, server.URL)
+	require.NoError(t, err)
+	job, err := queue.Submit(ctx, "pdf", nil, "")
+	require.NoError(t, err)
+
+	// The single worker runs the second job while the first webhook hangs
+	assert.Eventually(t, func() bool {
+		stored, err := queue.Get(ctx, job.ID)
+		return err == nil && stored.Status == StatusSucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMemoryBackendRetention(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend(10)
+	backend.Retention = time.Minute
+
+	job := &Job{ID: "1", Status: StatusQueued}
+	require.NoError(t, backend.Enqueue(ctx, job))
+
+	// Stored jobs are copies
+	job.Status = StatusRunning
+	stored, err := backend.Get(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, StatusQueued, stored.Status)
+
+	dequeued, err := backend.Dequeue(ctx)
+	require.NoError(t, err)
+	dequeued.Status = StatusSucceeded
+	dequeued.FinishedAt = time.Now().Add(-2 * time.Minute)
+	require.NoError(t, backend.Update(ctx, dequeued))
+
+	_, err = backend.Get(ctx, "1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, backend.Update(ctx, &Job{ID: "2"}), ErrNotFound)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = backend.Dequeue(canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMemoryBackendLimits(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend(10)
+	backend.MaxFinished = 2
+	backend.MaxOutputBytes = 10
+
+	finish := func(id string, output []byte) {
+		require.NoError(t, backend.Enqueue(ctx, &Job{ID: id, Status: StatusQueued}))
+		job, err := backend.Dequeue(ctx)
+		require.NoError(t, err)
+		job.Status = StatusSucceeded
+		job.Output = output
+		job.FinishedAt = time.Now()
+		require.NoError(t, backend.Update(ctx, job))
+	}
+	kept := func() []string {
+		var ids []string
+		for _, id := range []string{"1", "2", "3", "4", "5"} {
+			if _, err := backend.Get(ctx, id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+
+	finish("1", []byte("1234"))
+	finish("2", []byte("1234"))
+	finish("3", []byte("1234"))
+	assert.Equal(t, []string{"2", "3"}, kept(), "finished jobs are limited")
+
+	finish("4", []byte("12345678"))
+	assert.Equal(t, []string{"4"}, kept(), "outputs are limited")
+
+	// The latest job is kept even when its output alone is over the limit
+	finish("5", []byte("123456789012"))
+	assert.Equal(t, []string{"5"}, kept())
+
+	// Running jobs are not evicted
+	require.NoError(t, backend.Enqueue(ctx, &Job{ID: "6", Status: StatusQueued}))
+	_, err := backend.Dequeue(ctx)
+	require.NoError(t, err)
+	finish("7", nil)
+	finish("8", nil)
+	_, err = backend.Get(ctx, "6")
+	assert.NoError(t, err)
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"job_id":"1"}`)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		wantErr   bool
+	}{
+		{name: "valid", timestamp: strconv.FormatInt(now, 10), signature: SignWebhook("secret", now, body)},
+		{name: "other_secret", timestamp: strconv.FormatInt(now, 10), signature: SignWebhook("other", now, body), wantErr: true},
+		{name: "other_timestamp", timestamp: strconv.FormatInt(now+1, 10), signature: SignWebhook("secret", now, body), wantErr: true},
+		{name: "expired", timestamp: strconv.FormatInt(now-600, 10), signature: SignWebhook("secret", now-600, body), wantErr: true},
+		{name: "invalid_timestamp", timestamp: "now", signature: SignWebhook("secret", now, body), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyWebhook("secret", tt.timestamp, tt.signature, body, 5*time.Minute)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package jobqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory backend defaults
+const (
+	defaultMemoryCapacity       = 1000
+	defaultMemoryRetention      = time.Hour
+	defaultMemoryMaxFinished    = 1000
+	defaultMemoryMaxOutputBytes = 512 << 20
+)
+
+// MemoryBackend keeps jobs in process memory. Jobs are lost on restart, it
+// stands in for a broker in tests and single instance deployments. Finished
+// jobs are dropped oldest first once MaxFinished or MaxOutputBytes is
+// exceeded, even within the retention.
+type MemoryBackend struct {
+	// Retention is how long finished jobs can be polled, an hour when zero
+	Retention time.Duration
+	// MaxFinished is how many finished jobs are kept, 1000 when zero
+	MaxFinished int
+	// MaxOutputBytes is the total size of the outputs of finished jobs
+	// kept, 512 MB when zero. The latest finished job is always kept.
+	MaxOutputBytes int64
+
+	mu          sync.Mutex
+	jobs        map[string]*Job
+	queued      chan string
+	finished    []string
+	outputBytes int64
+	expiredAt   time.Time
+}
+
+// NewMemoryBackend returns a backend queueing up to capacity jobs, 1000
+// when zero
+func NewMemoryBackend(capacity int) *MemoryBackend {
+	if capacity <= 0 {
+		capacity = defaultMemoryCapacity
+	}
+	return &MemoryBackend{
+		jobs:   map[string]*Job{},
+		queued: make(chan string, capacity),
+	}
+}
+
+func (m *MemoryBackend) Enqueue(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	select {
+	case m.queued <- job.ID:
+	default:
+		return ErrQueueFull
+	}
+	stored := *job
+	m.jobs[job.ID] = &stored
+
+	return nil
+}
+
+func (m *MemoryBackend) Dequeue(ctx context.Context) (*Job, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case id := <-m.queued:
+			// Enqueue stores the job after queueing its id, under the lock
+			m.mu.Lock()
+			job, ok := m.jobs[id]
+			m.mu.Unlock()
+			if !ok {
+				continue
+			}
+			copied := *job
+			return &copied, nil
+		}
+	}
+}
+
+func (m *MemoryBackend) Update(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.jobs[job.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored := *job
+	m.jobs[job.ID] = &stored
+
+	if current.Finished() {
+		m.outputBytes -= int64(len(current.Output))
+	} else if stored.Finished() {
+		m.finished = append(m.finished, job.ID)
+	}
+	if stored.Finished() {
+		m.outputBytes += int64(len(stored.Output))
+		m.evict()
+	}
+
+	return nil
+}
+
+func (m *MemoryBackend) Get(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	job, ok := m.jobs[id]
+	if !ok || m.expired(job) {
+		return nil, ErrNotFound
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// expire removes finished jobs older than the retention, at most once a
+// minute. m.mu must be held.
+func (m *MemoryBackend) expire() {
+	if time.Since(m.expiredAt) < time.Minute {
+		return
+	}
+	m.expiredAt = time.Now()
+
+	kept := m.finished[:0]
+	for _, id := range m.finished {
+		if job := m.jobs[id]; m.expired(job) {
+			m.remove(id)
+		} else {
+			kept = append(kept, id)
+		}
+	}
+	m.finished = kept
+}
+
+// evict removes the oldest finished jobs while more than MaxFinished jobs or
+// MaxOutputBytes of outputs are kept. m.mu must be held.
+func (m *MemoryBackend) evict() {
+	maxFinished := m.MaxFinished
+	if maxFinished <= 0 {
+		maxFinished = defaultMemoryMaxFinished
+	}
+	maxOutputBytes := m.MaxOutputBytes
+	if maxOutputBytes <= 0 {
+		maxOutputBytes = defaultMemoryMaxOutputBytes
+	}
+
+	for len(m.finished) > maxFinished || (len(m.finished) > 1 && m.outputBytes > maxOutputBytes) {
+		m.remove(m.finished[0])
+		m.finished = m.finished[1:]
+	}
+}
+
+// remove deletes a finished job and its output. m.mu must be held.
+func (m *MemoryBackend) remove(id string) {
+	m.outputBytes -= int64(len(m.jobs[id].Output))
+	delete(m.jobs, id)
+}
+
+// expired reports whether job finished longer than the retention ago
+func (m *MemoryBackend) expired(job *Job) bool {
+	retention := m.Retention
+	if retention <= 0 {
+		retention = defaultMemoryRetention
+	}
+	return job.Finished() && time.Since(job.FinishedAt) > retention
+}
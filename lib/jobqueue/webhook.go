@@ -0,0 +1,195 @@
+package jobqueue
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook headers. The signature is "sha256=" and the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the webhook secret.
+const (
+	HeaderJobID     = "X-Espresso-Job-Id"
+	HeaderTimestamp = "X-Espresso-Timestamp"
+	HeaderSignature = "X-Espresso-Signature"
+)
+
+// Webhook defaults
+const (
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookMaxAttempts = 3
+	defaultWebhookRetryDelay  = time.Second
+)
+
+// WebhookConfig configures the delivery of webhooks
+type WebhookConfig struct {
+	// Secret signs the deliveries. Jobs with a webhook are refused when it is
+	// empty, receivers could not tell deliveries from forgeries.
+	Secret string
+	// AllowedHosts are the hosts webhook URLs may point to, case insensitive,
+	// and with a leading dot their subdomains, e.g. ".example.com". Jobs with
+	// a webhook are refused when it is empty, callers would otherwise choose
+	// where the service sends requests.
+	AllowedHosts []string
+	// Client sends the deliveries, one with a 10 second timeout when nil.
+	// Redirects are not followed by the default client, a custom one should
+	// not follow them either as they could lead to hosts not allowed.
+	Client *http.Client
+	// MaxAttempts per delivery, 3 when zero. Network errors, 429 and 5xx
+	// responses are retried with a doubling delay starting at RetryDelay,
+	// one second when zero.
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+// WebhookPayload is the JSON body of a webhook delivery
+type WebhookPayload struct {
+	JobID      string          `json:"job_id"`
+	Type       string          `json:"type"`
+	Status     Status          `json:"status"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+}
+
+// SignWebhook returns the signature header value of a delivery
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature and timestamp headers of a delivery,
+// for receivers. Deliveries older than tolerance are rejected to prevent
+// replays, zero skips the check.
+func VerifyWebhook(secret, timestampHeader, signatureHeader string, body []byte, tolerance time.Duration) error {
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook timestamp %q", timestampHeader)
+	}
+	if tolerance > 0 {
+		age := time.Since(time.Unix(timestamp, 0))
+		if age > tolerance || age < -tolerance {
+			return fmt.Errorf("webhook timestamp is outside the tolerance of %s", tolerance)
+		}
+	}
+
+	expected := SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signatureHeader))) {
+		return fmt.Errorf("webhook signature does not match")
+	}
+	return nil
+}
+
+// checkURL fails unless webhookURL is an http or https URL of an allowed
+// host and deliveries can be signed
+func (c *WebhookConfig) checkURL(webhookURL string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid webhook url %q", webhookURL)
+	}
+	if c.Secret == "" {
+		return fmt.Errorf("%w: no webhook secret is configured to sign deliveries", ErrWebhookNotAllowed)
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, allowed := range c.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: host %q is not an allowed webhook host", ErrWebhookNotAllowed, host)
+}
+
+// deliver posts the outcome of job to its webhook URL
+func (c *WebhookConfig) deliver(ctx context.Context, job *Job) error {
+	body, err := json.Marshal(WebhookPayload{
+		JobID:      job.ID,
+		Type:       job.Type,
+		Status:     job.Status,
+		Result:     job.Result,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		FinishedAt: job.FinishedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	client := c.Client
+	if client == nil {
+		client = &http.Client{
+			Timeout: defaultWebhookTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	max_attempts := c.MaxAttempts
+	if max_attempts <= 0 {
+		max_attempts = defaultWebhookMaxAttempts
+	}
+	delay := c.RetryDelay
+	if delay <= 0 {
+		delay = defaultWebhookRetryDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := c.post(ctx, client, job, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= max_attempts {
+			return fmt.Errorf("attempt %d: %w", attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends one delivery and reports whether a failure is worth retrying
+func (c *WebhookConfig) post(ctx context.Context, client *http.Client, job *Job, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderJobID, job.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if c.Secret != "" {
+		req.Header.Set(HeaderSignature, SignWebhook(c.Secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+}
@@ -0,0 +1,542 @@
+package pdfops
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/digitorus/pdf"
+)
+
+// Object is a PDF object: nil, bool, int64, float64, String, Name, Array,
+// Dict, Ref or *Stream
+type Object interface{}
+
+// Name is a PDF name without the leading slash
+type Name string
+
+// String is a PDF string holding its raw bytes
+type String string
+
+type Array []Object
+
+type Dict map[Name]Object
+
+// Ref references the object with that number
+type Ref uint32
+
+// Stream is a stream object, Data is encoded with the filters in Dict
+type Stream struct {
+	Dict Dict
+	Data []byte
+}
+
+// Document is a PDF loaded into memory to be rewritten as a whole. Objects
+// keep their numbers, generation numbers are reset to 0 when writing.
+type Document struct {
+	Version string
+	Objects map[uint32]Object
+	Trailer Dict
+
+	// Encryption encrypts the document when set
+	Encryption *Encryption
+
+	// pdfa is the level ConvertToPDFA made the document conform to
+	pdfa PDFA
+}
+
+// Load reads the document from input. Encrypted documents are not supported.
+func Load(input io.ReaderAt, size int64) (doc *Document, err error) {
+	rdr, err := pdf.NewReader(input, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF reader: %w", err)
+	}
+
+	// The reader panics on malformed objects
+	defer func() {
+		if r := recover(); r != nil {
+			doc, err = nil, fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+
+	trailer := rdr.Trailer()
+	if !trailer.Key("Encrypt").IsNull() {
+		return nil, fmt.Errorf("encrypted documents are not supported")
+	}
+
+	loader := &loader{input: input, size: size}
+	doc = &Document{
+		Version: rdr.PDFVersion,
+		Objects: map[uint32]Object{},
+		Trailer: Dict{},
+	}
+
+	for _, xref := range rdr.Xref() {
+		ptr := xref.Ptr()
+		if ptr.GetID() == 0 {
+			continue
+		}
+
+		value := rdr.Resolve(ptr, ptr)
+		if value.IsNull() {
+			continue
+		}
+		// Object and xref streams are replaced by the plain objects and xref table written
+		if value.Kind() == pdf.Stream && (value.Key("Type").Name() == "ObjStm" || value.Key("Type").Name() == "XRef") {
+			continue
+		}
+
+		object, err := loader.object(value, ptr.GetID(), ptr.GetGen())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %d: %w", ptr.GetID(), err)
+		}
+		doc.Objects[ptr.GetID()] = object
+	}
+
+	trailer_ptr := trailer.GetPtr()
+	for _, key := range []string{"Root", "Info", "ID"} {
+		value := trailer.Key(key)
+		if value.IsNull() {
+			continue
+		}
+		object, err := loader.object(value, trailer_ptr.GetID(), trailer_ptr.GetGen())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trailer: %w", err)
+		}
+		doc.Trailer[Name(key)] = object
+	}
+
+	if _, ok := doc.Trailer["Root"].(Ref); !ok {
+		return nil, fmt.Errorf("malformed PDF: the trailer has no catalog reference")
+	}
+
+	return doc, nil
+}
+
+// LoadBytes reads the document from pdfBytes, see Load
+func LoadBytes(pdfBytes []byte) (*Document, error) {
+	return Load(bytes.NewReader(pdfBytes), int64(len(pdfBytes)))
+}
+
+type loader struct {
+	input io.ReaderAt
+	size  int64
+}
+
+// object converts value, which is part of the object id gen. Values the
+// reader resolved from another object become references to it.
+func (l *loader) object(value pdf.Value, id uint32, gen uint16) (Object, error) {
+	ptr := value.GetPtr()
+	if ptr.GetID() != id || ptr.GetGen() != gen {
+		if ptr.GetID() == 0 {
+			// A reference to a missing object
+			return nil, nil
+		}
+		return Ref(ptr.GetID()), nil
+	}
+
+	switch value.Kind() {
+	case pdf.Null:
+		return nil, nil
+	case pdf.Bool:
+		return value.Bool(), nil
+	case pdf.Integer:
+		return value.Int64(), nil
+	case pdf.Real:
+		return value.Float64(), nil
+	case pdf.String:
+		return String(value.RawString()), nil
+	case pdf.Name:
+		return Name(value.Name()), nil
+	case pdf.Array:
+		array := make(Array, value.Len())
+		for i := range array {
+			element, err := l.object(value.Index(i), id, gen)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = element
+		}
+		return array, nil
+	case pdf.Dict, pdf.Stream:
+		dict := Dict{}
+		for _, key := range value.Keys() {
+			element, err := l.object(value.Key(key), id, gen)
+			if err != nil {
+				return nil, err
+			}
+			dict[Name(key)] = element
+		}
+		if value.Kind() == pdf.Dict {
+			return dict, nil
+		}
+
+		data, err := l.streamData(value)
+		if err != nil {
+			return nil, err
+		}
+		return &Stream{Dict: dict, Data: data}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported value %v", value)
+}
+
+// streamData returns the encoded data of stream. The reader only hands out
+// decoded data, the offset of the data is the end of its description.
+func (l *loader) streamData(stream pdf.Value) ([]byte, error) {
+	description := stream.String()
+	offset, err := strconv.ParseInt(description[strings.LastIndex(description, "@")+1:], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find stream data: %w", err)
+	}
+
+	length := stream.Key("Length").Int64()
+	if length < 0 || offset+length > l.size {
+		return nil, fmt.Errorf("stream length %d exceeds the document", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := l.input.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("failed to read stream data: %w", err)
+	}
+
+	return data, nil
+}
+
+// Get returns the object ref points to, other objects are returned as is
+func (d *Document) Get(object Object) Object {
+	for i := 0; i < 32; i++ {
+		ref, ok := object.(Ref)
+		if !ok {
+			return object
+		}
+		object = d.Objects[uint32(ref)]
+	}
+	return nil
+}
+
+// GetDict returns the dictionary object refers to, or of the stream it refers to
+func (d *Document) GetDict(object Object) Dict {
+	switch object := d.Get(object).(type) {
+	case Dict:
+		return object
+	case *Stream:
+		return object.Dict
+	}
+	return nil
+}
+
+// Add adds object to the document and returns its reference
+func (d *Document) Add(object Object) Ref {
+	id := d.maxID() + 1
+	d.Objects[id] = object
+	return Ref(id)
+}
+
+// Catalog returns the document catalog
+func (d *Document) Catalog() Dict {
+	return d.GetDict(d.Trailer["Root"])
+}
+
+// Pages returns the references of the pages in document order
+func (d *Document) Pages() []Ref {
+	var pages []Ref
+	visited := map[Ref]bool{}
+
+	var walk func(node Object)
+	walk = func(node Object) {
+		ref, ok := node.(Ref)
+		if !ok || visited[ref] {
+			return
+		}
+		visited[ref] = true
+
+		dict := d.GetDict(ref)
+		if d.Get(dict["Type"]) == Name("Page") {
+			pages = append(pages, ref)
+			return
+		}
+		kids, _ := d.Get(dict["Kids"]).(Array)
+		for _, kid := range kids {
+			walk(kid)
+		}
+	}
+	walk(d.Catalog()["Pages"])
+
+	return pages
+}
+
+// ensureID adds a file identifier to the trailer when it has none
+func (d *Document) ensureID() error {
+	if _, ok := d.Trailer["ID"].(Array); ok {
+		return nil
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	d.Trailer["ID"] = Array{String(id), String(id)}
+
+	return nil
+}
+
+// removeUnreferenced drops the objects that cannot be reached from the
+// trailer, like the ones whose references were removed
+func (d *Document) removeUnreferenced() {
+	reachable := map[uint32]bool{}
+
+	var mark func(object Object)
+	mark = func(object Object) {
+		switch o := object.(type) {
+		case Ref:
+			if reachable[uint32(o)] {
+				return
+			}
+			reachable[uint32(o)] = true
+			mark(d.Objects[uint32(o)])
+		case Dict:
+			for _, value := range o {
+				mark(value)
+			}
+		case Array:
+			for _, value := range o {
+				mark(value)
+			}
+		case *Stream:
+			mark(o.Dict)
+		}
+	}
+	mark(d.Trailer)
+
+	for id := range d.Objects {
+		if !reachable[id] {
+			delete(d.Objects, id)
+		}
+	}
+}
+
+func (d *Document) maxID() uint32 {
+	var max_id uint32
+	for id := range d.Objects {
+		max_id = max(max_id, id)
+	}
+	return max_id
+}
+
+// Write writes the document with a cross-reference table, encrypting it when
+// Encryption is set.
+func (d *Document) Write(w io.Writer) error {
+	var security *securityHandler
+	if d.Encryption != nil {
+		var err error
+		security, err = d.Encryption.securityHandler()
+		if err != nil {
+			return fmt.Errorf("failed to set up encryption: %w", err)
+		}
+		if err := d.prepareEncryption(security); err != nil {
+			return err
+		}
+	}
+
+	ids := d.sortedIDs()
+
+	out := &countingWriter{w: w}
+
+	version := d.Version
+	if version == "" || (security != nil && version < "1.7") {
+		version = "1.7"
+	}
+	fmt.Fprintf(out, "%%PDF-%s\n%%\xe2\xe3\xcf\xd3\n", version)
+
+	offsets := map[uint32]int64{}
+	for _, id := range ids {
+		offsets[id] = out.n
+
+		var buffer bytes.Buffer
+		fmt.Fprintf(&buffer, "%d 0 obj\n", id)
+		if err := writeObject(&buffer, d.Objects[id], security.encrypter()); err != nil {
+			return fmt.Errorf("failed to write object %d: %w", id, err)
+		}
+		buffer.WriteString("\nendobj\n")
+
+		if _, err := out.Write(buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	xref_start := out.n
+	size := d.maxID() + 1
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "xref\n0 %d\n", size)
+	buffer.WriteString("0000000000 65535 f \n")
+	for id := uint32(1); id < size; id++ {
+		if offset, ok := offsets[id]; ok {
+			fmt.Fprintf(&buffer, "%010d 00000 n \n", offset)
+		} else {
+			buffer.WriteString("0000000000 00000 f \n")
+		}
+	}
+
+	trailer := Dict{"Size": int64(size)}
+	for _, key := range []Name{"Root", "Info", "ID"} {
+		if value, ok := d.Trailer[key]; ok {
+			trailer[key] = value
+		}
+	}
+	if security != nil {
+		trailer["Encrypt"] = security.dict()
+	}
+	buffer.WriteString("trailer\n")
+	// Neither the ID nor the encryption dictionary are encrypted
+	if err := writeObject(&buffer, trailer, nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(&buffer, "\nstartxref\n%d\n%%%%EOF\n", xref_start)
+
+	_, err := out.Write(buffer.Bytes())
+	return err
+}
+
+// Bytes returns the written document
+func (d *Document) Bytes() ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := d.Write(&buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeObject serializes object, encrypting strings and stream data with
+// encrypt when it is set
+func writeObject(w *bytes.Buffer, object Object, encrypt func([]byte) ([]byte, error)) error {
+	switch object := object.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		w.WriteString(strconv.FormatBool(object))
+	case int64:
+		w.WriteString(strconv.FormatInt(object, 10))
+	case float64:
+		w.WriteString(strconv.FormatFloat(object, 'f', -1, 64))
+	case Name:
+		writeName(w, object)
+	case String:
+		if encrypt == nil {
+			writeString(w, object)
+			return nil
+		}
+		encrypted, err := encrypt([]byte(object))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "<%x>", encrypted)
+	case Ref:
+		fmt.Fprintf(w, "%d 0 R", object)
+	case Array:
+		w.WriteString("[")
+		for i, element := range object {
+			if i > 0 {
+				w.WriteString(" ")
+			}
+			if err := writeObject(w, element, encrypt); err != nil {
+				return err
+			}
+		}
+		w.WriteString("]")
+	case Dict:
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, string(key))
+		}
+		sort.Strings(keys)
+
+		w.WriteString("<<")
+		for _, key := range keys {
+			writeName(w, Name(key))
+			w.WriteString(" ")
+			if err := writeObject(w, object[Name(key)], encrypt); err != nil {
+				return err
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString(">>")
+	case *Stream:
+		data := object.Data
+		if encrypt != nil {
+			var err error
+			if data, err = encrypt(data); err != nil {
+				return err
+			}
+		}
+
+		dict := Dict{}
+		for key, value := range object.Dict {
+			dict[key] = value
+		}
+		dict["Length"] = int64(len(data))
+
+		if err := writeObject(w, dict, encrypt); err != nil {
+			return err
+		}
+		w.WriteString("\nstream\n")
+		w.Write(data)
+		w.WriteString("\nendstream")
+	default:
+		return fmt.Errorf("unsupported object type %T", object)
+	}
+
+	return nil
+}
+
+func writeName(w *bytes.Buffer, name Name) {
+	w.WriteString("/")
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c > '~' || c == '#' || strings.IndexByte("()<>[]{}/%", c) >= 0 {
+			fmt.Fprintf(w, "#%02X", c)
+		} else {
+			w.WriteByte(c)
+		}
+	}
+}
+
+func writeString(w *bytes.Buffer, s String) {
+	// Binary strings, e.g. identifiers, are written in hex
+	for i := 0; i < len(s); i++ {
+		if (s[i] < ' ' && s[i] != '\n' && s[i] != '\r' && s[i] != '\t') || s[i] > '~' {
+			fmt.Fprintf(w, "<%x>", string(s))
+			return
+		}
+	}
+
+	w.WriteString("(")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '(', ')':
+			w.WriteByte('\\')
+			w.WriteByte(c)
+		case '\r':
+			w.WriteString("\\r")
+		default:
+			w.WriteByte(c)
+		}
+	}
+	w.WriteString(")")
+}
@@ -0,0 +1,294 @@
+package pdfops
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+)
+
+// Permission is a set of operations a reader opening the document with the
+// user password is allowed to do. The owner password allows everything.
+type Permission uint32
+
+// Permission bits of the standard security handler, ISO 32000-2 table 22
+const (
+	PermissionPrint            Permission = 1 << 2
+	PermissionModify           Permission = 1 << 3
+	PermissionCopy             Permission = 1 << 4
+	PermissionAnnotate         Permission = 1 << 5
+	PermissionFillForms        Permission = 1 << 8
+	PermissionExtract          Permission = 1 << 9 // for accessibility
+	PermissionAssemble         Permission = 1 << 10
+	PermissionPrintHighQuality Permission = 1 << 11
+
+	PermissionAll = PermissionPrint | PermissionModify | PermissionCopy | PermissionAnnotate |
+		PermissionFillForms | PermissionExtract | PermissionAssemble | PermissionPrintHighQuality
+)
+
+// maxPasswordLength is the length passwords are truncated to
+const maxPasswordLength = 127
+
+// Encryption configures AES-256 encryption with the standard security
+// handler (revision 6).
+type Encryption struct {
+	// UserPassword is needed to open the document, empty opens it without
+	// a password and only applies the permissions.
+	UserPassword string
+	// OwnerPassword lifts the permissions, a random one is used when empty
+	// so the permissions cannot be lifted.
+	OwnerPassword string
+	Permissions   Permission
+}
+
+// Encrypt rewrites the PDF read from pdfStream encrypted with AES-256. Signed
+// documents lose their signatures, encrypt before signing.
+func Encrypt(ctx context.Context, pdfStream io.Reader, encryption Encryption) ([]byte, error) {
+	pdfBytes, err := io.ReadAll(pdfStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	doc, err := LoadBytes(pdfBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load PDF: %v", err)
+	}
+
+	doc.Encryption = &encryption
+
+	encrypted, err := doc.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write encrypted PDF: %v", err)
+	}
+
+	return encrypted, nil
+}
+
+// securityHandler holds the file encryption key and the values of the
+// encryption dictionary derived from the passwords
+type securityHandler struct {
+	key         []byte
+	permissions int32
+	o, u        []byte
+	oe, ue      []byte
+	perms       []byte
+}
+
+func (e *Encryption) securityHandler() (*securityHandler, error) {
+	if e.Permissions&^PermissionAll != 0 {
+		return nil, fmt.Errorf("unknown permission bits %#x", uint32(e.Permissions&^PermissionAll))
+	}
+
+	owner_password := e.OwnerPassword
+	if owner_password == "" {
+		random, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+		if err != nil {
+			return nil, err
+		}
+		owner_password = random.Text(62)
+	}
+
+	// Bits 7, 8 and 13 to 32 are reserved and must be set
+	handler := &securityHandler{
+		key:         make([]byte, 32),
+		permissions: int32(uint32(e.Permissions) | 0xfffff0c0),
+	}
+	if _, err := rand.Read(handler.key); err != nil {
+		return nil, err
+	}
+
+	user := truncatePassword(e.UserPassword)
+	owner := truncatePassword(owner_password)
+
+	// Algorithm 8: U holds the hash, validation salt and key salt of the user password
+	var err error
+	handler.u, handler.ue, err = passwordEntries(user, nil, handler.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Algorithm 9: the owner entries also hash U
+	handler.o, handler.oe, err = passwordEntries(owner, handler.u, handler.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Algorithm 10: Perms protects P against tampering
+	perms := make([]byte, 16)
+	binary.LittleEndian.PutUint32(perms, uint32(handler.permissions))
+	copy(perms[4:], []byte{0xff, 0xff, 0xff, 0xff, 'T', 'a', 'd', 'b'})
+	if _, err := rand.Read(perms[12:]); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(handler.key)
+	if err != nil {
+		return nil, err
+	}
+	handler.perms = make([]byte, 16)
+	block.Encrypt(handler.perms, perms)
+
+	return handler, nil
+}
+
+// passwordEntries returns the 48 byte U or O entry, the hash with the
+// validation and key salts, and the UE or OE entry, the file key encrypted
+// with a key derived from the password.
+func passwordEntries(password, userEntry, fileKey []byte) ([]byte, []byte, error) {
+	salts := make([]byte, 16)
+	if _, err := rand.Read(salts); err != nil {
+		return nil, nil, err
+	}
+	validation_salt, key_salt := salts[:8], salts[8:]
+
+	entry := append(hashPassword(password, validation_salt, userEntry), salts...)
+
+	block, err := aes.NewCipher(hashPassword(password, key_salt, userEntry))
+	if err != nil {
+		return nil, nil, err
+	}
+	encrypted_key := make([]byte, 32)
+	cipher.NewCBCEncrypter(block, make([]byte, 16)).CryptBlocks(encrypted_key, fileKey)
+
+	return entry, encrypted_key, nil
+}
+
+// hashPassword is algorithm 2.B of ISO 32000-2
+func hashPassword(password, salt, userEntry []byte) []byte {
+	h := sha256.New()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(userEntry)
+	k := h.Sum(nil)
+
+	for round := 0; ; round++ {
+		k1 := bytes.Repeat(append(append(append([]byte{}, password...), k...), userEntry...), 64)
+
+		block, _ := aes.NewCipher(k[:16])
+		e := make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+
+		sum := 0
+		for _, b := range e[:16] {
+			sum += int(b)
+		}
+		var next hash.Hash
+		switch sum % 3 {
+		case 0:
+			next = sha256.New()
+		case 1:
+			next = sha512.New384()
+		default:
+			next = sha512.New()
+		}
+		next.Write(e)
+		k = next.Sum(nil)
+
+		if round >= 63 && int(e[len(e)-1]) <= round+1-32 {
+			break
+		}
+	}
+
+	return k[:32]
+}
+
+// truncatePassword returns the UTF-8 password limited to 127 bytes
+func truncatePassword(password string) []byte {
+	if len(password) > maxPasswordLength {
+		password = password[:maxPasswordLength]
+	}
+	return []byte(password)
+}
+
+// dict returns the encryption dictionary
+func (s *securityHandler) dict() Dict {
+	return Dict{
+		"Filter": Name("Standard"),
+		"V":      int64(5),
+		"R":      int64(6),
+		"Length": int64(256),
+		"CF": Dict{
+			"StdCF": Dict{
+				"AuthEvent": Name("DocOpen"),
+				"CFM":       Name("AESV3"),
+				"Length":    int64(32),
+			},
+		},
+		"StmF":            Name("StdCF"),
+		"StrF":            Name("StdCF"),
+		"O":               String(s.o),
+		"U":               String(s.u),
+		"OE":              String(s.oe),
+		"UE":              String(s.ue),
+		"P":               int64(s.permissions),
+		"Perms":           String(s.perms),
+		"EncryptMetadata": true,
+	}
+}
+
+// encrypter returns the function encrypting strings and streams, nil when
+// the document is not encrypted. AESV3 uses the file key for all objects.
+func (s *securityHandler) encrypter() func([]byte) ([]byte, error) {
+	if s == nil {
+		return nil
+	}
+
+	return func(data []byte) ([]byte, error) {
+		block, err := aes.NewCipher(s.key)
+		if err != nil {
+			return nil, err
+		}
+
+		padding := aes.BlockSize - len(data)%aes.BlockSize
+		encrypted := make([]byte, aes.BlockSize+len(data)+padding)
+		iv := encrypted[:aes.BlockSize]
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+
+		plain := encrypted[aes.BlockSize:]
+		copy(plain, data)
+		for i := len(data); i < len(plain); i++ {
+			plain[i] = byte(padding)
+		}
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(plain, plain)
+
+		return encrypted, nil
+	}
+}
+
+// prepareEncryption adds the file identifier encryption requires and
+// declares the Adobe extension level AES-256 belongs to in PDF 1.7
+func (d *Document) prepareEncryption(security *securityHandler) error {
+	if d.pdfa != "" {
+		return fmt.Errorf("PDF/A documents cannot be encrypted")
+	}
+	if err := d.ensureID(); err != nil {
+		return err
+	}
+
+	catalog := d.Catalog()
+	if catalog == nil {
+		return fmt.Errorf("the document has no catalog")
+	}
+	if d.Version < "2.0" {
+		extensions := d.GetDict(catalog["Extensions"])
+		if extensions == nil {
+			extensions = Dict{}
+			catalog["Extensions"] = extensions
+		}
+		extensions["ADBE"] = Dict{
+			"BaseVersion":    Name("1.7"),
+			"ExtensionLevel": int64(8),
+		}
+	}
+
+	return nil
+}
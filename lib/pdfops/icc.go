@@ -0,0 +1,110 @@
+package pdfops
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// srgbProfile is an ICC version 2 display profile for sRGB IEC61966-2.1,
+// the output intent of PDF/A documents rendered by Chrome
+var srgbProfile = sync.OnceValue(func() []byte {
+	// Primaries and white point adapted to the D50 connection space
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", iccDescription("sRGB IEC61966-2.1")},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(0.9642, 1.0, 0.8249)},
+		{"rXYZ", iccXYZ(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", iccXYZ(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", iccXYZ(0.1431, 0.0606, 0.7141)},
+		{"rTRC", iccSRGBCurve()},
+		{"gTRC", iccSRGBCurve()},
+		{"bTRC", iccSRGBCurve()},
+	}
+
+	var table, data bytes.Buffer
+	offsets := map[string]int{}
+	data_start := 128 + 4 + 12*len(tags)
+
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	for _, tag := range tags {
+		// Tags with the same data, the curves, share it
+		offset, ok := offsets[string(tag.data)]
+		if !ok {
+			offset = data_start + data.Len()
+			offsets[string(tag.data)] = offset
+			data.Write(tag.data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+
+		table.WriteString(tag.signature)
+		binary.Write(&table, binary.BigEndian, uint32(offset))
+		binary.Write(&table, binary.BigEndian, uint32(len(tag.data)))
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(data_start+data.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	for i, v := range []uint16{2024, 1, 1, 0, 0, 0} {
+		binary.BigEndian.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	copy(header[68:], iccXYZ(0.9642, 1.0, 0.8249)[8:]) // D50 illuminant
+
+	return append(append(header, table.Bytes()...), data.Bytes()...)
+})
+
+func iccS15Fixed16(v float64) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(int32(math.Round(v*65536))))
+}
+
+func iccXYZ(x, y, z float64) []byte {
+	data := []byte("XYZ \x00\x00\x00\x00")
+	for _, v := range []float64{x, y, z} {
+		data = append(data, iccS15Fixed16(v)...)
+	}
+	return data
+}
+
+// iccSRGBCurve samples the sRGB transfer function
+func iccSRGBCurve() []byte {
+	const points = 1024
+
+	data := []byte("curv\x00\x00\x00\x00")
+	data = binary.BigEndian.AppendUint32(data, points)
+	for i := 0; i < points; i++ {
+		x := float64(i) / (points - 1)
+		y := x / 12.92
+		if x > 0.04045 {
+			y = math.Pow((x+0.055)/1.055, 2.4)
+		}
+		data = binary.BigEndian.AppendUint16(data, uint16(math.Round(y*65535)))
+	}
+	return data
+}
+
+// iccDescription is a version 2 textDescriptionType without Unicode and
+// ScriptCode descriptions
+func iccDescription(text string) []byte {
+	data := []byte("desc\x00\x00\x00\x00")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(text)+1))
+	data = append(data, text...)
+	data = append(data, 0)
+	data = append(data, make([]byte, 4+4+2+1+67)...)
+	return data
+}
+
+func iccText(text string) []byte {
+	data := []byte("text\x00\x00\x00\x00")
+	data = append(data, text...)
+	return append(data, 0)
+}
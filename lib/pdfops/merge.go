@@ -0,0 +1,162 @@
+package pdfops
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Merge concatenates the PDFs read from pdfStreams, see MergeDocuments
+func Merge(ctx context.Context, pdfStreams ...io.Reader) ([]byte, error) {
+	docs := make([]*Document, len(pdfStreams))
+	for i, pdfStream := range pdfStreams {
+		pdfBytes, err := io.ReadAll(pdfStream)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pdf stream %d: %v", i+1, err)
+		}
+
+		docs[i], err = LoadBytes(pdfBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load PDF %d: %v", i+1, err)
+		}
+	}
+
+	merged, err := MergeDocuments(docs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge PDFs: %v", err)
+	}
+
+	mergedBytes, err := merged.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write merged PDF: %v", err)
+	}
+
+	return mergedBytes, nil
+}
+
+// MergeDocuments returns a document with the pages of docs in order. The
+// bookmarks of every document become top level bookmarks of the result,
+// links keep pointing to their pages and form fields are combined, fields
+// with a name already taken get the number of their document appended.
+// Signatures of the inputs are removed as merging invalidates them, sign the
+// merged document instead. The Info dictionary is the one of the first
+// document. docs are modified and cannot be used afterwards.
+func MergeDocuments(docs ...*Document) (*Document, error) {
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no documents to merge")
+	}
+
+	merged := &Document{
+		Version: "1.4",
+		Objects: map[uint32]Object{},
+		Trailer: Dict{},
+	}
+
+	var pages, bookmarks []Ref
+	var fields Array
+	field_names := map[string]bool{}
+	acro_form := Dict{}
+
+	for i, doc := range docs {
+		if doc.Catalog() == nil {
+			return nil, fmt.Errorf("document %d has no catalog", i+1)
+		}
+		doc.resolveNamedDestinations()
+		doc.inheritPageAttributes()
+		doc.removeStructure()
+
+		// Renumber the objects after the ones of the documents before
+		next := merged.maxID() + 1
+		mapping := map[uint32]uint32{}
+		for _, id := range doc.sortedIDs() {
+			mapping[id] = next
+			next++
+		}
+		for id, object := range doc.Objects {
+			merged.Objects[mapping[id]] = copyObject(object, mapping)
+		}
+		remap := func(object Object) Object {
+			return copyObject(object, mapping)
+		}
+
+		if merged.Version < doc.Version {
+			merged.Version = doc.Version
+		}
+		if i == 0 {
+			merged.Trailer["Info"] = remap(doc.Trailer["Info"])
+		}
+
+		for _, page := range doc.Pages() {
+			pages = append(pages, remap(page).(Ref))
+		}
+		for _, item := range doc.outlineItems() {
+			bookmarks = append(bookmarks, remap(item).(Ref))
+		}
+
+		form := merged.GetDict(remap(doc.Catalog()["AcroForm"]))
+		doc_fields, _ := merged.Get(form["Fields"]).(Array)
+		for _, field := range doc_fields {
+			field_dict := merged.GetDict(field)
+			if field_dict == nil {
+				continue
+			}
+			title, _ := merged.Get(field_dict["T"]).(String)
+			name := decodeText(title)
+			if field_names[name] {
+				name = fmt.Sprintf("%s_%d", name, i+1)
+				field_dict["T"] = textString(name)
+			}
+			field_names[name] = true
+			fields = append(fields, field)
+		}
+		for _, key := range []Name{"DA", "DR", "Q"} {
+			if _, ok := acro_form[key]; !ok && form[key] != nil {
+				acro_form[key] = form[key]
+			}
+		}
+	}
+
+	catalog := Dict{"Type": Name("Catalog")}
+	merged.Trailer["Root"] = merged.Add(catalog)
+	merged.setPages(pages)
+
+	if len(bookmarks) > 0 {
+		outlines := merged.Add(Dict{"Type": Name("Outlines")})
+		merged.GetDict(outlines)["Count"] = merged.linkOutline(outlines, bookmarks)
+		catalog["Outlines"] = outlines
+	}
+	if len(fields) > 0 {
+		acro_form["Fields"] = fields
+		catalog["AcroForm"] = acro_form
+	}
+
+	merged.removeUnreferenced()
+
+	return merged, nil
+}
+
+// copyObject deep copies object, replacing references by mapping
+func copyObject(object Object, mapping map[uint32]uint32) Object {
+	switch o := object.(type) {
+	case Ref:
+		if id, ok := mapping[uint32(o)]; ok {
+			return Ref(id)
+		}
+		return nil
+	case Dict:
+		dict := make(Dict, len(o))
+		for key, value := range o {
+			dict[key] = copyObject(value, mapping)
+		}
+		return dict
+	case Array:
+		array := make(Array, len(o))
+		for i, value := range o {
+			array[i] = copyObject(value, mapping)
+		}
+		return array
+	case *Stream:
+		return &Stream{Dict: copyObject(o.Dict, mapping).(Dict), Data: o.Data}
+	}
+	return object
+}
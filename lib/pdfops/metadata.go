@@ -0,0 +1,349 @@
+package pdfops
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Metadata are the document properties written to the Info dictionary and
+// the XMP metadata. Empty fields keep the values the document has.
+type Metadata struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+	Creator  string // the application the document was created with
+	Producer string // the application that produced the PDF
+	// Custom are additional properties, e.g. a customer id to index the
+	// document by. Keys must be valid XML names.
+	Custom map[string]string
+}
+
+// standardInfoKeys are the Info entries defined by ISO 32000, they cannot be
+// set as custom properties
+var standardInfoKeys = map[Name]bool{
+	"Title": true, "Author": true, "Subject": true, "Keywords": true, "Creator": true,
+	"Producer": true, "CreationDate": true, "ModDate": true, "Trapped": true,
+}
+
+// customKeyPattern matches the keys that are both PDF names and XML element names
+var customKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// SetMetadata rewrites the PDF read from pdfStream with metadata, see
+// Document.SetMetadata
+func SetMetadata(ctx context.Context, pdfStream io.Reader, metadata Metadata) ([]byte, error) {
+	pdfBytes, err := io.ReadAll(pdfStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	doc, err := LoadBytes(pdfBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load PDF: %v", err)
+	}
+
+	if err := doc.SetMetadata(metadata); err != nil {
+		return nil, fmt.Errorf("failed to set metadata: %v", err)
+	}
+
+	updated, err := doc.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write PDF: %v", err)
+	}
+
+	return updated, nil
+}
+
+// SetMetadata writes metadata to the Info dictionary, updates the
+// modification date and regenerates the XMP metadata from it
+func (d *Document) SetMetadata(metadata Metadata) error {
+	for key := range metadata.Custom {
+		if !customKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid custom metadata key %q", key)
+		}
+		if standardInfoKeys[Name(key)] {
+			return fmt.Errorf("custom metadata key %q is a standard property", key)
+		}
+	}
+
+	info := d.Info()
+	for key, value := range map[Name]string{
+		"Title":    metadata.Title,
+		"Author":   metadata.Author,
+		"Subject":  metadata.Subject,
+		"Keywords": metadata.Keywords,
+		"Creator":  metadata.Creator,
+		"Producer": metadata.Producer,
+	} {
+		if value != "" {
+			info[key] = textString(value)
+		}
+	}
+	for key, value := range metadata.Custom {
+		info[Name(key)] = textString(value)
+	}
+	info["ModDate"] = formatDate(time.Now())
+
+	return d.updateXMP()
+}
+
+// Info returns the document information dictionary, adding an empty one
+// when the document has none
+func (d *Document) Info() Dict {
+	info := d.GetDict(d.Trailer["Info"])
+	if info == nil {
+		info = Dict{}
+		d.Trailer["Info"] = d.Add(info)
+	}
+	return info
+}
+
+// updateXMP writes the XMP metadata stream of the catalog from the Info
+// dictionary, so both carry the same values as PDF/A requires
+func (d *Document) updateXMP() error {
+	catalog := d.Catalog()
+	if catalog == nil {
+		return fmt.Errorf("the document has no catalog")
+	}
+
+	packet, err := d.xmpPacket()
+	if err != nil {
+		return err
+	}
+
+	// The metadata stream stays unfiltered so it can be read without PDF support
+	stream := &Stream{
+		Dict: Dict{"Type": Name("Metadata"), "Subtype": Name("XML")},
+		Data: packet,
+	}
+	if ref, ok := catalog["Metadata"].(Ref); ok {
+		d.Objects[uint32(ref)] = stream
+	} else {
+		catalog["Metadata"] = d.Add(stream)
+	}
+
+	return nil
+}
+
+// pdfaIdentification returns the PDF/A part and conformance the XMP metadata
+// of the document claims, empty when it claims none
+func (d *Document) pdfaIdentification() (string, string) {
+	stream, ok := d.Get(d.Catalog()["Metadata"]).(*Stream)
+	if !ok || stream.Dict["Filter"] != nil {
+		return "", ""
+	}
+
+	part := regexp.MustCompile(`pdfaid:part(?:="|>)(\d)`).FindSubmatch(stream.Data)
+	conformance := regexp.MustCompile(`pdfaid:conformance(?:="|>)([ABUabu])`).FindSubmatch(stream.Data)
+	if part == nil || conformance == nil {
+		return "", ""
+	}
+
+	return string(part[1]), strings.ToUpper(string(conformance[1]))
+}
+
+// xmpPacket builds the XMP packet with the Info entries and the PDF/A
+// identification of the document
+func (d *Document) xmpPacket() ([]byte, error) {
+	info := d.GetDict(d.Trailer["Info"])
+	part, conformance := d.pdfaIdentification()
+	if d.pdfa != "" {
+		part, conformance = string(d.pdfa[0]), strings.ToUpper(string(d.pdfa[1]))
+	}
+
+	text := func(key Name) string {
+		value, _ := d.Get(info[key]).(String)
+		return decodeText(value)
+	}
+	date := func(key Name) string {
+		value, _ := d.Get(info[key]).(String)
+		t, err := parseDate(string(value))
+		if err != nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	var description bytes.Buffer
+	element := func(name, value string) {
+		if value == "" {
+			return
+		}
+		fmt.Fprintf(&description, "      <%s>", name)
+		xml.EscapeText(&description, []byte(value))
+		fmt.Fprintf(&description, "</%s>\n", name)
+	}
+	alternative := func(name, value string) {
+		if value == "" {
+			return
+		}
+		fmt.Fprintf(&description, "      <%s><rdf:Alt><rdf:li xml:lang=\"x-default\">", name)
+		xml.EscapeText(&description, []byte(value))
+		fmt.Fprintf(&description, "</rdf:li></rdf:Alt></%s>\n", name)
+	}
+
+	alternative("dc:title", text("Title"))
+	if author := text("Author"); author != "" {
+		description.WriteString("      <dc:creator><rdf:Seq><rdf:li>")
+		xml.EscapeText(&description, []byte(author))
+		description.WriteString("</rdf:li></rdf:Seq></dc:creator>\n")
+	}
+	alternative("dc:description", text("Subject"))
+	element("pdf:Keywords", text("Keywords"))
+	element("pdf:Producer", text("Producer"))
+	element("xmp:CreatorTool", text("Creator"))
+	element("xmp:CreateDate", date("CreationDate"))
+	element("xmp:ModifyDate", date("ModDate"))
+	element("xmp:MetadataDate", date("ModDate"))
+	if part != "" {
+		element("pdfaid:part", part)
+		element("pdfaid:conformance", conformance)
+	}
+
+	// Custom Info entries go to the namespace Acrobat uses for them
+	var custom []string
+	for key := range info {
+		if _, ok := d.Get(info[key]).(String); ok && !standardInfoKeys[key] && customKeyPattern.MatchString(string(key)) {
+			custom = append(custom, string(key))
+		}
+	}
+	sort.Strings(custom)
+	for _, key := range custom {
+		element("pdfx:"+key, text(Name(key)))
+	}
+
+	var packet bytes.Buffer
+	packet.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	packet.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	packet.WriteString("  <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	packet.WriteString("    <rdf:Description rdf:about=\"\"\n")
+	packet.WriteString("        xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	packet.WriteString("        xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\"\n")
+	packet.WriteString("        xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n")
+	packet.WriteString("        xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\"\n")
+	packet.WriteString("        xmlns:pdfx=\"http://ns.adobe.com/pdfx/1.3/\">\n")
+	packet.Write(description.Bytes())
+	packet.WriteString("    </rdf:Description>\n")
+	if part != "" && len(custom) > 0 {
+		writeExtensionSchema(&packet, custom)
+	}
+	packet.WriteString("  </rdf:RDF>\n")
+	packet.WriteString("</x:xmpmeta>\n")
+	// Padding lets editors update the packet in place
+	packet.WriteString(strings.Repeat(strings.Repeat(" ", 99)+"\n", 20))
+	packet.WriteString("<?xpacket end=\"w\"?>")
+
+	return packet.Bytes(), nil
+}
+
+// writeExtensionSchema describes the custom properties, PDF/A only allows
+// properties outside the predefined schemas when they are described
+func writeExtensionSchema(packet *bytes.Buffer, custom []string) {
+	packet.WriteString("    <rdf:Description rdf:about=\"\"\n")
+	packet.WriteString("        xmlns:pdfaExtension=\"http://www.aiim.org/pdfa/ns/extension/\"\n")
+	packet.WriteString("        xmlns:pdfaSchema=\"http://www.aiim.org/pdfa/ns/schema#\"\n")
+	packet.WriteString("        xmlns:pdfaProperty=\"http://www.aiim.org/pdfa/ns/property#\">\n")
+	packet.WriteString("      <pdfaExtension:schemas><rdf:Bag><rdf:li rdf:parseType=\"Resource\">\n")
+	packet.WriteString("        <pdfaSchema:schema>Custom document properties</pdfaSchema:schema>\n")
+	packet.WriteString("        <pdfaSchema:namespaceURI>http://ns.adobe.com/pdfx/1.3/</pdfaSchema:namespaceURI>\n")
+	packet.WriteString("        <pdfaSchema:prefix>pdfx</pdfaSchema:prefix>\n")
+	packet.WriteString("        <pdfaSchema:property><rdf:Seq>\n")
+	for _, key := range custom {
+		fmt.Fprintf(packet, "          <rdf:li rdf:parseType=\"Resource\"><pdfaProperty:name>%s</pdfaProperty:name>"+
+			"<pdfaProperty:valueType>Text</pdfaProperty:valueType><pdfaProperty:category>external</pdfaProperty:category>"+
+			"<pdfaProperty:description>Custom document property</pdfaProperty:description></rdf:li>\n", key)
+	}
+	packet.WriteString("        </rdf:Seq></pdfaSchema:property>\n")
+	packet.WriteString("      </rdf:li></rdf:Bag></pdfaExtension:schemas>\n")
+	packet.WriteString("    </rdf:Description>\n")
+}
+
+// textString encodes s as a PDF text string, PDFDocEncoding for ASCII and
+// UTF-16BE with a byte order mark otherwise
+func textString(s string) String {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] > '~' {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return String(s)
+	}
+
+	encoded := []byte{0xfe, 0xff}
+	for _, u := range utf16.Encode([]rune(s)) {
+		encoded = append(encoded, byte(u>>8), byte(u))
+	}
+	return String(encoded)
+}
+
+// decodeText decodes a PDF text string, bytes outside ASCII in
+// PDFDocEncoding are read as Latin-1
+func decodeText(s String) string {
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		units := make([]uint16, 0, len(s)/2-1)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
+
+// formatDate formats t as a PDF date
+func formatDate(t time.Time) String {
+	_, offset := t.Zone()
+	if offset == 0 {
+		return String(t.Format("D:20060102150405") + "Z")
+	}
+
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return String(fmt.Sprintf("%s%c%02d'%02d'", t.Format("D:20060102150405"), sign, offset/3600, offset%3600/60))
+}
+
+// parseDate parses a PDF date, D:YYYYMMDDHHmmSSOHH'mm' where everything
+// after the year is optional
+func parseDate(date string) (time.Time, error) {
+	value := strings.TrimPrefix(date, "D:")
+	value = strings.ReplaceAll(value, "'", "")
+
+	digits := 0
+	for digits < len(value) && digits < 14 && value[digits] >= '0' && value[digits] <= '9' {
+		digits++
+	}
+	if digits < 4 || digits%2 != 0 {
+		return time.Time{}, fmt.Errorf("invalid PDF date %q", date)
+	}
+
+	// Fill in the defaults of the omitted fields
+	timestamp := value[:digits] + "0101000000"[digits-4:]
+	zone := value[digits:]
+
+	layout := "20060102150405"
+	switch {
+	case zone == "" || zone[0] == 'Z':
+		return time.ParseInLocation(layout, timestamp, time.UTC)
+	case len(zone) == 3:
+		zone += "00"
+	}
+
+	return time.Parse(layout+"-0700", timestamp+zone)
+}
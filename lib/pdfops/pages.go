@@ -0,0 +1,291 @@
+package pdfops
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// inheritableAttributes are the page attributes a page can inherit from the
+// nodes of the page tree above it
+var inheritableAttributes = []Name{"Resources", "MediaBox", "CropBox", "Rotate"}
+
+// ParsePageRange parses a selection of 1-based page numbers like "1-3,5,8-"
+// of a document with pageCount pages, "8-" selecting page 8 to the last one
+func ParsePageRange(spec string, pageCount int) ([]int, error) {
+	var pages []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("invalid page range %q", spec)
+		}
+
+		from_text, to_text, is_range := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(from_text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid page range %q", spec)
+		}
+		to := from
+		if is_range {
+			to = pageCount
+			if to_text = strings.TrimSpace(to_text); to_text != "" {
+				if to, err = strconv.Atoi(to_text); err != nil {
+					return nil, fmt.Errorf("invalid page range %q", spec)
+				}
+			}
+		}
+
+		if from < 1 || to > pageCount || from > to {
+			return nil, fmt.Errorf("page range %q is outside the %d pages of the document", part, pageCount)
+		}
+		for page := from; page <= to; page++ {
+			pages = append(pages, page)
+		}
+	}
+
+	return pages, nil
+}
+
+// setPages replaces the page tree with a single node holding pages, which
+// must have their inherited attributes pushed down
+func (d *Document) setPages(pages []Ref) {
+	root := d.Add(Dict{})
+	for _, page := range pages {
+		d.GetDict(page)["Parent"] = root
+	}
+
+	kids := make(Array, len(pages))
+	for i, page := range pages {
+		kids[i] = page
+	}
+	d.Objects[uint32(root)] = Dict{
+		"Type":  Name("Pages"),
+		"Kids":  kids,
+		"Count": int64(len(pages)),
+	}
+	d.Catalog()["Pages"] = root
+}
+
+// inheritPageAttributes copies the attributes pages inherit from the page
+// tree to the pages, so they can be moved to another tree
+func (d *Document) inheritPageAttributes() {
+	for _, page := range d.Pages() {
+		dict := d.GetDict(page)
+		visited := map[Ref]bool{page: true}
+		for parent, ok := dict["Parent"].(Ref); ok && !visited[parent]; parent, ok = d.GetDict(parent)["Parent"].(Ref) {
+			visited[parent] = true
+			node := d.GetDict(parent)
+			for _, key := range inheritableAttributes {
+				if _, set := dict[key]; !set && node[key] != nil {
+					dict[key] = node[key]
+				}
+			}
+		}
+	}
+}
+
+// walkDicts calls fn for every dictionary of the document, including the
+// ones nested in other objects
+func (d *Document) walkDicts(fn func(dict Dict)) {
+	var walk func(object Object)
+	walk = func(object Object) {
+		switch o := object.(type) {
+		case Dict:
+			fn(o)
+			for _, value := range o {
+				walk(value)
+			}
+		case Array:
+			for _, value := range o {
+				walk(value)
+			}
+		case *Stream:
+			walk(o.Dict)
+		}
+	}
+
+	for _, object := range d.Objects {
+		walk(object)
+	}
+	walk(d.Trailer)
+}
+
+// resolveNamedDestinations replaces destination names in links, bookmarks
+// and actions with the destinations they name and removes the name trees.
+// Explicit destinations keep working when pages are renumbered or moved to
+// another document, names could collide or point to removed pages.
+func (d *Document) resolveNamedDestinations() {
+	catalog := d.Catalog()
+	named := map[string]Object{}
+
+	if dests := d.GetDict(catalog["Dests"]); dests != nil {
+		for name, dest := range dests {
+			named[string(name)] = dest
+		}
+	}
+	names := d.GetDict(catalog["Names"])
+	if names != nil {
+		d.walkNameTree(names["Dests"], func(name String, dest Object) {
+			named[string(name)] = dest
+		})
+	}
+
+	resolve := func(dest Object) Object {
+		var name string
+		switch dest := d.Get(dest).(type) {
+		case Name:
+			name = string(dest)
+		case String:
+			name = string(dest)
+		default:
+			return dest
+		}
+
+		// A named destination is an array or a dictionary with the array as D
+		resolved := d.Get(named[name])
+		if dict, ok := resolved.(Dict); ok {
+			resolved = d.Get(dict["D"])
+		}
+		if _, ok := resolved.(Array); !ok {
+			return nil
+		}
+		return resolved
+	}
+
+	d.walkDicts(func(dict Dict) {
+		if dest, ok := dict["Dest"]; ok {
+			dict["Dest"] = resolve(dest)
+		}
+		if dict["S"] == Name("GoTo") {
+			dict["D"] = resolve(dict["D"])
+		}
+	})
+
+	delete(catalog, "Dests")
+	if names != nil {
+		delete(names, "Dests")
+	}
+}
+
+// walkNameTree calls fn for the entries of the name tree rooted at node
+func (d *Document) walkNameTree(node Object, fn func(name String, value Object)) {
+	visited := map[Ref]bool{}
+
+	var walk func(node Object)
+	walk = func(node Object) {
+		if ref, ok := node.(Ref); ok {
+			if visited[ref] {
+				return
+			}
+			visited[ref] = true
+		}
+
+		dict := d.GetDict(node)
+		names, _ := d.Get(dict["Names"]).(Array)
+		for i := 0; i+1 < len(names); i += 2 {
+			if name, ok := d.Get(names[i]).(String); ok {
+				fn(name, names[i+1])
+			}
+		}
+
+		kids, _ := d.Get(dict["Kids"]).(Array)
+		for _, kid := range kids {
+			walk(kid)
+		}
+	}
+	walk(node)
+}
+
+// destinationPage returns the page an explicit destination points to
+func (d *Document) destinationPage(dest Object) (Ref, bool) {
+	array, ok := d.Get(dest).(Array)
+	if !ok || len(array) == 0 {
+		return 0, false
+	}
+	page, ok := array[0].(Ref)
+	return page, ok
+}
+
+// removeStructure drops what ties the document to its own pages and cannot
+// be carried over when pages are merged or extracted: the structure tree of
+// tagged documents, article threads and the values of signatures, which are
+// invalid once the document changes
+func (d *Document) removeStructure() {
+	catalog := d.Catalog()
+	for _, key := range []Name{"StructTreeRoot", "MarkInfo", "Threads", "Perms"} {
+		delete(catalog, key)
+	}
+
+	for _, page := range d.Pages() {
+		dict := d.GetDict(page)
+		delete(dict, "StructParents")
+		delete(dict, "B")
+	}
+
+	d.walkDicts(func(dict Dict) {
+		if d.Get(dict["FT"]) == Name("Sig") {
+			delete(dict, "V")
+			delete(dict, "Lock")
+		}
+	})
+	if acro_form := d.GetDict(catalog["AcroForm"]); acro_form != nil {
+		delete(acro_form, "SigFlags")
+	}
+}
+
+// outlineItems returns the top level bookmarks of the document
+func (d *Document) outlineItems() []Ref {
+	var items []Ref
+	visited := map[Ref]bool{}
+
+	outlines := d.GetDict(d.Catalog()["Outlines"])
+	for item, ok := outlines["First"].(Ref); ok && !visited[item]; item, ok = d.GetDict(item)["Next"].(Ref) {
+		visited[item] = true
+		items = append(items, item)
+	}
+	return items
+}
+
+// linkOutline makes items the children of parent, returning the number of
+// items visible when parent is open
+func (d *Document) linkOutline(parent Ref, items []Ref) int64 {
+	parent_dict := d.GetDict(parent)
+	delete(parent_dict, "First")
+	delete(parent_dict, "Last")
+	if len(items) == 0 {
+		return 0
+	}
+
+	count := int64(len(items))
+	for i, item := range items {
+		dict := d.GetDict(item)
+		dict["Parent"] = parent
+		delete(dict, "Prev")
+		delete(dict, "Next")
+		if i > 0 {
+			dict["Prev"] = items[i-1]
+		}
+		if i < len(items)-1 {
+			dict["Next"] = items[i+1]
+		}
+		// Open items show their children, closed ones have a negative count
+		if open, _ := d.Get(dict["Count"]).(int64); open > 0 {
+			count += open
+		}
+	}
+	parent_dict["First"] = items[0]
+	parent_dict["Last"] = items[len(items)-1]
+
+	return count
+}
+
+// sortedIDs returns the object numbers of the document in ascending order
+func (d *Document) sortedIDs() []uint32 {
+	ids := make([]uint32, 0, len(d.Objects))
+	for id := range d.Objects {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
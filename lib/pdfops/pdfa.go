@@ -0,0 +1,312 @@
+package pdfops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PDFA is a PDF/A conformance level
+type PDFA string
+
+const (
+	PDFA2B PDFA = "2b"
+	PDFA3B PDFA = "3b"
+)
+
+// srgbOutputCondition identifies the sRGB output intent
+const srgbOutputCondition = "sRGB IEC61966-2.1"
+
+// allowedActions are the action types PDF/A-2 permits, named actions only
+// for page navigation
+var (
+	allowedActions      = map[Name]bool{"GoTo": true, "GoToR": true, "GoToE": true, "Thread": true, "URI": true, "Named": true, "SubmitForm": true}
+	allowedNamedActions = map[Name]bool{"NextPage": true, "PrevPage": true, "FirstPage": true, "LastPage": true}
+	// forbiddenAnnotations are removed, file attachments only for PDF/A-2
+	forbiddenAnnotations = map[Name]bool{"Sound": true, "Movie": true, "Screen": true, "3D": true, "RichMedia": true}
+)
+
+// Annotation flags changed for PDF/A
+const (
+	annotationFlagInvisible = 1 << 0
+	annotationFlagHidden    = 1 << 1
+	annotationFlagPrint     = 1 << 2
+	annotationFlagNoView    = 1 << 5
+)
+
+// ConvertToPDFA rewrites the PDF read from pdfStream to conform to level,
+// see Document.ConvertToPDFA. The result can be signed with lib/signer.
+func ConvertToPDFA(ctx context.Context, pdfStream io.Reader, level PDFA) ([]byte, error) {
+	pdfBytes, err := io.ReadAll(pdfStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	doc, err := LoadBytes(pdfBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load PDF: %v", err)
+	}
+
+	if err := doc.ConvertToPDFA(level); err != nil {
+		return nil, fmt.Errorf("failed to convert to PDF/A-%s: %v", level, err)
+	}
+
+	converted, err := doc.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write PDF/A: %v", err)
+	}
+
+	return converted, nil
+}
+
+// ConvertToPDFA makes the document conform to PDF/A-2b or PDF/A-3b: it adds
+// an sRGB output intent, XMP metadata matching the Info dictionary and a file
+// identifier, and removes what PDF/A forbids, like JavaScript, actions other
+// than navigation, multimedia annotations, interpolation and transfer
+// functions. Fonts have to be embedded and streams must not use LZW, which
+// cannot be fixed and is reported as an error. PDF/A-2b drops embedded
+// files, PDF/A-3b keeps them as associated files.
+func (d *Document) ConvertToPDFA(level PDFA) error {
+	if level != PDFA2B && level != PDFA3B {
+		return fmt.Errorf("unsupported PDF/A level %q, expected %s or %s", level, PDFA2B, PDFA3B)
+	}
+	if d.Encryption != nil {
+		return fmt.Errorf("PDF/A documents cannot be encrypted")
+	}
+
+	catalog := d.Catalog()
+	if catalog == nil {
+		return fmt.Errorf("the document has no catalog")
+	}
+
+	for _, object := range d.Objects {
+		if stream, ok := object.(*Stream); ok && d.hasFilter(stream, "LZWDecode") {
+			return fmt.Errorf("LZW compressed streams are not allowed")
+		}
+	}
+
+	// Resources can be direct objects, like the font of a text watermark
+	unembedded := map[string]bool{}
+	d.walkDicts(func(dict Dict) {
+		switch {
+		case d.Get(dict["Type"]) == Name("Font"):
+			if !d.fontEmbedded(dict) {
+				base_font, _ := d.Get(dict["BaseFont"]).(Name)
+				unembedded[string(base_font)] = true
+			}
+		case d.Get(dict["Subtype"]) == Name("Image"):
+			if dict["Interpolate"] != nil {
+				dict["Interpolate"] = false
+			}
+			delete(dict, "Alternates")
+			delete(dict, "OPI")
+		case d.Get(dict["Subtype"]) == Name("Form"):
+			delete(dict, "OPI")
+			delete(dict, "PS")
+		case d.Get(dict["Type"]) == Name("ExtGState"):
+			delete(dict, "TR")
+			if d.Get(dict["TR2"]) != Name("Default") {
+				delete(dict, "TR2")
+			}
+		case d.Get(dict["Type"]) == Name("Page"):
+			delete(dict, "AA")
+		}
+	})
+	if len(unembedded) > 0 {
+		fonts := make([]string, 0, len(unembedded))
+		for font := range unembedded {
+			fonts = append(fonts, font)
+		}
+		sort.Strings(fonts)
+		return fmt.Errorf("fonts must be embedded: %s", strings.Join(fonts, ", "))
+	}
+
+	for _, page := range d.Pages() {
+		d.cleanAnnotations(d.GetDict(page), level)
+	}
+
+	delete(catalog, "AA")
+	delete(catalog, "NeedsRendering")
+	if !d.allowedAction(catalog["OpenAction"]) {
+		delete(catalog, "OpenAction")
+	}
+	if acro_form := d.GetDict(catalog["AcroForm"]); acro_form != nil {
+		delete(acro_form, "XFA")
+		delete(acro_form, "NeedAppearances")
+	}
+	if names := d.GetDict(catalog["Names"]); names != nil {
+		delete(names, "JavaScript")
+		if level == PDFA2B {
+			delete(names, "EmbeddedFiles")
+		} else {
+			d.associateEmbeddedFiles(catalog, names["EmbeddedFiles"])
+		}
+	}
+
+	catalog["OutputIntents"] = Array{Dict{
+		"Type":                      Name("OutputIntent"),
+		"S":                         Name("GTS_PDFA1"),
+		"OutputConditionIdentifier": String(srgbOutputCondition),
+		"Info":                      String(srgbOutputCondition),
+		"RegistryName":              String("http://www.color.org"),
+		"DestOutputProfile": d.Add(&Stream{
+			Dict: Dict{"N": int64(3)},
+			Data: srgbProfile(),
+		}),
+	}}
+
+	if err := d.ensureID(); err != nil {
+		return err
+	}
+
+	// The XMP dates are derived from these, both have to match
+	info := d.Info()
+	now := formatDate(time.Now())
+	if creation_date, _ := d.Get(info["CreationDate"]).(String); creation_date == "" {
+		info["CreationDate"] = now
+	} else if _, err := parseDate(string(creation_date)); err != nil {
+		info["CreationDate"] = now
+	}
+	info["ModDate"] = now
+
+	// PDF/A-2 and 3 are based on PDF 1.7
+	if d.Version > "1.7" {
+		d.Version = "1.7"
+	}
+
+	d.pdfa = level
+	if err := d.updateXMP(); err != nil {
+		return err
+	}
+
+	d.removeUnreferenced()
+
+	return nil
+}
+
+// cleanAnnotations removes forbidden annotations and actions of page and
+// makes the remaining annotations printable
+func (d *Document) cleanAnnotations(page Dict, level PDFA) {
+	annotations, ok := d.Get(page["Annots"]).(Array)
+	if !ok {
+		return
+	}
+
+	kept := Array{}
+	for _, reference := range annotations {
+		annotation := d.GetDict(reference)
+		if annotation == nil {
+			continue
+		}
+
+		subtype, _ := d.Get(annotation["Subtype"]).(Name)
+		if forbiddenAnnotations[subtype] || (level == PDFA2B && subtype == "FileAttachment") {
+			continue
+		}
+
+		delete(annotation, "AA")
+		if !d.allowedAction(annotation["A"]) {
+			delete(annotation, "A")
+		}
+		if subtype != "Popup" {
+			flags, _ := d.Get(annotation["F"]).(int64)
+			annotation["F"] = flags&^(annotationFlagInvisible|annotationFlagHidden|annotationFlagNoView) | annotationFlagPrint
+		}
+
+		kept = append(kept, reference)
+	}
+
+	if ref, ok := page["Annots"].(Ref); ok {
+		d.Objects[uint32(ref)] = kept
+	} else {
+		page["Annots"] = kept
+	}
+}
+
+// allowedAction reports whether the action PDF/A permits, dropping a
+// forbidden action that follows it
+func (d *Document) allowedAction(object Object) bool {
+	action := d.GetDict(object)
+	if action == nil {
+		return true
+	}
+
+	action_type, _ := d.Get(action["S"]).(Name)
+	if !allowedActions[action_type] {
+		return false
+	}
+	if action_type == "Named" {
+		named, _ := d.Get(action["N"]).(Name)
+		if !allowedNamedActions[named] {
+			return false
+		}
+	}
+
+	if !d.allowedAction(action["Next"]) {
+		delete(action, "Next")
+	}
+
+	return true
+}
+
+// fontEmbedded reports whether the program of font is embedded, Type 3
+// fonts are defined in the document itself
+func (d *Document) fontEmbedded(font Dict) bool {
+	switch d.Get(font["Subtype"]) {
+	case Name("Type3"):
+		return true
+	case Name("Type0"):
+		descendants, _ := d.Get(font["DescendantFonts"]).(Array)
+		if len(descendants) == 0 {
+			return false
+		}
+		return d.fontEmbedded(d.GetDict(descendants[0]))
+	}
+
+	descriptor := d.GetDict(font["FontDescriptor"])
+	for _, key := range []Name{"FontFile", "FontFile2", "FontFile3"} {
+		if descriptor[key] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFilter reports whether stream is encoded with filter
+func (d *Document) hasFilter(stream *Stream, filter Name) bool {
+	switch filters := d.Get(stream.Dict["Filter"]).(type) {
+	case Name:
+		return filters == filter
+	case Array:
+		for _, f := range filters {
+			if d.Get(f) == filter {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// associateEmbeddedFiles declares the files of the EmbeddedFiles name tree
+// as associated files of the document, which PDF/A-3 requires
+func (d *Document) associateEmbeddedFiles(catalog Dict, tree Object) {
+	associated, _ := d.Get(catalog["AF"]).(Array)
+
+	d.walkNameTree(tree, func(name String, value Object) {
+		file_spec := d.GetDict(value)
+		if file_spec == nil {
+			return
+		}
+		if file_spec["AFRelationship"] == nil {
+			file_spec["AFRelationship"] = Name("Unspecified")
+		}
+		associated = append(associated, value)
+	})
+
+	if len(associated) > 0 {
+		catalog["AF"] = associated
+	}
+}
@@ -0,0 +1,884 @@
+package pdfops
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math/big"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/digitorus/pdf"
+	"github.com/rchougule/espresso/lib/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testContent = "BT /F1 24 Tf 72 700 Td (Hello World) Tj ET"
+
+func TestLoadWrite(t *testing.T) {
+	doc, err := LoadBytes(getTestPDF(t))
+	require.NoError(t, err)
+
+	catalog := doc.Catalog()
+	require.NotNil(t, catalog)
+	assert.Equal(t, Name("Catalog"), catalog["Type"])
+
+	written, err := doc.Bytes()
+	require.NoError(t, err)
+
+	rdr, err := pdf.NewReader(bytes.NewReader(written), int64(len(written)))
+	require.NoError(t, err)
+	assert.Equal(t, 1, rdr.NumPage())
+	assert.Equal(t, "Test (Document)", rdr.Trailer().Key("Info").Key("Title").Text())
+
+	content := new(bytes.Buffer)
+	_, err = content.ReadFrom(rdr.Page(1).V.Key("Contents").Reader())
+	require.NoError(t, err)
+	assert.Equal(t, testContent, content.String())
+
+	_, err = LoadBytes([]byte("not a pdf"))
+	assert.Error(t, err)
+}
+
+func TestEncrypt(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		encryption    Encryption
+		wantErr       bool
+		wantPasswords []string
+	}{
+		{
+			name:          "user_and_owner_password",
+			encryption:    Encryption{UserPassword: "01011990", OwnerPassword: "owner", Permissions: PermissionPrint},
+			wantPasswords: []string{"01011990", "owner"},
+		},
+		{
+			name:          "permissions_only",
+			encryption:    Encryption{Permissions: PermissionPrint | PermissionCopy},
+			wantPasswords: []string{""},
+		},
+		{
+			name:       "unknown_permission",
+			encryption: Encryption{UserPassword: "secret", Permissions: 1 << 20},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := Encrypt(ctx, bytes.NewReader(getTestPDF(t)), tt.encryption)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.NotContains(t, string(encrypted), "Hello World")
+			assert.NotContains(t, string(encrypted), "Test (Document)")
+
+			entries := encryptionEntries(t, encrypted)
+			assert.Len(t, entries["U"], 48)
+			assert.Len(t, entries["O"], 48)
+
+			for _, password := range tt.wantPasswords {
+				key := fileKey(t, entries, password)
+				require.NotNil(t, key, "password %q must open the document", password)
+
+				// Perms holds P and the "adb" marker encrypted with the file key
+				block, err := aes.NewCipher(key)
+				require.NoError(t, err)
+				perms := make([]byte, 16)
+				block.Decrypt(perms, entries["Perms"])
+				assert.Equal(t, "adb", string(perms[9:12]))
+				assert.Equal(t, uint32(tt.encryption.Permissions), binary.LittleEndian.Uint32(perms)&uint32(PermissionAll))
+
+				assert.Equal(t, testContent, string(decryptStream(t, key, encrypted, 4)))
+			}
+			assert.Nil(t, fileKey(t, entries, "wrong"))
+
+			_, err = LoadBytes(encrypted)
+			assert.Error(t, err, "encrypted documents cannot be loaded again")
+		})
+	}
+}
+
+func TestConvertToPDFA(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		level   PDFA
+		modify  func(doc *Document)
+		wantErr string
+	}{
+		{
+			name:  "pdfa_2b",
+			level: PDFA2B,
+		},
+		{
+			name:  "pdfa_3b",
+			level: PDFA3B,
+		},
+		{
+			name:    "font_not_embedded",
+			level:   PDFA2B,
+			modify:  func(doc *Document) { delete(doc.GetDict(Ref(5)), "FontDescriptor") },
+			wantErr: "fonts must be embedded: Helvetica",
+		},
+		{
+			name:  "lzw_stream",
+			level: PDFA2B,
+			modify: func(doc *Document) {
+				doc.Objects[4].(*Stream).Dict["Filter"] = Name("LZWDecode")
+			},
+			wantErr: "LZW",
+		},
+		{
+			name:    "unknown_level",
+			level:   "1a",
+			wantErr: "unsupported PDF/A level",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := LoadBytes(getTestPDFA(t))
+			require.NoError(t, err)
+			if tt.modify != nil {
+				tt.modify(doc)
+			}
+			input, err := doc.Bytes()
+			require.NoError(t, err)
+
+			converted, err := ConvertToPDFA(ctx, bytes.NewReader(input), tt.level)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			doc, err = LoadBytes(converted)
+			require.NoError(t, err)
+			catalog := doc.Catalog()
+
+			intents, ok := doc.Get(catalog["OutputIntents"]).(Array)
+			require.True(t, ok)
+			require.Len(t, intents, 1)
+			intent := doc.GetDict(intents[0])
+			assert.Equal(t, Name("GTS_PDFA1"), intent["S"])
+			profile, ok := doc.Get(intent["DestOutputProfile"]).(*Stream)
+			require.True(t, ok)
+			assert.Equal(t, "acsp", string(profile.Data[36:40]))
+
+			metadata, ok := doc.Get(catalog["Metadata"]).(*Stream)
+			require.True(t, ok)
+			xmp := string(metadata.Data)
+			assert.Contains(t, xmp, "<pdfaid:part>"+string(tt.level[:1])+"</pdfaid:part>")
+			assert.Contains(t, xmp, "<pdfaid:conformance>B</pdfaid:conformance>")
+			assert.Contains(t, xmp, "<rdf:li xml:lang=\"x-default\">Test (Document)</rdf:li>")
+			assert.Contains(t, xmp, "<xmp:ModifyDate>")
+			assert.NotNil(t, doc.Trailer["ID"])
+
+			// JavaScript, the multimedia annotation and the forbidden action are gone
+			assert.False(t, bytes.Contains(converted, []byte("JavaScript")))
+			assert.False(t, bytes.Contains(converted, []byte("/Sound")))
+			assert.Nil(t, catalog["OpenAction"])
+			annotations := doc.Get(doc.GetDict(Ref(3))["Annots"]).(Array)
+			require.Len(t, annotations, 1)
+			link := doc.GetDict(annotations[0])
+			assert.Nil(t, link["A"])
+			assert.Equal(t, int64(annotationFlagPrint), link["F"])
+
+			// The result stays signable
+			cert, key := generateTestCertificate(t)
+			signed, err := signer.SignPdfStream(ctx, bytes.NewReader(converted), cert, key)
+			require.NoError(t, err)
+
+			roots := x509.NewCertPool()
+			roots.AddCert(cert)
+			report, err := signer.VerifyPdfStream(ctx, bytes.NewReader(signed), signer.VerifyOptions{Roots: roots})
+			require.NoError(t, err)
+			assert.True(t, report.Valid)
+		})
+	}
+
+	_, err := ConvertToPDFA(ctx, bytes.NewReader(getTestPDF(t)), PDFA2B)
+	assert.ErrorContains(t, err, "fonts must be embedded")
+
+	// The font of text watermarks is a direct object of the watermark form
+	watermarked, err := AddWatermarks(ctx, bytes.NewReader(getTestPDFA(t)), Watermark{Text: "DRAFT"})
+	require.NoError(t, err)
+	_, err = ConvertToPDFA(ctx, bytes.NewReader(watermarked), PDFA2B)
+	assert.ErrorContains(t, err, "fonts must be embedded: Helvetica")
+}
+
+func TestSetMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		metadata Metadata
+		pdfa     bool
+		wantErr  string
+	}{
+		{
+			name: "all_properties",
+			metadata: Metadata{
+				Title:    "Loan Agreement",
+				Author:   "Jürgen <Ops>",
+				Subject:  "Agreement",
+				Keywords: "loan, agreement",
+				Creator:  "espresso",
+				Producer: "espresso pdfops",
+				Custom:   map[string]string{"customer_id": "C-42", "document_type": "loan_agreement"},
+			},
+		},
+		{
+			name:     "pdfa_document",
+			metadata: Metadata{Custom: map[string]string{"customer_id": "C-42"}},
+			pdfa:     true,
+		},
+		{
+			name:     "invalid_custom_key",
+			metadata: Metadata{Custom: map[string]string{"customer id": "C-42"}},
+			wantErr:  "invalid custom metadata key",
+		},
+		{
+			name:     "standard_custom_key",
+			metadata: Metadata{Custom: map[string]string{"Title": "Other"}},
+			wantErr:  "standard property",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := getTestPDF(t)
+			if tt.pdfa {
+				converted, err := ConvertToPDFA(ctx, bytes.NewReader(getTestPDFA(t)), PDFA2B)
+				require.NoError(t, err)
+				input = converted
+			}
+
+			updated, err := SetMetadata(ctx, bytes.NewReader(input), tt.metadata)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			rdr, err := pdf.NewReader(bytes.NewReader(updated), int64(len(updated)))
+			require.NoError(t, err)
+			info := rdr.Trailer().Key("Info")
+			if tt.metadata.Title != "" {
+				assert.Equal(t, tt.metadata.Title, info.Key("Title").Text())
+				assert.Equal(t, tt.metadata.Author, info.Key("Author").Text())
+				assert.Equal(t, tt.metadata.Producer, info.Key("Producer").Text())
+			} else {
+				assert.Equal(t, "Test (Document)", info.Key("Title").Text())
+			}
+			assert.Equal(t, "C-42", info.Key("customer_id").Text())
+			assert.NotEmpty(t, info.Key("ModDate").Text())
+
+			doc, err := LoadBytes(updated)
+			require.NoError(t, err)
+			metadata, ok := doc.Get(doc.Catalog()["Metadata"]).(*Stream)
+			require.True(t, ok)
+			xmp := string(metadata.Data)
+			assert.Contains(t, xmp, "<pdfx:customer_id>C-42</pdfx:customer_id>")
+			if tt.pdfa {
+				assert.Contains(t, xmp, "<pdfaid:part>2</pdfaid:part>")
+				assert.Contains(t, xmp, "<pdfaProperty:name>customer_id</pdfaProperty:name>")
+			} else {
+				assert.Contains(t, xmp, "<rdf:li>Jürgen &lt;Ops&gt;</rdf:li>")
+				assert.Contains(t, xmp, "<pdf:Keywords>loan, agreement</pdf:Keywords>")
+				assert.NotContains(t, xmp, "<pdfaid:part>")
+				assert.NotContains(t, xmp, "pdfaExtension:schemas")
+			}
+		})
+	}
+}
+
+func TestPDFDate(t *testing.T) {
+	zone := time.FixedZone("", -(3*3600 + 30*60))
+	date := time.Date(2024, 3, 15, 10, 20, 30, 0, zone)
+
+	assert.Equal(t, String("D:20240315102030-03'30'"), formatDate(date))
+	assert.Equal(t, String("D:20240315135030Z"), formatDate(date.UTC()))
+
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "D:20240315102030-03'30'", want: date},
+		{value: "D:20240315135030Z", want: date},
+		{value: "D:2024", want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{value: "D:20240315102030+01", want: time.Date(2024, 3, 15, 9, 20, 30, 0, time.UTC)},
+		{value: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDate(tt.value)
+		if tt.wantErr {
+			assert.Error(t, err, tt.value)
+			continue
+		}
+		require.NoError(t, err, tt.value)
+		assert.True(t, tt.want.Equal(got), "%s: got %s", tt.value, got)
+	}
+
+	assert.Equal(t, "Grüße", decodeText(textString("Grüße")))
+	assert.Equal(t, String("plain"), textString("plain"))
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+
+	merged, err := Merge(ctx, bytes.NewReader(getTestMultiPagePDF(t, "A", 2)), bytes.NewReader(getTestMultiPagePDF(t, "B", 3)))
+	require.NoError(t, err)
+
+	rdr, err := pdf.NewReader(bytes.NewReader(merged), int64(len(merged)))
+	require.NoError(t, err)
+	require.Equal(t, 5, rdr.NumPage())
+	for i, want := range []string{"A 1", "A 2", "B 1", "B 2", "B 3"} {
+		page := rdr.Page(i + 1)
+		content := new(bytes.Buffer)
+		_, err = content.ReadFrom(page.V.Key("Contents").Reader())
+		require.NoError(t, err)
+		assert.Contains(t, content.String(), "("+want+")")
+		// Inherited from the page tree of the input
+		assert.Equal(t, 4, page.V.Key("MediaBox").Len())
+		assert.False(t, page.V.Key("Resources").Key("Font").IsNull())
+	}
+	assert.Equal(t, "Test A", rdr.Trailer().Key("Info").Key("Title").Text())
+
+	var titles []string
+	for _, item := range rdr.Outline().Child {
+		titles = append(titles, item.Title)
+	}
+	assert.Equal(t, []string{"A page 1", "A page 2", "B page 1", "B page 2", "B page 3"}, titles)
+
+	doc, err := LoadBytes(merged)
+	require.NoError(t, err)
+	pages := doc.Pages()
+
+	// The named destination of the link on the first page of B now points to its last page
+	link := doc.GetDict(doc.Get(doc.GetDict(pages[2])["Annots"]).(Array)[0])
+	target, ok := doc.destinationPage(doc.GetDict(link["A"])["D"])
+	require.True(t, ok)
+	assert.Equal(t, pages[4], target)
+
+	fields := doc.Get(doc.GetDict(doc.Catalog()["AcroForm"])["Fields"]).(Array)
+	require.Len(t, fields, 2)
+	assert.Equal(t, String("Signature1"), doc.GetDict(fields[0])["T"])
+	assert.Equal(t, String("Signature1_2"), doc.GetDict(fields[1])["T"])
+	assert.Nil(t, doc.GetDict(fields[1])["V"], "signatures are invalid after merging")
+
+	// The merged document can be signed as a whole
+	cert, key := generateTestCertificate(t)
+	signed, err := signer.SignPdfStream(ctx, bytes.NewReader(merged), cert, key)
+	require.NoError(t, err)
+	report, err := signer.VerifyPdfStream(ctx, bytes.NewReader(signed), signer.VerifyOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Signatures, 1)
+	assert.True(t, report.Signatures[0].ValidSignature)
+
+	_, err = Merge(ctx)
+	assert.Error(t, err)
+	_, err = Merge(ctx, bytes.NewReader([]byte("not a pdf")))
+	assert.Error(t, err)
+}
+
+func TestSplit(t *testing.T) {
+	ctx := context.Background()
+	input := getTestMultiPagePDF(t, "A", 3)
+
+	tests := []struct {
+		name       string
+		ranges     []string
+		wantPages  [][]string
+		wantTitles [][]string
+		wantFields []int
+		wantErr    bool
+	}{
+		{
+			name:       "ranges",
+			ranges:     []string{"1", "2-"},
+			wantPages:  [][]string{{"A 1"}, {"A 2", "A 3"}},
+			wantTitles: [][]string{{"A page 1"}, {"A page 2", "A page 3"}},
+			wantFields: []int{0, 1},
+		},
+		{
+			name:       "reordered",
+			ranges:     []string{"3,1"},
+			wantPages:  [][]string{{"A 3", "A 1"}},
+			wantTitles: [][]string{{"A page 1", "A page 3"}},
+			wantFields: []int{1},
+		},
+		{
+			name:       "every_page",
+			wantPages:  [][]string{{"A 1"}, {"A 2"}, {"A 3"}},
+			wantTitles: [][]string{{"A page 1"}, {"A page 2"}, {"A page 3"}},
+			wantFields: []int{0, 0, 1},
+		},
+		{
+			name:    "out_of_range",
+			ranges:  []string{"2-4"},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate_page",
+			ranges:  []string{"1,1"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := Split(ctx, bytes.NewReader(input), tt.ranges)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, parts, len(tt.wantPages))
+
+			for i, part := range parts {
+				rdr, err := pdf.NewReader(bytes.NewReader(part), int64(len(part)))
+				require.NoError(t, err)
+				require.Equal(t, len(tt.wantPages[i]), rdr.NumPage())
+				for j, want := range tt.wantPages[i] {
+					content := new(bytes.Buffer)
+					_, err = content.ReadFrom(rdr.Page(j + 1).V.Key("Contents").Reader())
+					require.NoError(t, err)
+					assert.Contains(t, content.String(), "("+want+")")
+				}
+
+				var titles []string
+				for _, item := range rdr.Outline().Child {
+					titles = append(titles, item.Title)
+				}
+				assert.Equal(t, tt.wantTitles[i], titles)
+
+				doc, err := LoadBytes(part)
+				require.NoError(t, err)
+				assert.Len(t, doc.Pages(), len(tt.wantPages[i]), "pages left out must not be written")
+
+				// The link on page 1 points to page 3
+				for _, page := range doc.Pages() {
+					annotations, _ := doc.Get(doc.GetDict(page)["Annots"]).(Array)
+					for _, annotation := range annotations {
+						link := doc.GetDict(annotation)
+						if link["Subtype"] != Name("Link") {
+							continue
+						}
+						if action := doc.GetDict(link["A"]); action != nil {
+							target, _ := doc.destinationPage(action["D"])
+							assert.Contains(t, doc.Pages(), target)
+						}
+					}
+				}
+
+				fields, _ := doc.Get(doc.GetDict(doc.Catalog()["AcroForm"])["Fields"]).(Array)
+				assert.Len(t, fields, tt.wantFields[i])
+			}
+		})
+	}
+}
+
+// pngHeader returns the start of a PNG of width x height pixels, enough for
+// image.DecodeConfig
+func pngHeader(width, height uint32) []byte {
+	chunk := binary.BigEndian.AppendUint32([]byte("IHDR"), width)
+	chunk = binary.BigEndian.AppendUint32(chunk, height)
+	chunk = append(chunk, 8, 2, 0, 0, 0)
+
+	header := append([]byte("\x89PNG\r\n\x1a\n"), 0, 0, 0, 13)
+	header = append(header, chunk...)
+	return binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(chunk))
+}
+
+func TestAddWatermark(t *testing.T) {
+	ctx := context.Background()
+	input := getTestMultiPagePDF(t, "A", 3)
+
+	logo := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for x := 0; x < 20; x++ {
+		logo.Set(x, 5, color.NRGBA{R: 0xff, A: 0x80})
+	}
+	png_buffer := new(bytes.Buffer)
+	require.NoError(t, png.Encode(png_buffer, logo))
+
+	tests := []struct {
+		name       string
+		watermark  Watermark
+		wantPages  []bool
+		wantForm   []string
+		wantMatrix string
+		wantErr    bool
+	}{
+		{
+			name:       "text",
+			watermark:  Watermark{Text: "DRAFT"},
+			wantPages:  []bool{true, true, true},
+			wantForm:   []string{"<4452414654> Tj", "0.502 0.502 0.502 rg"},
+			wantMatrix: "1.00000 0.00000 -0.00000 1.00000",
+		},
+		{
+			name:       "rotated_page_selection",
+			watermark:  Watermark{Text: "COPY\nrecipient 42", Rotation: 45, Color: "#FF0000", Opacity: 0.5, Pages: "2-"},
+			wantPages:  []bool{false, true, true},
+			wantForm:   []string{"<434f5059> Tj", "<726563697069656e74203432> Tj", "1.000 0.000 0.000 rg"},
+			wantMatrix: "0.70711 0.70711 -0.70711 0.70711",
+		},
+		{
+			name:       "image",
+			watermark:  Watermark{Image: png_buffer.Bytes(), ImageWidth: 100, Position: PositionBottomRight},
+			wantPages:  []bool{true, true, true},
+			wantForm:   []string{"100.00 0 0 50.00", "/Im1 Do"},
+			wantMatrix: "1.00000 0.00000 -0.00000 1.00000",
+		},
+		{
+			name:      "empty",
+			watermark: Watermark{},
+			wantErr:   true,
+		},
+		{
+			name:      "oversized_image",
+			watermark: Watermark{Image: pngHeader(10000, 10000)},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid_color",
+			watermark: Watermark{Text: "DRAFT", Color: "red"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid_position",
+			watermark: Watermark{Text: "DRAFT", Position: "middle"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid_opacity",
+			watermark: Watermark{Text: "DRAFT", Opacity: 2},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid_character",
+			watermark: Watermark{Text: "कॉपी"},
+			wantErr:   true,
+		},
+		{
+			name:      "page_out_of_range",
+			watermark: Watermark{Text: "DRAFT", Pages: "4"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := AddWatermarks(ctx, bytes.NewReader(input), tt.watermark)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			rdr, err := pdf.NewReader(bytes.NewReader(output), int64(len(output)))
+			require.NoError(t, err)
+			require.Equal(t, 3, rdr.NumPage())
+
+			doc, err := LoadBytes(output)
+			require.NoError(t, err)
+			for i, page := range doc.Pages() {
+				dict := doc.GetDict(page)
+				form := doc.GetDict(dict["Resources"])["XObject"]
+				if !tt.wantPages[i] {
+					assert.Nil(t, form, "page %d", i+1)
+					continue
+				}
+
+				// The original content stays between the save and the watermark
+				contents, ok := dict["Contents"].(Array)
+				require.True(t, ok, "page %d", i+1)
+				require.Len(t, contents, 3)
+				assert.Equal(t, "q\n", string(doc.Get(contents[0]).(*Stream).Data))
+				assert.Contains(t, string(doc.Get(contents[1]).(*Stream).Data), fmt.Sprintf("(A %d)", i+1))
+				watermark_content := string(doc.Get(contents[2]).(*Stream).Data)
+				assert.Contains(t, watermark_content, "/Wm1 Do")
+				assert.Contains(t, watermark_content, tt.wantMatrix)
+
+				form_stream, ok := doc.Get(doc.GetDict(form)["Wm1"]).(*Stream)
+				require.True(t, ok)
+				for _, want := range tt.wantForm {
+					assert.Contains(t, string(form_stream.Data), want)
+				}
+			}
+		})
+	}
+
+	t.Run("multiple", func(t *testing.T) {
+		output, err := AddWatermarks(ctx, bytes.NewReader(input), Watermark{Text: "DRAFT"}, Watermark{Text: "ID 42", Position: PositionBottom, FontSize: 10})
+		require.NoError(t, err)
+
+		doc, err := LoadBytes(output)
+		require.NoError(t, err)
+		for _, page := range doc.Pages() {
+			forms := doc.GetDict(doc.GetDict(doc.GetDict(page)["Resources"])["XObject"])
+			assert.Len(t, forms, 2)
+			assert.NotNil(t, forms["Wm2"])
+		}
+	})
+}
+
+func TestParsePageRange(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []int
+		wantErr bool
+	}{
+		{spec: "1", want: []int{1}},
+		{spec: "1-3,5", want: []int{1, 2, 3, 5}},
+		{spec: " 4 - , 2", want: []int{4, 5, 2}},
+		{spec: "0", wantErr: true},
+		{spec: "3-2", wantErr: true},
+		{spec: "1-6", wantErr: true},
+		{spec: "1,,2", wantErr: true},
+		{spec: "a-b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePageRange(tt.spec, 5)
+		if tt.wantErr {
+			assert.Error(t, err, tt.spec)
+			continue
+		}
+		require.NoError(t, err, tt.spec)
+		assert.Equal(t, tt.want, got, tt.spec)
+	}
+}
+
+// encryptionEntries returns the hex string entries of the encryption dictionary
+func encryptionEntries(t *testing.T, encrypted []byte) map[string][]byte {
+	entries := map[string][]byte{}
+	for _, key := range []string{"O", "U", "OE", "UE", "Perms"} {
+		match := regexp.MustCompile(`/` + key + ` <([0-9a-f]+)>`).FindSubmatch(encrypted)
+		require.NotNil(t, match, "missing /%s", key)
+
+		value, err := hex.DecodeString(string(match[1]))
+		require.NoError(t, err)
+		entries[key] = value
+	}
+	return entries
+}
+
+// fileKey checks password against U and O and returns the file key it
+// decrypts, nil for a wrong password
+func fileKey(t *testing.T, entries map[string][]byte, password string) []byte {
+	u, o := entries["U"], entries["O"]
+
+	var key_key, encrypted_key []byte
+	switch {
+	case bytes.Equal(hashPassword([]byte(password), u[32:40], nil), u[:32]):
+		key_key, encrypted_key = hashPassword([]byte(password), u[40:48], nil), entries["UE"]
+	case bytes.Equal(hashPassword([]byte(password), o[32:40], u), o[:32]):
+		key_key, encrypted_key = hashPassword([]byte(password), o[40:48], u), entries["OE"]
+	default:
+		return nil
+	}
+
+	block, err := aes.NewCipher(key_key)
+	require.NoError(t, err)
+	key := make([]byte, 32)
+	cipher.NewCBCDecrypter(block, make([]byte, 16)).CryptBlocks(key, encrypted_key)
+	return key
+}
+
+func decryptStream(t *testing.T, key, encrypted []byte, id int) []byte {
+	match := regexp.MustCompile(fmt.Sprintf(`(?s)\n%d 0 obj\n.*?\nstream\n(.*?)\nendstream`, id)).FindSubmatch(encrypted)
+	require.NotNil(t, match)
+
+	data := bytes.Clone(match[1])
+	require.Zero(t, len(data)%aes.BlockSize)
+
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	plain := data[aes.BlockSize:]
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plain, plain)
+
+	padding := int(plain[len(plain)-1])
+	return plain[:len(plain)-padding]
+}
+
+// getTestPDF returns a one page document with a content stream (object 4)
+// and an Info dictionary
+func getTestPDF(t *testing.T) []byte {
+	doc := &Document{
+		Version: "1.4",
+		Objects: map[uint32]Object{
+			1: Dict{"Type": Name("Catalog"), "Pages": Ref(2)},
+			2: Dict{"Type": Name("Pages"), "Kids": Array{Ref(3)}, "Count": int64(1)},
+			3: Dict{
+				"Type":      Name("Page"),
+				"Parent":    Ref(2),
+				"MediaBox":  Array{int64(0), int64(0), int64(612), int64(792)},
+				"Contents":  Ref(4),
+				"Resources": Dict{"Font": Dict{"F1": Ref(5)}},
+			},
+			4: &Stream{Dict: Dict{}, Data: []byte(testContent)},
+			5: Dict{"Type": Name("Font"), "Subtype": Name("Type1"), "BaseFont": Name("Helvetica")},
+			6: Dict{"Title": String("Test (Document)")},
+		},
+		Trailer: Dict{"Root": Ref(1), "Info": Ref(6)},
+	}
+
+	pdfBytes, err := doc.Bytes()
+	require.NoError(t, err)
+	return pdfBytes
+}
+
+// getTestPDFA returns the test document with an embedded font, JavaScript and
+// annotations PDF/A does not allow
+func getTestPDFA(t *testing.T) []byte {
+	doc, err := LoadBytes(getTestPDF(t))
+	require.NoError(t, err)
+
+	font_file := doc.Add(&Stream{Dict: Dict{}, Data: []byte("font program")})
+	doc.GetDict(Ref(5))["FontDescriptor"] = doc.Add(Dict{
+		"Type":     Name("FontDescriptor"),
+		"FontName": Name("Helvetica"),
+		"FontFile": font_file,
+	})
+
+	script := Dict{"S": Name("JavaScript"), "JS": String("app.alert('hi')")}
+	catalog := doc.Catalog()
+	catalog["OpenAction"] = script
+	catalog["Names"] = Dict{"JavaScript": Dict{"Names": Array{String("init"), doc.Add(script)}}}
+
+	doc.GetDict(Ref(3))["Annots"] = Array{
+		doc.Add(Dict{
+			"Type":    Name("Annot"),
+			"Subtype": Name("Link"),
+			"Rect":    Array{int64(72), int64(700), int64(200), int64(724)},
+			"F":       int64(annotationFlagHidden),
+			"A":       Dict{"S": Name("Launch"), "F": String("calc.exe")},
+		}),
+		doc.Add(Dict{
+			"Type":    Name("Annot"),
+			"Subtype": Name("Sound"),
+			"Rect":    Array{int64(0), int64(0), int64(10), int64(10)},
+		}),
+	}
+
+	pdfBytes, err := doc.Bytes()
+	require.NoError(t, err)
+	return pdfBytes
+}
+
+// getTestMultiPagePDF returns a document with pageCount pages below a page
+// tree node they inherit the media box and resources from, a bookmark per
+// page, a link on page 1 to the last page through a named destination and a
+// signed signature field on the last page
+func getTestMultiPagePDF(t *testing.T, name string, pageCount int) []byte {
+	doc := &Document{
+		Version: "1.4",
+		Objects: map[uint32]Object{
+			1: Dict{"Type": Name("Catalog"), "Pages": Ref(2), "Outlines": Ref(3)},
+			2: Dict{
+				"Type":      Name("Pages"),
+				"Kids":      Array{},
+				"Count":     int64(pageCount),
+				"MediaBox":  Array{int64(0), int64(0), int64(612), int64(792)},
+				"Resources": Dict{"Font": Dict{"F1": Ref(4)}},
+			},
+			3: Dict{"Type": Name("Outlines")},
+			4: Dict{"Type": Name("Font"), "Subtype": Name("Type1"), "BaseFont": Name("Helvetica")},
+			5: Dict{"Title": String("Test " + name)},
+		},
+		Trailer: Dict{"Root": Ref(1), "Info": Ref(5)},
+	}
+
+	var pages, bookmarks []Ref
+	dests := Array{}
+	for i := 1; i <= pageCount; i++ {
+		contents := doc.Add(&Stream{Dict: Dict{}, Data: []byte(fmt.Sprintf("BT /F1 24 Tf 72 700 Td (%s %d) Tj ET", name, i))})
+		page := doc.Add(Dict{"Type": Name("Page"), "Parent": Ref(2), "Contents": contents})
+		pages = append(pages, page)
+		dests = append(dests, String(fmt.Sprintf("page%d", i)), Array{page, Name("Fit")})
+		bookmarks = append(bookmarks, doc.Add(Dict{
+			"Title": String(fmt.Sprintf("%s page %d", name, i)),
+			"Dest":  String(fmt.Sprintf("page%d", i)),
+		}))
+	}
+
+	kids := Array{}
+	for _, page := range pages {
+		kids = append(kids, page)
+	}
+	doc.GetDict(Ref(2))["Kids"] = kids
+	doc.Catalog()["Names"] = Dict{"Dests": doc.Add(Dict{"Names": dests})}
+	doc.GetDict(Ref(3))["Count"] = doc.linkOutline(Ref(3), bookmarks)
+
+	last := doc.GetDict(pages[pageCount-1])
+	doc.GetDict(pages[0])["Annots"] = Array{doc.Add(Dict{
+		"Type":    Name("Annot"),
+		"Subtype": Name("Link"),
+		"Rect":    Array{int64(72), int64(700), int64(200), int64(724)},
+		"A":       Dict{"S": Name("GoTo"), "D": String(fmt.Sprintf("page%d", pageCount))},
+	})}
+	field := doc.Add(Dict{
+		"Type":    Name("Annot"),
+		"Subtype": Name("Widget"),
+		"FT":      Name("Sig"),
+		"T":       String("Signature1"),
+		"Rect":    Array{int64(0), int64(0), int64(0), int64(0)},
+		"P":       pages[pageCount-1],
+		"V":       Dict{"Type": Name("Sig"), "Contents": String("signature")},
+	})
+	annotations, _ := last["Annots"].(Array)
+	last["Annots"] = append(Array{field}, annotations...)
+	doc.Catalog()["AcroForm"] = Dict{"Fields": Array{field}, "SigFlags": int64(3)}
+
+	pdfBytes, err := doc.Bytes()
+	require.NoError(t, err)
+	return pdfBytes
+}
+
+func generateTestCertificate(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:       big.NewInt(1),
+		Subject:            pkix.Name{CommonName: "Test Cert"},
+		NotBefore:          time.Now(),
+		NotAfter:           time.Now().Add(24 * time.Hour),
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(certDER)
+	require.NoError(t, err)
+
+	return cert, key
+}
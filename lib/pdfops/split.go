@@ -0,0 +1,229 @@
+package pdfops
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Split extracts page ranges of the PDF read from pdfStream into separate
+// PDFs, one per range in the syntax of ParsePageRange. Without ranges every
+// page becomes a PDF of its own.
+func Split(ctx context.Context, pdfStream io.Reader, ranges []string) ([][]byte, error) {
+	pdfBytes, err := io.ReadAll(pdfStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	doc, err := LoadBytes(pdfBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load PDF: %v", err)
+	}
+
+	page_count := len(doc.Pages())
+	if len(ranges) == 0 {
+		for page := 1; page <= page_count; page++ {
+			ranges = append(ranges, fmt.Sprint(page))
+		}
+	}
+
+	parts := make([][]byte, len(ranges))
+	for i, spec := range ranges {
+		pages, err := ParsePageRange(spec, page_count)
+		if err != nil {
+			return nil, err
+		}
+
+		part, err := doc.ExtractPages(pages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract pages %s: %v", spec, err)
+		}
+
+		parts[i], err = part.Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to write pages %s: %v", spec, err)
+		}
+	}
+
+	return parts, nil
+}
+
+// ExtractPages returns a copy of the document with the given 1-based pages
+// in that order. Bookmarks and links to pages left out are removed, as are
+// form fields without a widget on the extracted pages. Signatures are removed
+// as extracting invalidates them. The document itself is not modified.
+func (d *Document) ExtractPages(pages []int) (*Document, error) {
+	all_pages := d.Pages()
+
+	selected := make([]Ref, len(pages))
+	included := map[Ref]bool{}
+	for i, page := range pages {
+		if page < 1 || page > len(all_pages) {
+			return nil, fmt.Errorf("page %d is outside the %d pages of the document", page, len(all_pages))
+		}
+		selected[i] = all_pages[page-1]
+		if included[selected[i]] {
+			return nil, fmt.Errorf("page %d is selected twice", page)
+		}
+		included[selected[i]] = true
+	}
+
+	doc := d.clone()
+	catalog := doc.Catalog()
+	if catalog == nil {
+		return nil, fmt.Errorf("the document has no catalog")
+	}
+
+	doc.resolveNamedDestinations()
+	doc.inheritPageAttributes()
+	doc.removeStructure()
+	doc.setPages(selected)
+
+	// Destinations to pages left out would keep them in the document
+	excluded := func(dest Object) bool {
+		page, ok := doc.destinationPage(dest)
+		return ok && !included[page]
+	}
+	doc.walkDicts(func(dict Dict) {
+		if excluded(dict["Dest"]) {
+			delete(dict, "Dest")
+		}
+		if action := doc.GetDict(dict["A"]); action != nil && doc.Get(action["S"]) == Name("GoTo") && excluded(action["D"]) {
+			delete(dict, "A")
+		}
+	})
+	if action := doc.GetDict(catalog["OpenAction"]); (action != nil && excluded(action["D"])) || excluded(catalog["OpenAction"]) {
+		delete(catalog, "OpenAction")
+	}
+
+	if outlines := doc.GetDict(catalog["Outlines"]); outlines != nil {
+		items := doc.keptOutlineItems(doc.outlineItems())
+		outlines_ref, ok := catalog["Outlines"].(Ref)
+		switch {
+		case len(items) == 0:
+			delete(catalog, "Outlines")
+		case !ok:
+			// Items need a reference to their parent
+			outlines_ref = doc.Add(outlines)
+			catalog["Outlines"] = outlines_ref
+			fallthrough
+		default:
+			outlines["Count"] = doc.linkOutline(outlines_ref, items)
+		}
+	}
+
+	if acro_form := doc.GetDict(catalog["AcroForm"]); acro_form != nil {
+		widgets := map[Ref]bool{}
+		for _, page := range selected {
+			annotations, _ := doc.Get(doc.GetDict(page)["Annots"]).(Array)
+			for _, annotation := range annotations {
+				if ref, ok := annotation.(Ref); ok {
+					widgets[ref] = true
+				}
+			}
+		}
+
+		fields, _ := doc.Get(acro_form["Fields"]).(Array)
+		kept := Array{}
+		for _, field := range fields {
+			if doc.hasWidget(field, widgets, map[Ref]bool{}) {
+				kept = append(kept, field)
+			}
+		}
+		acro_form["Fields"] = kept
+	}
+
+	doc.removeUnreferenced()
+
+	return doc, nil
+}
+
+// keptOutlineItems returns the bookmarks of items that point to pages of
+// the document. Bookmarks to other pages are kept without a destination
+// when some of their children are kept.
+func (d *Document) keptOutlineItems(items []Ref) []Ref {
+	pages := map[Ref]bool{}
+	for _, page := range d.Pages() {
+		pages[page] = true
+	}
+
+	var kept []Ref
+	for _, item := range items {
+		dict := d.GetDict(item)
+
+		var children []Ref
+		visited := map[Ref]bool{}
+		for child, ok := dict["First"].(Ref); ok && !visited[child]; child, ok = d.GetDict(child)["Next"].(Ref) {
+			visited[child] = true
+			children = append(children, child)
+		}
+		children = d.keptOutlineItems(children)
+
+		// Links to removed pages are already gone
+		dest := dict["Dest"]
+		if action := d.GetDict(dict["A"]); action != nil && d.Get(action["S"]) == Name("GoTo") {
+			dest = action["D"]
+		}
+		page, ok := d.destinationPage(dest)
+		has_target := (ok && pages[page]) || dict["A"] != nil
+
+		if !has_target && len(children) == 0 {
+			continue
+		}
+
+		count := d.linkOutline(item, children)
+		if open, _ := d.Get(dict["Count"]).(int64); open < 0 {
+			count = -count
+		}
+		if count == 0 {
+			delete(dict, "Count")
+		} else {
+			dict["Count"] = count
+		}
+		kept = append(kept, item)
+	}
+
+	return kept
+}
+
+// hasWidget reports whether field or one of its kids is one of widgets
+func (d *Document) hasWidget(field Object, widgets map[Ref]bool, visited map[Ref]bool) bool {
+	ref, ok := field.(Ref)
+	if ok {
+		if visited[ref] {
+			return false
+		}
+		visited[ref] = true
+		if widgets[ref] {
+			return true
+		}
+	}
+
+	kids, _ := d.Get(d.GetDict(field)["Kids"]).(Array)
+	for _, kid := range kids {
+		if d.hasWidget(kid, widgets, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a deep copy of the document
+func (d *Document) clone() *Document {
+	identity := make(map[uint32]uint32, len(d.Objects))
+	for id := range d.Objects {
+		identity[id] = id
+	}
+
+	clone := &Document{
+		Version: d.Version,
+		Objects: make(map[uint32]Object, len(d.Objects)),
+		Trailer: copyObject(d.Trailer, identity).(Dict),
+		pdfa:    d.pdfa,
+	}
+	for id, object := range d.Objects {
+		clone.Objects[id] = copyObject(object, identity)
+	}
+
+	return clone
+}
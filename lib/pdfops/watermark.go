@@ -0,0 +1,519 @@
+package pdfops
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Watermark positions on the page as it is displayed
+const (
+	PositionCenter      = "center"
+	PositionTop         = "top"
+	PositionBottom      = "bottom"
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+)
+
+// Watermark defaults
+const (
+	defaultWatermarkFontSize = 48
+	defaultWatermarkOpacity  = 0.3
+	defaultWatermarkColor    = "#808080"
+	defaultWatermarkMargin   = 36
+	// maxWatermarkImagePixels bounds watermark images, they are decoded to
+	// 4 bytes per pixel before being embedded
+	maxWatermarkImagePixels = 25_000_000
+)
+
+// Watermark is text and/or an image drawn over the content of pages
+type Watermark struct {
+	// Text is drawn in Helvetica, lines separated by "\n". Only characters
+	// of Latin-1 can be drawn.
+	Text string
+	// Image is a JPEG or PNG of up to 25 megapixels drawn above the text
+	Image []byte
+	// FontSize in points, 48 by default
+	FontSize float64
+	// Color of the text as "#RRGGBB", gray by default
+	Color string
+	// Opacity from 0 to 1, 0.3 by default
+	Opacity float64
+	// Rotation in degrees counterclockwise around the watermark center,
+	// e.g. 45 for a diagonal watermark
+	Rotation float64
+	// Position is one of the Position constants, PositionCenter by default.
+	// Margin is the distance to the page edges, 36 points by default.
+	Position string
+	Margin   float64
+	// ImageWidth in points, the image is scaled keeping its aspect ratio.
+	// By default one pixel is one point.
+	ImageWidth float64
+	// Pages in the syntax of ParsePageRange, every page when empty
+	Pages string
+}
+
+// AddWatermarks draws watermarks over the pages of the PDF read from
+// pdfStream, in order. Signed documents lose their signatures, add
+// watermarks before signing.
+func AddWatermarks(ctx context.Context, pdfStream io.Reader, watermarks ...Watermark) ([]byte, error) {
+	pdfBytes, err := io.ReadAll(pdfStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	doc, err := LoadBytes(pdfBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load PDF: %v", err)
+	}
+
+	for i, watermark := range watermarks {
+		if err := doc.AddWatermark(watermark); err != nil {
+			return nil, fmt.Errorf("failed to add watermark %d: %v", i+1, err)
+		}
+	}
+
+	watermarkedBytes, err := doc.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write watermarked PDF: %v", err)
+	}
+
+	return watermarkedBytes, nil
+}
+
+// AddWatermark draws watermark over the selected pages. The existing page
+// content is wrapped in a saved graphics state so it cannot move the
+// watermark, which is shared by the pages as a form XObject.
+func (d *Document) AddWatermark(watermark Watermark) error {
+	if watermark.Text == "" && len(watermark.Image) == 0 {
+		return fmt.Errorf("watermark needs a text or an image")
+	}
+	if watermark.Text != "" && d.pdfa != "" {
+		return fmt.Errorf("text watermarks use a font that is not embedded and cannot be added to PDF/A documents")
+	}
+	if watermark.Opacity < 0 || watermark.Opacity > 1 {
+		return fmt.Errorf("watermark opacity %g is not between 0 and 1", watermark.Opacity)
+	}
+	if watermark.Opacity == 0 {
+		watermark.Opacity = defaultWatermarkOpacity
+	}
+	if watermark.Margin <= 0 {
+		watermark.Margin = defaultWatermarkMargin
+	}
+
+	all_pages := d.Pages()
+	pages := make([]int, len(all_pages))
+	for i := range all_pages {
+		pages[i] = i + 1
+	}
+	if watermark.Pages != "" {
+		var err error
+		if pages, err = ParsePageRange(watermark.Pages, len(all_pages)); err != nil {
+			return err
+		}
+	}
+
+	form, width, height, err := d.watermarkForm(watermark)
+	if err != nil {
+		return err
+	}
+	graphics_state := d.Add(Dict{
+		"Type": Name("ExtGState"),
+		"CA":   watermark.Opacity,
+		"ca":   watermark.Opacity,
+	})
+	save := d.Add(&Stream{Dict: Dict{}, Data: []byte("q\n")})
+
+	d.inheritPageAttributes()
+	done := map[Ref]bool{}
+	for _, number := range pages {
+		page := all_pages[number-1]
+		if done[page] {
+			continue
+		}
+		done[page] = true
+		dict := d.GetDict(page)
+
+		// Resources are often shared between pages, each page gets its own copy
+		resources := Dict{}
+		for key, value := range d.GetDict(dict["Resources"]) {
+			resources[key] = value
+		}
+		form_name := addResource(d, resources, "XObject", "Wm", form)
+		state_name := addResource(d, resources, "ExtGState", "GSWm", graphics_state)
+		dict["Resources"] = resources
+
+		matrix, err := watermarkMatrix(d.pageBox(dict), d.pageRotation(dict), watermark, width, height)
+		if err != nil {
+			return err
+		}
+		var content bytes.Buffer
+		content.WriteString("\nQ\nq\n")
+		content.WriteString("/" + string(state_name) + " gs\n")
+		content.WriteString(matrix + " cm\n")
+		content.WriteString("/" + string(form_name) + " Do\n")
+		content.WriteString("Q\n")
+
+		contents := Array{save}
+		switch existing := dict["Contents"].(type) {
+		case Array:
+			contents = append(contents, existing...)
+		case nil:
+		default:
+			if array, ok := d.Get(existing).(Array); ok {
+				contents = append(contents, array...)
+			} else {
+				contents = append(contents, existing)
+			}
+		}
+		dict["Contents"] = append(contents, d.Add(&Stream{Dict: Dict{}, Data: content.Bytes()}))
+	}
+
+	return nil
+}
+
+// watermarkForm adds the form XObject drawing watermark and returns it with
+// its size
+func (d *Document) watermarkForm(watermark Watermark) (Ref, float64, float64, error) {
+	font_size := watermark.FontSize
+	if font_size <= 0 {
+		font_size = defaultWatermarkFontSize
+	}
+	color_text := watermark.Color
+	if color_text == "" {
+		color_text = defaultWatermarkColor
+	}
+	rgb, err := parseColor(color_text)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var lines [][]byte
+	var line_widths []float64
+	var text_width float64
+	if watermark.Text != "" {
+		for _, line := range strings.Split(watermark.Text, "\n") {
+			encoded, width, err := encodeHelvetica(line)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			lines = append(lines, encoded)
+			line_widths = append(line_widths, width*font_size)
+			text_width = math.Max(text_width, width*font_size)
+		}
+	}
+	leading := font_size * 1.2
+	text_height := float64(len(lines)) * leading
+
+	resources := Dict{}
+	var image_width, image_height float64
+	if len(watermark.Image) > 0 {
+		image_ref, pixel_width, pixel_height, err := d.addImage(watermark.Image)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		image_width = float64(pixel_width)
+		if watermark.ImageWidth > 0 {
+			image_width = watermark.ImageWidth
+		}
+		image_height = image_width * float64(pixel_height) / float64(pixel_width)
+		resources["XObject"] = Dict{"Im1": image_ref}
+	}
+
+	width := math.Max(text_width, image_width)
+	height := text_height + image_height
+
+	var content bytes.Buffer
+	if image_height > 0 {
+		fmt.Fprintf(&content, "q\n%.2f 0 0 %.2f %.2f %.2f cm\n/Im1 Do\nQ\n", image_width, image_height, (width-image_width)/2, text_height)
+	}
+	if len(lines) > 0 {
+		resources["Font"] = Dict{"F1": Dict{
+			"Type":     Name("Font"),
+			"Subtype":  Name("Type1"),
+			"BaseFont": Name("Helvetica"),
+			"Encoding": Name("WinAnsiEncoding"),
+		}}
+		fmt.Fprintf(&content, "BT\n/F1 %.2f Tf\n%.3f %.3f %.3f rg\n", font_size, rgb[0], rgb[1], rgb[2])
+		for i, line := range lines {
+			// Lines are centered, the baseline leaves room for descenders
+			x := (width - line_widths[i]) / 2
+			y := text_height - float64(i+1)*leading + 0.25*font_size
+			fmt.Fprintf(&content, "1 0 0 1 %.2f %.2f Tm\n<%x> Tj\n", x, y, line)
+		}
+		content.WriteString("ET\n")
+	}
+
+	form := d.Add(&Stream{
+		Dict: Dict{
+			"Type":      Name("XObject"),
+			"Subtype":   Name("Form"),
+			"BBox":      Array{0.0, 0.0, width, height},
+			"Resources": resources,
+		},
+		Data: content.Bytes(),
+	})
+	return form, width, height, nil
+}
+
+// watermarkMatrix returns the transformation placing a watermark of width
+// and height on a page with the visible box and rotation
+func watermarkMatrix(box [4]float64, rotation int64, watermark Watermark, width, height float64) (string, error) {
+	// The page is displayed rotated clockwise, the watermark is placed on the
+	// displayed page and turned the other way to stay upright
+	display_width, display_height := box[2]-box[0], box[3]-box[1]
+	if rotation == 90 || rotation == 270 {
+		display_width, display_height = display_height, display_width
+	}
+
+	radians := watermark.Rotation * math.Pi / 180
+	cos, sin := math.Cos(radians), math.Sin(radians)
+	bound_width := math.Abs(width*cos) + math.Abs(height*sin)
+	bound_height := math.Abs(width*sin) + math.Abs(height*cos)
+
+	margin := watermark.Margin
+	left, right := margin+bound_width/2, display_width-margin-bound_width/2
+	bottom, top := margin+bound_height/2, display_height-margin-bound_height/2
+	center_x, center_y := display_width/2, display_height/2
+
+	var x, y float64
+	switch watermark.Position {
+	case PositionCenter, "":
+		x, y = center_x, center_y
+	case PositionTop:
+		x, y = center_x, top
+	case PositionBottom:
+		x, y = center_x, bottom
+	case PositionTopLeft:
+		x, y = left, top
+	case PositionTopRight:
+		x, y = right, top
+	case PositionBottomLeft:
+		x, y = left, bottom
+	case PositionBottomRight:
+		x, y = right, bottom
+	default:
+		return "", fmt.Errorf("unknown watermark position: %s", watermark.Position)
+	}
+
+	switch rotation {
+	case 90:
+		x, y = box[2]-y, box[1]+x
+	case 180:
+		x, y = box[2]-x, box[3]-y
+	case 270:
+		x, y = box[0]+y, box[3]-x
+	default:
+		x, y = box[0]+x, box[1]+y
+	}
+
+	// Rotate around the center of the watermark
+	radians += float64(rotation) * math.Pi / 180
+	cos, sin = math.Cos(radians), math.Sin(radians)
+	e := x - (cos*width-sin*height)/2
+	f := y - (sin*width+cos*height)/2
+
+	return fmt.Sprintf("%.5f %.5f %.5f %.5f %.2f %.2f", cos, sin, -sin, cos, e, f), nil
+}
+
+// pageBox returns the visible area of a page with inherited attributes
+// pushed down, the crop box or else the media box
+func (d *Document) pageBox(page Dict) [4]float64 {
+	// US Letter, the PDF default
+	box := [4]float64{0, 0, 612, 792}
+	for _, key := range []Name{"MediaBox", "CropBox"} {
+		array, _ := d.Get(page[key]).(Array)
+		if len(array) != 4 {
+			continue
+		}
+		var values [4]float64
+		valid := true
+		for i, value := range array {
+			switch value := d.Get(value).(type) {
+			case int64:
+				values[i] = float64(value)
+			case float64:
+				values[i] = value
+			default:
+				valid = false
+			}
+		}
+		if valid {
+			box = [4]float64{
+				math.Min(values[0], values[2]), math.Min(values[1], values[3]),
+				math.Max(values[0], values[2]), math.Max(values[1], values[3]),
+			}
+		}
+	}
+	return box
+}
+
+// pageRotation returns the clockwise display rotation of a page, 0, 90, 180
+// or 270
+func (d *Document) pageRotation(page Dict) int64 {
+	rotation, _ := d.Get(page["Rotate"]).(int64)
+	return (rotation%360 + 360) % 360 / 90 * 90
+}
+
+// addResource adds ref to the category of resources under an unused name
+// starting with prefix and returns the name
+func addResource(d *Document, resources Dict, category Name, prefix string, ref Ref) Name {
+	entries := Dict{}
+	for key, value := range d.GetDict(resources[category]) {
+		entries[key] = value
+	}
+
+	name := Name(prefix + "1")
+	for i := 2; entries[name] != nil; i++ {
+		name = Name(prefix + strconv.Itoa(i))
+	}
+	entries[name] = ref
+	resources[category] = entries
+
+	return name
+}
+
+// addImage adds a JPEG or PNG as an image XObject and returns it with its
+// pixel dimensions. Baseline RGB and grayscale JPEGs are embedded as is,
+// everything else is compressed with FlateDecode and an alpha soft mask.
+func (d *Document) addImage(data []byte) (Ref, int, int, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return 0, 0, 0, fmt.Errorf("image is empty")
+	}
+	if config.Width*config.Height > maxWatermarkImagePixels {
+		return 0, 0, 0, fmt.Errorf("image of %dx%d pixels is larger than %d pixels", config.Width, config.Height, maxWatermarkImagePixels)
+	}
+
+	if format == "jpeg" && (config.ColorModel == color.GrayModel || config.ColorModel == color.YCbCrModel) {
+		color_space := Name("DeviceRGB")
+		if config.ColorModel == color.GrayModel {
+			color_space = Name("DeviceGray")
+		}
+		return d.Add(imageStream(config.Width, config.Height, color_space, "DCTDecode", data)), config.Width, config.Height, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	alpha := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			if c.A != 0xff {
+				opaque = false
+			}
+		}
+	}
+
+	compressed_rgb, err := flateEncode(rgb)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	stream := imageStream(bounds.Dx(), bounds.Dy(), "DeviceRGB", "FlateDecode", compressed_rgb)
+
+	if !opaque {
+		compressed_alpha, err := flateEncode(alpha)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		stream.Dict["SMask"] = d.Add(imageStream(bounds.Dx(), bounds.Dy(), "DeviceGray", "FlateDecode", compressed_alpha))
+	}
+
+	return d.Add(stream), bounds.Dx(), bounds.Dy(), nil
+}
+
+func imageStream(width, height int, colorSpace, filter Name, data []byte) *Stream {
+	return &Stream{
+		Dict: Dict{
+			"Type":             Name("XObject"),
+			"Subtype":          Name("Image"),
+			"Width":            int64(width),
+			"Height":           int64(height),
+			"ColorSpace":       colorSpace,
+			"BitsPerComponent": int64(8),
+			"Filter":           filter,
+		},
+		Data: data,
+	}
+}
+
+func flateEncode(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// parseColor parses a "#RRGGBB" color into its components from 0 to 1
+func parseColor(text string) ([3]float64, error) {
+	var rgb [3]float64
+	if len(text) != 7 || text[0] != '#' {
+		return rgb, fmt.Errorf("invalid color %q, expected #RRGGBB", text)
+	}
+	for i := range rgb {
+		component, err := strconv.ParseUint(text[1+2*i:3+2*i], 16, 8)
+		if err != nil {
+			return rgb, fmt.Errorf("invalid color %q, expected #RRGGBB", text)
+		}
+		rgb[i] = float64(component) / 255
+	}
+	return rgb, nil
+}
+
+// encodeHelvetica encodes text with WinAnsiEncoding and returns it with its
+// width at a font size of 1
+func encodeHelvetica(text string) ([]byte, float64, error) {
+	encoded := make([]byte, 0, len(text))
+	var width float64
+	for _, r := range text {
+		switch {
+		case r >= 32 && r <= 126:
+			width += float64(helveticaWidths[r-32])
+		case r >= 0xA0 && r <= 0xFF:
+			// Latin-1 letters are about as wide as digits
+			width += 556
+		default:
+			return nil, 0, fmt.Errorf("character %q cannot be drawn in a text watermark", r)
+		}
+		encoded = append(encoded, byte(r))
+	}
+	return encoded, width / 1000, nil
+}
+
+// helveticaWidths are the widths of the printable ASCII characters of
+// Helvetica in thousandths of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
@@ -1,6 +1,8 @@
 package renderer
 
 import (
+	"text/template"
+
 	"github.com/go-rod/rod/lib/proto"
 	"github.com/rchougule/espresso/lib/browser_manager"
 	"github.com/rchougule/espresso/lib/templatestore"
@@ -8,8 +10,11 @@ import (
 
 type GetHtmlPdfInput struct {
 	TemplateRequest templatestore.GetTemplateRequest
-	Data            []byte
-	ViewPort        *browser_manager.ViewportConfig
-	PdfParams       *proto.PagePrintToPDF
-	IsSinglePage    bool
+	// Template is an already parsed template, e.g. shared by the documents of a batch.
+	// TemplateRequest is ignored when it is set.
+	Template     *template.Template
+	Data         []byte
+	ViewPort     *browser_manager.ViewportConfig
+	PdfParams    *proto.PagePrintToPDF
+	IsSinglePage bool
 }
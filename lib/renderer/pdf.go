@@ -22,20 +22,11 @@ func GetHtmlPdf(ctx context.Context, params *GetHtmlPdfInput, storeAdapter *temp
 	duration := time.Since(startTime)
 	fmt.Println("starting template parsing at :: ", duration)
 	var err error
-	var templateFile *template.Template
-	if storeAdapter != nil {
-		templateFile, err = (*storeAdapter).GetTemplate(ctx, &params.TemplateRequest)
+	templateFile := params.Template // parsed by the caller, e.g. once for a batch
+	if templateFile == nil {
+		templateFile, err = GetTemplate(ctx, &params.TemplateRequest, storeAdapter)
 		if err != nil {
-			return nil, fmt.Errorf("unable to get template file from store: %v", err)
-		}
-	} else {
-		if len(params.TemplateRequest.TemplateBytes) > 0 {
-			templateFile, err = template.New("stream").Parse(string(params.TemplateRequest.TemplateBytes))
-			if err != nil {
-				return nil, fmt.Errorf("unable to parse template file: %v", err)
-			}
-		} else {
-			return nil, fmt.Errorf("storage configuration is invalid")
+			return nil, err
 		}
 	}
 
@@ -133,6 +124,28 @@ func GetHtmlPdf(ctx context.Context, params *GetHtmlPdfInput, storeAdapter *temp
 	return pdfStream, nil
 }
 
+// GetTemplate fetches and parses the template of req from storeAdapter, or parses its TemplateBytes
+// without a store
+func GetTemplate(ctx context.Context, req *templatestore.GetTemplateRequest, storeAdapter *templatestore.StorageAdapter) (*template.Template, error) {
+	if storeAdapter != nil {
+		templateFile, err := (*storeAdapter).GetTemplate(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get template file from store: %v", err)
+		}
+		return templateFile, nil
+	}
+
+	if len(req.TemplateBytes) > 0 {
+		templateFile, err := template.New("stream").Parse(string(req.TemplateBytes))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse template file: %v", err)
+		}
+		return templateFile, nil
+	}
+
+	return nil, fmt.Errorf("storage configuration is invalid")
+}
+
 func getMetaInfo(data map[string]interface{}) map[string]interface{} {
 	if data == nil {
 		return nil
@@ -1,11 +1,13 @@
 package s3
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
@@ -40,6 +42,7 @@ type AwsCredConfig struct {
 }
 
 type S3Client struct {
+	Client     *s3.Client
 	Uploader   *manager.Uploader
 	Presigner  *s3.PresignClient
 	Downloader *manager.Downloader
@@ -115,6 +118,7 @@ func NewS3Client(ctx context.Context, options ...func(*Config)) (*S3Client, erro
 
 	presignClient := s3.NewPresignClient(awsS3Client)
 
+	s3Client.Client = awsS3Client
 	s3Client.Uploader = uploader
 	s3Client.Downloader = downloader
 	s3Client.Presigner = presignClient
@@ -161,6 +165,71 @@ func (s3Client *S3Client) GetFileReader(ctx context.Context, templatePath string
 	return resp.Body, nil
 }
 
+// GetFileETag returns the ETag of an object without downloading it
+func (s3Client *S3Client) GetFileETag(ctx context.Context, key string) (string, error) {
+	resp, err := s3Client.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s3Client.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.ETag), nil
+}
+
+// GetFile returns the content of an object with its ETag
+func (s3Client *S3Client) GetFile(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	resp, err := s3Client.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s3Client.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, aws.ToString(resp.ETag), nil
+}
+
+// PutFile uploads an object in a single request and returns its ETag. With ifMatch set the object is only
+// replaced if it still has that ETag, S3 answers with a 412 otherwise.
+func (s3Client *S3Client) PutFile(ctx context.Context, key string, body []byte, ifMatch string) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s3Client.Config.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	}
+	if ifMatch != "" {
+		input.IfMatch = aws.String(ifMatch)
+	}
+	resp, err := s3Client.Client.PutObject(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.ETag), nil
+}
+
+// CopyFile copies an object within the bucket, with ifMatch set only if the source still has that ETag
+func (s3Client *S3Client) CopyFile(ctx context.Context, sourceKey, key, ifMatch string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(s3Client.Config.Bucket),
+		CopySource: aws.String(s3Client.Config.Bucket + "/" + (&url.URL{Path: sourceKey}).EscapedPath()),
+		Key:        aws.String(key),
+	}
+	if ifMatch != "" {
+		input.CopySourceIfMatch = aws.String(ifMatch)
+	}
+	_, err := s3Client.Client.CopyObject(ctx, input)
+	return err
+}
+
+// DeleteFile deletes an object
+func (s3Client *S3Client) DeleteFile(ctx context.Context, key string) error {
+	_, err := s3Client.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s3Client.Config.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
 func (s3Client *S3Client) GetPresignURL(ctx context.Context, key string, presignTime int) (*v4.PresignedHTTPRequest, error) {
 	presign, err := s3Client.Presigner.PresignGetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s3Client.Config.Bucket),
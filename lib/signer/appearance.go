@@ -2,10 +2,22 @@ package signer
 
 import (
 	"bytes"
+	"compress/zlib"
 	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
 	"strconv"
+
+	"github.com/digitorus/pdf"
 )
 
+// maxImagePixels bounds the images of appearances, they are decoded to 4
+// bytes per pixel before being embedded
+const maxImagePixels = 25_000_000
+
 func (context *SignContext) createVisualSignature(visible bool, pageNumber uint32, rect [4]float64) ([]byte, error) {
 	var visual_signature bytes.Buffer
 
@@ -71,7 +83,7 @@ func (context *SignContext) createVisualSignature(visible bool, pageNumber uint3
 
 	visual_signature.WriteString("  /FT /Sig\n")
 
-	visual_signature.WriteString(fmt.Sprintf("  /T %s\n", pdfString("Signature "+strconv.Itoa(len(context.existingSignatures)+1))))
+	visual_signature.WriteString(fmt.Sprintf("  /T %s\n", pdfString(context.signatureFieldName())))
 
 	visual_signature.WriteString(fmt.Sprintf("  /V %d 0 R\n", context.SignData.objectId))
 
@@ -80,7 +92,29 @@ func (context *SignContext) createVisualSignature(visible bool, pageNumber uint3
 	return visual_signature.Bytes(), nil
 }
 
-func (context *SignContext) createIncPageUpdate(pageNumber, annot uint32) ([]byte, error) {
+// signatureFieldName returns SignData.FieldName or else the first
+// "Signature N" name not already used by a form field, field names have to be
+// unique within the document.
+func (context *SignContext) signatureFieldName() string {
+	if context.SignData.FieldName != "" {
+		return context.SignData.FieldName
+	}
+
+	names := map[string]bool{}
+	for _, field := range formFields(context.PDFReader) {
+		names[field.Key("T").Text()] = true
+	}
+
+	for i := len(context.existingSignatures) + 1; ; i++ {
+		name := "Signature " + strconv.Itoa(i)
+		if !names[name] {
+			return name
+		}
+	}
+}
+
+// createIncPageUpdate rewrites a page with annots appended to its /Annots
+func (context *SignContext) createIncPageUpdate(pageNumber uint32, annots ...uint32) ([]byte, error) {
 	var page_buffer bytes.Buffer
 
 	root := context.PDFReader.Trailer().Key("Root")
@@ -102,7 +136,9 @@ func (context *SignContext) createIncPageUpdate(pageNumber, annot uint32) ([]byt
 				ptr := page.Key(key).Index(i).GetPtr()
 				page_buffer.WriteString(fmt.Sprintf("    %d 0 R\n", ptr.GetID()))
 			}
-			page_buffer.WriteString(fmt.Sprintf("    %d 0 R\n", annot))
+			for _, annot := range annots {
+				page_buffer.WriteString(fmt.Sprintf("    %d 0 R\n", annot))
+			}
 			page_buffer.WriteString("  ]\n")
 		default:
 			page_buffer.WriteString(fmt.Sprintf("  /%s %s\n", key, page.Key(key).String()))
@@ -110,7 +146,14 @@ func (context *SignContext) createIncPageUpdate(pageNumber, annot uint32) ([]byt
 	}
 
 	if page.Key("Annots").IsNull() {
-		page_buffer.WriteString(fmt.Sprintf("  /Annots [%d 0 R]\n", annot))
+		page_buffer.WriteString("  /Annots [")
+		for i, annot := range annots {
+			if i > 0 {
+				page_buffer.WriteString(" ")
+			}
+			page_buffer.WriteString(fmt.Sprintf("%d 0 R", annot))
+		}
+		page_buffer.WriteString("]\n")
 	}
 
 	page_buffer.WriteString(">>\n")
@@ -119,7 +162,7 @@ func (context *SignContext) createIncPageUpdate(pageNumber, annot uint32) ([]byt
 }
 
 func (context *SignContext) createAppearance(rect [4]float64) ([]byte, error) {
-	text := context.SignData.Signature.Info.Name
+	appearance := context.SignData.Appearance
 
 	rectWidth := rect[2] - rect[0]
 	rectHeight := rect[3] - rect[1]
@@ -128,20 +171,82 @@ func (context *SignContext) createAppearance(rect [4]float64) ([]byte, error) {
 		return nil, fmt.Errorf("invalid rectangle dimensions: width %.2f and height %.2f must be greater than 0", rectWidth, rectHeight)
 	}
 
-	fontSize := rectHeight * 0.8
-	textWidth := float64(len(text)) * fontSize * 0.5
-	if textWidth > rectWidth {
-		fontSize = rectWidth / (float64(len(text)) * 0.5)
+	font := appearance.Font
+	if font == "" {
+		font = defaultAppearanceFont
+	}
+	if !standardFonts[font] {
+		return nil, fmt.Errorf("unsupported font %s, only the standard 14 PDF fonts are available", font)
+	}
+
+	lines := appearance.Text
+	if len(lines) == 0 {
+		lines = context.defaultAppearanceText()
 	}
 
+	padding := math.Min(rectWidth, rectHeight) * 0.05
+	textX := padding
+
 	var appearance_stream_buffer bytes.Buffer
 	appearance_stream_buffer.WriteString("q\n")
-	appearance_stream_buffer.WriteString("BT\n")
-	appearance_stream_buffer.WriteString(fmt.Sprintf("/F1 %.2f Tf\n", fontSize))
-	appearance_stream_buffer.WriteString(fmt.Sprintf("0 %.2f Td\n", rectHeight-fontSize))
-	appearance_stream_buffer.WriteString("0.2 0.2 0.6 rg\n")
-	appearance_stream_buffer.WriteString(fmt.Sprintf("%s Tj\n", pdfString(text)))
-	appearance_stream_buffer.WriteString("ET\n")
+
+	var imageObjectId uint32
+	if len(appearance.Image) > 0 {
+		var imageWidth, imageHeight int
+		var err error
+		imageObjectId, imageWidth, imageHeight, err = context.addImageObject(appearance.Image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add stamp image: %w", err)
+		}
+
+		// the logo takes the left part of the stamp, or all of it without text
+		boxWidth := rectWidth - 2*padding
+		if len(lines) > 0 {
+			boxWidth = math.Min(rectHeight-2*padding, rectWidth*0.4)
+		}
+		boxHeight := rectHeight - 2*padding
+		scale := math.Min(boxWidth/float64(imageWidth), boxHeight/float64(imageHeight))
+		drawWidth := float64(imageWidth) * scale
+		drawHeight := float64(imageHeight) * scale
+
+		appearance_stream_buffer.WriteString("q\n")
+		appearance_stream_buffer.WriteString(fmt.Sprintf("%.2f 0 0 %.2f %.2f %.2f cm\n", drawWidth, drawHeight, padding+(boxWidth-drawWidth)/2, (rectHeight-drawHeight)/2))
+		appearance_stream_buffer.WriteString("/Im1 Do\n")
+		appearance_stream_buffer.WriteString("Q\n")
+
+		textX = padding*2 + boxWidth
+	}
+
+	if len(lines) > 0 {
+		textWidth := rectWidth - textX - padding
+		textHeight := rectHeight - 2*padding
+
+		fontSize := appearance.FontSize
+		if fontSize <= 0 {
+			longest := 1
+			for _, line := range lines {
+				if len(line) > longest {
+					longest = len(line)
+				}
+			}
+			fontSize = math.Min(textHeight/(float64(len(lines))*1.2), textWidth/(float64(longest)*0.5))
+		}
+		leading := fontSize * 1.2
+
+		appearance_stream_buffer.WriteString("BT\n")
+		appearance_stream_buffer.WriteString(fmt.Sprintf("/F1 %.2f Tf\n", fontSize))
+		appearance_stream_buffer.WriteString(fmt.Sprintf("%.2f TL\n", leading))
+		appearance_stream_buffer.WriteString("0.2 0.2 0.6 rg\n")
+		appearance_stream_buffer.WriteString(fmt.Sprintf("%.2f %.2f Td\n", textX, rectHeight-padding-fontSize))
+		for i, line := range lines {
+			if i > 0 {
+				appearance_stream_buffer.WriteString("T*\n")
+			}
+			appearance_stream_buffer.WriteString(fmt.Sprintf("%s Tj\n", pdfTextString(line)))
+		}
+		appearance_stream_buffer.WriteString("ET\n")
+	}
+
 	appearance_stream_buffer.WriteString("Q\n")
 
 	var appearance_buffer bytes.Buffer
@@ -152,13 +257,20 @@ func (context *SignContext) createAppearance(rect [4]float64) ([]byte, error) {
 	appearance_buffer.WriteString("  /Matrix [1 0 0 1 0 0]\n")
 
 	appearance_buffer.WriteString("  /Resources <<\n")
+	appearance_buffer.WriteString("   /ProcSet [/PDF /Text /ImageB /ImageC]\n")
 	appearance_buffer.WriteString("   /Font <<\n")
 	appearance_buffer.WriteString("     /F1 <<\n")
 	appearance_buffer.WriteString("       /Type /Font\n")
 	appearance_buffer.WriteString("       /Subtype /Type1\n")
-	appearance_buffer.WriteString("       /BaseFont /Times-Roman\n")
+	appearance_buffer.WriteString("       /BaseFont /" + font + "\n")
+	if font != "Symbol" && font != "ZapfDingbats" {
+		appearance_buffer.WriteString("       /Encoding /WinAnsiEncoding\n")
+	}
 	appearance_buffer.WriteString("     >>\n")
 	appearance_buffer.WriteString("   >>\n")
+	if imageObjectId != 0 {
+		appearance_buffer.WriteString(fmt.Sprintf("   /XObject << /Im1 %d 0 R >>\n", imageObjectId))
+	}
 	appearance_buffer.WriteString("  >>\n")
 
 	appearance_buffer.WriteString("  /FormType 1\n")
@@ -171,3 +283,189 @@ func (context *SignContext) createAppearance(rect [4]float64) ([]byte, error) {
 
 	return appearance_buffer.Bytes(), nil
 }
+
+// defaultAppearanceText returns the stamp text used when no lines are given.
+func (context *SignContext) defaultAppearanceText() []string {
+	info := context.SignData.Signature.Info
+
+	var lines []string
+	if info.Name != "" {
+		lines = append(lines, "Digitally signed by "+info.Name)
+	}
+	if !info.Date.IsZero() {
+		lines = append(lines, "Date: "+info.Date.Format("2006-01-02 15:04:05 -07:00"))
+	}
+	if info.Reason != "" {
+		lines = append(lines, "Reason: "+info.Reason)
+	}
+	if info.Location != "" {
+		lines = append(lines, "Location: "+info.Location)
+	}
+
+	return lines
+}
+
+// appearanceRect resolves the visible signature rectangle, either the explicit
+// coordinates or a position anchored to the page media box.
+func (context *SignContext) appearanceRect() ([4]float64, error) {
+	appearance := context.SignData.Appearance
+
+	if appearance.Anchor == "" {
+		return [4]float64{
+			appearance.LowerLeftX,
+			appearance.LowerLeftY,
+			appearance.UpperRightX,
+			appearance.UpperRightY,
+		}, nil
+	}
+
+	width := appearance.Width
+	if width <= 0 {
+		width = defaultAppearanceWidth
+	}
+	height := appearance.Height
+	if height <= 0 {
+		height = defaultAppearanceHeight
+	}
+	margin := appearance.Margin
+	if margin <= 0 {
+		margin = defaultAppearanceMargin
+	}
+
+	page, err := findPageByNumber(context.PDFReader.Trailer().Key("Root").Key("Pages"), appearance.Page)
+	if err != nil {
+		return [4]float64{}, err
+	}
+	box := pageMediaBox(page)
+
+	var llx, lly float64
+	switch appearance.Anchor {
+	case AnchorTopLeft:
+		llx, lly = box[0]+margin, box[3]-margin-height
+	case AnchorTopRight:
+		llx, lly = box[2]-margin-width, box[3]-margin-height
+	case AnchorBottomLeft:
+		llx, lly = box[0]+margin, box[1]+margin
+	case AnchorBottomRight:
+		llx, lly = box[2]-margin-width, box[1]+margin
+	case AnchorCenter:
+		llx, lly = (box[0]+box[2]-width)/2, (box[1]+box[3]-height)/2
+	default:
+		return [4]float64{}, fmt.Errorf("unknown appearance anchor: %s", appearance.Anchor)
+	}
+
+	return [4]float64{llx, lly, llx + width, lly + height}, nil
+}
+
+// pageMediaBox returns the media box of a page, looking it up on the parent
+// page tree nodes when it is inherited.
+func pageMediaBox(page pdf.Value) [4]float64 {
+	for node := page; !node.IsNull(); node = node.Key("Parent") {
+		mediaBox := node.Key("MediaBox")
+		if mediaBox.Len() == 4 {
+			return [4]float64{
+				mediaBox.Index(0).Float64(),
+				mediaBox.Index(1).Float64(),
+				mediaBox.Index(2).Float64(),
+				mediaBox.Index(3).Float64(),
+			}
+		}
+	}
+
+	// US Letter, the PDF default
+	return [4]float64{0, 0, 612, 792}
+}
+
+// addImageObject writes a JPEG or PNG as an image XObject and returns its
+// object id and pixel dimensions. Baseline RGB and grayscale JPEGs are embedded
+// as is, everything else is re-encoded with FlateDecode and an alpha soft mask.
+func (context *SignContext) addImageObject(data []byte) (uint32, int, int, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return 0, 0, 0, fmt.Errorf("image of %dx%d pixels is larger than %d pixels", config.Width, config.Height, maxImagePixels)
+	}
+
+	if format == "jpeg" && (config.ColorModel == color.GrayModel || config.ColorModel == color.YCbCrModel) {
+		colorSpace := "/DeviceRGB"
+		if config.ColorModel == color.GrayModel {
+			colorSpace = "/DeviceGray"
+		}
+		id, err := context.addObject(imageXObject(config.Width, config.Height, colorSpace, "/DCTDecode", "", data))
+		return id, config.Width, config.Height, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	alpha := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			if c.A != 0xff {
+				opaque = false
+			}
+		}
+	}
+
+	smask := ""
+	if !opaque {
+		compressedAlpha, err := flateEncode(alpha)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		smaskId, err := context.addObject(imageXObject(bounds.Dx(), bounds.Dy(), "/DeviceGray", "/FlateDecode", "", compressedAlpha))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		smask = fmt.Sprintf("  /SMask %d 0 R\n", smaskId)
+	}
+
+	compressedRGB, err := flateEncode(rgb)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	id, err := context.addObject(imageXObject(bounds.Dx(), bounds.Dy(), "/DeviceRGB", "/FlateDecode", smask, compressedRGB))
+	return id, bounds.Dx(), bounds.Dy(), err
+}
+
+func imageXObject(width, height int, colorSpace, filter, extra string, data []byte) []byte {
+	var image_buffer bytes.Buffer
+	image_buffer.WriteString("<<\n")
+	image_buffer.WriteString("  /Type /XObject\n")
+	image_buffer.WriteString("  /Subtype /Image\n")
+	image_buffer.WriteString(fmt.Sprintf("  /Width %d\n", width))
+	image_buffer.WriteString(fmt.Sprintf("  /Height %d\n", height))
+	image_buffer.WriteString("  /ColorSpace " + colorSpace + "\n")
+	image_buffer.WriteString("  /BitsPerComponent 8\n")
+	image_buffer.WriteString("  /Filter " + filter + "\n")
+	image_buffer.WriteString(extra)
+	image_buffer.WriteString(fmt.Sprintf("  /Length %d\n", len(data)))
+	image_buffer.WriteString(">>\n")
+	image_buffer.WriteString("stream\n")
+	image_buffer.Write(data)
+	image_buffer.WriteString("\nendstream\n")
+
+	return image_buffer.Bytes()
+}
+
+func flateEncode(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
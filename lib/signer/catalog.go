@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 
 	"github.com/digitorus/pdf"
 )
@@ -20,35 +21,75 @@ func (context *SignContext) createCatalog() ([]byte, error) {
 	context.CatalogData.RootString = strconv.Itoa(int(rootPtr.GetID())) + " " + strconv.Itoa(int(rootPtr.GetGen())) + " R"
 
 	for _, key := range root.Keys() {
-		if key != "Type" && key != "AcroForm" {
-			_, _ = fmt.Fprintf(&catalog_buffer, "  /%s ", key)
-			context.serializeCatalogEntry(&catalog_buffer, rootPtr.GetID(), root.Key(key))
-			catalog_buffer.WriteString("\n")
+		if key == "Type" || key == "AcroForm" {
+			continue
 		}
+		if key == "Perms" && context.SignData.Signature.CertType == CertificationSignature {
+			continue
+		}
+		if key == "DSS" && context.dssObjectId != 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(&catalog_buffer, "  /%s ", key)
+		context.serializeCatalogEntry(&catalog_buffer, rootPtr.GetID(), root.Key(key))
+		catalog_buffer.WriteString("\n")
+	}
+
+	// A certification signature is referenced from the catalog so viewers
+	// apply its DocMDP restrictions, usage rights already present are kept.
+	if context.SignData.Signature.CertType == CertificationSignature {
+		catalog_buffer.WriteString("  /Perms <<\n")
+		catalog_buffer.WriteString(fmt.Sprintf("    /DocMDP %d 0 R\n", context.SignData.objectId))
+		if ur3 := root.Key("Perms").Key("UR3"); !ur3.IsNull() {
+			ur3Ptr := ur3.GetPtr()
+			catalog_buffer.WriteString(fmt.Sprintf("    /UR3 %d %d R\n", ur3Ptr.GetID(), ur3Ptr.GetGen()))
+		}
+		catalog_buffer.WriteString("  >>\n")
+	}
+
+	if context.dssObjectId != 0 {
+		catalog_buffer.WriteString(fmt.Sprintf("  /DSS %d 0 R\n", context.dssObjectId))
 	}
 
+	acroForm := root.Key("AcroForm")
+	acroFormPtr := acroForm.GetPtr()
+
 	catalog_buffer.WriteString("  /AcroForm <<\n")
 	catalog_buffer.WriteString("    /Fields [")
 
-	for i, sig := range context.existingSignatures {
-		if i > 0 {
-			catalog_buffer.WriteString(" ")
-		}
-		catalog_buffer.WriteString(strconv.Itoa(int(sig.objectId)) + " 0 R")
+	// Keep every existing field, signed or not, so earlier signatures and
+	// form data are still reachable from the new catalog.
+	fieldRefs := []string{}
+	for _, field := range formFields(context.PDFReader) {
+		fieldPtr := field.GetPtr()
+		fieldRefs = append(fieldRefs, fmt.Sprintf("%d %d R", fieldPtr.GetID(), fieldPtr.GetGen()))
 	}
-
-	if len(context.existingSignatures) > 0 {
-		catalog_buffer.WriteString(" ")
+	// Updates that only add validation data or sign an existing field have no new field
+	for _, id := range context.newFieldIds {
+		fieldRefs = append(fieldRefs, strconv.Itoa(int(id))+" 0 R")
 	}
-	catalog_buffer.WriteString(strconv.Itoa(int(context.VisualSignData.objectId)) + " 0 R")
+	catalog_buffer.WriteString(strings.Join(fieldRefs, " "))
 
 	catalog_buffer.WriteString("]\n")
 
+	for _, key := range acroForm.Keys() {
+		if key == "Fields" || key == "SigFlags" {
+			continue
+		}
+		_, _ = fmt.Fprintf(&catalog_buffer, "    /%s ", key)
+		context.serializeCatalogEntry(&catalog_buffer, acroFormPtr.GetID(), acroForm.Key(key))
+		catalog_buffer.WriteString("\n")
+	}
+
 	switch context.SignData.Signature.CertType {
 	case CertificationSignature, ApprovalSignature, TimeStampSignature:
 		catalog_buffer.WriteString("    /SigFlags 3\n")
 	case UsageRightsSignature:
 		catalog_buffer.WriteString("    /SigFlags 1\n")
+	default:
+		if sigFlags := acroForm.Key("SigFlags"); !sigFlags.IsNull() {
+			catalog_buffer.WriteString(fmt.Sprintf("    /SigFlags %d\n", sigFlags.Int64()))
+		}
 	}
 
 	catalog_buffer.WriteString("  >>\n")
@@ -141,9 +182,9 @@ func (context *SignContext) serializeCatalogEntry(w io.Writer, rootObjId uint32,
 					}
 					key := current.keys[current.index]
 					fmt.Fprintf(w, "/%s ", key)
-					// Push the value onto the stack
-					stack = append(stack, stackItem{val: current.val.Key(key), state: 0})
+					// Advance before pushing, append may move the stack and leave current stale
 					current.index++
+					stack = append(stack, stackItem{val: current.val.Key(key), state: 0})
 				}
 			} else if current.val.Kind() == pdf.Array {
 				// Array processing
@@ -154,9 +195,9 @@ func (context *SignContext) serializeCatalogEntry(w io.Writer, rootObjId uint32,
 					if current.index > 0 {
 						fmt.Fprint(w, " ")
 					}
-					// Push the value onto the stack
-					stack = append(stack, stackItem{val: current.val.Index(current.index), state: 0})
+					// Advance before pushing, append may move the stack and leave current stale
 					current.index++
+					stack = append(stack, stackItem{val: current.val.Index(current.index - 1), state: 0})
 				}
 			}
 		}
@@ -34,6 +34,41 @@ const (
 	objectFooter = "\nendobj\n"
 )
 
+// Visible signature anchors
+const (
+	AnchorTopLeft     = "top-left"
+	AnchorTopRight    = "top-right"
+	AnchorBottomLeft  = "bottom-left"
+	AnchorBottomRight = "bottom-right"
+	AnchorCenter      = "center"
+)
+
+// Visible signature defaults, in points
+const (
+	defaultAppearanceWidth  = 180
+	defaultAppearanceHeight = 60
+	defaultAppearanceMargin = 36
+	defaultAppearanceFont   = "Helvetica"
+)
+
+// standardFonts are the PDF standard 14 fonts usable without embedding
+var standardFonts = map[string]bool{
+	"Times-Roman":           true,
+	"Times-Bold":            true,
+	"Times-Italic":          true,
+	"Times-BoldItalic":      true,
+	"Helvetica":             true,
+	"Helvetica-Bold":        true,
+	"Helvetica-Oblique":     true,
+	"Helvetica-BoldOblique": true,
+	"Courier":               true,
+	"Courier-Bold":          true,
+	"Courier-Oblique":       true,
+	"Courier-BoldOblique":   true,
+	"Symbol":                true,
+	"ZapfDingbats":          true,
+}
+
 // Signature placeholder strings
 const (
 	signatureByteRangePlaceholder = "/ByteRange[0 ********** ********** **********]"
@@ -58,6 +93,32 @@ func (i CertType) String() string {
 	return _CertType_name[_CertType_index[i]:_CertType_index[i+1]]
 }
 
+// Signature profiles, PAdES baseline levels build on each other
+const (
+	ProfilePKCS7     Profile = iota // adbe.pkcs7.detached with the Adobe revocation attribute
+	ProfilePAdESBB                  // ETSI.CAdES.detached with ESS signing-certificate-v2
+	ProfilePAdESBT                  // B-B with a signature timestamp
+	ProfilePAdESBLT                 // B-T with certificates and revocation data in the DSS
+	ProfilePAdESBLTA                // B-LT with a final document timestamp
+)
+
+// String method for Profile
+func (p Profile) String() string {
+	switch p {
+	case ProfilePKCS7:
+		return "PKCS7"
+	case ProfilePAdESBB:
+		return "PAdES-B-B"
+	case ProfilePAdESBT:
+		return "PAdES-B-T"
+	case ProfilePAdESBLT:
+		return "PAdES-B-LT"
+	case ProfilePAdESBLTA:
+		return "PAdES-B-LTA"
+	}
+	return "Profile(" + strconv.FormatInt(int64(p), 10) + ")"
+}
+
 const (
 	DoNotAllowAnyChangesPerms DocMDPPerm = iota + 1
 	AllowFillingExistingFormFieldsAndSignaturesPerms
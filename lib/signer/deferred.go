@@ -0,0 +1,138 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/digitorus/pdf"
+	"github.com/digitorus/pkcs7"
+)
+
+// defaultDeferredSignatureSize is the CMS size PrepareSignature reserves by
+// default, enough for a signature with a certificate chain and a timestamp.
+const defaultDeferredSignatureSize = 16384
+
+// PrepareSignature is the first phase of a deferred signature, for keys that
+// are not available as a crypto.Signer, e.g. a remote signing service or a
+// user's signing device. It writes the signature field, dictionary and
+// appearance like SignPdfStreamWithData, reserving signatureSize bytes (16 KB
+// when zero) for the CMS, and returns the document with the digest to sign.
+//
+// The external signer makes a detached CMS SignedData whose messageDigest is
+// the returned Digest, CompleteSignature then injects it. The certificate in
+// signData is optional and only names the signature. Certification and
+// approval signatures with the PKCS7 and PAdES B-B profiles can be deferred,
+// a timestamp has to be added to the CMS by the external signer. The whole
+// document is read into memory.
+func PrepareSignature(ctx context.Context, pdfStream io.Reader, signData SignData, signatureSize int) (*PreparedSignature, error) {
+	switch signData.Signature.CertType {
+	case 0, CertificationSignature, ApprovalSignature:
+	default:
+		return nil, fmt.Errorf("%s cannot be deferred", signData.Signature.CertType)
+	}
+	if signData.Profile > ProfilePAdESBB {
+		return nil, fmt.Errorf("%s cannot be deferred, it needs the signature to add further data", signData.Profile)
+	}
+	if signData.TSA.enabled() {
+		return nil, fmt.Errorf("a deferred signature cannot request a timestamp, the external signer has to add it to the CMS")
+	}
+	if signatureSize <= 0 {
+		signatureSize = defaultDeferredSignatureSize
+	}
+
+	pdfBytes, err := io.ReadAll(pdfStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	pdfReader, err := pdf.NewReader(bytes.NewReader(pdfBytes), int64(len(pdfBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF reader: %v", err)
+	}
+
+	var outputBuffer bytes.Buffer
+	context, err := newSignContext(ctx, bytes.NewReader(pdfBytes), &outputBuffer, pdfReader, signData.withDefaults())
+	if err != nil {
+		return nil, err
+	}
+	context.deferred = true
+	context.SignatureMaxLengthBase = uint32(hex.EncodedLen(signatureSize))
+
+	if err := context.SignPDF(); err != nil {
+		return nil, fmt.Errorf("failed to prepare signature: %v", err)
+	}
+
+	digest, err := context.byteRangeDigest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash byte range: %v", err)
+	}
+
+	return &PreparedSignature{
+		PDF:             outputBuffer.Bytes(),
+		ByteRange:       context.ByteRangeValues,
+		Digest:          digest,
+		DigestAlgorithm: context.SignData.DigestAlgorithm,
+	}, nil
+}
+
+// CompleteSignature is the second phase of a deferred signature. It injects
+// cms, the DER encoded detached CMS SignedData made over the digest of
+// PrepareSignature, into the prepared PDF. The CMS has to verify against the
+// signed content, its certificate chain is not checked.
+func CompleteSignature(preparedPDF []byte, cms []byte) ([]byte, error) {
+	byteRange, err := preparedByteRange(preparedPDF)
+	if err != nil {
+		return nil, err
+	}
+
+	p7, err := pkcs7.Parse(cms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CMS signature: %w", err)
+	}
+	p7.Content = byteRangeContent(preparedPDF, byteRange)
+	if err := p7.Verify(); err != nil {
+		return nil, fmt.Errorf("CMS signature does not match the prepared document: %w", err)
+	}
+
+	contents := make([]byte, hex.EncodedLen(len(cms)))
+	hex.Encode(contents, cms)
+
+	reserved := byteRange[2] - byteRange[1] - 2
+	if int64(len(contents)) > reserved {
+		return nil, fmt.Errorf("signature of %d bytes does not fit the %d bytes reserved, prepare it with a larger signature size", len(cms), reserved/2)
+	}
+
+	signed := bytes.Clone(preparedPDF)
+	copy(signed[byteRange[1]+1:], contents)
+
+	return signed, nil
+}
+
+// preparedByteRange reads the byte range of the last signature dictionary in
+// pdfBytes and checks it surrounds an unfilled /Contents placeholder.
+func preparedByteRange(pdfBytes []byte) ([]int64, error) {
+	index := bytes.LastIndex(pdfBytes, []byte("/ByteRange ["))
+	if index == -1 {
+		return nil, fmt.Errorf("no prepared signature found")
+	}
+
+	byteRange := make([]int64, 4)
+	line := pdfBytes[index:min(len(pdfBytes), index+len(signatureByteRangePlaceholder))]
+	if _, err := fmt.Sscanf(string(line), "/ByteRange [%d %d %d %d]", &byteRange[0], &byteRange[1], &byteRange[2], &byteRange[3]); err != nil {
+		return nil, fmt.Errorf("failed to read byte range: %w", err)
+	}
+
+	if byteRange[0] != 0 || byteRange[1] <= 0 || byteRange[2] < byteRange[1]+2 || byteRange[2]+byteRange[3] != int64(len(pdfBytes)) {
+		return nil, fmt.Errorf("byte range %v does not match the document", byteRange)
+	}
+
+	placeholder := pdfBytes[byteRange[1]:byteRange[2]]
+	if placeholder[0] != '<' || placeholder[len(placeholder)-1] != '>' || len(bytes.Trim(placeholder[1:len(placeholder)-1], "0")) != 0 {
+		return nil, fmt.Errorf("the signature placeholder is already filled in")
+	}
+
+	return byteRange, nil
+}
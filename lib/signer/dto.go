@@ -1,10 +1,13 @@
 package signer
 
 import (
+	"context"
 	"crypto"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
 	"io"
+	"net/http"
 	"time"
 
 	"github.com/digitorus/pdf"
@@ -22,6 +25,23 @@ type TSA struct {
 	URL      string
 	Username string
 	Password string
+	// FallbackURLs are tried in order when URL fails
+	FallbackURLs []string
+	// BearerToken is sent instead of basic auth when set
+	BearerToken string
+	// ClientCertificate authenticates to the TSA with mutual TLS
+	ClientCertificate *tls.Certificate
+	// RootCAs verifies the TSA's TLS certificate, system roots when nil
+	RootCAs *x509.CertPool
+	// Timeout per attempt, 30 seconds when zero
+	Timeout time.Duration
+	// MaxAttempts per endpoint, 2 when zero. Failed attempts are retried
+	// after RetryBackoff (500ms when zero), doubled for every further retry.
+	MaxAttempts  int
+	RetryBackoff time.Duration
+	// HTTPClient replaces the client built from ClientCertificate and RootCAs,
+	// see NewHTTPClient
+	HTTPClient *http.Client
 }
 
 type CRL []asn1.RawValue
@@ -54,11 +74,23 @@ type SignData struct {
 	RevocationFunction RevocationFunction
 	Appearance         Appearance
 
+	// FieldName signs the empty signature field of that name, e.g. one added
+	// by AddSignatureFields, drawing the appearance into the field's
+	// rectangle. When no field has the name a new one is created with it,
+	// when empty the new field is named "Signature N".
+	FieldName string
+
+	// Profile selects the signature format, ProfilePKCS7 by default. PAdES
+	// B-T and above need a TSA, B-LT and B-LTA add a Document Security Store
+	// with the validation data and B-LTA finishes with a document timestamp.
+	Profile Profile
+
 	objectId uint32
 }
 
 type CertType uint
 type DocMDPPerm uint
+type Profile uint
 
 // SignDataSignature contains signature metadata
 type SignDataSignature struct {
@@ -84,6 +116,48 @@ type Appearance struct {
 	LowerLeftY  float64
 	UpperRightX float64
 	UpperRightY float64
+
+	// Anchor places the stamp relative to the page media box instead of the
+	// rectangle above, one of the Anchor* constants. Width and Height set the
+	// stamp size and Margin the distance to the page edges.
+	Anchor string
+	Width  float64
+	Height float64
+	Margin float64
+
+	// Image is a JPEG or PNG logo of up to 25 megapixels drawn on the left
+	// side of the stamp, or over the whole stamp when there is no text.
+	Image []byte
+	// Text lines drawn in the stamp. When empty the signer name, signing
+	// date and reason are used.
+	Text []string
+	// Font is one of the standard 14 PDF fonts, Helvetica by default.
+	Font string
+	// FontSize in points, 0 fits the text into the stamp.
+	FontSize float64
+}
+
+// SignatureField is an empty signature field to be signed later by name
+type SignatureField struct {
+	Name string
+	// Page is 1 based, the first page by default
+	Page uint32
+	// Rect is the widget rectangle [lower-left x, lower-left y, upper-right x,
+	// upper-right y] in points, all zero for an invisible field
+	Rect [4]float64
+}
+
+// PreparedSignature is the first half of a deferred signature, see
+// PrepareSignature. The CMS made over Digest is injected into PDF with
+// CompleteSignature.
+type PreparedSignature struct {
+	// PDF holds the signature dictionary with a zero filled /Contents
+	PDF []byte
+	// ByteRange is the part of PDF covered by the signature
+	ByteRange []int64
+	// Digest of the ByteRange content, the messageDigest of the CMS
+	Digest          []byte
+	DigestAlgorithm crypto.Hash
 }
 
 // VisualSignData contains object IDs for the visual signature
@@ -112,7 +186,13 @@ type SignContext struct {
 	SignatureMaxLength     uint32
 	SignatureMaxLengthBase uint32
 
+	// ctx bounds the requests made while signing, e.g. to the TSA
+	ctx                context.Context
 	existingSignatures []SignData
+	newFieldIds        []uint32
+	deferred           bool
+	updateOffset       int64
+	dssObjectId        uint32
 	lastXrefID         uint32
 	newXrefEntries     []xrefEntry
 	updatedXrefEntries []xrefEntry
@@ -125,3 +205,59 @@ type xrefEntry struct {
 	Generation int
 	Free       bool
 }
+
+// VerifyOptions configures how signatures are validated by Verify
+type VerifyOptions struct {
+	// Roots is the trust anchor pool for certificate chain validation. When
+	// nil the system roots are used.
+	Roots *x509.CertPool
+	// Intermediates are added to the certificates embedded in the signature
+	// when building the chain.
+	Intermediates []*x509.Certificate
+}
+
+// VerifyReport is the result of verifying all signatures in a PDF
+type VerifyReport struct {
+	FileSize   int64             `json:"file_size"`
+	Valid      bool              `json:"valid"`
+	Trusted    bool              `json:"trusted"`
+	Signatures []SignatureReport `json:"signatures"`
+}
+
+// SignatureReport describes a single signature found in a PDF
+type SignatureReport struct {
+	FieldName            string           `json:"field_name"`
+	SubFilter            string           `json:"sub_filter"`
+	CertType             string           `json:"cert_type"`
+	DocMDPPerm           DocMDPPerm       `json:"docmdp_perm,omitempty"`
+	Signer               *SignerReport    `json:"signer,omitempty"`
+	Name                 string           `json:"name,omitempty"`
+	Reason               string           `json:"reason,omitempty"`
+	Location             string           `json:"location,omitempty"`
+	ContactInfo          string           `json:"contact_info,omitempty"`
+	SigningTime          time.Time        `json:"signing_time,omitempty"`
+	Timestamp            *TimestampReport `json:"timestamp,omitempty"`
+	ByteRange            []int64          `json:"byte_range"`
+	CoversWholeFile      bool             `json:"covers_whole_file"`
+	ModifiedAfterSigning bool             `json:"modified_after_signing"`
+	ValidSignature       bool             `json:"valid_signature"`
+	TrustedChain         bool             `json:"trusted_chain"`
+	Errors               []string         `json:"errors,omitempty"`
+}
+
+// SignerReport holds the identity of the certificate that produced a signature
+type SignerReport struct {
+	CommonName   string    `json:"common_name"`
+	Organization []string  `json:"organization,omitempty"`
+	SerialNumber string    `json:"serial_number"`
+	Issuer       string    `json:"issuer"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+}
+
+// TimestampReport describes an RFC 3161 timestamp attached to a signature
+type TimestampReport struct {
+	Time      time.Time `json:"time"`
+	Authority string    `json:"authority,omitempty"`
+	Valid     bool      `json:"valid"`
+}
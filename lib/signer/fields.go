@@ -0,0 +1,250 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/digitorus/pdf"
+)
+
+// AddSignatureFields adds empty signature fields to the PDF read from
+// pdfStream as an incremental update. Each field is signed later by passing
+// its name as SignData.FieldName, visible fields get the signature appearance
+// drawn into their rectangle. The whole document is read into memory.
+func AddSignatureFields(ctx context.Context, pdfStream io.Reader, fields []SignatureField) ([]byte, error) {
+	pdfBytes, err := io.ReadAll(pdfStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	pdfReader, err := pdf.NewReader(bytes.NewReader(pdfBytes), int64(len(pdfBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF reader: %v", err)
+	}
+
+	var outputBuffer bytes.Buffer
+	context, err := newSignContext(ctx, bytes.NewReader(pdfBytes), &outputBuffer, pdfReader, SignData{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := context.addSignatureFields(fields); err != nil {
+		return nil, fmt.Errorf("failed to add signature fields: %v", err)
+	}
+
+	return outputBuffer.Bytes(), nil
+}
+
+func (context *SignContext) addSignatureFields(fields []SignatureField) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("no signature fields given")
+	}
+	for _, sig := range context.existingSignatures {
+		if sig.Signature.CertType == CertificationSignature && sig.Signature.DocMDPPerm == DoNotAllowAnyChangesPerms {
+			return fmt.Errorf("document is certified with %s and cannot be changed", DoNotAllowAnyChangesPerms)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, field := range formFields(context.PDFReader) {
+		names[field.Key("T").Text()] = true
+	}
+
+	if err := context.startUpdate(); err != nil {
+		return err
+	}
+
+	// Widgets are added to their page's /Annots, one page update per page
+	var pages []uint32
+	pageAnnots := map[uint32][]uint32{}
+
+	for _, field := range fields {
+		if field.Name == "" {
+			return fmt.Errorf("signature field name is required")
+		}
+		if names[field.Name] {
+			return fmt.Errorf("a field named %q already exists", field.Name)
+		}
+		names[field.Name] = true
+
+		if field.Page == 0 {
+			field.Page = 1
+		}
+
+		widget, err := context.createEmptySignatureField(field)
+		if err != nil {
+			return fmt.Errorf("failed to create field %q: %w", field.Name, err)
+		}
+
+		id, err := context.addObject(widget)
+		if err != nil {
+			return fmt.Errorf("failed to add field %q: %w", field.Name, err)
+		}
+		context.newFieldIds = append(context.newFieldIds, id)
+
+		if _, ok := pageAnnots[field.Page]; !ok {
+			pages = append(pages, field.Page)
+		}
+		pageAnnots[field.Page] = append(pageAnnots[field.Page], id)
+	}
+
+	root := context.PDFReader.Trailer().Key("Root")
+	for _, pageNumber := range pages {
+		page, err := findPageByNumber(root.Key("Pages"), pageNumber)
+		if err != nil {
+			return err
+		}
+
+		inc_page_update, err := context.createIncPageUpdate(pageNumber, pageAnnots[pageNumber]...)
+		if err != nil {
+			return fmt.Errorf("failed to create incremental page update: %w", err)
+		}
+		page_ptr := page.GetPtr()
+		if err := context.updateObject(page_ptr.GetID(), inc_page_update); err != nil {
+			return fmt.Errorf("failed to add incremental page update object: %w", err)
+		}
+	}
+
+	catalog, err := context.createCatalog()
+	if err != nil {
+		return fmt.Errorf("failed to create catalog: %w", err)
+	}
+
+	context.CatalogData.ObjectId, err = context.addObject(catalog)
+	if err != nil {
+		return fmt.Errorf("failed to add catalog object: %w", err)
+	}
+
+	if err := context.writeXref(); err != nil {
+		return fmt.Errorf("failed to write xref: %w", err)
+	}
+
+	if err := context.writeTrailer(); err != nil {
+		return fmt.Errorf("failed to write trailer: %w", err)
+	}
+
+	return context.writeOutput()
+}
+
+// createEmptySignatureField returns the merged field and widget dictionary of
+// an unsigned signature field.
+func (context *SignContext) createEmptySignatureField(field SignatureField) ([]byte, error) {
+	rect := field.Rect
+	if rect != [4]float64{} && (rect[2] <= rect[0] || rect[3] <= rect[1]) {
+		return nil, fmt.Errorf("invalid rectangle %v, the upper right corner has to be above and right of the lower left one", rect)
+	}
+
+	page, err := findPageByNumber(context.PDFReader.Trailer().Key("Root").Key("Pages"), field.Page)
+	if err != nil {
+		return nil, err
+	}
+	page_ptr := page.GetPtr()
+
+	var field_buffer bytes.Buffer
+
+	field_buffer.WriteString("<<\n")
+	field_buffer.WriteString("  /Type /Annot\n")
+	field_buffer.WriteString("  /Subtype /Widget\n")
+	field_buffer.WriteString(fmt.Sprintf("  /Rect [%f %f %f %f]\n", rect[0], rect[1], rect[2], rect[3]))
+	field_buffer.WriteString(fmt.Sprintf("  /P %d %d R\n", page_ptr.GetID(), page_ptr.GetGen()))
+	field_buffer.WriteString(fmt.Sprintf("  /F %d\n", AnnotationFlagPrint))
+	field_buffer.WriteString("  /FT /Sig\n")
+	field_buffer.WriteString(fmt.Sprintf("  /T %s\n", pdfString(field.Name)))
+	field_buffer.WriteString(">>\n")
+
+	return field_buffer.Bytes(), nil
+}
+
+// signatureField returns the unsigned signature field called name, or a null
+// value when there is none and a new field is to be created.
+func (context *SignContext) signatureField(name string) (pdf.Value, error) {
+	if name == "" {
+		return pdf.Value{}, nil
+	}
+
+	for _, field := range formFields(context.PDFReader) {
+		if field.Key("T").Text() != name {
+			continue
+		}
+
+		switch {
+		case field.Key("FT").Name() != "Sig":
+			return pdf.Value{}, fmt.Errorf("field %q is not a signature field", name)
+		case !field.Key("V").IsNull():
+			return pdf.Value{}, fmt.Errorf("signature field %q is already signed", name)
+		case !field.Key("Kids").IsNull():
+			return pdf.Value{}, fmt.Errorf("signature field %q has separate widgets, which is not supported", name)
+		}
+
+		return field, nil
+	}
+
+	return pdf.Value{}, nil
+}
+
+// signExistingField rewrites field with the signature as its value. A field
+// with a rectangle gets the signature appearance, other fields stay invisible.
+func (context *SignContext) signExistingField(field pdf.Value) error {
+	var rect [4]float64
+	if fieldRect := field.Key("Rect"); fieldRect.Len() == 4 {
+		llx, lly, urx, ury := fieldRect.Index(0).Float64(), fieldRect.Index(1).Float64(), fieldRect.Index(2).Float64(), fieldRect.Index(3).Float64()
+		rect = [4]float64{min(llx, urx), min(lly, ury), max(llx, urx), max(lly, ury)}
+	}
+
+	visible := rect[2] > rect[0] && rect[3] > rect[1]
+	switch context.SignData.Signature.CertType {
+	case CertificationSignature, ApprovalSignature:
+	default:
+		visible = false
+	}
+	if context.SignData.Appearance.Visible && !visible {
+		return fmt.Errorf("the field has no rectangle to draw a visible %s in", context.SignData.Signature.CertType)
+	}
+
+	field_ptr := field.GetPtr()
+
+	var field_buffer bytes.Buffer
+	field_buffer.WriteString("<<\n")
+
+	for _, key := range field.Keys() {
+		switch key {
+		case "V", "F":
+			continue
+		case "AP":
+			if visible {
+				continue
+			}
+		case "T":
+			field_buffer.WriteString(fmt.Sprintf("  /T %s\n", pdfString(field.Key("T").Text())))
+			continue
+		}
+		_, _ = fmt.Fprintf(&field_buffer, "  /%s ", key)
+		context.serializeCatalogEntry(&field_buffer, field_ptr.GetID(), field.Key(key))
+		field_buffer.WriteString("\n")
+	}
+
+	if visible {
+		appearance, err := context.createAppearance(rect)
+		if err != nil {
+			return fmt.Errorf("failed to create appearance: %w", err)
+		}
+
+		appearanceObjectId, err := context.addObject(appearance)
+		if err != nil {
+			return fmt.Errorf("failed to add appearance object: %w", err)
+		}
+
+		field_buffer.WriteString(fmt.Sprintf("  /AP << /N %d 0 R >>\n", appearanceObjectId))
+	}
+
+	annotationFlags := field.Key("F").Int64() | AnnotationFlagPrint | AnnotationFlagLocked
+	field_buffer.WriteString(fmt.Sprintf("  /F %d\n", annotationFlags))
+	field_buffer.WriteString(fmt.Sprintf("  /V %d 0 R\n", context.SignData.objectId))
+	field_buffer.WriteString(">>\n")
+
+	context.VisualSignData.objectId = field_ptr.GetID()
+
+	return context.updateObject(field_ptr.GetID(), field_buffer.Bytes())
+}
@@ -17,7 +17,7 @@ func (context *SignContext) addObject(object []byte) (uint32, error) {
 	objectID := context.lastXrefID + uint32(len(context.newXrefEntries)) + 1
 	context.newXrefEntries = append(context.newXrefEntries, xrefEntry{
 		ID:     objectID,
-		Offset: int64(context.OutputBuffer.Buff.Len()) + 1,
+		Offset: context.offset() + 1,
 	})
 
 	err := context.writeObject(objectID, object)
@@ -31,7 +31,7 @@ func (context *SignContext) addObject(object []byte) (uint32, error) {
 func (context *SignContext) updateObject(id uint32, object []byte) error {
 	context.updatedXrefEntries = append(context.updatedXrefEntries, xrefEntry{
 		ID:     id,
-		Offset: int64(context.OutputBuffer.Buff.Len()) + 1,
+		Offset: context.offset() + 1,
 	})
 
 	err := context.writeObject(id, object)
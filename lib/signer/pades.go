@@ -0,0 +1,290 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/digitorus/pdf"
+	"github.com/digitorus/pkcs7"
+)
+
+// addValidationData appends an incremental update with a Document Security
+// Store holding the certificates, OCSP responses and CRLs needed to validate
+// the signature just written to signedPDF, referenced from a /VRI entry keyed
+// by the signature hash. Entries of an existing DSS are kept.
+func (context *SignContext) addValidationData(signedPDF *io.SectionReader) (*io.SectionReader, error) {
+	rdr, err := pdf.NewReader(signedPDF, signedPDF.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF reader: %w", err)
+	}
+
+	// The field signed is not necessarily the last one, e.g. when filling
+	// fields added before
+	var contents []byte
+	for _, field := range signatureFields(rdr) {
+		if value := field.Key("V"); !value.IsNull() && signatureObjectId(value) == context.SignData.objectId {
+			contents = []byte(value.Key("Contents").RawString())
+			break
+		}
+	}
+	if contents == nil {
+		return nil, fmt.Errorf("no signature found")
+	}
+
+	certificates := context.SignData.CertificateChains
+	if len(certificates) == 0 {
+		certificates = [][]*x509.Certificate{{context.SignData.Certificate}}
+	}
+
+	revocation_data := context.SignData.RevocationData
+
+	// Validation data for the TSA that produced the signature timestamp
+	tsa_certificates, err := timestampCertificates(contents)
+	if err != nil {
+		return nil, err
+	}
+	if len(tsa_certificates) > 0 {
+		certificates = append(certificates, tsa_certificates)
+		if context.SignData.RevocationFunction != nil {
+			for _, certificate := range tsa_certificates {
+				if err := context.SignData.RevocationFunction(certificate, findIssuer(certificate, tsa_certificates), &revocation_data); err != nil {
+					return nil, fmt.Errorf("failed to fetch TSA revocation data: %w", err)
+				}
+			}
+		}
+	}
+
+	dss_data := map[string][][]byte{}
+	for _, chain := range certificates {
+		for _, certificate := range chain {
+			dss_data["Certs"] = append(dss_data["Certs"], certificate.Raw)
+		}
+	}
+	for _, ocsp := range revocation_data.OCSP {
+		dss_data["OCSPs"] = append(dss_data["OCSPs"], ocsp.FullBytes)
+	}
+	for _, crl := range revocation_data.CRL {
+		dss_data["CRLs"] = append(dss_data["CRLs"], crl.FullBytes)
+	}
+
+	update := &SignContext{
+		PDFReader: rdr,
+		InputFile: signedPDF,
+	}
+	if err := update.startUpdate(); err != nil {
+		return nil, err
+	}
+
+	vri_key := strings.ToUpper(hex.EncodeToString(sha1Sum(contents)))
+
+	dss, err := update.createDSS(vri_key, dss_data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DSS: %w", err)
+	}
+
+	update.dssObjectId, err = update.addObject(dss)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add DSS object: %w", err)
+	}
+
+	catalog, err := update.createCatalog()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create catalog: %w", err)
+	}
+
+	update.CatalogData.ObjectId, err = update.addObject(catalog)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add catalog object: %w", err)
+	}
+
+	if err := update.writeXref(); err != nil {
+		return nil, fmt.Errorf("failed to write xref: %w", err)
+	}
+
+	if err := update.writeTrailer(); err != nil {
+		return nil, fmt.Errorf("failed to write trailer: %w", err)
+	}
+
+	return appendUpdate(signedPDF, update.updateOffset, update.OutputBuffer.Buff.Bytes()), nil
+}
+
+// createDSS builds the /DSS dictionary from the existing store and the
+// validation data of the new signature, which also gets its /VRI entry.
+func (context *SignContext) createDSS(vriKey string, data map[string][][]byte) ([]byte, error) {
+	existing := context.PDFReader.Trailer().Key("Root").Key("DSS")
+
+	var dss_buffer bytes.Buffer
+	var vri_buffer bytes.Buffer
+
+	dss_buffer.WriteString("<<\n")
+	dss_buffer.WriteString("  /Type /DSS\n")
+
+	for _, key := range []string{"Certs", "OCSPs", "CRLs"} {
+		all, used, err := context.addDSSStreams(existing.Key(key), data[key])
+		if err != nil {
+			return nil, err
+		}
+
+		if len(all) > 0 {
+			dss_buffer.WriteString(fmt.Sprintf("  /%s [%s]\n", key, strings.Join(all, " ")))
+		}
+		if len(used) > 0 {
+			// VRI uses the singular names: /Cert, /OCSP and /CRL
+			vri_buffer.WriteString(fmt.Sprintf("      /%s [%s]\n", strings.TrimSuffix(key, "s"), strings.Join(used, " ")))
+		}
+	}
+
+	dss_buffer.WriteString("  /VRI <<\n")
+
+	existing_vri := existing.Key("VRI")
+	existing_vri_ptr := existing_vri.GetPtr()
+	for _, key := range existing_vri.Keys() {
+		if key == vriKey {
+			continue
+		}
+		dss_buffer.WriteString(fmt.Sprintf("    /%s ", key))
+		context.serializeCatalogEntry(&dss_buffer, existing_vri_ptr.GetID(), existing_vri.Key(key))
+		dss_buffer.WriteString("\n")
+	}
+
+	dss_buffer.WriteString(fmt.Sprintf("    /%s <<\n", vriKey))
+	dss_buffer.Write(vri_buffer.Bytes())
+	dss_buffer.WriteString("    >>\n")
+	dss_buffer.WriteString("  >>\n")
+	dss_buffer.WriteString(">>\n")
+
+	return dss_buffer.Bytes(), nil
+}
+
+// addDSSStreams writes the entries of data not yet present in the existing
+// DSS array as streams. It returns the references of the whole array and the
+// references of the entries in data.
+func (context *SignContext) addDSSStreams(existing pdf.Value, data [][]byte) ([]string, []string, error) {
+	var all, used []string
+
+	known := map[string]string{}
+	for i := 0; i < existing.Len(); i++ {
+		stream := existing.Index(i)
+		ptr := stream.GetPtr()
+		ref := fmt.Sprintf("%d %d R", ptr.GetID(), ptr.GetGen())
+		all = append(all, ref)
+
+		content, err := io.ReadAll(stream.Reader())
+		if err == nil {
+			known[string(content)] = ref
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, entry := range data {
+		ref, ok := known[string(entry)]
+		if !ok {
+			stream, err := dssStream(entry)
+			if err != nil {
+				return nil, nil, err
+			}
+			id, err := context.addObject(stream)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to add DSS stream: %w", err)
+			}
+			ref = fmt.Sprintf("%d 0 R", id)
+			known[string(entry)] = ref
+			all = append(all, ref)
+		}
+
+		if !seen[ref] {
+			seen[ref] = true
+			used = append(used, ref)
+		}
+	}
+
+	return all, used, nil
+}
+
+func dssStream(data []byte) ([]byte, error) {
+	compressed, err := flateEncode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var stream_buffer bytes.Buffer
+	stream_buffer.WriteString("<<\n")
+	stream_buffer.WriteString("  /Filter /FlateDecode\n")
+	stream_buffer.WriteString(fmt.Sprintf("  /Length %d\n", len(compressed)))
+	stream_buffer.WriteString(">>\n")
+	stream_buffer.WriteString("stream\n")
+	stream_buffer.Write(compressed)
+	stream_buffer.WriteString("\nendstream\n")
+
+	return stream_buffer.Bytes(), nil
+}
+
+// addArchiveTimestamp finishes a B-LTA signature with a document timestamp
+// covering the signature and its validation data, written to output.
+func addArchiveTimestamp(ctx context.Context, signedPDF *io.SectionReader, output io.Writer, sign_data SignData) error {
+	rdr, err := pdf.NewReader(signedPDF, signedPDF.Size())
+	if err != nil {
+		return fmt.Errorf("failed to create PDF reader: %w", err)
+	}
+
+	return signWithContext(ctx, signedPDF, output, rdr, signedPDF.Size(), SignData{
+		Signature: SignDataSignature{
+			CertType: TimeStampSignature,
+		},
+		DigestAlgorithm: sign_data.DigestAlgorithm,
+		TSA:             sign_data.TSA,
+	})
+}
+
+// timestampCertificates returns the certificates embedded in the signature
+// timestamp of a CMS signature, if it has one.
+func timestampCertificates(contents []byte) ([]*x509.Certificate, error) {
+	p7, err := pkcs7.Parse(contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signature: %w", err)
+	}
+
+	var certificates []*x509.Certificate
+	for _, signer := range p7.Signers {
+		for _, attr := range signer.UnauthenticatedAttributes {
+			if !attr.Type.Equal(timestampTokenOID) {
+				continue
+			}
+			token, err := pkcs7.Parse(attr.Value.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse timestamp token: %w", err)
+			}
+			certificates = append(certificates, token.Certificates...)
+		}
+	}
+
+	return certificates, nil
+}
+
+// findIssuer returns the certificate in candidates that issued cert, nil
+// for self-signed certificates or when the issuer is not included.
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if candidate != cert && cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func sha1Sum(data []byte) []byte {
+	sum := sha1.Sum(data)
+	return sum[:]
+}
+
+// signatureObjectId returns the object number of a signature dictionary
+func signatureObjectId(value pdf.Value) uint32 {
+	ptr := value.GetPtr()
+	return ptr.GetID()
+}
@@ -3,14 +3,6 @@ package signer
 import (
 	"crypto/x509"
 	"encoding/asn1"
-	"encoding/base64"
-	"encoding/hex"
-	"fmt"
-	"io"
-	"net/http"
-	"strings"
-
-	"golang.org/x/crypto/ocsp"
 )
 
 func (context *SignContext) fetchRevocationData() error {
@@ -35,74 +27,15 @@ func (context *SignContext) fetchRevocationData() error {
 		}
 	}
 
-	for _, crl := range context.SignData.RevocationData.CRL {
-		context.SignatureMaxLength += uint32(hex.EncodedLen(len(crl.FullBytes)))
-	}
-	for _, ocsp := range context.SignData.RevocationData.OCSP {
-		context.SignatureMaxLength += uint32(hex.EncodedLen(len(ocsp.FullBytes)))
-	}
-
 	return nil
 }
 
+// DefaultEmbedRevocationStatusFunction embeds OCSP responses, or CRLs when no
+// OCSP responder answers, using a shared RevocationClient with an in-memory
+// cache. Create a RevocationClient to configure timeouts, a proxy or a disk
+// cache and use its EmbedRevocationStatus method instead.
 func DefaultEmbedRevocationStatusFunction(cert, issuer *x509.Certificate, i *InfoArchival) error {
-
-	if issuer != nil && len(cert.OCSPServer) > 0 {
-		err := embedOCSPRevocationStatus(cert, issuer, i)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(cert.CRLDistributionPoints) > 0 {
-		err := embedCRLRevocationStatus(cert, issuer, i)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func embedOCSPRevocationStatus(cert, issuer *x509.Certificate, i *InfoArchival) error {
-	req, err := ocsp.CreateRequest(cert, issuer, nil)
-	if err != nil {
-		return err
-	}
-
-	ocspUrl := fmt.Sprintf("%s/%s", strings.TrimRight(cert.OCSPServer[0], "/"),
-		base64.StdEncoding.EncodeToString(req))
-
-	resp, err := http.Get(ocspUrl)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	_, err = ocsp.ParseResponseForCert(body, cert, issuer)
-	if err != nil {
-		return err
-	}
-
-	return i.AddOCSP(body)
-}
-
-func embedCRLRevocationStatus(cert, issuer *x509.Certificate, i *InfoArchival) error {
-	resp, err := http.Get(cert.CRLDistributionPoints[0])
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	return i.AddCRL(body)
+	return defaultRevocationClient.EmbedRevocationStatus(cert, issuer, i)
 }
 
 func (r *InfoArchival) AddCRL(b []byte) error {
@@ -0,0 +1,409 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	defaultRevocationTimeout = 10 * time.Second
+	// defaultRevocationMaxAge applies to responses without a next update time
+	defaultRevocationMaxAge = time.Hour
+	// OCSP requests are sent with GET when the encoded request is shorter, see RFC 5019
+	maxOCSPGetRequestLength   = 255
+	maxRevocationResponseSize = 10 << 20
+)
+
+// ErrCertificateRevoked is returned when an OCSP response or CRL lists the certificate as revoked
+var ErrCertificateRevoked = errors.New("certificate is revoked")
+
+// RevocationClientConfig configures how OCSP responses and CRLs are fetched and cached.
+type RevocationClientConfig struct {
+	// Timeout per request, 10 seconds when zero
+	Timeout time.Duration
+	// Proxy is the URL of the HTTP proxy to use, when empty HTTP_PROXY and
+	// HTTPS_PROXY from the environment apply
+	Proxy string
+	// HTTPClient replaces the client built from Timeout and Proxy
+	HTTPClient *http.Client
+	// CacheDir keeps responses on disk as well, so they survive restarts
+	CacheDir string
+	// MaxAge is how long responses without a next update time are reused, 1 hour when zero
+	MaxAge time.Duration
+}
+
+// RevocationClient fetches OCSP responses and CRLs for the certificates of a
+// signature. Every OCSP responder and CRL distribution point of a certificate
+// is tried in order. Responses are checked against the issuer and reused from
+// the cache until their next update time.
+type RevocationClient struct {
+	client *http.Client
+	maxAge time.Duration
+	cache  *revocationCache
+}
+
+func NewRevocationClient(conf RevocationClientConfig) (*RevocationClient, error) {
+	client := conf.HTTPClient
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if conf.Proxy != "" {
+			proxyURL, err := url.Parse(conf.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy URL: %w", err)
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+
+		timeout := conf.Timeout
+		if timeout == 0 {
+			timeout = defaultRevocationTimeout
+		}
+		client = &http.Client{Timeout: timeout, Transport: transport}
+	}
+
+	maxAge := conf.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultRevocationMaxAge
+	}
+
+	if conf.CacheDir != "" {
+		if err := os.MkdirAll(conf.CacheDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create revocation cache directory: %w", err)
+		}
+	}
+
+	return &RevocationClient{
+		client: client,
+		maxAge: maxAge,
+		cache:  &revocationCache{dir: conf.CacheDir, entries: map[string]revocationCacheEntry{}},
+	}, nil
+}
+
+// defaultRevocationClient backs DefaultEmbedRevocationStatusFunction
+var defaultRevocationClient, _ = NewRevocationClient(RevocationClientConfig{})
+
+// EmbedRevocationStatus is a RevocationFunction. It adds an OCSP response for
+// cert and falls back to its CRL when no responder answers. Certificates
+// without an issuer, the root of a chain, are skipped.
+func (c *RevocationClient) EmbedRevocationStatus(cert, issuer *x509.Certificate, i *InfoArchival) error {
+	if issuer == nil || (len(cert.OCSPServer) == 0 && len(cert.CRLDistributionPoints) == 0) {
+		return nil
+	}
+
+	var errs []error
+
+	if len(cert.OCSPServer) > 0 {
+		response, err := c.OCSP(context.Background(), cert, issuer)
+		if err == nil {
+			return i.AddOCSP(response)
+		}
+		if errors.Is(err, ErrCertificateRevoked) {
+			return err
+		}
+		errs = append(errs, err)
+	}
+
+	if len(cert.CRLDistributionPoints) > 0 {
+		crl, err := c.CRL(context.Background(), cert, issuer)
+		if err == nil {
+			return i.AddCRL(crl)
+		}
+		if errors.Is(err, ErrCertificateRevoked) {
+			return err
+		}
+		errs = append(errs, err)
+	}
+
+	return fmt.Errorf("failed to get revocation status of %q: %w", cert.Subject.CommonName, errors.Join(errs...))
+}
+
+// OCSP returns a DER OCSP response for cert signed for issuer, with a good status.
+func (c *RevocationClient) OCSP(ctx context.Context, cert, issuer *x509.Certificate) ([]byte, error) {
+	issuer_hash := sha256.Sum256(issuer.Raw)
+	cache_key := "ocsp-" + hex.EncodeToString(issuer_hash[:]) + "-" + cert.SerialNumber.Text(16)
+
+	if cached, fetched_at, ok := c.cache.get(cache_key); ok {
+		err := c.checkOCSP(cached, fetched_at, cert, issuer)
+		if err == nil {
+			return cached, nil
+		}
+		if errors.Is(err, ErrCertificateRevoked) {
+			return nil, err
+		}
+	}
+
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, server := range cert.OCSPServer {
+		body, err := c.fetchOCSP(ctx, server, req)
+		if err == nil {
+			err = c.checkOCSP(body, time.Now(), cert, issuer)
+		}
+		if err != nil {
+			if errors.Is(err, ErrCertificateRevoked) {
+				return nil, err
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+
+		c.cache.put(cache_key, body)
+		return body, nil
+	}
+
+	return nil, fmt.Errorf("no OCSP responder answered: %w", errors.Join(errs...))
+}
+
+func (c *RevocationClient) fetchOCSP(ctx context.Context, server string, ocspReq []byte) ([]byte, error) {
+	var req *http.Request
+	var err error
+
+	encoded := base64.StdEncoding.EncodeToString(ocspReq)
+	if len(encoded) < maxOCSPGetRequestLength {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(server, "/")+"/"+url.PathEscape(encoded), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(ocspReq))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/ocsp-request")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	return c.do(req)
+}
+
+// checkOCSP verifies the response signature and that it is current
+func (c *RevocationClient) checkOCSP(response []byte, fetchedAt time.Time, cert, issuer *x509.Certificate) error {
+	parsed, err := ocsp.ParseResponseForCert(response, cert, issuer)
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkFreshness(parsed.ThisUpdate, parsed.NextUpdate, fetchedAt); err != nil {
+		return fmt.Errorf("OCSP response %w", err)
+	}
+
+	switch parsed.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("%w since %s", ErrCertificateRevoked, parsed.RevokedAt)
+	default:
+		return fmt.Errorf("OCSP responder does not know the certificate")
+	}
+}
+
+// CRL returns the DER CRL of the first distribution point of cert that serves
+// a current CRL signed by issuer that does not list cert.
+func (c *RevocationClient) CRL(ctx context.Context, cert, issuer *x509.Certificate) ([]byte, error) {
+	var errs []error
+	for _, distribution_point := range cert.CRLDistributionPoints {
+		if !strings.HasPrefix(distribution_point, "http://") && !strings.HasPrefix(distribution_point, "https://") {
+			errs = append(errs, fmt.Errorf("%s: unsupported scheme", distribution_point))
+			continue
+		}
+
+		cache_key := "crl-" + distribution_point
+		if cached, fetched_at, ok := c.cache.get(cache_key); ok {
+			err := c.checkCRL(cached, fetched_at, cert, issuer)
+			if err == nil {
+				return cached, nil
+			}
+			if errors.Is(err, ErrCertificateRevoked) {
+				return nil, err
+			}
+		}
+
+		body, err := c.fetchCRL(ctx, distribution_point)
+		if err == nil {
+			err = c.checkCRL(body, time.Now(), cert, issuer)
+		}
+		if err != nil {
+			if errors.Is(err, ErrCertificateRevoked) {
+				return nil, err
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", distribution_point, err))
+			continue
+		}
+
+		c.cache.put(cache_key, body)
+		return body, nil
+	}
+
+	return nil, fmt.Errorf("no CRL distribution point answered: %w", errors.Join(errs...))
+}
+
+func (c *RevocationClient) fetchCRL(ctx context.Context, distributionPoint string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, distributionPoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Some CAs serve PEM, the DSS and the CMS need DER
+	if block, _ := pem.Decode(body); block != nil && block.Type == "X509 CRL" {
+		body = block.Bytes
+	}
+
+	return body, nil
+}
+
+// checkCRL verifies the CRL was issued and signed by issuer, is current and
+// does not list cert.
+func (c *RevocationClient) checkCRL(crl []byte, fetchedAt time.Time, cert, issuer *x509.Certificate) error {
+	parsed, err := x509.ParseRevocationList(crl)
+	if err != nil {
+		return fmt.Errorf("failed to parse CRL: %w", err)
+	}
+
+	if !bytes.Equal(parsed.RawIssuer, issuer.RawSubject) {
+		return fmt.Errorf("CRL issuer %s does not match %s", parsed.Issuer, issuer.Subject)
+	}
+	if err := parsed.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("invalid CRL signature: %w", err)
+	}
+
+	if err := c.checkFreshness(parsed.ThisUpdate, parsed.NextUpdate, fetchedAt); err != nil {
+		return fmt.Errorf("CRL %w", err)
+	}
+
+	for _, entry := range parsed.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return fmt.Errorf("%w since %s", ErrCertificateRevoked, entry.RevocationTime)
+		}
+	}
+
+	return nil
+}
+
+// checkFreshness accepts data until nextUpdate, or for MaxAge after it was
+// fetched when there is no next update time.
+func (c *RevocationClient) checkFreshness(thisUpdate, nextUpdate, fetchedAt time.Time) error {
+	now := time.Now()
+	if thisUpdate.After(now.Add(time.Minute)) {
+		return fmt.Errorf("is not valid before %s", thisUpdate)
+	}
+	if !nextUpdate.IsZero() && now.After(nextUpdate) {
+		return fmt.Errorf("expired at %s", nextUpdate)
+	}
+	if nextUpdate.IsZero() && now.Sub(fetchedAt) > c.maxAge {
+		return fmt.Errorf("is older than %s", c.maxAge)
+	}
+	return nil
+}
+
+func (c *RevocationClient) do(req *http.Request) ([]byte, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseSize))
+}
+
+// revocationCache keeps responses in memory and, with a directory, on disk.
+// Entries are validated by the caller, stale ones are simply overwritten.
+type revocationCache struct {
+	dir     string
+	mu      sync.RWMutex
+	entries map[string]revocationCacheEntry
+}
+
+type revocationCacheEntry struct {
+	data      []byte
+	fetchedAt time.Time
+}
+
+func (c *revocationCache) get(key string) ([]byte, time.Time, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if ok {
+		return entry.data, entry.fetchedAt, true
+	}
+
+	if c.dir == "" {
+		return nil, time.Time{}, false
+	}
+
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+
+	c.mu.Lock()
+	c.entries[key] = revocationCacheEntry{data: data, fetchedAt: info.ModTime()}
+	c.mu.Unlock()
+
+	return data, info.ModTime(), true
+}
+
+func (c *revocationCache) put(key string, data []byte) {
+	c.mu.Lock()
+	c.entries[key] = revocationCacheEntry{data: data, fetchedAt: time.Now()}
+	c.mu.Unlock()
+
+	if c.dir == "" {
+		return
+	}
+
+	// Write and rename so concurrent readers never see a partial file
+	tmp, err := os.CreateTemp(c.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+func (c *revocationCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
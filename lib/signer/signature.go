@@ -3,21 +3,29 @@ package signer
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"strconv"
 	"strings"
 
 	"github.com/digitorus/pkcs7"
-	"github.com/digitorus/timestamp"
 	"golang.org/x/crypto/cryptobyte"
 
 	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
 )
 
+// errSignatureTooLong is returned by replaceSignature when the signature does
+// not fit the /Contents placeholder, the placeholder has been enlarged.
+var errSignatureTooLong = errors.New("signature does not fit the reserved space")
+
 func (context *SignContext) createSignaturePlaceholder() []byte {
 
 	var signature_buffer bytes.Buffer
@@ -25,7 +33,11 @@ func (context *SignContext) createSignaturePlaceholder() []byte {
 	signature_buffer.WriteString("<<\n")
 	signature_buffer.WriteString(" /Type /Sig\n")
 	signature_buffer.WriteString(" /Filter /Adobe.PPKLite\n")
-	signature_buffer.WriteString(" /SubFilter /adbe.pkcs7.detached\n")
+	if context.SignData.Profile == ProfilePKCS7 {
+		signature_buffer.WriteString(" /SubFilter /adbe.pkcs7.detached\n")
+	} else {
+		signature_buffer.WriteString(" /SubFilter /ETSI.CAdES.detached\n")
+	}
 
 	signature_buffer.WriteString(context.createPropBuild())
 
@@ -122,7 +134,8 @@ func (context *SignContext) createSignaturePlaceholder() []byte {
 		signature_buffer.WriteString("\n")
 	}
 
-	if context.SignData.TSA.URL == "" && !context.SignData.Signature.Info.Date.IsZero() {
+	// PAdES carries the claimed signing time in /M only, the CMS has no signing-time attribute
+	if (!context.SignData.TSA.enabled() || context.SignData.Profile != ProfilePKCS7) && !context.SignData.Signature.Info.Date.IsZero() {
 		signature_buffer.WriteString(" /M ")
 		signature_buffer.WriteString(pdfDateTime(context.SignData.Signature.Info.Date))
 		signature_buffer.WriteString("\n")
@@ -154,32 +167,24 @@ func (context *SignContext) createTimestampPlaceholder() []byte {
 }
 
 func (context *SignContext) createSignature() ([]byte, error) {
-	if _, err := context.OutputBuffer.Seek(0, 0); err != nil {
-		return nil, err
+	digest, err := context.byteRangeDigest()
+	if err != nil {
+		return nil, fmt.Errorf("hash byte range: %w", err)
 	}
 
-	file_content := context.OutputBuffer.Buff.Bytes()
-
-	sign_content := make([]byte, 0)
-	sign_content = append(sign_content, file_content[context.ByteRangeValues[0]:(context.ByteRangeValues[0]+context.ByteRangeValues[1])]...)
-	sign_content = append(sign_content, file_content[context.ByteRangeValues[2]:(context.ByteRangeValues[2]+context.ByteRangeValues[3])]...)
-
 	if context.SignData.Signature.CertType == TimeStampSignature {
 
-		timestamp_response, err := context.GetTSA(sign_content)
+		_, ts, err := context.SignData.TSA.timestampDigest(context.ctx, digest, context.SignData.DigestAlgorithm)
 		if err != nil {
 			return nil, fmt.Errorf("get timestamp: %w", err)
 		}
 
-		ts, err := timestamp.ParseResponse(timestamp_response)
-		if err != nil {
-			return nil, fmt.Errorf("parse timestamp: %w", err)
-		}
-
 		return ts.RawToken, nil
 	}
 
-	signed_data, err := pkcs7.NewSignedData(sign_content)
+	// The content was hashed while streaming it, pkcs7 only assembles the
+	// structure and the signed attributes are signed in signAttributes
+	signed_data, err := pkcs7.NewSignedData(nil)
 	if err != nil {
 		return nil, fmt.Errorf("new signed data: %w", err)
 	}
@@ -191,39 +196,34 @@ func (context *SignContext) createSignature() ([]byte, error) {
 	}
 
 	signer_config := pkcs7.SignerInfoConfig{
-		ExtraSignedAttributes: []pkcs7.Attribute{
-			{
-				Type:  asn1.ObjectIdentifier{1, 2, 840, 113583, 1, 1, 8},
-				Value: context.SignData.RevocationData,
-			},
-			*signingCertificate,
-		},
+		ExtraSignedAttributes: []pkcs7.Attribute{*signingCertificate},
 	}
-
-	var certificate_chain []*x509.Certificate
-	if len(context.SignData.CertificateChains) > 0 && len(context.SignData.CertificateChains[0]) > 1 {
-		certificate_chain = context.SignData.CertificateChains[0][1:]
+	// PAdES keeps revocation data in the DSS instead of the Adobe attribute
+	if context.SignData.Profile == ProfilePKCS7 {
+		signer_config.ExtraSignedAttributes = append(signer_config.ExtraSignedAttributes, pkcs7.Attribute{
+			Type:  asn1.ObjectIdentifier{1, 2, 840, 113583, 1, 1, 8},
+			Value: context.SignData.RevocationData,
+		})
 	}
 
-	if err := signed_data.AddSignerChain(context.SignData.Certificate, context.SignData.Signer, certificate_chain, signer_config); err != nil {
+	if err := signed_data.AddSignerChain(context.SignData.Certificate, unsignedSigner{context.SignData.Signer}, context.certificateChain(), signer_config); err != nil {
 		return nil, fmt.Errorf("add signer chain: %w", err)
 	}
 
+	if err := context.signAttributes(signed_data, digest); err != nil {
+		return nil, fmt.Errorf("sign attributes: %w", err)
+	}
+
 	signed_data.Detach()
 
-	if context.SignData.TSA.URL != "" {
+	if context.SignData.TSA.enabled() {
 		signature_data := signed_data.GetSignedData()
 
-		timestamp_response, err := context.GetTSA(signature_data.SignerInfos[0].EncryptedDigest)
+		ts, err := context.getTimestamp(signature_data.SignerInfos[0].EncryptedDigest)
 		if err != nil {
 			return nil, fmt.Errorf("get timestamp: %w", err)
 		}
 
-		ts, err := timestamp.ParseResponse(timestamp_response)
-		if err != nil {
-			return nil, fmt.Errorf("parse timestamp: %w", err)
-		}
-
 		_, err = pkcs7.Parse(ts.RawToken)
 		if err != nil {
 			return nil, fmt.Errorf("parse timestamp token: %w", err)
@@ -241,6 +241,66 @@ func (context *SignContext) createSignature() ([]byte, error) {
 	return signed_data.Finish()
 }
 
+// unsignedSigner stands in for the signer while pkcs7 assembles the signer
+// info, the signed attributes are signed once they are final.
+type unsignedSigner struct {
+	crypto.Signer
+}
+
+func (unsignedSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, nil
+}
+
+// signAttributes sets the messageDigest attribute to digest, drops the
+// signing-time attribute pkcs7 always adds when PAdES forbids it, and signs
+// the final signed attributes.
+func (context *SignContext) signAttributes(signed_data *pkcs7.SignedData, digest []byte) error {
+	signer_info := &signed_data.GetSignedData().SignerInfos[0]
+
+	message_digest, err := asn1.Marshal(digest)
+	if err != nil {
+		return err
+	}
+
+	attributes := signer_info.AuthenticatedAttributes[:0]
+	for _, attribute := range signer_info.AuthenticatedAttributes {
+		if context.SignData.Profile != ProfilePKCS7 && attribute.Type.Equal(pkcs7.OIDAttributeSigningTime) {
+			continue
+		}
+		if attribute.Type.Equal(pkcs7.OIDAttributeMessageDigest) {
+			attribute.Value.Bytes = message_digest
+		}
+		attributes = append(attributes, attribute)
+	}
+	signer_info.AuthenticatedAttributes = attributes
+
+	// The signature is computed over the DER encoded SET OF signed attributes
+	encoded, err := asn1.MarshalWithParams(attributes, "set")
+	if err != nil {
+		return err
+	}
+
+	// Ed25519 hashes as part of signing
+	if _, ok := context.SignData.Signer.Public().(ed25519.PublicKey); ok {
+		signer_info.EncryptedDigest, err = context.SignData.Signer.Sign(rand.Reader, encoded, crypto.Hash(0))
+		return err
+	}
+
+	hash := context.SignData.DigestAlgorithm.New()
+	hash.Write(encoded)
+
+	signer_info.EncryptedDigest, err = context.SignData.Signer.Sign(rand.Reader, hash.Sum(nil), context.SignData.DigestAlgorithm)
+	return err
+}
+
+// certificateChain returns the certificates of the first chain after the signing certificate
+func (context *SignContext) certificateChain() []*x509.Certificate {
+	if len(context.SignData.CertificateChains) > 0 && len(context.SignData.CertificateChains[0]) > 1 {
+		return context.SignData.CertificateChains[0][1:]
+	}
+	return nil
+}
+
 func (context *SignContext) createSigningCertificateAttribute() (*pkcs7.Attribute, error) {
 	hash := context.SignData.DigestAlgorithm.New()
 	hash.Write(context.SignData.Certificate.Raw)
@@ -256,6 +316,15 @@ func (context *SignContext) createSigningCertificateAttribute() (*pkcs7.Attribut
 					})
 				}
 				b.AddASN1OctetString(hash.Sum(nil))
+				// issuerSerial binds the hash to the issuer name and serial number
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						b.AddASN1(cryptobyte_asn1.Tag(4).ContextSpecific().Constructed(), func(b *cryptobyte.Builder) {
+							b.AddBytes(context.SignData.Certificate.RawIssuer)
+						})
+					})
+					b.AddASN1BigInt(context.SignData.Certificate.SerialNumber)
+				})
 			})
 		})
 	})
@@ -275,23 +344,21 @@ func (context *SignContext) createSigningCertificateAttribute() (*pkcs7.Attribut
 }
 
 func (context *SignContext) updateByteRange() error {
-	if _, err := context.OutputBuffer.Seek(0, 0); err != nil {
-		return err
-	}
+	update := context.OutputBuffer.Buff.Bytes()
 
 	contentsPlaceholder := bytes.Repeat([]byte("0"), int(context.SignatureMaxLength))
-	contentsIndex := bytes.Index(context.OutputBuffer.Buff.Bytes(), contentsPlaceholder)
+	contentsIndex := bytes.Index(update, contentsPlaceholder)
 	if contentsIndex == -1 {
 		return fmt.Errorf("failed to find contents placeholder")
 	}
 
-	signatureContentsStart := int64(contentsIndex) - 1
+	signatureContentsStart := context.updateOffset + int64(contentsIndex) - 1
 	signatureContentsEnd := signatureContentsStart + int64(context.SignatureMaxLength) + 2
 	context.ByteRangeValues = []int64{
 		0,
 		signatureContentsStart,
 		signatureContentsEnd,
-		int64(context.OutputBuffer.Buff.Len()) - signatureContentsEnd,
+		context.offset() - signatureContentsEnd,
 	}
 
 	new_byte_range := fmt.Sprintf("/ByteRange [%d %d %d %d]", context.ByteRangeValues[0], context.ByteRangeValues[1], context.ByteRangeValues[2], context.ByteRangeValues[3])
@@ -302,18 +369,13 @@ func (context *SignContext) updateByteRange() error {
 		return fmt.Errorf("new byte range string is the same lenght as the placeholder")
 	}
 
-	placeholderIndex := bytes.Index(context.OutputBuffer.Buff.Bytes(), []byte(signatureByteRangePlaceholder))
+	placeholderIndex := bytes.Index(update, []byte(signatureByteRangePlaceholder))
 	if placeholderIndex == -1 {
 		return fmt.Errorf("failed to find ByteRange placeholder")
 	}
 
-	bufferBytes := context.OutputBuffer.Buff.Bytes()
-	copy(bufferBytes[placeholderIndex:placeholderIndex+len(new_byte_range)], []byte(new_byte_range))
-
-	context.OutputBuffer.Buff.Reset()
-	if _, err := context.OutputBuffer.Buff.Write(bufferBytes); err != nil {
-		return err
-	}
+	// Patched in place, the update keeps its length
+	copy(update[placeholderIndex:], new_byte_range)
 
 	return nil
 }
@@ -328,43 +390,72 @@ func (context *SignContext) replaceSignature() error {
 	hex.Encode(dst, signature)
 
 	if uint32(len(dst)) > context.SignatureMaxLength {
-		log.Println("Signature too long, retrying with increased buffer size.")
-
-		context.SignatureMaxLengthBase += (uint32(len(dst)) - context.SignatureMaxLength) + 1
-		return context.SignPDF()
+		// Keep the placeholder an even number of hex digits
+		context.SignatureMaxLengthBase += (uint32(len(dst)) - context.SignatureMaxLength) + 2
+		return errSignatureTooLong
 	}
 
-	if _, err := context.OutputBuffer.Seek(0, 0); err != nil {
-		return err
-	}
-	file_content := context.OutputBuffer.Buff.Bytes()
+	// The zero filled placeholder after the signature is its padding
+	contentsStart := context.ByteRangeValues[1] - context.updateOffset + 1
+	copy(context.OutputBuffer.Buff.Bytes()[contentsStart:], dst)
 
-	if _, err := context.OutputBuffer.Write(file_content[context.ByteRangeValues[0]:context.ByteRangeValues[1]]); err != nil {
-		return err
-	}
+	return nil
+}
 
-	if _, err := context.OutputBuffer.Write([]byte("<")); err != nil {
-		return err
+// signatureSize returns the size of the CMS the signature will have: the
+// signature value, certificates, signed attributes and embedded revocation
+// data are known before signing, only a timestamp token is estimated.
+func (context *SignContext) signatureSize() (int, error) {
+	size := 0
+	if context.SignData.TSA.enabled() {
+		size += timestampTokenSize
 	}
-
-	if _, err := context.OutputBuffer.Write([]byte(dst)); err != nil {
-		return err
+	if context.SignData.Signature.CertType == TimeStampSignature {
+		return size, nil
 	}
 
-	zeroPadding := bytes.Repeat([]byte("0"), int(context.SignatureMaxLength)-len(dst))
-	if _, err := context.OutputBuffer.Write(zeroPadding); err != nil {
-		return err
+	certificate := context.SignData.Certificate
+	digest_size := context.SignData.DigestAlgorithm.Size()
+
+	// Structure, algorithm identifiers and the contentType and signing-time attributes
+	size += 512
+
+	switch public_key := certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		size += public_key.Size()
+	case *ecdsa.PublicKey:
+		// DER SEQUENCE of two INTEGERs, each possibly with a leading zero
+		size += 2*((public_key.Curve.Params().BitSize+7)/8+3) + 3
+	case ed25519.PublicKey:
+		size += ed25519.SignatureSize
+	default:
+		return 0, fmt.Errorf("unsupported public key type %T", public_key)
 	}
 
-	if _, err := context.OutputBuffer.Write([]byte(">")); err != nil {
-		return err
+	// issuerAndSerialNumber, messageDigest and signingCertificate attributes
+	size += 2*len(certificate.RawIssuer) + 2*len(certificate.SerialNumber.Bytes()) + 3*digest_size
+
+	size += len(certificate.Raw)
+	for _, cert := range context.certificateChain() {
+		size += len(cert.Raw)
 	}
 
-	if _, err := context.OutputBuffer.Write(file_content[context.ByteRangeValues[2] : context.ByteRangeValues[2]+context.ByteRangeValues[3]]); err != nil {
-		return err
+	if context.SignData.Profile == ProfilePKCS7 {
+		revocation_data, err := asn1.Marshal(context.SignData.RevocationData)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode revocation data: %w", err)
+		}
+		size += len(revocation_data)
 	}
 
-	return nil
+	return size, nil
+}
+
+// byteRangeContent returns the parts of file covered by a signature byte range
+func byteRangeContent(file []byte, byteRange []int64) []byte {
+	content := make([]byte, 0, byteRange[1]+byteRange[3])
+	content = append(content, file[byteRange[0]:byteRange[0]+byteRange[1]]...)
+	return append(content, file[byteRange[2]:byteRange[2]+byteRange[3]]...)
 }
 
 func getOIDFromHashAlgorithm(target crypto.Hash) asn1.ObjectIdentifier {
@@ -6,42 +6,84 @@ import (
 	"crypto"
 	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"time"
 
 	"github.com/digitorus/pdf"
-	"github.com/digitorus/pkcs7"
-	"github.com/mattetti/filebuffer"
 )
 
 func Sign(input io.ReadSeeker, output io.Writer, rdr *pdf.Reader, size int64, sign_data SignData) error {
+	return signWithContext(context.Background(), input, output, rdr, size, sign_data)
+}
+
+// signWithContext is Sign with ctx bounding the TSA requests
+func signWithContext(ctx context.Context, input io.ReadSeeker, output io.Writer, rdr *pdf.Reader, size int64, sign_data SignData) error {
+	context, err := newSignContext(ctx, input, output, rdr, sign_data)
+	if err != nil {
+		return err
+	}
+
+	if sign_data.Profile < ProfilePAdESBLT {
+		return context.SignPDF()
+	}
+
+	// B-LT and B-LTA add further incremental updates to the signed
+	// document, which is read from the input and the signature update.
+	if err := context.sign(); err != nil {
+		return err
+	}
+
+	signed, err := context.addValidationData(context.signedRevision())
+	if err != nil {
+		return fmt.Errorf("failed to add validation data: %w", err)
+	}
+
+	if sign_data.Profile == ProfilePAdESBLTA {
+		if err := addArchiveTimestamp(ctx, signed, output, context.SignData); err != nil {
+			return fmt.Errorf("failed to add archive timestamp: %w", err)
+		}
+		return nil
+	}
+
+	_, err = io.Copy(output, signed)
+	return err
+}
+
+func newSignContext(ctx context.Context, input io.ReadSeeker, output io.Writer, rdr *pdf.Reader, sign_data SignData) (*SignContext, error) {
 	sign_data.objectId = uint32(rdr.XrefInformation.ItemCount) + 2
 
-	context := SignContext{
+	context := &SignContext{
 		PDFReader:              rdr,
 		InputFile:              input,
 		OutputFile:             output,
 		SignData:               sign_data,
 		SignatureMaxLengthBase: uint32(hex.EncodedLen(512)),
+		ctx:                    ctx,
 	}
 
 	existingSignatures, err := context.fetchExistingSignatures()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	context.existingSignatures = existingSignatures
 
-	err = context.SignPDF()
-	if err != nil {
+	return context, nil
+}
+
+// SignPDF signs the input and writes it with the signature update to OutputFile
+func (context *SignContext) SignPDF() error {
+	if err := context.sign(); err != nil {
 		return err
 	}
 
-	return nil
+	return context.writeOutput()
 }
 
-func (context *SignContext) SignPDF() error {
-
+// sign writes the incremental update with the signature to OutputBuffer
+func (context *SignContext) sign() error {
 	if context.SignData.Signature.CertType == 0 {
 		context.SignData.Signature.CertType = 1
 	}
@@ -54,75 +96,79 @@ func (context *SignContext) SignPDF() error {
 	if context.SignData.Appearance.Page == 0 {
 		context.SignData.Appearance.Page = 1
 	}
-
-	context.OutputBuffer = filebuffer.New([]byte{})
-
-	_, err := context.InputFile.Seek(0, 0)
-	if err != nil {
-		return err
+	if context.SignData.Signature.CertType > TimeStampSignature {
+		return fmt.Errorf("unsupported certificate type: %s", context.SignData.Signature.CertType)
 	}
-	if _, err := io.Copy(context.OutputBuffer, context.InputFile); err != nil {
-		return err
+	if context.SignData.Signature.DocMDPPerm > AllowFillingExistingFormFieldsAndSignaturesAndCRUDAnnotationsPerms {
+		return fmt.Errorf("unsupported DocMDP permission: %s", context.SignData.Signature.DocMDPPerm)
 	}
-
-	if _, err := context.OutputBuffer.Write([]byte("\n")); err != nil {
-		return err
+	if context.SignData.Signature.CertType == TimeStampSignature && !context.SignData.TSA.enabled() {
+		return fmt.Errorf("a TSA URL is required for timestamp signatures")
 	}
-
-	context.SignatureMaxLength = context.SignatureMaxLengthBase
-
-	if context.SignData.Signature.CertType != TimeStampSignature {
-		switch context.SignData.Certificate.SignatureAlgorithm.String() {
-		case "SHA1-RSA":
-		case "ECDSA-SHA1":
-		case "DSA-SHA1":
-			context.SignatureMaxLength += uint32(hex.EncodedLen(128))
-		case "SHA256-RSA":
-		case "ECDSA-SHA256":
-		case "DSA-SHA256":
-			context.SignatureMaxLength += uint32(hex.EncodedLen(256))
-		case "SHA384-RSA":
-		case "ECDSA-SHA384":
-			context.SignatureMaxLength += uint32(hex.EncodedLen(384))
-		case "SHA512-RSA":
-		case "ECDSA-SHA512":
-			context.SignatureMaxLength += uint32(hex.EncodedLen(512))
+	if context.SignData.Signature.CertType != TimeStampSignature && !context.deferred && (context.SignData.Certificate == nil || context.SignData.Signer == nil) {
+		return fmt.Errorf("a certificate and signer are required for %s", context.SignData.Signature.CertType)
+	}
+	if context.SignData.Profile > ProfilePAdESBLTA {
+		return fmt.Errorf("unsupported signature profile: %s", context.SignData.Profile)
+	}
+	if context.SignData.Profile != ProfilePKCS7 {
+		if context.SignData.Signature.CertType != CertificationSignature && context.SignData.Signature.CertType != ApprovalSignature {
+			return fmt.Errorf("%s applies to certification and approval signatures only", context.SignData.Profile)
 		}
-
-		context.SignatureMaxLength += uint32(hex.EncodedLen(context.SignData.DigestAlgorithm.Size() * 2))
-
-		degenerated, err := pkcs7.DegenerateCertificate(context.SignData.Certificate.Raw)
-		if err != nil {
-			return fmt.Errorf("failed to degenerate certificate: %w", err)
+		if context.SignData.Profile >= ProfilePAdESBT && !context.SignData.TSA.enabled() {
+			return fmt.Errorf("a TSA URL is required for %s", context.SignData.Profile)
 		}
-
-		context.SignatureMaxLength += uint32(hex.EncodedLen(len(degenerated)))
-
-		context.SignatureMaxLength += uint32(hex.EncodedLen(len(context.SignData.Certificate.RawIssuer)))
-
-		var certificate_chain []*x509.Certificate
-		if len(context.SignData.CertificateChains) > 0 && len(context.SignData.CertificateChains[0]) > 1 {
-			certificate_chain = context.SignData.CertificateChains[0][1:]
+		if context.SignData.Profile >= ProfilePAdESBLT && context.SignData.Signature.CertType == CertificationSignature &&
+			context.SignData.Signature.DocMDPPerm == DoNotAllowAnyChangesPerms {
+			return fmt.Errorf("%s adds validation data after signing, which %s forbids", context.SignData.Profile, DoNotAllowAnyChangesPerms)
 		}
-
-		if len(certificate_chain) > 0 {
-			for _, cert := range certificate_chain {
-				degenerated, err := pkcs7.DegenerateCertificate(cert.Raw)
-				if err != nil {
-					return fmt.Errorf("failed to degenerate certificate in chain: %w", err)
-				}
-
-				context.SignatureMaxLength += uint32(hex.EncodedLen(len(degenerated)))
-			}
+		if context.SignData.Profile >= ProfilePAdESBLT && context.SignData.RevocationFunction == nil {
+			context.SignData.RevocationFunction = DefaultEmbedRevocationStatusFunction
 		}
+	}
+	if err := context.checkExistingSignatures(); err != nil {
+		return err
+	}
 
+	if context.SignData.Signature.CertType != TimeStampSignature && !context.deferred {
 		if err := context.fetchRevocationData(); err != nil {
 			return fmt.Errorf("failed to fetch revocation data: %w", err)
 		}
 	}
 
-	if context.SignData.TSA.URL != "" {
-		context.SignatureMaxLength += uint32(hex.EncodedLen(9000))
+	err := context.createUpdate()
+	if errors.Is(err, errSignatureTooLong) {
+		// Only a timestamp token larger than estimated gets here, the
+		// placeholder has been resized to the measured signature
+		log.Println("Signature too long, retrying with increased buffer size.")
+		err = context.createUpdate()
+	}
+	return err
+}
+
+// createUpdate writes the incremental update with the signature to
+// OutputBuffer. The placeholder is sized from the certificates, key and
+// revocation data up front, only a timestamp token is estimated.
+func (context *SignContext) createUpdate() error {
+	// A retry after an undersized placeholder starts the update from scratch
+	context.lastXrefID = 0
+	context.newXrefEntries = nil
+	context.updatedXrefEntries = nil
+	context.newFieldIds = nil
+
+	if err := context.startUpdate(); err != nil {
+		return err
+	}
+
+	context.SignatureMaxLength = context.SignatureMaxLengthBase
+
+	// A deferred signature reserves the size given by the caller, the CMS is made elsewhere
+	if !context.deferred {
+		size, err := context.signatureSize()
+		if err != nil {
+			return err
+		}
+		context.SignatureMaxLength += uint32(hex.EncodedLen(size))
 	}
 
 	var signature_object []byte
@@ -134,36 +180,51 @@ func (context *SignContext) SignPDF() error {
 		signature_object = context.createSignaturePlaceholder()
 	}
 
+	var err error
 	context.SignData.objectId, err = context.addObject(signature_object)
 	if err != nil {
 		return fmt.Errorf("failed to add signature object: %w", err)
 	}
 
-	visible := false
-	rectangle := [4]float64{0, 0, 0, 0}
-	if context.SignData.Signature.CertType != ApprovalSignature && context.SignData.Appearance.Visible {
-		return fmt.Errorf("visible signatures are only allowed for approval signatures")
-	} else if context.SignData.Signature.CertType == ApprovalSignature && context.SignData.Appearance.Visible {
-		visible = true
-		rectangle = [4]float64{
-			context.SignData.Appearance.LowerLeftX,
-			context.SignData.Appearance.LowerLeftY,
-			context.SignData.Appearance.UpperRightX,
-			context.SignData.Appearance.UpperRightY,
-		}
-	}
-
-	visual_signature, err := context.createVisualSignature(visible, context.SignData.Appearance.Page, rectangle)
+	field, err := context.signatureField(context.SignData.FieldName)
 	if err != nil {
-		return fmt.Errorf("failed to create visual signature: %w", err)
+		return err
 	}
 
-	context.VisualSignData.objectId, err = context.addObject(visual_signature)
-	if err != nil {
-		return fmt.Errorf("failed to add visual signature object: %w", err)
+	if !field.IsNull() {
+		if err := context.signExistingField(field); err != nil {
+			return fmt.Errorf("failed to sign field %q: %w", context.SignData.FieldName, err)
+		}
+	} else {
+		visible := false
+		rectangle := [4]float64{0, 0, 0, 0}
+		if context.SignData.Appearance.Visible {
+			switch context.SignData.Signature.CertType {
+			case CertificationSignature, ApprovalSignature:
+			default:
+				return fmt.Errorf("visible signatures are only allowed for certification and approval signatures")
+			}
+
+			visible = true
+			rectangle, err = context.appearanceRect()
+			if err != nil {
+				return fmt.Errorf("failed to place visible signature: %w", err)
+			}
+		}
+
+		visual_signature, err := context.createVisualSignature(visible, context.SignData.Appearance.Page, rectangle)
+		if err != nil {
+			return fmt.Errorf("failed to create visual signature: %w", err)
+		}
+
+		context.VisualSignData.objectId, err = context.addObject(visual_signature)
+		if err != nil {
+			return fmt.Errorf("failed to add visual signature object: %w", err)
+		}
+		context.newFieldIds = append(context.newFieldIds, context.VisualSignData.objectId)
 	}
 
-	if context.SignData.Appearance.Visible {
+	if context.SignData.Appearance.Visible && field.IsNull() {
 		inc_page_update, err := context.createIncPageUpdate(context.SignData.Appearance.Page, context.VisualSignData.objectId)
 		if err != nil {
 			return fmt.Errorf("failed to create incremental page update: %w", err)
@@ -196,47 +257,78 @@ func (context *SignContext) SignPDF() error {
 		return fmt.Errorf("failed to update byte range: %w", err)
 	}
 
+	// PrepareSignature hands out the document with the placeholder, CompleteSignature fills it in
+	if context.deferred {
+		return nil
+	}
+
 	if err := context.replaceSignature(); err != nil {
 		return fmt.Errorf("failed to replace signature: %w", err)
 	}
 
-	if _, err := context.OutputBuffer.Seek(0, 0); err != nil {
-		return err
+	return nil
+}
+
+func (context *SignContext) fetchExistingSignatures() ([]SignData, error) {
+	var signatures []SignData
+
+	for _, field := range signatureFields(context.PDFReader) {
+		if field.Key("V").IsNull() {
+			continue
+		}
+
+		ptr := field.GetPtr()
+		sig := SignData{
+			objectId: uint32(ptr.GetID()),
+		}
+		sig.Signature.CertType, sig.Signature.DocMDPPerm = signatureCertType(field.Key("V"))
+		signatures = append(signatures, sig)
 	}
-	file_content := context.OutputBuffer.Buff.Bytes()
 
-	if _, err := context.OutputFile.Write(file_content); err != nil {
-		return err
+	return signatures, nil
+}
+
+// checkExistingSignatures makes sure the new signature can be appended as an
+// incremental update without invalidating the signatures already present.
+func (context *SignContext) checkExistingSignatures() error {
+	for _, sig := range context.existingSignatures {
+		if sig.Signature.CertType == CertificationSignature && sig.Signature.DocMDPPerm == DoNotAllowAnyChangesPerms {
+			return fmt.Errorf("document is certified with %s and cannot be signed again", DoNotAllowAnyChangesPerms)
+		}
+	}
+
+	if len(context.existingSignatures) > 0 && context.SignData.Signature.CertType == CertificationSignature {
+		return fmt.Errorf("a certification signature must be the first signature in the document, use an approval signature instead")
 	}
 
 	return nil
 }
 
-func (context *SignContext) fetchExistingSignatures() ([]SignData, error) {
-	var signatures []SignData
+// formFields returns the top level AcroForm fields in the order they appear
+// in the document's /Fields array.
+func formFields(rdr *pdf.Reader) []pdf.Value {
+	var fields []pdf.Value
 
-	acroForm := context.PDFReader.Trailer().Key("Root").Key("AcroForm")
-	if acroForm.IsNull() {
-		return signatures, nil
+	fieldsArray := rdr.Trailer().Key("Root").Key("AcroForm").Key("Fields")
+	for i := 0; i < fieldsArray.Len(); i++ {
+		fields = append(fields, fieldsArray.Index(i))
 	}
 
-	fields := acroForm.Key("Fields")
-	if fields.IsNull() {
-		return signatures, nil
-	}
+	return fields
+}
 
-	for i := 0; i < fields.Len(); i++ {
-		field := fields.Index(i)
+// signatureFields returns the AcroForm fields of type /Sig in the order they
+// appear in the document's /Fields array.
+func signatureFields(rdr *pdf.Reader) []pdf.Value {
+	var sigFields []pdf.Value
+
+	for _, field := range formFields(rdr) {
 		if field.Key("FT").Name() == "Sig" {
-			ptr := field.GetPtr()
-			sig := SignData{
-				objectId: uint32(ptr.GetID()),
-			}
-			signatures = append(signatures, sig)
+			sigFields = append(sigFields, field)
 		}
 	}
 
-	return signatures, nil
+	return sigFields
 }
 
 func (context *SignContext) createPropBuild() string {
@@ -249,34 +341,15 @@ func (context *SignContext) createPropBuild() string {
 	return buffer.String()
 }
 
+// SignPdfStream signs the PDF read from pdfStream with a certification signature
+// named after the certificate's common name. Use SignPdfStreamWithData to control
+// the signature metadata, type and timestamping.
 func SignPdfStream(ctx context.Context, pdfStream io.Reader, cert *x509.Certificate, privateKey crypto.Signer) ([]byte, error) {
-
-	var pdfBuffer bytes.Buffer
-	_, err := io.Copy(&pdfBuffer, pdfStream)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
-	}
-
-	pdfBytes := pdfBuffer.Bytes()
-
-	pdfReader, err := pdf.NewReader(bytes.NewReader(pdfBytes), int64(len(pdfBytes)))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create PDF reader: %v", err)
-	}
-
-	outputBuffer := new(bytes.Buffer)
-
-	inputPdf := bytes.NewReader(pdfBytes)
-	size := int64(len(pdfBytes))
-
-	err = Sign(inputPdf, outputBuffer, pdfReader, size, SignData{
+	return SignPdfStreamWithData(ctx, pdfStream, SignData{
 		Signature: SignDataSignature{
 			Info: SignDataSignatureInfo{
-				Name:        "John Doe",
-				Location:    "locdummt",
-				Reason:      "reason",
-				ContactInfo: "https://www.org.com",
-				Date:        time.Now().Local(),
+				Name: cert.Subject.CommonName,
+				Date: time.Now().Local(),
 			},
 			CertType:   CertificationSignature,
 			DocMDPPerm: AllowFillingExistingFormFieldsAndSignaturesPerms,
@@ -285,11 +358,105 @@ func SignPdfStream(ctx context.Context, pdfStream io.Reader, cert *x509.Certific
 		DigestAlgorithm:   crypto.SHA256,
 		Certificate:       cert,
 		CertificateChains: [][]*x509.Certificate{{cert}},
-		TSA:               TSA{},
 	})
+}
+
+// TimestampPdfStream appends an RFC 3161 document timestamp obtained from tsa
+// to the PDF read from pdfStream. Existing signatures stay intact and are
+// covered by the timestamp, which extends how long they can be validated.
+// digestAlgorithm defaults to SHA-256.
+func TimestampPdfStream(ctx context.Context, pdfStream io.Reader, tsa TSA, digestAlgorithm crypto.Hash) ([]byte, error) {
+	if digestAlgorithm == 0 {
+		digestAlgorithm = crypto.SHA256
+	}
+
+	return SignPdfStreamWithData(ctx, pdfStream, SignData{
+		Signature: SignDataSignature{
+			CertType: TimeStampSignature,
+		},
+		DigestAlgorithm: digestAlgorithm,
+		TSA:             tsa,
+	})
+}
+
+// SignPdfStreamSequentially applies the signatures in order, each one as its own
+// incremental update, so earlier signatures remain valid. Only the first
+// signature can be a certification signature; approvals and document
+// timestamps can follow as long as its DocMDP permissions allow changes.
+// The whole document is read into memory.
+func SignPdfStreamSequentially(ctx context.Context, pdfStream io.Reader, signDatas []SignData) ([]byte, error) {
+	pdfBytes, err := io.ReadAll(pdfStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	for i, signData := range signDatas {
+		pdfBytes, err = SignPdfStreamWithData(ctx, bytes.NewReader(pdfBytes), signData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply signature %d: %v", i+1, err)
+		}
+	}
+
+	return pdfBytes, nil
+}
+
+// SignPdfStreamWithData signs the PDF read from pdfStream using the caller supplied
+// SignData and returns the signed document. Signatures already in the document
+// are kept intact, the new one is written as an incremental update.
+// A pdfStream that is an io.ReaderAt and io.Seeker, like an *os.File, is read
+// in place, other streams are read into memory once. Use SignPdf to write the
+// signed document to a file instead of returning it.
+func SignPdfStreamWithData(ctx context.Context, pdfStream io.Reader, signData SignData) ([]byte, error) {
+	input, size, err := readerAtFromStream(pdfStream)
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign PDF: %v", err)
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	var outputBuffer bytes.Buffer
+	if err := SignPdf(ctx, input, size, &outputBuffer, signData); err != nil {
+		return nil, err
 	}
 
 	return outputBuffer.Bytes(), nil
 }
+
+// readerAtFromStream returns the remainder of stream as an io.ReaderAt with
+// its size, reading it into memory only when it cannot be read in place
+func readerAtFromStream(stream io.Reader) (io.ReaderAt, int64, error) {
+	if seeker, ok := stream.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, err
+		}
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, err
+		}
+		return io.NewSectionReader(seeker, start, end-start), end - start, nil
+	}
+
+	pdfBytes, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return bytes.NewReader(pdfBytes), int64(len(pdfBytes)), nil
+}
+
+// withDefaults fills in the signing date, the signer name and the certificate
+// chain from the certificate when they are not set.
+func (signData SignData) withDefaults() SignData {
+	if signData.Signature.Info.Date.IsZero() {
+		signData.Signature.Info.Date = time.Now().Local()
+	}
+	if signData.Signature.Info.Name == "" && signData.Certificate != nil {
+		signData.Signature.Info.Name = signData.Certificate.Subject.CommonName
+	}
+	if len(signData.CertificateChains) == 0 && signData.Certificate != nil {
+		signData.CertificateChains = [][]*x509.Certificate{{signData.Certificate}}
+	}
+	return signData
+}
@@ -2,17 +2,41 @@ package signer
 
 import (
 	"bytes"
+	"compress/zlib"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/digitorus/pdf"
+	"github.com/digitorus/pkcs7"
+	"github.com/digitorus/timestamp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
 )
 
 func TestSignPdfStream(t *testing.T) {
@@ -59,6 +83,1088 @@ func TestSignPdfStream(t *testing.T) {
 	}
 }
 
+func TestSignPdfStreamWithData(t *testing.T) {
+	ctx := context.Background()
+
+	cert, key := generateTestCertificate(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	tests := []struct {
+		name         string
+		signature    SignDataSignature
+		digest       crypto.Hash
+		wantErr      bool
+		wantCertType CertType
+	}{
+		{
+			name: "approval_with_metadata",
+			signature: SignDataSignature{
+				CertType: ApprovalSignature,
+				Info: SignDataSignatureInfo{
+					Name:        "Jane Signer",
+					Location:    "Gurugram",
+					Reason:      "Invoice approval",
+					ContactInfo: "billing@example.com",
+				},
+			},
+			digest:       crypto.SHA512,
+			wantCertType: ApprovalSignature,
+		},
+		{
+			name: "certification_no_changes",
+			signature: SignDataSignature{
+				CertType:   CertificationSignature,
+				DocMDPPerm: DoNotAllowAnyChangesPerms,
+			},
+			digest:       crypto.SHA256,
+			wantCertType: CertificationSignature,
+		},
+		{
+			name:      "timestamp_without_tsa",
+			signature: SignDataSignature{CertType: TimeStampSignature},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid_docmdp",
+			signature: SignDataSignature{CertType: CertificationSignature, DocMDPPerm: 7},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signedPDF, err := SignPdfStreamWithData(ctx, bytes.NewReader(getTestPDF(t)), SignData{
+				Signature:       tt.signature,
+				Signer:          key,
+				DigestAlgorithm: tt.digest,
+				Certificate:     cert,
+			})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			report, err := VerifyPdfStream(ctx, bytes.NewReader(signedPDF), VerifyOptions{Roots: roots})
+			require.NoError(t, err)
+			require.Len(t, report.Signatures, 1)
+			assert.True(t, report.Valid)
+
+			sig := report.Signatures[0]
+			assert.Equal(t, tt.wantCertType.String(), sig.CertType)
+			assert.Equal(t, tt.signature.DocMDPPerm, sig.DocMDPPerm)
+			assert.Equal(t, tt.signature.Info.Reason, sig.Reason)
+			assert.Equal(t, tt.signature.Info.Location, sig.Location)
+			if tt.signature.Info.Name != "" {
+				assert.Equal(t, tt.signature.Info.Name, sig.Name)
+			} else {
+				assert.Equal(t, cert.Subject.CommonName, sig.Name)
+			}
+		})
+	}
+}
+
+func TestSignPdfStreamVisible(t *testing.T) {
+	ctx := context.Background()
+
+	cert, key := generateTestCertificate(t)
+
+	logo := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		logo.Set(x, 1, color.NRGBA{R: 0xcb, G: 0x20, B: 0x2d, A: 0x80})
+	}
+	var logoPNG bytes.Buffer
+	require.NoError(t, png.Encode(&logoPNG, logo))
+
+	tests := []struct {
+		name       string
+		certType   CertType
+		appearance Appearance
+		wantRect   string
+		wantErr    bool
+	}{
+		{
+			name:     "approval_rectangle_text",
+			certType: ApprovalSignature,
+			appearance: Appearance{
+				Visible:     true,
+				LowerLeftX:  50,
+				LowerLeftY:  50,
+				UpperRightX: 250,
+				UpperRightY: 110,
+				Text:        []string{"Signed (for) Zomato", "Ünïcode falls back"},
+				Font:        "Times-Bold",
+			},
+			wantRect: "/Rect [50.000000 50.000000 250.000000 110.000000]",
+		},
+		{
+			name:     "certification_anchor_logo",
+			certType: CertificationSignature,
+			appearance: Appearance{
+				Visible: true,
+				Anchor:  AnchorBottomRight,
+				Image:   logoPNG.Bytes(),
+			},
+			wantRect: "/Rect [396.000000 36.000000 576.000000 96.000000]",
+		},
+		{
+			name:       "oversized_image",
+			certType:   ApprovalSignature,
+			appearance: Appearance{Visible: true, Anchor: AnchorCenter, Image: pngHeader(10000, 10000)},
+			wantErr:    true,
+		},
+		{
+			name:       "unknown_font",
+			certType:   ApprovalSignature,
+			appearance: Appearance{Visible: true, Anchor: AnchorCenter, Font: "Comic-Sans"},
+			wantErr:    true,
+		},
+		{
+			name:       "unknown_anchor",
+			certType:   ApprovalSignature,
+			appearance: Appearance{Visible: true, Anchor: "somewhere"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signedPDF, err := SignPdfStreamWithData(ctx, bytes.NewReader(getTestPDF(t)), SignData{
+				Signature: SignDataSignature{
+					CertType: tt.certType,
+					Info:     SignDataSignatureInfo{Reason: "Contract acceptance"},
+				},
+				Signer:      key,
+				Certificate: cert,
+				Appearance:  tt.appearance,
+			})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Contains(t, string(signedPDF), tt.wantRect)
+			assert.Contains(t, string(signedPDF), "/Subtype /Form")
+			if len(tt.appearance.Image) > 0 {
+				assert.Contains(t, string(signedPDF), "/Subtype /Image")
+				assert.Contains(t, string(signedPDF), "/SMask")
+			}
+
+			report, err := VerifyPdfStream(ctx, bytes.NewReader(signedPDF), VerifyOptions{})
+			require.NoError(t, err)
+			require.Len(t, report.Signatures, 1)
+			assert.True(t, report.Valid)
+		})
+	}
+}
+
+// pngHeader returns the start of a PNG of width x height pixels, enough for
+// image.DecodeConfig
+func pngHeader(width, height uint32) []byte {
+	chunk := binary.BigEndian.AppendUint32([]byte("IHDR"), width)
+	chunk = binary.BigEndian.AppendUint32(chunk, height)
+	chunk = append(chunk, 8, 2, 0, 0, 0)
+
+	header := append([]byte("\x89PNG\r\n\x1a\n"), 0, 0, 0, 13)
+	header = append(header, chunk...)
+	return binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(chunk))
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+
+	cert, key := generateTestCertificate(t)
+	signedPDF, err := SignPdfStream(ctx, bytes.NewReader(getTestPDF(t)), cert, key)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	tampered := bytes.Clone(signedPDF)
+	tampered[bytes.Index(tampered, []byte("/MediaBox"))+1] = 'X'
+
+	tests := []struct {
+		name        string
+		pdfData     []byte
+		options     VerifyOptions
+		wantValid   bool
+		wantTrusted bool
+		wantSigs    int
+	}{
+		{
+			name:        "signed_trusted",
+			pdfData:     signedPDF,
+			options:     VerifyOptions{Roots: roots},
+			wantValid:   true,
+			wantTrusted: true,
+			wantSigs:    1,
+		},
+		{
+			name:        "signed_untrusted_root",
+			pdfData:     signedPDF,
+			options:     VerifyOptions{Roots: x509.NewCertPool()},
+			wantValid:   true,
+			wantTrusted: false,
+			wantSigs:    1,
+		},
+		{
+			name:        "tampered",
+			pdfData:     tampered,
+			options:     VerifyOptions{Roots: roots},
+			wantValid:   false,
+			wantTrusted: true,
+			wantSigs:    1,
+		},
+		{
+			name:        "unsigned",
+			pdfData:     getTestPDF(t),
+			wantValid:   false,
+			wantTrusted: false,
+			wantSigs:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := VerifyPdfStream(ctx, bytes.NewReader(tt.pdfData), tt.options)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantValid, report.Valid)
+			assert.Equal(t, tt.wantTrusted, report.Trusted)
+			require.Len(t, report.Signatures, tt.wantSigs)
+
+			for _, sig := range report.Signatures {
+				assert.Equal(t, CertificationSignature.String(), sig.CertType)
+				assert.Equal(t, AllowFillingExistingFormFieldsAndSignaturesPerms, sig.DocMDPPerm)
+				assert.True(t, sig.CoversWholeFile)
+				assert.False(t, sig.ModifiedAfterSigning)
+				require.NotNil(t, sig.Signer)
+				assert.Equal(t, "Test Cert", sig.Signer.CommonName)
+			}
+		})
+	}
+
+	t.Run("appended_update", func(t *testing.T) {
+		modified := append(bytes.Clone(signedPDF), []byte("\n% trailing update\n")...)
+		report, err := VerifyPdfStream(ctx, bytes.NewReader(modified), VerifyOptions{Roots: roots})
+		require.NoError(t, err)
+		require.Len(t, report.Signatures, 1)
+		assert.True(t, report.Signatures[0].ValidSignature)
+		assert.False(t, report.Signatures[0].CoversWholeFile)
+		assert.True(t, report.Signatures[0].ModifiedAfterSigning)
+	})
+
+	t.Run("appended_update_no_changes_allowed", func(t *testing.T) {
+		certified, err := SignPdfStreamWithData(ctx, bytes.NewReader(getTestPDF(t)), SignData{
+			Signature:   SignDataSignature{CertType: CertificationSignature, DocMDPPerm: DoNotAllowAnyChangesPerms},
+			Signer:      key,
+			Certificate: cert,
+		})
+		require.NoError(t, err)
+
+		report, err := VerifyPdfStream(ctx, bytes.NewReader(certified), VerifyOptions{Roots: roots})
+		require.NoError(t, err)
+		assert.True(t, report.Valid)
+
+		modified := append(bytes.Clone(certified), []byte("\n% trailing update\n")...)
+		report, err = VerifyPdfStream(ctx, bytes.NewReader(modified), VerifyOptions{Roots: roots})
+		require.NoError(t, err)
+		require.Len(t, report.Signatures, 1)
+		assert.Equal(t, DoNotAllowAnyChangesPerms, report.Signatures[0].DocMDPPerm)
+		assert.False(t, report.Signatures[0].ValidSignature)
+		assert.False(t, report.Valid)
+	})
+
+	t.Run("expired_backdated", func(t *testing.T) {
+		expiredKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "Expired Cert"},
+			NotBefore:    time.Now().Add(-48 * time.Hour),
+			NotAfter:     time.Now().Add(-24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}
+		certDER, err := x509.CreateCertificate(rand.Reader, template, template, &expiredKey.PublicKey, expiredKey)
+		require.NoError(t, err)
+		expired, err := x509.ParseCertificate(certDER)
+		require.NoError(t, err)
+
+		// PAdES has no signing-time attribute, /M is the only claimed time
+		backdated, err := SignPdfStreamWithData(ctx, bytes.NewReader(getTestPDF(t)), SignData{
+			Signature: SignDataSignature{
+				CertType: ApprovalSignature,
+				Info:     SignDataSignatureInfo{Date: time.Now().Add(-36 * time.Hour)},
+			},
+			Profile:     ProfilePAdESBB,
+			Signer:      expiredKey,
+			Certificate: expired,
+		})
+		require.NoError(t, err)
+
+		expiredRoots := x509.NewCertPool()
+		expiredRoots.AddCert(expired)
+		report, err := VerifyPdfStream(ctx, bytes.NewReader(backdated), VerifyOptions{Roots: expiredRoots})
+		require.NoError(t, err)
+		require.Len(t, report.Signatures, 1)
+		assert.True(t, report.Valid)
+		assert.False(t, report.Trusted, "a backdated /M does not make an expired certificate trusted")
+	})
+}
+
+func TestSignPdfStreamSequentially(t *testing.T) {
+	ctx := context.Background()
+
+	cert, key := generateTestCertificate(t)
+	tsaURL, tsaCert := startTestTSA(t)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	roots.AddCert(tsaCert)
+
+	steps := []SignData{
+		{
+			Signature: SignDataSignature{
+				CertType:   CertificationSignature,
+				DocMDPPerm: AllowFillingExistingFormFieldsAndSignaturesPerms,
+				Info:       SignDataSignatureInfo{Reason: "Issuer"},
+			},
+			Signer:      key,
+			Certificate: cert,
+		},
+		{
+			Signature: SignDataSignature{
+				CertType: ApprovalSignature,
+				Info:     SignDataSignatureInfo{Reason: "Counterparty"},
+			},
+			Signer:      key,
+			Certificate: cert,
+			Appearance:  Appearance{Visible: true, Anchor: AnchorBottomRight},
+		},
+		{
+			Signature: SignDataSignature{CertType: TimeStampSignature},
+			TSA:       TSA{URL: tsaURL},
+		},
+	}
+	wantCertTypes := []CertType{CertificationSignature, ApprovalSignature, TimeStampSignature}
+
+	tests := []struct {
+		name    string
+		pdfData []byte
+	}{
+		{name: "xref_table", pdfData: getTestPDF(t)},
+		{name: "xref_stream", pdfData: getTestPDFXrefStream(t)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdfData := tt.pdfData
+
+			// Sign one step at a time so every intermediate revision is checked
+			for i, signData := range steps {
+				var err error
+				pdfData, err = SignPdfStreamWithData(ctx, bytes.NewReader(pdfData), signData)
+				require.NoError(t, err)
+
+				report, err := VerifyPdfStream(ctx, bytes.NewReader(pdfData), VerifyOptions{Roots: roots})
+				require.NoError(t, err)
+				require.Len(t, report.Signatures, i+1)
+				assert.True(t, report.Valid)
+				assert.True(t, report.Trusted)
+
+				for j, sig := range report.Signatures {
+					assert.Equal(t, wantCertTypes[j].String(), sig.CertType)
+					assert.Equal(t, fmt.Sprintf("Signature %d", j+1), sig.FieldName)
+					assert.Empty(t, sig.Errors)
+					assert.Equal(t, j < i, sig.ModifiedAfterSigning)
+				}
+			}
+
+			sequential, err := SignPdfStreamSequentially(ctx, bytes.NewReader(tt.pdfData), steps)
+			require.NoError(t, err)
+
+			report, err := VerifyPdfStream(ctx, bytes.NewReader(sequential), VerifyOptions{Roots: roots})
+			require.NoError(t, err)
+			assert.Len(t, report.Signatures, len(steps))
+			assert.True(t, report.Valid)
+		})
+	}
+
+	t.Run("certification_after_signature", func(t *testing.T) {
+		_, err := SignPdfStreamSequentially(ctx, bytes.NewReader(getTestPDF(t)), []SignData{steps[1], steps[0]})
+		assert.ErrorContains(t, err, "certification signature must be the first")
+	})
+
+	t.Run("certified_no_changes", func(t *testing.T) {
+		certified := steps[0]
+		certified.Signature.DocMDPPerm = DoNotAllowAnyChangesPerms
+
+		_, err := SignPdfStreamSequentially(ctx, bytes.NewReader(getTestPDF(t)), []SignData{certified, steps[1]})
+		assert.ErrorContains(t, err, "cannot be signed again")
+	})
+}
+
+func TestSignatureFields(t *testing.T) {
+	ctx := context.Background()
+
+	cert, key := generateTestCertificate(t)
+
+	fields := []SignatureField{
+		{Name: "Buyer", Rect: [4]float64{50, 50, 250, 110}},
+		{Name: "Seller", Rect: [4]float64{300, 50, 500, 110}},
+		{Name: "Witness"},
+	}
+
+	tests := []struct {
+		name    string
+		pdfData []byte
+	}{
+		{name: "xref_table", pdfData: getTestPDF(t)},
+		{name: "xref_stream", pdfData: getTestPDFXrefStream(t)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdfData, err := AddSignatureFields(ctx, bytes.NewReader(tt.pdfData), fields)
+			require.NoError(t, err)
+
+			rdr, err := pdf.NewReader(bytes.NewReader(pdfData), int64(len(pdfData)))
+			require.NoError(t, err)
+			require.Len(t, signatureFields(rdr), len(fields))
+			assert.Equal(t, len(fields), rdr.Page(1).V.Key("Annots").Len())
+
+			// Fill the fields out of order, none of them adds a new field
+			for i, name := range []string{"Seller", "Witness", "Buyer"} {
+				pdfData, err = SignPdfStreamWithData(ctx, bytes.NewReader(pdfData), SignData{
+					Signature:   SignDataSignature{CertType: ApprovalSignature},
+					Signer:      key,
+					Certificate: cert,
+					FieldName:   name,
+				})
+				require.NoError(t, err)
+
+				report, err := VerifyPdfStream(ctx, bytes.NewReader(pdfData), VerifyOptions{})
+				require.NoError(t, err)
+				require.Len(t, report.Signatures, i+1)
+				assert.True(t, report.Valid)
+			}
+
+			rdr, err = pdf.NewReader(bytes.NewReader(pdfData), int64(len(pdfData)))
+			require.NoError(t, err)
+			assert.Len(t, signatureFields(rdr), len(fields))
+			// The visible fields got an appearance, the invisible one did not
+			assert.Equal(t, 2, strings.Count(string(pdfData), "/Subtype /Form"))
+
+			_, err = SignPdfStreamWithData(ctx, bytes.NewReader(pdfData), SignData{
+				Signature:   SignDataSignature{CertType: ApprovalSignature},
+				Signer:      key,
+				Certificate: cert,
+				FieldName:   "Buyer",
+			})
+			assert.ErrorContains(t, err, "already signed")
+		})
+	}
+
+	// B-LT adds validation data for the field signed, not the last field
+	t.Run("b_lt_first_field", func(t *testing.T) {
+		caCert, leafCert, leafKey := generateTestChain(t)
+		tsaURL, tsaCert := startTestTSA(t)
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		roots.AddCert(tsaCert)
+
+		pdfData, err := AddSignatureFields(ctx, bytes.NewReader(getTestPDF(t)), []SignatureField{{Name: "A"}, {Name: "B"}})
+		require.NoError(t, err)
+
+		for _, name := range []string{"A", "B"} {
+			pdfData, err = SignPdfStreamWithData(ctx, bytes.NewReader(pdfData), SignData{
+				Signature:         SignDataSignature{CertType: ApprovalSignature},
+				Signer:            leafKey,
+				Certificate:       leafCert,
+				CertificateChains: [][]*x509.Certificate{{leafCert, caCert}},
+				TSA:               TSA{URL: tsaURL},
+				Profile:           ProfilePAdESBLT,
+				FieldName:         name,
+			})
+			require.NoError(t, err, name)
+		}
+
+		report, err := VerifyPdfStream(ctx, bytes.NewReader(pdfData), VerifyOptions{Roots: roots})
+		require.NoError(t, err)
+		require.Len(t, report.Signatures, 2)
+		assert.True(t, report.Valid)
+
+		rdr, err := pdf.NewReader(bytes.NewReader(pdfData), int64(len(pdfData)))
+		require.NoError(t, err)
+		vri := rdr.Trailer().Key("Root").Key("DSS").Key("VRI")
+		for _, field := range signatureFields(rdr) {
+			contents := []byte(field.Key("V").Key("Contents").RawString())
+			assert.False(t, vri.Key(strings.ToUpper(hex.EncodeToString(sha1Sum(contents)))).IsNull(), field.Key("T").Text())
+		}
+	})
+
+	t.Run("duplicate_name", func(t *testing.T) {
+		_, err := AddSignatureFields(ctx, bytes.NewReader(getTestPDF(t)), []SignatureField{{Name: "A"}, {Name: "A"}})
+		assert.ErrorContains(t, err, "already exists")
+	})
+
+	t.Run("visible_in_invisible_field", func(t *testing.T) {
+		pdfData, err := AddSignatureFields(ctx, bytes.NewReader(getTestPDF(t)), []SignatureField{{Name: "Hidden"}})
+		require.NoError(t, err)
+
+		_, err = SignPdfStreamWithData(ctx, bytes.NewReader(pdfData), SignData{
+			Signature:   SignDataSignature{CertType: ApprovalSignature},
+			Signer:      key,
+			Certificate: cert,
+			FieldName:   "Hidden",
+			Appearance:  Appearance{Visible: true},
+		})
+		assert.ErrorContains(t, err, "no rectangle")
+	})
+}
+
+func TestDeferredSignature(t *testing.T) {
+	ctx := context.Background()
+
+	cert, key := generateTestCertificate(t)
+
+	pdfData, err := AddSignatureFields(ctx, bytes.NewReader(getTestPDF(t)), []SignatureField{
+		{Name: "Customer", Rect: [4]float64{50, 50, 250, 110}},
+	})
+	require.NoError(t, err)
+
+	signData := SignData{
+		Signature: SignDataSignature{
+			CertType: ApprovalSignature,
+			Info:     SignDataSignatureInfo{Name: "Customer", Reason: "Signed on device"},
+		},
+		FieldName: "Customer",
+	}
+
+	// externalSign stands in for a signing device, which gets the digest and
+	// returns a detached CMS
+	externalSign := func(t *testing.T, content []byte) []byte {
+		signedData, err := pkcs7.NewSignedData(content)
+		require.NoError(t, err)
+		signedData.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+		require.NoError(t, signedData.AddSigner(cert, key, pkcs7.SignerInfoConfig{}))
+		signedData.Detach()
+		cms, err := signedData.Finish()
+		require.NoError(t, err)
+		return cms
+	}
+
+	t.Run("complete", func(t *testing.T) {
+		prepared, err := PrepareSignature(ctx, bytes.NewReader(pdfData), signData, 0)
+		require.NoError(t, err)
+		assert.Equal(t, crypto.SHA256, prepared.DigestAlgorithm)
+
+		content := byteRangeContent(prepared.PDF, prepared.ByteRange)
+		digest := crypto.SHA256.New()
+		digest.Write(content)
+		assert.Equal(t, digest.Sum(nil), prepared.Digest)
+
+		signedPDF, err := CompleteSignature(prepared.PDF, externalSign(t, content))
+		require.NoError(t, err)
+
+		report, err := VerifyPdfStream(ctx, bytes.NewReader(signedPDF), VerifyOptions{})
+		require.NoError(t, err)
+		require.Len(t, report.Signatures, 1)
+		assert.True(t, report.Valid)
+		assert.Equal(t, "Customer", report.Signatures[0].FieldName)
+		assert.Equal(t, "Signed on device", report.Signatures[0].Reason)
+
+		_, err = CompleteSignature(signedPDF, externalSign(t, content))
+		assert.ErrorContains(t, err, "already filled in")
+	})
+
+	t.Run("wrong_content", func(t *testing.T) {
+		prepared, err := PrepareSignature(ctx, bytes.NewReader(pdfData), signData, 0)
+		require.NoError(t, err)
+
+		_, err = CompleteSignature(prepared.PDF, externalSign(t, []byte("something else")))
+		assert.ErrorContains(t, err, "does not match")
+	})
+
+	t.Run("too_large", func(t *testing.T) {
+		prepared, err := PrepareSignature(ctx, bytes.NewReader(pdfData), signData, 64)
+		require.NoError(t, err)
+
+		_, err = CompleteSignature(prepared.PDF, externalSign(t, byteRangeContent(prepared.PDF, prepared.ByteRange)))
+		assert.ErrorContains(t, err, "larger signature size")
+	})
+
+	t.Run("lt_profile", func(t *testing.T) {
+		ltData := signData
+		ltData.Profile = ProfilePAdESBLT
+		_, err := PrepareSignature(ctx, bytes.NewReader(pdfData), ltData, 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestTimestampPdfStream(t *testing.T) {
+	ctx := context.Background()
+
+	cert, key := generateTestCertificate(t)
+	tsaURL, tsaCert := startTestTSA(t)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	roots.AddCert(tsaCert)
+
+	signed, err := SignPdfStream(ctx, bytes.NewReader(getTestPDF(t)), cert, key)
+	require.NoError(t, err)
+
+	timestamped, err := TimestampPdfStream(ctx, bytes.NewReader(signed), TSA{URL: tsaURL}, 0)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(timestamped, signed), "the timestamp is an incremental update")
+
+	report, err := VerifyPdfStream(ctx, bytes.NewReader(timestamped), VerifyOptions{Roots: roots})
+	require.NoError(t, err)
+	require.Len(t, report.Signatures, 2)
+	assert.True(t, report.Valid)
+	assert.Equal(t, TimeStampSignature.String(), report.Signatures[1].CertType)
+	assert.Equal(t, "ETSI.RFC3161", report.Signatures[1].SubFilter)
+
+	_, err = TimestampPdfStream(ctx, bytes.NewReader(signed), TSA{}, crypto.SHA256)
+	assert.ErrorContains(t, err, "TSA URL is required")
+}
+
+func TestSignPdfStreamPAdES(t *testing.T) {
+	ctx := context.Background()
+
+	caCert, leafCert, leafKey := generateTestChain(t)
+	tsaURL, tsaCert := startTestTSA(t)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	roots.AddCert(tsaCert)
+
+	tests := []struct {
+		name          string
+		profile       Profile
+		tsaURL        string
+		wantErr       bool
+		wantTimestamp bool
+		wantDSS       bool
+		wantSigs      int
+	}{
+		{name: "b_b", profile: ProfilePAdESBB, wantSigs: 1},
+		{name: "b_t", profile: ProfilePAdESBT, tsaURL: tsaURL, wantTimestamp: true, wantSigs: 1},
+		{name: "b_lt", profile: ProfilePAdESBLT, tsaURL: tsaURL, wantTimestamp: true, wantDSS: true, wantSigs: 1},
+		{name: "b_lta", profile: ProfilePAdESBLTA, tsaURL: tsaURL, wantTimestamp: true, wantDSS: true, wantSigs: 2},
+		{name: "b_t_without_tsa", profile: ProfilePAdESBT, wantErr: true},
+		{name: "unknown_profile", profile: Profile(9), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signedPDF, err := SignPdfStreamWithData(ctx, bytes.NewReader(getTestPDF(t)), SignData{
+				Signature: SignDataSignature{
+					CertType:   CertificationSignature,
+					DocMDPPerm: AllowFillingExistingFormFieldsAndSignaturesPerms,
+				},
+				Signer:            leafKey,
+				Certificate:       leafCert,
+				CertificateChains: [][]*x509.Certificate{{leafCert, caCert}},
+				TSA:               TSA{URL: tt.tsaURL},
+				Profile:           tt.profile,
+			})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			report, err := VerifyPdfStream(ctx, bytes.NewReader(signedPDF), VerifyOptions{Roots: roots})
+			require.NoError(t, err)
+			require.Len(t, report.Signatures, tt.wantSigs)
+			assert.True(t, report.Valid)
+			assert.True(t, report.Trusted)
+
+			sig := report.Signatures[0]
+			assert.Equal(t, "ETSI.CAdES.detached", sig.SubFilter)
+			assert.Empty(t, sig.Errors)
+			assert.False(t, sig.SigningTime.IsZero())
+			assert.Equal(t, tt.wantTimestamp, sig.Timestamp != nil)
+			if tt.wantSigs > 1 {
+				assert.Equal(t, TimeStampSignature.String(), report.Signatures[1].CertType)
+				assert.True(t, report.Signatures[1].CoversWholeFile)
+			}
+
+			rdr, err := pdf.NewReader(bytes.NewReader(signedPDF), int64(len(signedPDF)))
+			require.NoError(t, err)
+
+			contents := []byte(signatureFields(rdr)[0].Key("V").Key("Contents").RawString())
+			p7, err := pkcs7.Parse(contents)
+			require.NoError(t, err)
+			for _, attr := range p7.Signers[0].AuthenticatedAttributes {
+				assert.False(t, attr.Type.Equal(pkcs7.OIDAttributeSigningTime), "PAdES must not carry signing-time")
+			}
+
+			dss := rdr.Trailer().Key("Root").Key("DSS")
+			if !tt.wantDSS {
+				assert.True(t, dss.IsNull())
+				return
+			}
+			// signer, CA and TSA certificates plus the OCSP response for the signer
+			assert.Equal(t, 3, dss.Key("Certs").Len())
+			assert.Equal(t, 1, dss.Key("OCSPs").Len())
+
+			vri := dss.Key("VRI").Key(strings.ToUpper(hex.EncodeToString(sha1Sum(contents))))
+			require.False(t, vri.IsNull())
+			assert.Equal(t, 3, vri.Key("Cert").Len())
+			assert.Equal(t, 1, vri.Key("OCSP").Len())
+		})
+	}
+}
+
+func TestSignPdfFile(t *testing.T) {
+	ctx := context.Background()
+
+	rsaCert, rsaKey := generateTestCertificate(t)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	ed25519Public, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	caCert, leafCert, leafKey := generateTestChain(t)
+	tsaURL, tsaCert := startTestTSA(t)
+
+	newCertificate := func(public crypto.PublicKey, key crypto.Signer) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "Test Cert"},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}
+		certDER, err := x509.CreateCertificate(rand.Reader, template, template, public, key)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(certDER)
+		require.NoError(t, err)
+		return cert
+	}
+
+	tests := []struct {
+		name     string
+		signData SignData
+		roots    []*x509.Certificate
+		wantSigs int
+	}{
+		{
+			name:     "rsa",
+			signData: SignData{Signer: rsaKey, Certificate: rsaCert},
+			roots:    []*x509.Certificate{rsaCert},
+			wantSigs: 1,
+		},
+		{
+			name:     "ecdsa",
+			signData: SignData{Signer: ecdsaKey, Certificate: newCertificate(&ecdsaKey.PublicKey, ecdsaKey), DigestAlgorithm: crypto.SHA384},
+			wantSigs: 1,
+		},
+		{
+			name:     "ed25519",
+			signData: SignData{Signer: ed25519Key, Certificate: newCertificate(ed25519Public, ed25519Key), DigestAlgorithm: crypto.SHA512},
+			wantSigs: 1,
+		},
+		{
+			name: "pades_b_lta",
+			signData: SignData{
+				Signature: SignDataSignature{
+					CertType:   ApprovalSignature,
+					DocMDPPerm: AllowFillingExistingFormFieldsAndSignaturesPerms,
+				},
+				Signer:            leafKey,
+				Certificate:       leafCert,
+				CertificateChains: [][]*x509.Certificate{{leafCert, caCert}},
+				TSA:               TSA{URL: tsaURL},
+				Profile:           ProfilePAdESBLTA,
+			},
+			roots:    []*x509.Certificate{caCert, tsaCert},
+			wantSigs: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			inputPath := filepath.Join(dir, "input.pdf")
+			outputPath := filepath.Join(dir, "output.pdf")
+			require.NoError(t, os.WriteFile(inputPath, getTestPDF(t), 0o600))
+
+			require.NoError(t, SignPdfFile(ctx, inputPath, outputPath, tt.signData))
+
+			signedPDF, err := os.ReadFile(outputPath)
+			require.NoError(t, err)
+			assert.True(t, bytes.HasPrefix(signedPDF, getTestPDF(t)), "the input must be kept as is")
+
+			roots := x509.NewCertPool()
+			for _, root := range tt.roots {
+				roots.AddCert(root)
+			}
+			report, err := VerifyPdfStream(ctx, bytes.NewReader(signedPDF), VerifyOptions{Roots: roots})
+			require.NoError(t, err)
+			require.Len(t, report.Signatures, tt.wantSigs)
+			for _, sig := range report.Signatures {
+				assert.True(t, sig.ValidSignature, sig.Errors)
+			}
+			assert.True(t, report.Signatures[len(report.Signatures)-1].CoversWholeFile)
+
+			// Without a timestamp token to estimate the placeholder is sized from the key and certificates
+			rdr, err := pdf.NewReader(bytes.NewReader(signedPDF), int64(len(signedPDF)))
+			require.NoError(t, err)
+			contents := signatureFields(rdr)[0].Key("V").Key("Contents").RawString()
+			p7, err := pkcs7.Parse([]byte(contents))
+			require.NoError(t, err)
+			require.NotNil(t, p7)
+			if !tt.signData.TSA.enabled() {
+				padding := len(contents) - len(strings.TrimRight(contents, "\x00"))
+				assert.Less(t, padding, 2048)
+			}
+		})
+	}
+
+	t.Run("missing_input", func(t *testing.T) {
+		outputPath := filepath.Join(t.TempDir(), "output.pdf")
+		assert.Error(t, SignPdfFile(ctx, filepath.Join(t.TempDir(), "missing.pdf"), outputPath, SignData{Signer: rsaKey, Certificate: rsaCert}))
+		assert.NoFileExists(t, outputPath)
+	})
+
+	t.Run("invalid_pdf", func(t *testing.T) {
+		dir := t.TempDir()
+		inputPath := filepath.Join(dir, "input.pdf")
+		outputPath := filepath.Join(dir, "output.pdf")
+		require.NoError(t, os.WriteFile(inputPath, []byte("not a pdf"), 0o600))
+
+		assert.Error(t, SignPdfFile(ctx, inputPath, outputPath, SignData{Signer: rsaKey, Certificate: rsaCert}))
+		assert.NoFileExists(t, outputPath)
+	})
+}
+
+func TestRevocationClient(t *testing.T) {
+	ctx := context.Background()
+
+	newCA := func(name string) (*x509.Certificate, *rsa.PrivateKey) {
+		caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(20),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+		require.NoError(t, err)
+		caCert, err := x509.ParseCertificate(caDER)
+		require.NoError(t, err)
+		return caCert, caKey
+	}
+
+	caCert, caKey := newCA("Revocation Test CA")
+	otherCert, otherKey := newCA("Other CA")
+
+	revoked := big.NewInt(66)
+	server, hits := startTestRevocationServer(t, caCert, caKey, revoked)
+	otherServer, _ := startTestRevocationServer(t, otherCert, otherKey, nil)
+	// nothing listens on a closed server
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	issue := func(serial int64, ocspServers, crlDistributionPoints []string) *x509.Certificate {
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: fmt.Sprintf("Leaf %d", serial)},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			OCSPServer:            ocspServers,
+			CRLDistributionPoints: crlDistributionPoints,
+		}, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		return cert
+	}
+
+	tests := []struct {
+		name        string
+		cert        *x509.Certificate
+		wantOCSP    int
+		wantCRL     int
+		wantErr     error
+		wantErrText string
+	}{
+		{name: "ocsp_fallback_to_second_responder", cert: issue(61, []string{closed.URL, server + "/ocsp"}, nil), wantOCSP: 1},
+		{name: "crl_when_ocsp_fails", cert: issue(62, []string{closed.URL}, []string{"ldap://ldap.example.com/ca.crl", server + "/crl"}), wantCRL: 1},
+		{name: "crl_from_other_issuer", cert: issue(63, nil, []string{otherServer + "/crl"}), wantErrText: "does not match"},
+		{name: "revoked_ocsp", cert: issue(66, []string{server + "/ocsp"}, nil), wantErr: ErrCertificateRevoked},
+		{name: "revoked_crl", cert: issue(66, nil, []string{server + "/crl"}), wantErr: ErrCertificateRevoked},
+		{name: "no_revocation_info", cert: issue(64, nil, nil)},
+	}
+
+	client, err := NewRevocationClient(RevocationClientConfig{Timeout: 5 * time.Second})
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info InfoArchival
+			err := client.EmbedRevocationStatus(tt.cert, caCert, &info)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			if tt.wantErrText != "" {
+				assert.ErrorContains(t, err, tt.wantErrText)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, info.OCSP, tt.wantOCSP)
+			assert.Len(t, info.CRL, tt.wantCRL)
+		})
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		cert := issue(65, []string{server + "/ocsp"}, []string{server + "/crl"})
+
+		diskClient, err := NewRevocationClient(RevocationClientConfig{CacheDir: cacheDir})
+		require.NoError(t, err)
+
+		before := hits.Load()
+		first, err := diskClient.OCSP(ctx, cert, caCert)
+		require.NoError(t, err)
+		_, err = diskClient.CRL(ctx, cert, caCert)
+		require.NoError(t, err)
+		assert.Equal(t, before+2, hits.Load())
+
+		cached, err := diskClient.OCSP(ctx, cert, caCert)
+		require.NoError(t, err)
+		assert.Equal(t, first, cached)
+
+		// A new client reads the responses back from disk
+		restarted, err := NewRevocationClient(RevocationClientConfig{CacheDir: cacheDir})
+		require.NoError(t, err)
+		_, err = restarted.OCSP(ctx, cert, caCert)
+		require.NoError(t, err)
+		_, err = restarted.CRL(ctx, cert, caCert)
+		require.NoError(t, err)
+		assert.Equal(t, before+2, hits.Load(), "cached responses are reused until their next update")
+	})
+}
+
+func TestTSAFailover(t *testing.T) {
+	content := []byte("signature value")
+
+	good, _ := newTestTSAHandler(t, nil)
+	wrongNonce, _ := newTestTSAHandler(t, func(ts *timestamp.Timestamp) { ts.Nonce = big.NewInt(1) })
+	wrongImprint, _ := newTestTSAHandler(t, func(ts *timestamp.Timestamp) { ts.HashedMessage = make([]byte, len(ts.HashedMessage)) })
+
+	// start serves handler after failures 503 responses, requests counts all calls
+	start := func(handler http.Handler, failures int32, token string) (string, *atomic.Int32) {
+		requests := &atomic.Int32{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) <= failures {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL, requests
+	}
+
+	tests := []struct {
+		name         string
+		handler      http.Handler
+		failures     int32
+		token        string
+		bearer       string
+		fallback     bool
+		wantRequests int32
+		wantErr      string
+	}{
+		{name: "success", handler: good, wantRequests: 1},
+		{name: "retry_after_server_error", handler: good, failures: 1, wantRequests: 2},
+		{name: "failover_after_retries", handler: good, failures: 5, fallback: true, wantRequests: 3},
+		{name: "all_endpoints_down", handler: good, failures: 5, wantRequests: 3, wantErr: "no TSA returned a valid timestamp"},
+		{name: "bearer_token", handler: good, token: "s3cret", bearer: "s3cret", wantRequests: 1},
+		{name: "unauthorized_not_retried", handler: good, token: "s3cret", bearer: "guess", wantRequests: 1, wantErr: "401"},
+		{name: "nonce_mismatch", handler: wrongNonce, wantRequests: 1, wantErr: "nonce"},
+		{name: "nonce_mismatch_failover", handler: wrongNonce, fallback: true, wantRequests: 1},
+		{name: "imprint_mismatch", handler: wrongImprint, wantRequests: 1, wantErr: "message imprint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, requests := start(tt.handler, tt.failures, tt.token)
+
+			tsa := TSA{URL: url, BearerToken: tt.bearer, MaxAttempts: 3, RetryBackoff: time.Millisecond, Timeout: 5 * time.Second}
+			if tt.fallback {
+				fallbackURL, _ := start(good, 0, "")
+				tsa.FallbackURLs = []string{fallbackURL}
+			}
+
+			context := &SignContext{SignData: SignData{TSA: tsa, DigestAlgorithm: crypto.SHA256}}
+			response, err := context.GetTSA(content)
+			assert.Equal(t, tt.wantRequests, requests.Load())
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			ts, err := timestamp.ParseResponse(response)
+			require.NoError(t, err)
+			digest := crypto.SHA256.New()
+			digest.Write(content)
+			assert.Equal(t, digest.Sum(nil), ts.HashedMessage)
+		})
+	}
+
+	t.Run("canceled_during_backoff", func(t *testing.T) {
+		url, requests := start(good, 5, "")
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		tsa := TSA{URL: url, MaxAttempts: 3, RetryBackoff: time.Hour}
+		started := time.Now()
+		_, _, err := tsa.timestamp(ctx, content, crypto.SHA256)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, time.Since(started), 10*time.Second)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("client_built_once", func(t *testing.T) {
+		tsa := TSA{RootCAs: x509.NewCertPool()}
+		assert.NotSame(t, tsa.httpClient(), tsa.httpClient(), "a client per timestamp without HTTPClient")
+		tsa.HTTPClient = tsa.NewHTTPClient()
+		assert.Same(t, tsa.HTTPClient, tsa.httpClient())
+		assert.Same(t, defaultTSAClient, TSA{}.httpClient())
+	})
+}
+
+func TestParsePdfDateTime(t *testing.T) {
+	date := time.Date(2024, 3, 9, 14, 5, 6, 0, time.FixedZone("", 5*3600+30*60))
+
+	parsed, err := parsePdfDateTime(pdfDateTime(date)[1 : len(pdfDateTime(date))-1])
+	require.NoError(t, err)
+	assert.True(t, date.Equal(parsed))
+
+	_, err = parsePdfDateTime("not a date")
+	assert.Error(t, err)
+}
+
 // Helper functions
 func generateTestCertificate(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
 	key, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -101,3 +1207,256 @@ startxref
 163
 %%EOF`)
 }
+
+// getTestPDFXrefStream returns the same document as getTestPDF with a
+// cross-reference stream instead of a classic xref table.
+func getTestPDFXrefStream(t *testing.T) []byte {
+	var pdfBuffer bytes.Buffer
+	pdfBuffer.WriteString("%PDF-1.5\n")
+
+	objects := []string{
+		"<</Type/Catalog/Pages 2 0 R>>",
+		"<</Type/Pages/Kids[3 0 R]/Count 1>>",
+		"<</Type/Page/Parent 2 0 R/MediaBox[0 0 612 792]>>",
+	}
+
+	var offsets []int
+	for i, object := range objects {
+		offsets = append(offsets, pdfBuffer.Len())
+		fmt.Fprintf(&pdfBuffer, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xrefOffset := pdfBuffer.Len()
+
+	// W [1 2 1]: type, offset, generation
+	entries := []byte{0, 0, 0, 255}
+	for _, offset := range append(offsets, xrefOffset) {
+		entries = append(entries, 1, byte(offset>>8), byte(offset), 0)
+	}
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	_, err := w.Write(entries)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	fmt.Fprintf(&pdfBuffer, "4 0 obj\n<</Type/XRef/Size 5/W[1 2 1]/Root 1 0 R/Filter/FlateDecode/Length %d>>\nstream\n", compressed.Len())
+	pdfBuffer.Write(compressed.Bytes())
+	fmt.Fprintf(&pdfBuffer, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xrefOffset)
+
+	return pdfBuffer.Bytes()
+}
+
+// startTestTSA serves RFC 3161 responses signed by a throwaway TSA certificate.
+func startTestTSA(t *testing.T) (string, *x509.Certificate) {
+	handler, cert := newTestTSAHandler(t, nil)
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return server.URL, cert
+}
+
+// newTestTSAHandler answers timestamp requests, tamper can alter the token
+// before it is signed.
+func newTestTSAHandler(t *testing.T, tamper func(*timestamp.Timestamp)) (http.Handler, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Test TSA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		BasicConstraintsValid: true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(certDER)
+	require.NoError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tsReq, err := timestamp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ts := timestamp.Timestamp{
+			HashAlgorithm:     tsReq.HashAlgorithm,
+			HashedMessage:     tsReq.HashedMessage,
+			Time:              time.Now(),
+			Nonce:             tsReq.Nonce,
+			Policy:            []int{1, 2, 3},
+			Ordering:          true,
+			Qualified:         false,
+			AddTSACertificate: tsReq.Certificates,
+		}
+		if tamper != nil {
+			tamper(&ts)
+		}
+
+		resp, err := ts.CreateResponseWithOpts(cert, key, crypto.SHA256)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		_, _ = w.Write(resp)
+	})
+
+	return handler, cert
+}
+
+// generateTestChain returns a CA and a leaf certificate issued by it whose
+// OCSP responder is served by startTestOCSP.
+func generateTestChain(t *testing.T) (*x509.Certificate, *x509.Certificate, *rsa.PrivateKey) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(10),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(11),
+		Subject:      pkix.Name{CommonName: "Test Signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		OCSPServer:   []string{startTestOCSP(t, caCert, caKey)},
+	}
+
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	leafCert, err := x509.ParseCertificate(leafDER)
+	require.NoError(t, err)
+
+	return caCert, leafCert, leafKey
+}
+
+// startTestOCSP answers OCSP GET requests with a good status signed by the CA.
+func startTestOCSP(t *testing.T, caCert *x509.Certificate, caKey *rsa.PrivateKey) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBytes, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ocspReq, err := ocsp.ParseRequest(reqBytes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := ocsp.CreateResponse(caCert, caCert, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: ocspReq.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// startTestRevocationServer serves OCSP responses under /ocsp and a CRL under
+// /crl, both signed by the CA and valid for an hour. revoked is reported as
+// revoked by both. hits counts the requests.
+func startTestRevocationServer(t *testing.T, caCert *x509.Certificate, caKey crypto.Signer, revoked *big.Int) (string, *atomic.Int64) {
+	hits := &atomic.Int64{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ocsp/", func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		reqBytes, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/ocsp/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ocspReq, err := ocsp.ParseRequest(reqBytes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		template := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: ocspReq.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if revoked != nil && ocspReq.SerialNumber.Cmp(revoked) == 0 {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = time.Now().Add(-time.Minute)
+		}
+
+		resp, err := ocsp.CreateResponse(caCert, caCert, template, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(resp)
+	})
+	mux.HandleFunc("/crl", func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		template := &x509.RevocationList{
+			Number:     big.NewInt(1),
+			ThisUpdate: time.Now().Add(-time.Minute),
+			NextUpdate: time.Now().Add(time.Hour),
+		}
+		if revoked != nil {
+			template.RevokedCertificateEntries = []x509.RevocationListEntry{{SerialNumber: revoked, RevocationTime: time.Now().Add(-time.Minute)}}
+		}
+
+		crl, err := x509.CreateRevocationList(rand.Reader, template, caCert, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		_, _ = w.Write(crl)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server.URL, hits
+}
@@ -0,0 +1,195 @@
+package signer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/digitorus/pdf"
+	"github.com/mattetti/filebuffer"
+)
+
+// SignPdf signs the size bytes of PDF read from input and writes the signed
+// document to output. The input is never copied into memory: the signed byte
+// ranges are hashed while reading it and only the incremental update with the
+// signature is buffered, so large documents can be signed straight from a file.
+func SignPdf(ctx context.Context, input io.ReaderAt, size int64, output io.Writer, signData SignData) error {
+	pdfReader, err := pdf.NewReader(input, size)
+	if err != nil {
+		return fmt.Errorf("failed to create PDF reader: %v", err)
+	}
+
+	err = signWithContext(ctx, io.NewSectionReader(input, 0, size), output, pdfReader, size, signData.withDefaults())
+	if err != nil {
+		return fmt.Errorf("failed to sign PDF: %v", err)
+	}
+
+	return nil
+}
+
+// SignPdfFile signs the PDF at inputPath and writes the signed document to
+// outputPath, see SignPdf. The output is removed again when signing fails.
+func SignPdfFile(ctx context.Context, inputPath, outputPath string, signData SignData) error {
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input: %v", err)
+	}
+	defer input.Close()
+
+	info, err := input.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat input: %v", err)
+	}
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output: %v", err)
+	}
+
+	err = SignPdf(ctx, input, info.Size(), output, signData)
+	if closeErr := output.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write output: %v", closeErr)
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+
+	return nil
+}
+
+// startUpdate starts a new incremental update after the end of InputFile.
+// Only the update is written to OutputBuffer, offsets into it are relative
+// to updateOffset, the size of the input.
+func (context *SignContext) startUpdate() error {
+	size, err := context.InputFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	context.updateOffset = size
+	context.OutputBuffer = filebuffer.New([]byte{})
+
+	_, err = context.OutputBuffer.Write([]byte("\n"))
+	return err
+}
+
+// offset returns the position in the output document the next write to
+// OutputBuffer ends up at
+func (context *SignContext) offset() int64 {
+	return context.updateOffset + int64(context.OutputBuffer.Buff.Len())
+}
+
+// writeOutput writes the input followed by the update to OutputFile
+func (context *SignContext) writeOutput() error {
+	if _, err := context.InputFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(context.OutputFile, context.InputFile, context.updateOffset); err != nil {
+		return err
+	}
+
+	_, err := context.OutputFile.Write(context.OutputBuffer.Buff.Bytes())
+	return err
+}
+
+// byteRangeDigest hashes the signed byte ranges of the output document, the
+// input is streamed through the hash.
+func (context *SignContext) byteRangeDigest() ([]byte, error) {
+	hash := context.SignData.DigestAlgorithm.New()
+
+	// The placeholder is part of the update, so the whole input is signed
+	if _, err := context.InputFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(hash, context.InputFile, context.updateOffset); err != nil {
+		return nil, err
+	}
+
+	update := context.OutputBuffer.Buff.Bytes()
+	hash.Write(update[:context.ByteRangeValues[1]-context.updateOffset])
+	hash.Write(update[context.ByteRangeValues[2]-context.updateOffset:])
+
+	return hash.Sum(nil), nil
+}
+
+// signedRevision returns the output document as a reader over the input and
+// the update, for further incremental updates without writing it out first.
+func (context *SignContext) signedRevision() *io.SectionReader {
+	return appendUpdate(readerAt(context.InputFile), context.updateOffset, context.OutputBuffer.Buff.Bytes())
+}
+
+// appendUpdate returns a reader over the size bytes of base followed by update
+func appendUpdate(base io.ReaderAt, size int64, update []byte) *io.SectionReader {
+	revision := &revisionReader{base: base, baseSize: size, update: update}
+	return io.NewSectionReader(revision, 0, size+int64(len(update)))
+}
+
+// revisionReader reads a document revision, an incremental update appended
+// to the previous revision
+type revisionReader struct {
+	base     io.ReaderAt
+	baseSize int64
+	update   []byte
+}
+
+func (r *revisionReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	if off < r.baseSize {
+		base := p[:min(int64(len(p)), r.baseSize-off)]
+		read, err := r.base.ReadAt(base, off)
+		n += read
+		if read < len(base) {
+			if err == nil || errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		p = p[read:]
+		off += int64(read)
+	}
+
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	update_offset := off - r.baseSize
+	if update_offset >= int64(len(r.update)) {
+		return n, io.EOF
+	}
+
+	read := copy(p, r.update[update_offset:])
+	n += read
+	if read < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// readerAt returns r as an io.ReaderAt, seeking for each read when it does
+// not implement it
+func readerAt(r io.ReadSeeker) io.ReaderAt {
+	if reader_at, ok := r.(io.ReaderAt); ok {
+		return reader_at
+	}
+	return readSeekerAt{r}
+}
+
+type readSeekerAt struct {
+	io.ReadSeeker
+}
+
+func (r readSeekerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(r, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -2,64 +2,236 @@ package signer
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/digitorus/timestamp"
 )
 
+const (
+	defaultTSATimeout      = 30 * time.Second
+	defaultTSAMaxAttempts  = 2
+	defaultTSARetryBackoff = 500 * time.Millisecond
+	maxTSAResponseSize     = 1 << 20
+
+	// timestampTokenSize is the space reserved for a timestamp token with
+	// the TSA certificates, a larger token resizes the placeholder
+	timestampTokenSize = 9000
+)
+
+// defaultTSAClient is shared by TSAs without TLS client settings, so
+// connections to the TSA are reused across signatures
+var defaultTSAClient = &http.Client{}
+
+// tsaRejectedError marks failures a retry against the same endpoint will not fix
+type tsaRejectedError struct {
+	err error
+}
+
+func (e tsaRejectedError) Error() string { return e.err.Error() }
+func (e tsaRejectedError) Unwrap() error { return e.err }
+
+// GetTSA requests a timestamp over sign_content from the configured TSA
+// endpoints and returns the verified timestamp response.
 func (context *SignContext) GetTSA(sign_content []byte) (timestamp_response []byte, err error) {
-	sign_reader := bytes.NewReader(sign_content)
-	ts_request, err := timestamp.CreateRequest(sign_reader, &timestamp.RequestOptions{
-		Hash:         context.SignData.DigestAlgorithm,
-		Certificates: true,
-	})
+	response, _, err := context.SignData.TSA.timestamp(context.ctx, sign_content, context.SignData.DigestAlgorithm)
+	return response, err
+}
+
+// getTimestamp requests and parses a timestamp over sign_content
+func (context *SignContext) getTimestamp(sign_content []byte) (*timestamp.Timestamp, error) {
+	_, ts, err := context.SignData.TSA.timestamp(context.ctx, sign_content, context.SignData.DigestAlgorithm)
+	return ts, err
+}
+
+// enabled reports whether a TSA endpoint is configured
+func (tsa TSA) enabled() bool {
+	return tsa.URL != "" || len(tsa.FallbackURLs) > 0
+}
+
+func (tsa TSA) endpoints() []string {
+	var endpoints []string
+	if tsa.URL != "" {
+		endpoints = append(endpoints, tsa.URL)
+	}
+	return append(endpoints, tsa.FallbackURLs...)
+}
+
+// timestamp requests a timestamp over content, see timestampDigest
+func (tsa TSA) timestamp(ctx context.Context, content []byte, hash crypto.Hash) ([]byte, *timestamp.Timestamp, error) {
+	digest := hash.New()
+	digest.Write(content)
+	return tsa.timestampDigest(ctx, digest.Sum(nil), hash)
+}
+
+// timestampDigest sends a request for imprint, the hash of the content, with
+// a random nonce to each endpoint in turn, retrying failed attempts with
+// exponential backoff, and returns the first response whose token is signed
+// correctly and matches the request. It gives up as soon as ctx is done.
+func (tsa TSA) timestampDigest(ctx context.Context, imprint []byte, hash crypto.Hash) ([]byte, *timestamp.Timestamp, error) {
+	// Sign contexts built by callers have none
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create nonce: %w", err)
+	}
+
+	ts_request, err := (&timestamp.Request{
+		HashAlgorithm: hash,
+		HashedMessage: imprint,
+		Certificates:  true,
+		Nonce:         nonce,
+	}).Marshal()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	client := tsa.httpClient()
+
+	max_attempts := tsa.MaxAttempts
+	if max_attempts <= 0 {
+		max_attempts = defaultTSAMaxAttempts
+	}
+	backoff := tsa.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultTSARetryBackoff
+	}
+
+	var errs []error
+	for _, endpoint := range tsa.endpoints() {
+		delay := backoff
+		for attempt := 1; attempt <= max_attempts; attempt++ {
+			response, ts, err := tsa.request(ctx, client, endpoint, ts_request)
+			if err == nil {
+				err = verifyTimestamp(ts, hash, imprint, nonce)
+			}
+			if err == nil {
+				return response, ts, nil
+			}
+
+			errs = append(errs, fmt.Errorf("%s (attempt %d): %w", endpoint, attempt, err))
+			if ctx.Err() != nil {
+				return nil, nil, fmt.Errorf("no TSA returned a valid timestamp: %w", errors.Join(errs...))
+			}
+			var rejected tsaRejectedError
+			if errors.As(err, &rejected) || attempt == max_attempts {
+				break
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				errs = append(errs, ctx.Err())
+				return nil, nil, fmt.Errorf("no TSA returned a valid timestamp: %w", errors.Join(errs...))
+			case <-timer.C:
+			}
+			delay *= 2
+		}
+	}
+
+	return nil, nil, fmt.Errorf("no TSA returned a valid timestamp: %w", errors.Join(errs...))
+}
+
+func (tsa TSA) request(ctx context.Context, client *http.Client, endpoint string, ts_request []byte) ([]byte, *timestamp.Timestamp, error) {
+	timeout := tsa.Timeout
+	if timeout == 0 {
+		timeout = defaultTSATimeout
 	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	ts_request_reader := bytes.NewReader(ts_request)
-	req, err := http.NewRequest("POST", context.SignData.TSA.URL, ts_request_reader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(ts_request))
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare request (%s): %w", context.SignData.TSA.URL, err)
+		return nil, nil, tsaRejectedError{fmt.Errorf("failed to prepare request: %w", err)}
 	}
 
 	req.Header.Add("Content-Type", "application/timestamp-query")
 	req.Header.Add("Content-Transfer-Encoding", "binary")
 
-	if context.SignData.TSA.Username != "" && context.SignData.TSA.Password != "" {
-		req.SetBasicAuth(context.SignData.TSA.Username, context.SignData.TSA.Password)
+	if tsa.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+tsa.BearerToken)
+	} else if tsa.Username != "" && tsa.Password != "" {
+		req.SetBasicAuth(tsa.Username, tsa.Password)
 	}
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
-	code := 0
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
 
-	if resp != nil {
-		code = resp.StatusCode
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTSAResponseSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	if err != nil || (code < 200 || code > 299) {
-		if err == nil {
-			defer resp.Body.Close()
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read non success response: %w", err)
-			}
-			return nil, errors.New("non success response (" + strconv.Itoa(code) + "): " + string(body))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := errors.New("non success response (" + strconv.Itoa(resp.StatusCode) + "): " + string(body))
+		// Server errors and rate limiting are worth a retry, other client errors are not
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, nil, tsaRejectedError{err}
 		}
-
-		return nil, errors.New("non success response (" + strconv.Itoa(code) + ")")
+		return nil, nil, err
 	}
 
-	defer resp.Body.Close()
-	timestamp_response_body, err := io.ReadAll(resp.Body)
+	ts, err := timestamp.ParseResponse(body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, nil, tsaRejectedError{fmt.Errorf("parse timestamp: %w", err)}
+	}
+
+	return body, ts, nil
+}
+
+// verifyTimestamp checks the token answers our request: same nonce, hash
+// algorithm and message imprint. The token signature is verified on parsing.
+func verifyTimestamp(ts *timestamp.Timestamp, hash crypto.Hash, imprint []byte, nonce *big.Int) error {
+	if ts.Nonce == nil || ts.Nonce.Cmp(nonce) != 0 {
+		return tsaRejectedError{errors.New("timestamp nonce does not match the request")}
+	}
+	if ts.HashAlgorithm != hash {
+		return tsaRejectedError{fmt.Errorf("timestamp uses %s instead of %s", ts.HashAlgorithm, hash)}
+	}
+	if !bytes.Equal(ts.HashedMessage, imprint) {
+		return tsaRejectedError{errors.New("timestamp message imprint does not match the signed content")}
+	}
+	return nil
+}
+
+func (tsa TSA) httpClient() *http.Client {
+	if tsa.HTTPClient != nil {
+		return tsa.HTTPClient
+	}
+	if tsa.ClientCertificate == nil && tsa.RootCAs == nil {
+		return defaultTSAClient
+	}
+	return tsa.NewHTTPClient()
+}
+
+// NewHTTPClient builds a client with the TLS settings of the TSA,
+// ClientCertificate and RootCAs. Without HTTPClient every timestamp builds
+// its own, so set it once per TSA config to reuse connections.
+func (tsa TSA) NewHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    tsa.RootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	if tsa.ClientCertificate != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*tsa.ClientCertificate}
 	}
 
-	return timestamp_response_body, nil
+	return &http.Client{Transport: transport}
 }
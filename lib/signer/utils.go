@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/digitorus/pdf"
+	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 )
@@ -31,6 +32,28 @@ func pdfString(text string) string {
 	return text
 }
 
+// pdfTextString encodes text for a content stream using a standard font with
+// WinAnsiEncoding, characters outside that encoding are replaced by '?'.
+func pdfTextString(text string) string {
+	var encoded strings.Builder
+	for _, r := range text {
+		b, ok := charmap.Windows1252.EncodeRune(r)
+		if !ok {
+			b = '?'
+		}
+		switch b {
+		case '\\', '(', ')':
+			encoded.WriteByte('\\')
+			encoded.WriteByte(b)
+		case '\r':
+			encoded.WriteString("\\r")
+		default:
+			encoded.WriteByte(b)
+		}
+	}
+	return "(" + encoded.String() + ")"
+}
+
 func pdfDateTime(date time.Time) string {
 
 	_, original_offset := date.Zone()
@@ -0,0 +1,345 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/digitorus/pdf"
+	"github.com/digitorus/pkcs7"
+	"github.com/digitorus/timestamp"
+)
+
+var timestampTokenOID = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+
+// Verify checks every signed /Sig field of the PDF and returns a report per
+// signature. Broken or untrusted signatures are described in the report; an
+// error is only returned when the document itself cannot be read.
+func Verify(input io.ReaderAt, size int64, options VerifyOptions) (*VerifyReport, error) {
+	rdr, err := pdf.NewReader(input, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF reader: %w", err)
+	}
+
+	report := &VerifyReport{
+		FileSize:   size,
+		Valid:      true,
+		Trusted:    true,
+		Signatures: []SignatureReport{},
+	}
+
+	for _, field := range signatureFields(rdr) {
+		if field.Key("V").IsNull() {
+			continue
+		}
+
+		sigReport := verifySignatureField(input, size, field, options)
+		if !sigReport.ValidSignature {
+			report.Valid = false
+		}
+		if !sigReport.TrustedChain {
+			report.Trusted = false
+		}
+		report.Signatures = append(report.Signatures, sigReport)
+	}
+
+	if len(report.Signatures) == 0 {
+		report.Valid = false
+		report.Trusted = false
+	}
+
+	return report, nil
+}
+
+// VerifyPdfStream reads the whole PDF from pdfStream and verifies its signatures.
+func VerifyPdfStream(ctx context.Context, pdfStream io.Reader, options VerifyOptions) (*VerifyReport, error) {
+	var pdfBuffer bytes.Buffer
+	if _, err := io.Copy(&pdfBuffer, pdfStream); err != nil {
+		return nil, fmt.Errorf("failed to read pdf stream: %v", err)
+	}
+
+	pdfBytes := pdfBuffer.Bytes()
+
+	return Verify(bytes.NewReader(pdfBytes), int64(len(pdfBytes)), options)
+}
+
+func verifySignatureField(input io.ReaderAt, size int64, field pdf.Value, options VerifyOptions) SignatureReport {
+	sig := field.Key("V")
+
+	report := SignatureReport{
+		FieldName:   field.Key("T").Text(),
+		SubFilter:   sig.Key("SubFilter").Name(),
+		Name:        sig.Key("Name").Text(),
+		Reason:      sig.Key("Reason").Text(),
+		Location:    sig.Key("Location").Text(),
+		ContactInfo: sig.Key("ContactInfo").Text(),
+	}
+
+	certType, docMDPPerm := signatureCertType(sig)
+	report.CertType = certType.String()
+	report.DocMDPPerm = docMDPPerm
+
+	if m := sig.Key("M"); !m.IsNull() {
+		if signingTime, err := parsePdfDateTime(m.Text()); err == nil {
+			report.SigningTime = signingTime
+		}
+	}
+
+	signedContent, err := readByteRange(input, size, sig.Key("ByteRange"), &report)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+
+	contents := []byte(sig.Key("Contents").RawString())
+	if len(contents) == 0 {
+		report.Errors = append(report.Errors, "signature has no contents")
+		return report
+	}
+
+	if certType == TimeStampSignature {
+		verifyDocumentTimestamp(contents, signedContent, options, &report)
+	} else {
+		verifySignedData(contents, signedContent, options, &report)
+	}
+
+	if certType == CertificationSignature && docMDPPerm == DoNotAllowAnyChangesPerms && report.ModifiedAfterSigning {
+		report.ValidSignature = false
+		report.Errors = append(report.Errors, "document was modified after a certification signature that allows no changes")
+	}
+
+	return report
+}
+
+// signatureCertType derives the signature type from the signature dictionary
+// and its /Reference transform methods.
+func signatureCertType(sig pdf.Value) (CertType, DocMDPPerm) {
+	if sig.Key("Type").Name() == "DocTimeStamp" || sig.Key("SubFilter").Name() == "ETSI.RFC3161" {
+		return TimeStampSignature, 0
+	}
+
+	references := sig.Key("Reference")
+	for i := 0; i < references.Len(); i++ {
+		reference := references.Index(i)
+		switch reference.Key("TransformMethod").Name() {
+		case "DocMDP":
+			perm := DocMDPPerm(reference.Key("TransformParams").Key("P").Int64())
+			if perm == 0 {
+				perm = AllowFillingExistingFormFieldsAndSignaturesPerms
+			}
+			return CertificationSignature, perm
+		case "UR3":
+			return UsageRightsSignature, 0
+		}
+	}
+
+	return ApprovalSignature, 0
+}
+
+// readByteRange validates the /ByteRange of a signature against the file and
+// returns the bytes it covers.
+func readByteRange(input io.ReaderAt, size int64, byteRange pdf.Value, report *SignatureReport) ([]byte, error) {
+	if byteRange.Len() != 4 {
+		return nil, fmt.Errorf("invalid byte range with %d entries", byteRange.Len())
+	}
+
+	for i := 0; i < byteRange.Len(); i++ {
+		report.ByteRange = append(report.ByteRange, byteRange.Index(i).Int64())
+	}
+	br := report.ByteRange
+
+	if br[0] != 0 || br[1] < 0 || br[2] <= br[1] || br[3] < 0 || br[2]+br[3] > size {
+		return nil, fmt.Errorf("byte range %v is outside of the file", br)
+	}
+
+	signedEnd := br[2] + br[3]
+	report.CoversWholeFile = signedEnd == size
+	report.ModifiedAfterSigning = signedEnd < size
+
+	gap := make([]byte, 1)
+	if _, err := input.ReadAt(gap, br[1]); err != nil || gap[0] != '<' {
+		return nil, fmt.Errorf("byte range gap does not start at the signature contents")
+	}
+	if _, err := input.ReadAt(gap, br[2]-1); err != nil || gap[0] != '>' {
+		return nil, fmt.Errorf("byte range gap does not end at the signature contents")
+	}
+
+	content := make([]byte, br[1]+br[3])
+	if _, err := input.ReadAt(content[:br[1]], br[0]); err != nil {
+		return nil, fmt.Errorf("failed to read signed content: %w", err)
+	}
+	if _, err := input.ReadAt(content[br[1]:], br[2]); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read signed content: %w", err)
+	}
+
+	return content, nil
+}
+
+func verifySignedData(contents, signedContent []byte, options VerifyOptions, report *SignatureReport) {
+	p7, err := pkcs7.Parse(contents)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to parse signature: %v", err))
+		return
+	}
+	p7.Content = signedContent
+
+	signerCert := p7.GetOnlySigner()
+	if signerCert != nil {
+		report.Signer = newSignerReport(signerCert)
+	}
+
+	if err := p7.Verify(); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("signature is invalid: %v", err))
+	} else {
+		report.ValidSignature = true
+	}
+
+	var signingTime time.Time
+	if err := p7.UnmarshalSignedAttribute(pkcs7.OIDAttributeSigningTime, &signingTime); err == nil {
+		report.SigningTime = signingTime
+	}
+
+	if len(p7.Signers) > 0 {
+		for _, attr := range p7.Signers[0].UnauthenticatedAttributes {
+			if !attr.Type.Equal(timestampTokenOID) {
+				continue
+			}
+			report.Timestamp = verifyTimestampToken(attr.Value.Bytes, p7.Signers[0].EncryptedDigest, report)
+			if !report.Timestamp.Valid {
+				report.ValidSignature = false
+			}
+		}
+	}
+
+	if signerCert == nil {
+		report.Errors = append(report.Errors, "signature does not contain exactly one signer certificate")
+		return
+	}
+
+	// /M and the signing-time attribute are claimed by the signer, only a
+	// timestamp proves the certificate was valid at an earlier time
+	validationTime := time.Now()
+	if report.Timestamp != nil && report.Timestamp.Valid {
+		validationTime = report.Timestamp.Time
+	}
+
+	if err := verifyChain(signerCert, p7.Certificates, validationTime, options); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("certificate chain is not trusted: %v", err))
+	} else {
+		report.TrustedChain = true
+	}
+}
+
+func verifyDocumentTimestamp(contents, signedContent []byte, options VerifyOptions, report *SignatureReport) {
+	report.Timestamp = verifyTimestampToken(contents, signedContent, report)
+	report.ValidSignature = report.Timestamp.Valid
+	report.SigningTime = report.Timestamp.Time
+
+	p7, err := pkcs7.Parse(contents)
+	if err != nil {
+		return
+	}
+
+	signerCert := p7.GetOnlySigner()
+	if signerCert == nil {
+		report.Errors = append(report.Errors, "timestamp does not contain the TSA certificate")
+		return
+	}
+	report.Signer = newSignerReport(signerCert)
+
+	if err := verifyChain(signerCert, p7.Certificates, report.Timestamp.Time, options); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("timestamp certificate chain is not trusted: %v", err))
+	} else {
+		report.TrustedChain = true
+	}
+}
+
+// verifyTimestampToken parses an RFC 3161 token and checks that its message
+// imprint matches the digest of message.
+func verifyTimestampToken(token, message []byte, report *SignatureReport) *TimestampReport {
+	tsReport := &TimestampReport{}
+
+	ts, err := timestamp.Parse(token)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to parse timestamp: %v", err))
+		return tsReport
+	}
+	tsReport.Time = ts.Time
+	if len(ts.Certificates) > 0 {
+		tsReport.Authority = ts.Certificates[0].Subject.CommonName
+	}
+
+	hash := ts.HashAlgorithm.New()
+	hash.Write(message)
+	if !bytes.Equal(hash.Sum(nil), ts.HashedMessage) {
+		report.Errors = append(report.Errors, "timestamp message imprint does not match the signed data")
+		return tsReport
+	}
+
+	tsReport.Valid = true
+	return tsReport
+}
+
+func verifyChain(cert *x509.Certificate, embedded []*x509.Certificate, at time.Time, options VerifyOptions) error {
+	intermediates := x509.NewCertPool()
+	for _, c := range embedded {
+		intermediates.AddCert(c)
+	}
+	for _, c := range options.Intermediates {
+		intermediates.AddCert(c)
+	}
+
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         options.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+func newSignerReport(cert *x509.Certificate) *SignerReport {
+	return &SignerReport{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		SerialNumber: cert.SerialNumber.String(),
+		Issuer:       cert.Issuer.String(),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+	}
+}
+
+var pdfDateTimeRegexp = regexp.MustCompile(`^D:(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?([+\-Z])?(\d{2})?'?(\d{2})?'?$`)
+
+// parsePdfDateTime parses a PDF date string as written by pdfDateTime.
+func parsePdfDateTime(value string) (time.Time, error) {
+	match := pdfDateTimeRegexp.FindStringSubmatch(value)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("invalid PDF date: %s", value)
+	}
+
+	field := func(i, def int) int {
+		if match[i] == "" {
+			return def
+		}
+		n, _ := strconv.Atoi(match[i])
+		return n
+	}
+
+	location := time.UTC
+	if match[7] == "+" || match[7] == "-" {
+		offset := field(8, 0)*3600 + field(9, 0)*60
+		if match[7] == "-" {
+			offset = -offset
+		}
+		location = time.FixedZone("", offset)
+	}
+
+	return time.Date(field(1, 0), time.Month(field(2, 1)), field(3, 1), field(4, 0), field(5, 0), field(6, 0), 0, location), nil
+}
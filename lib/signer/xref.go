@@ -15,7 +15,7 @@ func (context *SignContext) writeXref() error {
 	if _, err := context.OutputBuffer.Write([]byte("\n")); err != nil {
 		return fmt.Errorf("failed to write newline before xref: %w", err)
 	}
-	context.NewXrefStart = int64(context.OutputBuffer.Buff.Len())
+	context.NewXrefStart = context.offset()
 
 	switch context.PDFReader.XrefInformation.Type {
 	case "table":
@@ -45,6 +45,16 @@ func (context *SignContext) getLastObjectIDFromXref() (uint32, error) {
 	return maxID + 1, nil
 }
 
+// xrefSize returns the /Size of the updated cross-reference, one more than
+// the highest object number in use after the update.
+func (context *SignContext) xrefSize() int64 {
+	size := int64(context.lastXrefID) + int64(len(context.newXrefEntries)) + 1
+	if size < context.PDFReader.XrefInformation.ItemCount {
+		size = context.PDFReader.XrefInformation.ItemCount
+	}
+	return size
+}
+
 func (context *SignContext) writeIncrXrefTable() error {
 
 	if _, err := context.OutputBuffer.Write([]byte("xref\n")); err != nil {
@@ -81,6 +91,14 @@ func (context *SignContext) writeIncrXrefTable() error {
 func (context *SignContext) writeXrefStream() error {
 	var buffer bytes.Buffer
 
+	// The xref stream is an object of the update as well and has to list
+	// itself, otherwise the next incremental update could reuse its number.
+	xrefStreamID := context.lastXrefID + uint32(len(context.newXrefEntries)) + 1
+	context.newXrefEntries = append(context.newXrefEntries, xrefEntry{
+		ID:     xrefStreamID,
+		Offset: context.offset() + 1,
+	})
+
 	predictor := context.PDFReader.Trailer().Key("DecodeParms").Key("Predictor").Int64()
 	if predictor == 0 {
 		predictor = xrefStreamPredictor
@@ -105,8 +123,7 @@ func (context *SignContext) writeXrefStream() error {
 		return fmt.Errorf("failed to write xref stream content: %w", err)
 	}
 
-	_, err = context.addObject(xrefStreamObject.Bytes())
-	if err != nil {
+	if err := context.writeObject(xrefStreamID, xrefStreamObject.Bytes()); err != nil {
 		return fmt.Errorf("failed to add xref stream object: %w", err)
 	}
 
@@ -140,7 +157,6 @@ func encodeXrefStream(data []byte, predictor int64) ([]byte, error) {
 func writeXrefStreamHeader(buffer *bytes.Buffer, context *SignContext, streamLength int) error {
 	id := context.PDFReader.Trailer().Key("ID")
 
-	totalEntries := uint32(context.PDFReader.XrefInformation.ItemCount)
 	var indexArray []uint32
 
 	if len(context.updatedXrefEntries) > 0 {
@@ -151,7 +167,6 @@ func writeXrefStreamHeader(buffer *bytes.Buffer, context *SignContext, streamLen
 
 	if len(context.newXrefEntries) > 0 {
 		indexArray = append(indexArray, context.lastXrefID+1, uint32(len(context.newXrefEntries)))
-		totalEntries += uint32(len(context.newXrefEntries))
 	}
 
 	buffer.WriteString("<< /Type /XRef\n")
@@ -160,7 +175,7 @@ func writeXrefStreamHeader(buffer *bytes.Buffer, context *SignContext, streamLen
 
 	buffer.WriteString("  /W [ 1 4 1 ]\n")
 	buffer.WriteString(fmt.Sprintf("  /Prev %d\n", context.PDFReader.XrefInformation.StartPos))
-	buffer.WriteString(fmt.Sprintf("  /Size %d\n", totalEntries+1))
+	buffer.WriteString(fmt.Sprintf("  /Size %d\n", context.xrefSize()))
 
 	if len(indexArray) > 0 {
 		buffer.WriteString("  /Index [")
@@ -172,6 +187,11 @@ func writeXrefStreamHeader(buffer *bytes.Buffer, context *SignContext, streamLen
 
 	buffer.WriteString(fmt.Sprintf("  /Root %d 0 R\n", context.CatalogData.ObjectId))
 
+	if info := context.PDFReader.Trailer().Key("Info"); !info.IsNull() {
+		infoPtr := info.GetPtr()
+		buffer.WriteString(fmt.Sprintf("  /Info %d %d R\n", infoPtr.GetID(), infoPtr.GetGen()))
+	}
+
 	if !id.IsNull() {
 		id0 := hex.EncodeToString([]byte(id.Index(0).RawString()))
 		id1 := hex.EncodeToString([]byte(id.Index(1).RawString()))
@@ -225,7 +245,7 @@ func (context *SignContext) writeTrailer() error {
 		new_root := "Root " + strconv.FormatInt(int64(context.CatalogData.ObjectId), 10) + " 0 R"
 
 		size_string := "Size " + strconv.FormatInt(context.PDFReader.XrefInformation.ItemCount, 10)
-		new_size := "Size " + strconv.FormatInt(context.PDFReader.XrefInformation.ItemCount+int64(len(context.newXrefEntries)+1), 10)
+		new_size := "Size " + strconv.FormatInt(context.xrefSize(), 10)
 
 		prev_string := "Prev " + context.PDFReader.Trailer().Key("Prev").String()
 		new_prev := "Prev " + strconv.FormatInt(context.PDFReader.XrefInformation.StartPos, 10)
@@ -0,0 +1,309 @@
+package templatestore
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TemplateVersioner is implemented by storage adapters that can tell the
+// version of a template, e.g. its etag or content hash, without fetching
+// it. CachedStorage uses it to notice templates changed by other instances.
+type TemplateVersioner interface {
+	TemplateVersion(ctx context.Context, req *GetTemplateRequest) (string, error)
+}
+
+// CachedStorage is a StorageAdapter keeping the templates of another adapter
+// parsed, by id or path and version. With an adapter implementing
+// TemplateVersioner the version is checked before a cached template is used,
+// otherwise cached templates are used until TTL. Templates created, published,
+// updated or deleted through the cache are invalidated right away. Every other
+// call goes to the adapter.
+//
+// Cached templates are shared by concurrent requests, they must only be
+// executed; Clone one to change it.
+type CachedStorage struct {
+	StorageAdapter
+
+	// TTL drops cached templates older than this, zero keeps them until they
+	// are evicted or their version changes
+	TTL time.Duration
+	// MaxEntries evicts the least recently used templates above this count,
+	// zero keeps all of them
+	MaxEntries int
+	// RevalidateInterval checks the version of a cached template at most this
+	// often, zero checks it on every GetTemplate
+	RevalidateInterval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// templateFileResolver is implemented by adapters reading templates from
+// files. Their templates are cached by file, so the path given to GetTemplate
+// and the root relative path given to UpdateTemplate or DeleteTemplate name
+// the same entry.
+type templateFileResolver interface {
+	templateFile(templatePath string) string
+	rootTemplateFile(templatePath string) string
+}
+
+type templateCacheEntry struct {
+	mu          sync.Mutex
+	key         string
+	ids         [3]string // uuid, path and S3 path of the template
+	template    *template.Template
+	version     string
+	loadedAt    time.Time
+	validatedAt time.Time
+}
+
+// NewCachedStorage wraps adapter with a parsed template cache
+func NewCachedStorage(adapter StorageAdapter) *CachedStorage {
+	return &CachedStorage{
+		StorageAdapter: adapter,
+		entries:        map[string]*list.Element{},
+		lru:            list.New(),
+	}
+}
+
+// GetTemplate returns the cached template of req, fetching and parsing it
+// with the adapter when missing, expired or changed. Concurrent requests for
+// a template that is not cached wait for a single fetch.
+func (c *CachedStorage) GetTemplate(ctx context.Context, req *GetTemplateRequest) (*template.Template, error) {
+	entry := c.entry(req)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	versioner, hasVersion := c.StorageAdapter.(TemplateVersioner)
+
+	if entry.template != nil && (c.TTL <= 0 || time.Since(entry.loadedAt) < c.TTL) {
+		if !hasVersion || (c.RevalidateInterval > 0 && time.Since(entry.validatedAt) < c.RevalidateInterval) {
+			return entry.template, nil
+		}
+
+		version, err := versioner.TemplateVersion(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if version == entry.version {
+			entry.validatedAt = time.Now()
+			return entry.template, nil
+		}
+	}
+
+	// The version is read before the template, a change in between is seen next time
+	var version string
+	if hasVersion {
+		var err error
+		version, err = versioner.TemplateVersion(ctx, req)
+		if err != nil {
+			c.removeEmpty(entry)
+			return nil, err
+		}
+	}
+
+	templateFile, err := c.StorageAdapter.GetTemplate(ctx, req)
+	if err != nil {
+		c.removeEmpty(entry)
+		return nil, err
+	}
+
+	entry.template = templateFile
+	entry.version = version
+	entry.loadedAt = time.Now()
+	entry.validatedAt = entry.loadedAt
+
+	return templateFile, nil
+}
+
+// CreateTemplate creates the template with the adapter, dropping anything
+// cached under its id
+func (c *CachedStorage) CreateTemplate(ctx context.Context, req *CreateTemplateRequest) (string, error) {
+	templateID, err := c.StorageAdapter.CreateTemplate(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	c.Invalidate(templateID)
+
+	return templateID, nil
+}
+
+// CreateTemplateVersion saves the version with the adapter, dropping the
+// cached published template when the version is published
+func (c *CachedStorage) CreateTemplateVersion(ctx context.Context, req *CreateTemplateVersionRequest) (int, error) {
+	version, err := c.StorageAdapter.CreateTemplateVersion(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	if req.Publish {
+		c.invalidate(req.TemplateUUID, false)
+	}
+
+	return version, nil
+}
+
+// PublishTemplateVersion publishes the version with the adapter, dropping the
+// cached published template. Pinned versions stay cached, they never change.
+func (c *CachedStorage) PublishTemplateVersion(ctx context.Context, req *PublishTemplateVersionRequest) error {
+	if err := c.StorageAdapter.PublishTemplateVersion(ctx, req); err != nil {
+		return err
+	}
+
+	c.invalidate(req.TemplateUUID, false)
+
+	return nil
+}
+
+// UpdateTemplate updates the template with the adapter, dropping the cached
+// template. Pinned versions stay cached, an update is a new version.
+func (c *CachedStorage) UpdateTemplate(ctx context.Context, req *UpdateTemplateRequest) (string, error) {
+	templatePath := c.rootTemplatePath(req.TemplatePath)
+	etag, err := c.StorageAdapter.UpdateTemplate(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	for _, id := range []string{req.TemplateUUID, templatePath, req.TemplateS3Path} {
+		c.invalidate(id, false)
+	}
+
+	return etag, nil
+}
+
+// DeleteTemplate deletes the template with the adapter, dropping the cached
+// template and its pinned versions
+func (c *CachedStorage) DeleteTemplate(ctx context.Context, req *DeleteTemplateRequest) error {
+	// Resolved first, the file is gone afterwards
+	templatePath := c.rootTemplatePath(req.TemplatePath)
+	if err := c.StorageAdapter.DeleteTemplate(ctx, req); err != nil {
+		return err
+	}
+
+	for _, id := range []string{req.TemplateUUID, templatePath, req.TemplateS3Path} {
+		c.Invalidate(id)
+	}
+
+	return nil
+}
+
+// Invalidate drops the templates cached under id, a template uuid or path,
+// and the versions of the template pinned as id@version. Call it when a
+// template is changed other than through the cache.
+func (c *CachedStorage) Invalidate(id string) {
+	c.invalidate(id, true)
+}
+
+func (c *CachedStorage) invalidate(id string, pinned bool) {
+	if id == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, element := range c.entries {
+		entry := element.Value.(*templateCacheEntry)
+		for _, entryID := range entry.ids {
+			if entryID == id || (pinned && strings.HasPrefix(entryID, id+"@")) {
+				c.remove(element)
+				break
+			}
+		}
+	}
+}
+
+// InvalidateAll empties the cache
+func (c *CachedStorage) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+}
+
+// Len returns the number of cached templates
+func (c *CachedStorage) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// entry returns the cache entry of req, adding an empty one when missing
+func (c *CachedStorage) entry(req *GetTemplateRequest) *templateCacheEntry {
+	templatePath := req.TemplatePath
+	if resolver, ok := c.StorageAdapter.(templateFileResolver); ok && templatePath != "" {
+		templatePath = resolver.templateFile(templatePath)
+	}
+	key := templateCacheKey(req, templatePath)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(element)
+		return element.Value.(*templateCacheEntry)
+	}
+
+	entry := &templateCacheEntry{
+		key: key,
+		ids: [3]string{req.TemplateUUID, templatePath, req.TemplateS3Path},
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+
+	return entry
+}
+
+// removeEmpty drops entry when no template was ever loaded into it, so
+// requests for missing templates do not fill the cache
+func (c *CachedStorage) removeEmpty(entry *templateCacheEntry) {
+	if entry.template != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[entry.key]; ok && element.Value == entry {
+		c.remove(element)
+	}
+}
+
+func (c *CachedStorage) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*templateCacheEntry).key)
+}
+
+// rootTemplatePath returns the path the template of an update or delete is
+// cached under
+func (c *CachedStorage) rootTemplatePath(templatePath string) string {
+	if resolver, ok := c.StorageAdapter.(templateFileResolver); ok && templatePath != "" {
+		return resolver.rootTemplateFile(templatePath)
+	}
+	return templatePath
+}
+
+// templateCacheKey identifies a template by every field the adapters read,
+// its path as cached and template bytes by their hash
+func templateCacheKey(req *GetTemplateRequest, templatePath string) string {
+	var bytesHash string
+	if req.TemplateBytes != nil {
+		bytesHash = fmt.Sprintf("%x", sha256.Sum256(req.TemplateBytes))
+	}
+
+	return fmt.Sprintf("%q %q %q %s", req.TemplateUUID, templatePath, req.TemplateS3Path, bytesHash)
+}
@@ -0,0 +1,236 @@
+package templatestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage is a stream adapter counting the templates it parses
+type countingStorage struct {
+	StreamStorage
+	gets     atomic.Int32
+	versions map[string]string
+	err      error
+}
+
+func (s *countingStorage) GetTemplate(ctx context.Context, req *GetTemplateRequest) (*template.Template, error) {
+	s.gets.Add(1)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return template.New(req.TemplateUUID).Parse(req.TemplateUUID + " {{.name}}")
+}
+
+func (s *countingStorage) CreateTemplate(ctx context.Context, req *CreateTemplateRequest) (string, error) {
+	return req.TemplateName, nil
+}
+
+func (s *countingStorage) PublishTemplateVersion(ctx context.Context, req *PublishTemplateVersionRequest) error {
+	return nil
+}
+
+func (s *countingStorage) DeleteTemplate(ctx context.Context, req *DeleteTemplateRequest) error {
+	return nil
+}
+
+type versionedStorage struct {
+	*countingStorage
+}
+
+func (s versionedStorage) TemplateVersion(ctx context.Context, req *GetTemplateRequest) (string, error) {
+	version, ok := s.versions[req.TemplateUUID]
+	if !ok {
+		return "", errors.New("template not found")
+	}
+	return version, nil
+}
+
+func execute(t *testing.T, templateFile *template.Template) string {
+	var buf bytes.Buffer
+	require.NoError(t, templateFile.Execute(&buf, map[string]string{"name": "test"}))
+	return buf.String()
+}
+
+func TestCachedStorage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("cached_until_invalidated", func(t *testing.T) {
+		storage := &countingStorage{}
+		cache := NewCachedStorage(storage)
+
+		for i := 0; i < 3; i++ {
+			templateFile, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+			require.NoError(t, err)
+			assert.Equal(t, "a test", execute(t, templateFile))
+		}
+		_, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "b"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), storage.gets.Load())
+
+		cache.Invalidate("a")
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), storage.gets.Load())
+
+		// Creating a template drops what is cached under its id
+		_, err = cache.CreateTemplate(ctx, &CreateTemplateRequest{TemplateName: "b"})
+		require.NoError(t, err)
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "b"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(4), storage.gets.Load())
+
+		// Publishing a version drops the published template, pinned versions stay
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "b@1"})
+		require.NoError(t, err)
+		require.NoError(t, cache.PublishTemplateVersion(ctx, &PublishTemplateVersionRequest{TemplateUUID: "b", Version: 1}))
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "b@1"})
+		require.NoError(t, err)
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "b"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(6), storage.gets.Load())
+
+		// Deleting a template drops its pinned versions too
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a@1"})
+		require.NoError(t, err)
+		require.NoError(t, cache.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplateUUID: "b"}))
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "b@1"})
+		require.NoError(t, err)
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a@1"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(8), storage.gets.Load())
+
+		cache.InvalidateAll()
+		assert.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		storage := &countingStorage{}
+		cache := NewCachedStorage(storage)
+		cache.TTL = 20 * time.Millisecond
+
+		_, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		require.NoError(t, err)
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), storage.gets.Load())
+
+		time.Sleep(30 * time.Millisecond)
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), storage.gets.Load())
+	})
+
+	t.Run("max_entries", func(t *testing.T) {
+		storage := &countingStorage{}
+		cache := NewCachedStorage(storage)
+		cache.MaxEntries = 2
+
+		for _, id := range []string{"a", "b", "a", "c", "a", "b"} {
+			_, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: id})
+			require.NoError(t, err)
+		}
+		// c evicted b, the least recently used, and b evicted c
+		assert.Equal(t, int32(4), storage.gets.Load())
+		assert.Equal(t, 2, cache.Len())
+	})
+
+	t.Run("version", func(t *testing.T) {
+		storage := &countingStorage{versions: map[string]string{"a": "1"}}
+		cache := NewCachedStorage(versionedStorage{storage})
+
+		_, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		require.NoError(t, err)
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), storage.gets.Load())
+
+		storage.versions["a"] = "2"
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), storage.gets.Load())
+
+		delete(storage.versions, "a")
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		assert.Error(t, err)
+	})
+
+	t.Run("errors_not_cached", func(t *testing.T) {
+		storage := &countingStorage{err: errors.New("template not found")}
+		cache := NewCachedStorage(storage)
+
+		_, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		assert.Error(t, err)
+		assert.Equal(t, 0, cache.Len())
+
+		storage.err = nil
+		_, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), storage.gets.Load())
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		storage := &countingStorage{}
+		cache := NewCachedStorage(storage)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				templateFile, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: "a"})
+				assert.NoError(t, err)
+				assert.Equal(t, "a test", execute(t, templateFile))
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), storage.gets.Load())
+	})
+
+	t.Run("disk", func(t *testing.T) {
+		templatePath := filepath.Join(t.TempDir(), "template.html")
+		require.NoError(t, os.WriteFile(templatePath, []byte("v1 {{.name}}"), 0644))
+
+		cache := NewCachedStorage(&DiskTemplateStorage{})
+		templateFile, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplatePath: templatePath})
+		require.NoError(t, err)
+		assert.Equal(t, "v1 test", execute(t, templateFile))
+
+		require.NoError(t, os.WriteFile(templatePath, []byte("version 2 {{.name}}"), 0644))
+		templateFile, err = cache.GetTemplate(ctx, &GetTemplateRequest{TemplatePath: templatePath})
+		require.NoError(t, err)
+		assert.Equal(t, "version 2 test", execute(t, templateFile))
+	})
+
+	t.Run("disk_root_relative_changes", func(t *testing.T) {
+		root := t.TempDir()
+		templatePath := filepath.Join(root, "template.html")
+		require.NoError(t, os.WriteFile(templatePath, []byte("v1 {{.name}}"), 0644))
+
+		cache := NewCachedStorage(&DiskTemplateStorage{RootDir: root})
+		cache.RevalidateInterval = time.Hour
+		_, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplatePath: templatePath})
+		require.NoError(t, err)
+		require.Equal(t, 1, cache.Len())
+
+		_, err = cache.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplatePath: "template.html", TemplateHTML: "v2 {{.name}}"})
+		require.NoError(t, err)
+		assert.Equal(t, 0, cache.Len())
+		templateFile, err := cache.GetTemplate(ctx, &GetTemplateRequest{TemplatePath: templatePath})
+		require.NoError(t, err)
+		assert.Equal(t, "v2 test", execute(t, templateFile))
+
+		require.NoError(t, cache.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplatePath: "template.html"}))
+		assert.Equal(t, 0, cache.Len())
+	})
+}
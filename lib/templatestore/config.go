@@ -7,4 +7,6 @@ type StorageConfig struct {
 	S3Config      *s3.Config
 	AwsCredConfig *s3.AwsCredConfig
 	MysqlDSN      string
+	// DiskRootDir is the template root of disk storage, see DiskTemplateStorage.RootDir
+	DiskRootDir string
 }
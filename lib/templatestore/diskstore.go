@@ -2,16 +2,27 @@ package templatestore
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"text/template"
+	"time"
 )
 
 // DiskTemplateStorage is a concrete implementation of TemplateStorageAdapter for disk storage.
 type DiskTemplateStorage struct {
-	// disk storage implementation
+	// RootDir holds the templates that GetTemplateContent, UpdateTemplate and DeleteTemplate work on. Their
+	// template paths are relative to it and may not leave it, also not through symlinks. They fail when it is
+	// empty, so request supplied paths never reach other files of the host.
+	RootDir string
+
+	// mu serializes template updates and deletes of this process, the ETag check and the change of the file
+	// are not atomic across processes
+	mu sync.Mutex
 }
 
 func (d *DiskTemplateStorage) GetTemplate(ctx context.Context, req *GetTemplateRequest) (*template.Template, error) {
@@ -29,6 +40,39 @@ func (d *DiskTemplateStorage) GetTemplate(ctx context.Context, req *GetTemplateR
 	// implement disk storage retrieval
 }
 
+// TemplateVersion returns the ETag of the template file
+func (d *DiskTemplateStorage) TemplateVersion(ctx context.Context, req *GetTemplateRequest) (string, error) {
+	if req.TemplatePath == "" {
+		return "", fmt.Errorf("template path is required for disk storage")
+	}
+	return fileETag(req.TemplatePath)
+}
+
+// fileETag returns the ETag of the template file, the hash of its content. Modification times are too coarse
+// on some filesystems to tell two quick writes apart.
+func fileETag(path string) (string, error) {
+	content, err := readTemplateFile(path)
+	if err != nil {
+		return "", err
+	}
+	return contentETag(content), nil
+}
+
+func contentETag(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+func readTemplateFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, path)
+		}
+		return nil, fmt.Errorf("unable to read template file: %v", err)
+	}
+	return content, nil
+}
+
 func (d *DiskTemplateStorage) PutDocument(ctx context.Context, req *PostDocumentRequest, reader *io.Reader) (string, error) {
 	if req.FilePath == "" {
 		return "", fmt.Errorf("file path is required for disk storage")
@@ -72,9 +116,175 @@ func (d *DiskTemplateStorage) GetDocument(ctx context.Context, req *GetDocumentR
 func (d *DiskTemplateStorage) ListTemplates(ctx context.Context) ([]*TemplateInfo, error) {
 	return nil, fmt.Errorf("listing templates is not supported for disk storage")
 }
+
+// GetTemplateContent reads the template file with its ETag
 func (m *DiskTemplateStorage) GetTemplateContent(ctx context.Context, req *GetTemplateContentRequest) (*GetTemplateContentResponse, error) {
-	return nil, fmt.Errorf("get template content not implemented for disk storage")
+	templatePath, err := m.resolveTemplatePath(req.TemplatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := readTemplateFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetTemplateContentResponse{
+		TemplateContent: string(content),
+		TemplateName:    filepath.Base(req.TemplatePath),
+		ETag:            contentETag(content),
+	}, nil
 }
 func (m *DiskTemplateStorage) CreateTemplate(ctx context.Context, req *CreateTemplateRequest) (string, error) {
 	return "", fmt.Errorf("create template not implemented for disk storage")
 }
+func (m *DiskTemplateStorage) CreateTemplateVersion(ctx context.Context, req *CreateTemplateVersionRequest) (int, error) {
+	return 0, fmt.Errorf("template versions not implemented for disk storage")
+}
+func (m *DiskTemplateStorage) ListTemplateVersions(ctx context.Context, req *ListTemplateVersionsRequest) ([]*TemplateVersionInfo, error) {
+	return nil, fmt.Errorf("template versions not implemented for disk storage")
+}
+func (m *DiskTemplateStorage) PublishTemplateVersion(ctx context.Context, req *PublishTemplateVersionRequest) error {
+	return fmt.Errorf("template versions not implemented for disk storage")
+}
+
+// UpdateTemplate replaces the content of the template file. The new content is written next to it and renamed
+// over it, so readers never see a partial template.
+func (m *DiskTemplateStorage) UpdateTemplate(ctx context.Context, req *UpdateTemplateRequest) (string, error) {
+	if req.TemplateHTML == "" {
+		return "", fmt.Errorf("template HTML is required for disk storage")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	templatePath, err := m.resolveTemplatePath(req.TemplatePath)
+	if err != nil {
+		return "", err
+	}
+	if err := checkFileETag(templatePath, req.IfMatch); err != nil {
+		return "", err
+	}
+	info, err := os.Stat(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to stat template file: %v", err)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(templatePath), "."+filepath.Base(templatePath)+".*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.WriteString(req.TemplateHTML); err != nil {
+		tempFile.Close()
+		return "", fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return "", fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := os.Chmod(tempFile.Name(), info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("failed to set file mode: %v", err)
+	}
+	if err := os.Rename(tempFile.Name(), templatePath); err != nil {
+		return "", fmt.Errorf("failed to replace template file: %v", err)
+	}
+
+	return contentETag([]byte(req.TemplateHTML)), nil
+}
+
+// DeleteTemplate renames the template file to <path>.deleted.<time>, every deleted copy of a path is kept
+func (m *DiskTemplateStorage) DeleteTemplate(ctx context.Context, req *DeleteTemplateRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	templatePath, err := m.resolveTemplatePath(req.TemplatePath)
+	if err != nil {
+		return err
+	}
+	if err := checkFileETag(templatePath, req.IfMatch); err != nil {
+		return err
+	}
+
+	deletedPath := deletedTemplateName(templatePath, time.Now())
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(deletedPath); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		deletedPath = fmt.Sprintf("%s-%d", deletedTemplateName(templatePath, time.Now()), i)
+	}
+	if err := os.Rename(templatePath, deletedPath); err != nil {
+		return fmt.Errorf("failed to delete template file: %v", err)
+	}
+
+	return nil
+}
+
+// resolveTemplatePath returns the file of a template path relative to RootDir, with symlinks resolved
+func (m *DiskTemplateStorage) resolveTemplatePath(templatePath string) (string, error) {
+	if templatePath == "" {
+		return "", fmt.Errorf("template path is required for disk storage")
+	}
+	if m.RootDir == "" {
+		return "", fmt.Errorf("template root directory is not configured for disk storage")
+	}
+	if !filepath.IsLocal(templatePath) {
+		return "", fmt.Errorf("%w: %s is not relative to the template root", ErrInvalidTemplatePath, templatePath)
+	}
+
+	root, err := filepath.Abs(m.RootDir)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve template root directory: %v", err)
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, templatePath))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, templatePath)
+		}
+		return "", fmt.Errorf("unable to resolve template path: %v", err)
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %s leaves the template root", ErrInvalidTemplatePath, templatePath)
+	}
+
+	return resolved, nil
+}
+
+// templateFile returns the file GetTemplate reads for templatePath, absolute and with symlinks resolved when
+// it exists
+func (m *DiskTemplateStorage) templateFile(templatePath string) string {
+	file, err := filepath.Abs(templatePath)
+	if err != nil {
+		return templatePath
+	}
+	if resolved, err := filepath.EvalSymlinks(file); err == nil {
+		return resolved
+	}
+	return file
+}
+
+// rootTemplateFile returns the file UpdateTemplate and DeleteTemplate change for a path relative to RootDir,
+// templatePath itself when it does not resolve
+func (m *DiskTemplateStorage) rootTemplateFile(templatePath string) string {
+	file, err := m.resolveTemplatePath(templatePath)
+	if err != nil {
+		return templatePath
+	}
+	return file
+}
+
+// checkFileETag fails when the file does not exist or, with ifMatch set, has another ETag
+func checkFileETag(path, ifMatch string) error {
+	etag, err := fileETag(path)
+	if err != nil {
+		return err
+	}
+	if ifMatch != "" && ifMatch != etag {
+		return fmt.Errorf("%w: %s", ErrPreconditionFailed, path)
+	}
+	return nil
+}
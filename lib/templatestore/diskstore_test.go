@@ -0,0 +1,98 @@
+package templatestore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskTemplateStorageUpdate(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	templatePath := filepath.Join(root, "template.html")
+	require.NoError(t, os.WriteFile(templatePath, []byte("v1 {{.name}}"), 0640))
+
+	storage := &DiskTemplateStorage{RootDir: root}
+	content, err := storage.GetTemplateContent(ctx, &GetTemplateContentRequest{TemplatePath: "template.html"})
+	require.NoError(t, err)
+	assert.Equal(t, "v1 {{.name}}", content.TemplateContent)
+
+	etag, err := storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplatePath: "template.html", TemplateHTML: "v2 {{.name}}", IfMatch: content.ETag})
+	require.NoError(t, err)
+	assert.NotEqual(t, content.ETag, etag)
+
+	// A second editor still holding the first ETag
+	_, err = storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplatePath: "template.html", TemplateHTML: "v3 {{.name}}", IfMatch: content.ETag})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.ErrorIs(t, storage.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplatePath: "template.html", IfMatch: content.ETag}), ErrPreconditionFailed)
+
+	templateFile, err := storage.GetTemplate(ctx, &GetTemplateRequest{TemplatePath: templatePath})
+	require.NoError(t, err)
+	assert.Equal(t, "v2 test", execute(t, templateFile))
+	info, err := os.Stat(templatePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	require.NoError(t, storage.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplatePath: "template.html", IfMatch: etag}))
+	_, err = storage.GetTemplateContent(ctx, &GetTemplateContentRequest{TemplatePath: "template.html"})
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+	_, err = storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplatePath: "template.html", TemplateHTML: "v3"})
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+
+	// Deleting a template saved again at the path keeps both deleted copies
+	require.NoError(t, os.WriteFile(templatePath, []byte("v4"), 0640))
+	require.NoError(t, storage.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplatePath: "template.html"}))
+	deleted, err := filepath.Glob(templatePath + ".deleted.*")
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+	var contents []string
+	for _, path := range deleted {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		contents = append(contents, string(content))
+	}
+	assert.ElementsMatch(t, []string{"v2 {{.name}}", "v4"}, contents)
+}
+
+func TestDiskTemplateStorageRoot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	root := filepath.Join(dir, "templates")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "invoices"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "invoices", "invoice.html"), []byte("invoice"), 0644))
+	outside := filepath.Join(dir, "espressoconfig.yaml")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "config.html")))
+	require.NoError(t, os.Symlink(dir, filepath.Join(root, "parent")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "invoices", "invoice.html"), filepath.Join(root, "latest.html")))
+
+	storage := &DiskTemplateStorage{RootDir: root}
+
+	for _, templatePath := range []string{outside, "../espressoconfig.yaml", "invoices/../../espressoconfig.yaml", "config.html", "parent/espressoconfig.yaml"} {
+		t.Run(templatePath, func(t *testing.T) {
+			_, err := storage.GetTemplateContent(ctx, &GetTemplateContentRequest{TemplatePath: templatePath})
+			assert.ErrorIs(t, err, ErrInvalidTemplatePath)
+			_, err = storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplatePath: templatePath, TemplateHTML: "overwritten"})
+			assert.ErrorIs(t, err, ErrInvalidTemplatePath)
+			assert.ErrorIs(t, storage.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplatePath: templatePath}), ErrInvalidTemplatePath)
+		})
+	}
+	content, err := os.ReadFile(outside)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(content))
+
+	// Symlinks within the root are followed
+	_, err = storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplatePath: "latest.html", TemplateHTML: "invoice v2"})
+	require.NoError(t, err)
+	content, err = os.ReadFile(filepath.Join(root, "invoices", "invoice.html"))
+	require.NoError(t, err)
+	assert.Equal(t, "invoice v2", string(content))
+
+	// Without a root no request supplied path is used
+	_, err = (&DiskTemplateStorage{}).UpdateTemplate(ctx, &UpdateTemplateRequest{TemplatePath: "invoices/invoice.html", TemplateHTML: "overwritten"})
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package templatestore
 import "time"
 
 type GetTemplateRequest struct {
+	// TemplateUUID is the template id, or uuid@version to pin a version instead of the published one
 	TemplateUUID   string
 	TemplatePath   string
 	TemplateS3Path string
@@ -26,17 +27,70 @@ type TemplateInfo struct {
 	TemplateName string    `json:"template_name,omitempty"`
 	CreatedAt    time.Time `json:"created_at,omitempty"`
 	UpdatedAt    time.Time `json:"updated_at,omitempty"`
+	// PublishedVersion is the version used when the template is not pinned to one
+	PublishedVersion int `json:"published_version,omitempty"`
 }
 type GetTemplateContentRequest struct {
-	TemplateUUID string
+	// TemplateUUID is the template id, or uuid@version to get a version instead of the published one
+	TemplateUUID   string
+	TemplatePath   string
+	TemplateS3Path string
 }
 type GetTemplateContentResponse struct {
 	TemplateContent    string `json:"template_content"`
 	TemplateName       string `json:"template_name,omitempty"`
 	TemplateJsonSchema string `json:"template_json_schema,omitempty"`
+	Version            int    `json:"version,omitempty"`
+	// ETag changes with every change of the template, pass it as IfMatch to change the template
+	ETag string `json:"etag,omitempty"`
 }
 type CreateTemplateRequest struct {
 	TemplateName string
 	TemplateHTML string
 	TemplateJSON string
 }
+
+// TemplateVersionInfo contains metadata about a saved version of a template
+type TemplateVersionInfo struct {
+	Version     int       `json:"version"`
+	Published   bool      `json:"published"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+	PublishedAt time.Time `json:"published_at,omitempty"`
+}
+type CreateTemplateVersionRequest struct {
+	TemplateUUID string
+	TemplateHTML string
+	TemplateJSON string
+	// Publish makes the new version the one used when the template is not pinned to a version
+	Publish bool
+}
+type ListTemplateVersionsRequest struct {
+	TemplateUUID string
+}
+type PublishTemplateVersionRequest struct {
+	TemplateUUID string
+	Version      int
+}
+
+// UpdateTemplateRequest changes the template of TemplateUUID, TemplatePath or TemplateS3Path, depending on the
+// storage. Empty fields keep their value, name and JSON schema only exist in MySQL storage.
+type UpdateTemplateRequest struct {
+	TemplateUUID   string
+	TemplatePath   string
+	TemplateS3Path string
+	TemplateName   string
+	TemplateHTML   string
+	TemplateJSON   string
+	// IfMatch is the ETag the template was read with, the update fails with ErrPreconditionFailed when the
+	// template changed since. Empty updates unconditionally.
+	IfMatch string
+}
+
+// DeleteTemplateRequest soft deletes the template of TemplateUUID, TemplatePath or TemplateS3Path
+type DeleteTemplateRequest struct {
+	TemplateUUID   string
+	TemplatePath   string
+	TemplateS3Path string
+	// IfMatch is the ETag the template was read with, empty deletes unconditionally
+	IfMatch string
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
+	"strconv"
 	"text/template"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -101,22 +102,86 @@ func (m *MySQLTemplateStorage) initDatabase() error {
 		return fmt.Errorf("templates table is missing template_name column")
 	}
 
+	return m.migrateTemplates()
+}
+
+// migrateTemplates adds template versioning, revisions and soft deletes to databases created before them.
+// Templates without versions, e.g. inserted by the initialization script, get their content saved as their
+// first version.
+func (m *MySQLTemplateStorage) migrateTemplates() error {
+	columns := []struct{ name, definition string }{
+		{"published_version", "INT NOT NULL DEFAULT 1"},
+		{"revision", "INT NOT NULL DEFAULT 1"},
+		{"deleted_at", "TIMESTAMP NULL"},
+	}
+	for _, column := range columns {
+		var count int
+		err := m.DB.QueryRow(`
+			SELECT 
+				COUNT(*) FROM information_schema.columns 
+				WHERE table_schema = DATABASE() 
+				AND table_name = 'templates' 
+				AND column_name = ?`, column.name).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to check for %s column: %v", column.name, err)
+		}
+		if count == 0 {
+			if _, err := m.DB.Exec("ALTER TABLE templates ADD COLUMN " + column.name + " " + column.definition); err != nil {
+				return fmt.Errorf("failed to add %s column: %v", column.name, err)
+			}
+		}
+	}
+
+	_, err := m.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS template_versions (
+			template_id VARCHAR(255) NOT NULL,
+			version INT NOT NULL,
+			template_content TEXT NOT NULL,
+			json_schema TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			published_at TIMESTAMP NULL,
+			PRIMARY KEY (template_id, version)
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create template_versions table: %v", err)
+	}
+
+	_, err = m.DB.Exec(`
+		INSERT INTO template_versions (template_id, version, template_content, json_schema, created_at, published_at)
+		SELECT t.template_id, t.published_version, t.template_content, t.json_schema, t.updated_at, t.updated_at
+		FROM templates t
+		WHERE NOT EXISTS (SELECT 1 FROM template_versions v WHERE v.template_id = t.template_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to save existing templates as versions: %v", err)
+	}
+
 	return nil
 }
 
-// GetTemplate retrieves a template from MySQL.
+// GetTemplate retrieves a template from MySQL, its published version unless the UUID pins one.
 func (m *MySQLTemplateStorage) GetTemplate(ctx context.Context, req *GetTemplateRequest) (*template.Template, error) {
 	var templateContent string
-	var templateID string
 
 	if req.TemplateUUID == "" {
 		return nil, fmt.Errorf("template UUID is required for MySQL storage")
 	}
+	templateID, version, err := ParseTemplateRef(req.TemplateUUID)
+	if err != nil {
+		return nil, err
+	}
 
-	err := m.DB.QueryRowContext(ctx, "SELECT template_content FROM templates WHERE template_id = ?", req.TemplateUUID).Scan(&templateContent)
+	if version == 0 {
+		err = m.DB.QueryRowContext(ctx, "SELECT template_content FROM templates WHERE template_id = ? AND deleted_at IS NULL", templateID).Scan(&templateContent)
+	} else {
+		err = m.DB.QueryRowContext(ctx,
+			`SELECT v.template_content
+			FROM template_versions v JOIN templates t ON t.template_id = v.template_id
+			WHERE v.template_id = ? AND v.version = ? AND t.deleted_at IS NULL`,
+			templateID, version).Scan(&templateContent)
+	}
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("template not found: %s", req.TemplateUUID)
+			return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, req.TemplateUUID)
 		}
 		return nil, fmt.Errorf("error retrieving template: %v", err)
 	}
@@ -124,6 +189,32 @@ func (m *MySQLTemplateStorage) GetTemplate(ctx context.Context, req *GetTemplate
 	return template.New("template" + templateID).Parse(templateContent)
 }
 
+// TemplateVersion returns the revision of a template, its ETag, without reading its content. Pinned
+// versions never change, only whether their template was deleted is checked.
+func (m *MySQLTemplateStorage) TemplateVersion(ctx context.Context, req *GetTemplateRequest) (string, error) {
+	if req.TemplateUUID == "" {
+		return "", fmt.Errorf("template UUID is required for MySQL storage")
+	}
+	templateID, version, err := ParseTemplateRef(req.TemplateUUID)
+	if err != nil {
+		return "", err
+	}
+
+	var revision int
+	err = m.DB.QueryRowContext(ctx, "SELECT revision FROM templates WHERE template_id = ? AND deleted_at IS NULL", templateID).Scan(&revision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+		}
+		return "", fmt.Errorf("error retrieving template version: %v", err)
+	}
+
+	if version != 0 {
+		return "v" + strconv.Itoa(version), nil
+	}
+	return strconv.Itoa(revision), nil
+}
+
 // PutDocument stores a document in MySQL.
 func (m *MySQLTemplateStorage) PutDocument(ctx context.Context, req *PostDocumentRequest, reader *io.Reader) (string, error) {
 	return "", fmt.Errorf("put document not implemented for mysql, use other adapters for filestorage")
@@ -137,7 +228,7 @@ func (m *MySQLTemplateStorage) GetDocument(ctx context.Context, req *GetDocument
 // ListTemplates retrieves all templates from MySQL storage.
 func (m *MySQLTemplateStorage) ListTemplates(ctx context.Context) ([]*TemplateInfo, error) {
 	// Query all templates
-	rows, err := m.DB.QueryContext(ctx, "SELECT template_id, template_name, created_at, updated_at FROM templates")
+	rows, err := m.DB.QueryContext(ctx, "SELECT template_id, template_name, created_at, updated_at, published_version FROM templates WHERE deleted_at IS NULL")
 	if err != nil {
 		return nil, fmt.Errorf("error querying templates: %v", err)
 	}
@@ -148,7 +239,7 @@ func (m *MySQLTemplateStorage) ListTemplates(ctx context.Context) ([]*TemplateIn
 		var template TemplateInfo
 		var createdAt, updatedAt sql.NullTime
 
-		if err := rows.Scan(&template.TemplateID, &template.TemplateName, &createdAt, &updatedAt); err != nil {
+		if err := rows.Scan(&template.TemplateID, &template.TemplateName, &createdAt, &updatedAt, &template.PublishedVersion); err != nil {
 			return nil, fmt.Errorf("error scanning template row: %v", err)
 		}
 
@@ -170,19 +261,31 @@ func (m *MySQLTemplateStorage) ListTemplates(ctx context.Context) ([]*TemplateIn
 	return templates, nil
 }
 
-// Helper function to get template from MySQL
+// GetTemplateContent returns the published version of a template, or the version the UUID pins.
 func (m *MySQLTemplateStorage) GetTemplateContent(ctx context.Context, req *GetTemplateContentRequest) (*GetTemplateContentResponse, error) {
 	// Query template info from database
 	var templateContent, templateName, jsonSchema string
-	templateId := req.TemplateUUID
-	// First get the template content
-	err := m.DB.QueryRowContext(ctx,
-		"SELECT template_content, template_name,json_schema FROM templates WHERE template_id = ?",
-		templateId).Scan(&templateContent, &templateName, &jsonSchema)
+	var version, revision int
+	templateId, pinnedVersion, err := ParseTemplateRef(req.TemplateUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	if pinnedVersion == 0 {
+		err = m.DB.QueryRowContext(ctx,
+			"SELECT template_content, template_name,json_schema, published_version, revision FROM templates WHERE template_id = ? AND deleted_at IS NULL",
+			templateId).Scan(&templateContent, &templateName, &jsonSchema, &version, &revision)
+	} else {
+		err = m.DB.QueryRowContext(ctx,
+			`SELECT v.template_content, t.template_name, v.json_schema, v.version, t.revision
+			FROM template_versions v JOIN templates t ON t.template_id = v.template_id
+			WHERE v.template_id = ? AND v.version = ? AND t.deleted_at IS NULL`,
+			templateId, pinnedVersion).Scan(&templateContent, &templateName, &jsonSchema, &version, &revision)
+	}
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("template not found: %s", templateId)
+			return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, req.TemplateUUID)
 		}
 		return nil, fmt.Errorf("error retrieving template: %v", err)
 	}
@@ -191,6 +294,8 @@ func (m *MySQLTemplateStorage) GetTemplateContent(ctx context.Context, req *GetT
 		TemplateContent:    templateContent,
 		TemplateName:       templateName,
 		TemplateJsonSchema: jsonSchema,
+		Version:            version,
+		ETag:               strconv.Itoa(revision),
 	}
 
 	return resp, nil
@@ -212,18 +317,301 @@ func (m *MySQLTemplateStorage) CreateTemplate(ctx context.Context, req *CreateTe
 		return "", fmt.Errorf("server error, failed at id generation")
 	}
 
-	// Insert the template
-	_, err = m.DB.ExecContext(ctx,
-		"INSERT INTO templates (template_id, template_name, template_content, json_schema) VALUES (?, ?, ?, ?)",
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Insert the template and its content as its first, published version
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO templates (template_id, template_name, template_content, json_schema, published_version) VALUES (?, ?, ?, ?, 1)",
 		templateID, req.TemplateName, req.TemplateHTML, req.TemplateJSON)
 
 	if err != nil {
 		return "", fmt.Errorf("error inserting template into database: %v", err)
 	}
 
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO template_versions (template_id, version, template_content, json_schema, published_at) VALUES (?, 1, ?, ?, CURRENT_TIMESTAMP)",
+		templateID, req.TemplateHTML, req.TemplateJSON)
+	if err != nil {
+		return "", fmt.Errorf("error inserting template version into database: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing template: %v", err)
+	}
+
 	return templateID, nil
 }
 
+// CreateTemplateVersion saves the content as the next version of a template, publishing it when requested.
+func (m *MySQLTemplateStorage) CreateTemplateVersion(ctx context.Context, req *CreateTemplateVersionRequest) (int, error) {
+	if req.TemplateUUID == "" {
+		return 0, fmt.Errorf("template UUID is required for MySQL storage")
+	}
+	templateID, pinnedVersion, err := ParseTemplateRef(req.TemplateUUID)
+	if err != nil {
+		return 0, err
+	}
+	if pinnedVersion != 0 {
+		return 0, fmt.Errorf("versions are numbered when they are created, got %s", req.TemplateUUID)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// The template row lock numbers concurrent saves one after the other
+	if _, err := lockTemplate(ctx, tx, templateID); err != nil {
+		return 0, err
+	}
+
+	var latestVersion int
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM template_versions WHERE template_id = ?", templateID).Scan(&latestVersion)
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving template versions: %v", err)
+	}
+	version := latestVersion + 1
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO template_versions (template_id, version, template_content, json_schema) VALUES (?, ?, ?, ?)",
+		templateID, version, req.TemplateHTML, req.TemplateJSON)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting template version into database: %v", err)
+	}
+
+	if req.Publish {
+		if err := publishTemplateVersion(ctx, tx, templateID, version); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing template version: %v", err)
+	}
+
+	return version, nil
+}
+
+// ListTemplateVersions lists the versions of a template, newest first.
+func (m *MySQLTemplateStorage) ListTemplateVersions(ctx context.Context, req *ListTemplateVersionsRequest) ([]*TemplateVersionInfo, error) {
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT v.version, v.created_at, v.published_at, t.published_version
+		FROM template_versions v JOIN templates t ON t.template_id = v.template_id
+		WHERE v.template_id = ? AND t.deleted_at IS NULL ORDER BY v.version DESC`,
+		req.TemplateUUID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying template versions: %v", err)
+	}
+	defer rows.Close()
+
+	var versions []*TemplateVersionInfo
+	for rows.Next() {
+		var version TemplateVersionInfo
+		var createdAt, publishedAt sql.NullTime
+		var publishedVersion int
+
+		if err := rows.Scan(&version.Version, &createdAt, &publishedAt, &publishedVersion); err != nil {
+			return nil, fmt.Errorf("error scanning template version row: %v", err)
+		}
+
+		version.Published = version.Version == publishedVersion
+		if createdAt.Valid {
+			version.CreatedAt = createdAt.Time
+		}
+		if publishedAt.Valid {
+			version.PublishedAt = publishedAt.Time
+		}
+
+		versions = append(versions, &version)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating template version rows: %v", err)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, req.TemplateUUID)
+	}
+
+	return versions, nil
+}
+
+// PublishTemplateVersion makes a saved version the published one, also to roll back to an older version.
+func (m *MySQLTemplateStorage) PublishTemplateVersion(ctx context.Context, req *PublishTemplateVersionRequest) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := lockTemplate(ctx, tx, req.TemplateUUID); err != nil {
+		return err
+	}
+	if err := publishTemplateVersion(ctx, tx, req.TemplateUUID, req.Version); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing published version: %v", err)
+	}
+
+	return nil
+}
+
+// lockTemplate locks the row of a template until the end of tx and returns its revision
+func lockTemplate(ctx context.Context, tx *sql.Tx, templateID string) (int, error) {
+	var revision int
+	err := tx.QueryRowContext(ctx, "SELECT revision FROM templates WHERE template_id = ? AND deleted_at IS NULL FOR UPDATE", templateID).Scan(&revision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+		}
+		return 0, fmt.Errorf("error retrieving template: %v", err)
+	}
+	return revision, nil
+}
+
+// publishTemplateVersion points a template at one of its versions and counts a revision. The content is copied
+// to the template row, so the published version is read without a join.
+func publishTemplateVersion(ctx context.Context, tx *sql.Tx, templateID string, version int) error {
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM template_versions WHERE template_id = ? AND version = ?", templateID, version).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error retrieving template version: %v", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, TemplateRef(templateID, version))
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE templates t JOIN template_versions v ON v.template_id = t.template_id AND v.version = ?
+		SET t.template_content = v.template_content, t.json_schema = v.json_schema, t.published_version = v.version,
+			t.revision = t.revision + 1, v.published_at = CURRENT_TIMESTAMP
+		WHERE t.template_id = ?`,
+		version, templateID)
+	if err != nil {
+		return fmt.Errorf("error publishing template version: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateTemplate saves changed content as a new version and publishes it, older versions stay pinnable. The
+// ETag is the revision of the template, counted up by every change.
+func (m *MySQLTemplateStorage) UpdateTemplate(ctx context.Context, req *UpdateTemplateRequest) (string, error) {
+	if req.TemplateUUID == "" {
+		return "", fmt.Errorf("template UUID is required for MySQL storage")
+	}
+	templateID, pinnedVersion, err := ParseTemplateRef(req.TemplateUUID)
+	if err != nil {
+		return "", err
+	}
+	if pinnedVersion != 0 {
+		return "", fmt.Errorf("versions cannot be changed, got %s", req.TemplateUUID)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	revision, err := lockTemplate(ctx, tx, templateID)
+	if err != nil {
+		return "", err
+	}
+	if req.IfMatch != "" && req.IfMatch != strconv.Itoa(revision) {
+		return "", fmt.Errorf("%w: %s is at revision %d", ErrPreconditionFailed, templateID, revision)
+	}
+
+	var templateName, templateContent, jsonSchema string
+	err = tx.QueryRowContext(ctx, "SELECT template_name, template_content, json_schema FROM templates WHERE template_id = ?", templateID).Scan(&templateName, &templateContent, &jsonSchema)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving template: %v", err)
+	}
+
+	contentChanged := (req.TemplateHTML != "" && req.TemplateHTML != templateContent) || (req.TemplateJSON != "" && req.TemplateJSON != jsonSchema)
+	if contentChanged {
+		if req.TemplateHTML != "" {
+			templateContent = req.TemplateHTML
+		}
+		if req.TemplateJSON != "" {
+			jsonSchema = req.TemplateJSON
+		}
+
+		var latestVersion int
+		err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM template_versions WHERE template_id = ?", templateID).Scan(&latestVersion)
+		if err != nil {
+			return "", fmt.Errorf("error retrieving template versions: %v", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO template_versions (template_id, version, template_content, json_schema) VALUES (?, ?, ?, ?)",
+			templateID, latestVersion+1, templateContent, jsonSchema)
+		if err != nil {
+			return "", fmt.Errorf("error inserting template version into database: %v", err)
+		}
+		if err := publishTemplateVersion(ctx, tx, templateID, latestVersion+1); err != nil {
+			return "", err
+		}
+		revision++
+	}
+
+	if req.TemplateName != "" && req.TemplateName != templateName {
+		// Publishing counted the revision already
+		increment := 1
+		if contentChanged {
+			increment = 0
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE templates SET template_name = ?, revision = revision + ? WHERE template_id = ?", req.TemplateName, increment, templateID)
+		if err != nil {
+			return "", fmt.Errorf("error updating template name: %v", err)
+		}
+		revision += increment
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing template: %v", err)
+	}
+
+	return strconv.Itoa(revision), nil
+}
+
+// DeleteTemplate marks a template deleted, its row and versions are kept.
+func (m *MySQLTemplateStorage) DeleteTemplate(ctx context.Context, req *DeleteTemplateRequest) error {
+	if req.TemplateUUID == "" {
+		return fmt.Errorf("template UUID is required for MySQL storage")
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	revision, err := lockTemplate(ctx, tx, req.TemplateUUID)
+	if err != nil {
+		return err
+	}
+	if req.IfMatch != "" && req.IfMatch != strconv.Itoa(revision) {
+		return fmt.Errorf("%w: %s is at revision %d", ErrPreconditionFailed, req.TemplateUUID, revision)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE templates SET deleted_at = CURRENT_TIMESTAMP, revision = revision + 1 WHERE template_id = ?", req.TemplateUUID)
+	if err != nil {
+		return fmt.Errorf("error deleting template: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing template deletion: %v", err)
+	}
+
+	return nil
+}
+
 // Close closes the database connection.
 func (m *MySQLTemplateStorage) Close() error {
 	if m.DB != nil {
@@ -0,0 +1,65 @@
+package templatestore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMySQLTemplateStorageUpdate needs a MySQL database, e.g. the one of the service's docker compose:
+// ESPRESSO_TEST_MYSQL_DSN="pdf_user:pdf_password@tcp(localhost:3306)/pdf_templates?parseTime=true"
+func TestMySQLTemplateStorageUpdate(t *testing.T) {
+	dsn := os.Getenv("ESPRESSO_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("ESPRESSO_TEST_MYSQL_DSN is not set")
+	}
+	ctx := context.Background()
+
+	storage, err := NewMySQLStorageAdapter(dsn)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	templateID, err := storage.CreateTemplate(ctx, &CreateTemplateRequest{TemplateName: "update test", TemplateHTML: "v1 {{.name}}", TemplateJSON: "{}"})
+	require.NoError(t, err)
+	defer storage.DB.Exec("DELETE FROM template_versions WHERE template_id = ?", templateID)
+	defer storage.DB.Exec("DELETE FROM templates WHERE template_id = ?", templateID)
+
+	content, err := storage.GetTemplateContent(ctx, &GetTemplateContentRequest{TemplateUUID: templateID})
+	require.NoError(t, err)
+	assert.Equal(t, "1", content.ETag)
+
+	etag, err := storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplateUUID: templateID, TemplateHTML: "v2 {{.name}}", IfMatch: content.ETag})
+	require.NoError(t, err)
+	assert.Equal(t, "2", etag)
+
+	// A second editor still holding the first ETag
+	_, err = storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplateUUID: templateID, TemplateName: "renamed", IfMatch: content.ETag})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.ErrorIs(t, storage.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplateUUID: templateID, IfMatch: content.ETag}), ErrPreconditionFailed)
+
+	// The update is a new published version, the first one stays pinnable
+	templateFile, err := storage.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: templateID})
+	require.NoError(t, err)
+	assert.Equal(t, "v2 test", execute(t, templateFile))
+	templateFile, err = storage.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: TemplateRef(templateID, 1)})
+	require.NoError(t, err)
+	assert.Equal(t, "v1 test", execute(t, templateFile))
+
+	require.NoError(t, storage.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplateUUID: templateID, IfMatch: etag}))
+	_, err = storage.GetTemplateContent(ctx, &GetTemplateContentRequest{TemplateUUID: templateID})
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+	_, err = storage.GetTemplate(ctx, &GetTemplateRequest{TemplateUUID: TemplateRef(templateID, 1)})
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+	_, err = storage.ListTemplateVersions(ctx, &ListTemplateVersionsRequest{TemplateUUID: templateID})
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+	templates, err := storage.ListTemplates(ctx)
+	require.NoError(t, err)
+	for _, templateInfo := range templates {
+		assert.NotEqual(t, templateID, templateInfo.TemplateID)
+	}
+	_, err = storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplateUUID: templateID, TemplateHTML: "v3"})
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+}
@@ -2,10 +2,15 @@ package templatestore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"path"
+	"strings"
 	"text/template"
+	"time"
 
+	"github.com/aws/smithy-go"
 	"github.com/rchougule/espresso/lib/s3"
 )
 
@@ -36,6 +41,18 @@ func (s *S3TemplateStorage) GetTemplate(ctx context.Context, req *GetTemplateReq
 	return template.New("template").Parse(string(templateData))
 }
 
+// TemplateVersion returns the ETag of the template object
+func (s *S3TemplateStorage) TemplateVersion(ctx context.Context, req *GetTemplateRequest) (string, error) {
+	if req.TemplateS3Path == "" {
+		return "", fmt.Errorf("template path is required for S3 storage")
+	}
+	etag, err := s.client.GetFileETag(ctx, req.TemplateS3Path)
+	if err != nil {
+		return "", s3TemplateError(err, req.TemplateS3Path)
+	}
+	return strings.Trim(etag, `"`), nil
+}
+
 func (s *S3TemplateStorage) PutDocument(ctx context.Context, req *PostDocumentRequest, reader *io.Reader) (string, error) {
 	if req.FileS3Path == "" {
 		return "", fmt.Errorf("file S3 path is required for S3 storage")
@@ -59,9 +76,107 @@ func (s *S3TemplateStorage) GetDocument(ctx context.Context, req *GetDocumentReq
 func (s *S3TemplateStorage) ListTemplates(ctx context.Context) ([]*TemplateInfo, error) {
 	return nil, fmt.Errorf("list templates not implemented for S3 storage")
 }
+
+// GetTemplateContent reads the template object with its ETag
 func (m *S3TemplateStorage) GetTemplateContent(ctx context.Context, req *GetTemplateContentRequest) (*GetTemplateContentResponse, error) {
-	return nil, fmt.Errorf("get template content not implemented for S3 storage")
+	if req.TemplateS3Path == "" {
+		return nil, fmt.Errorf("template path is required for S3 storage")
+	}
+	body, etag, err := m.client.GetFile(ctx, req.TemplateS3Path)
+	if err != nil {
+		return nil, s3TemplateError(err, req.TemplateS3Path)
+	}
+	defer body.Close()
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetTemplateContentResponse{
+		TemplateContent: string(content),
+		TemplateName:    path.Base(req.TemplateS3Path),
+		ETag:            strings.Trim(etag, `"`),
+	}, nil
 }
 func (m *S3TemplateStorage) CreateTemplate(ctx context.Context, req *CreateTemplateRequest) (string, error) {
 	return "", fmt.Errorf("create template not implemented for S3 storage")
 }
+func (m *S3TemplateStorage) CreateTemplateVersion(ctx context.Context, req *CreateTemplateVersionRequest) (int, error) {
+	return 0, fmt.Errorf("template versions not implemented for S3 storage")
+}
+func (m *S3TemplateStorage) ListTemplateVersions(ctx context.Context, req *ListTemplateVersionsRequest) ([]*TemplateVersionInfo, error) {
+	return nil, fmt.Errorf("template versions not implemented for S3 storage")
+}
+func (m *S3TemplateStorage) PublishTemplateVersion(ctx context.Context, req *PublishTemplateVersionRequest) error {
+	return fmt.Errorf("template versions not implemented for S3 storage")
+}
+
+// UpdateTemplate replaces the template object, with IfMatch set as a conditional write
+func (m *S3TemplateStorage) UpdateTemplate(ctx context.Context, req *UpdateTemplateRequest) (string, error) {
+	if req.TemplateS3Path == "" {
+		return "", fmt.Errorf("template path is required for S3 storage")
+	}
+	if req.TemplateHTML == "" {
+		return "", fmt.Errorf("template HTML is required for S3 storage")
+	}
+
+	// Updates create no templates
+	if req.IfMatch == "" {
+		if _, err := m.TemplateVersion(ctx, &GetTemplateRequest{TemplateS3Path: req.TemplateS3Path}); err != nil {
+			return "", err
+		}
+	}
+
+	etag, err := m.client.PutFile(ctx, req.TemplateS3Path, []byte(req.TemplateHTML), quoteETag(req.IfMatch))
+	if err != nil {
+		return "", s3TemplateError(err, req.TemplateS3Path)
+	}
+
+	return strings.Trim(etag, `"`), nil
+}
+
+// DeleteTemplate moves the template object to <path>.deleted.<time>, every deleted copy is kept. S3 has no conditional delete for general
+// purpose buckets, the ETag is checked when copying, so a change right between copy and delete is lost.
+func (m *S3TemplateStorage) DeleteTemplate(ctx context.Context, req *DeleteTemplateRequest) error {
+	if req.TemplateS3Path == "" {
+		return fmt.Errorf("template path is required for S3 storage")
+	}
+
+	if req.IfMatch == "" {
+		if _, err := m.TemplateVersion(ctx, &GetTemplateRequest{TemplateS3Path: req.TemplateS3Path}); err != nil {
+			return err
+		}
+	}
+
+	if err := m.client.CopyFile(ctx, req.TemplateS3Path, deletedTemplateName(req.TemplateS3Path, time.Now()), quoteETag(req.IfMatch)); err != nil {
+		return s3TemplateError(err, req.TemplateS3Path)
+	}
+	if err := m.client.DeleteFile(ctx, req.TemplateS3Path); err != nil {
+		return s3TemplateError(err, req.TemplateS3Path)
+	}
+
+	return nil
+}
+
+// quoteETag returns an ETag as S3 expects it in conditions
+func quoteETag(etag string) string {
+	if etag == "" {
+		return ""
+	}
+	return `"` + strings.Trim(etag, `"`) + `"`
+}
+
+// s3TemplateError maps the S3 errors of missing objects and failed conditions of a template
+func s3TemplateError(err error, key string) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			return fmt.Errorf("%w: %s", ErrTemplateNotFound, key)
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return fmt.Errorf("%w: %s", ErrPreconditionFailed, key)
+		}
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package templatestore
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rchougule/espresso/lib/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is a path style S3 endpoint keeping the objects of one bucket in memory, with the conditions used by
+// S3TemplateStorage
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		content, ok := f.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", objectETag(content))
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "bucket/"))
+		content, ok := f.objects[source]
+		if err != nil || !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if ifMatch := r.Header.Get("X-Amz-Copy-Source-If-Match"); ifMatch != "" && ifMatch != objectETag(content) {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		f.objects[key] = content
+		fmt.Fprintf(w, "<CopyObjectResult><ETag>%s</ETag></CopyObjectResult>", objectETag(content))
+	case r.Method == http.MethodPut:
+		current, ok := f.objects[key]
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (!ok || ifMatch != objectETag(current)) {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		f.objects[key] = content
+		w.Header().Set("ETag", objectETag(content))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func objectETag(content []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(content))
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func TestS3TemplateStorageUpdate(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{objects: map[string][]byte{"templates/invoice.html": []byte("v1 {{.name}}")}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// The endpoint is only used locally
+	t.Setenv("GO_ENV", "local")
+	storage, err := NewS3StorageAdapter(ctx, s3.WithEndpoint(server.URL), s3.WithRegion("us-west-2"), s3.WithBucket("bucket"),
+		s3.WithForcePathStyle(true), s3.WithCredentials("key", "secret", ""), s3.WithRetryMaxAttempts(1))
+	require.NoError(t, err)
+
+	content, err := storage.GetTemplateContent(ctx, &GetTemplateContentRequest{TemplateS3Path: "templates/invoice.html"})
+	require.NoError(t, err)
+	assert.Equal(t, "v1 {{.name}}", content.TemplateContent)
+	assert.Equal(t, strings.Trim(objectETag([]byte("v1 {{.name}}")), `"`), content.ETag)
+
+	etag, err := storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplateS3Path: "templates/invoice.html", TemplateHTML: "v2 {{.name}}", IfMatch: content.ETag})
+	require.NoError(t, err)
+	assert.NotEqual(t, content.ETag, etag)
+
+	// A second editor still holding the first ETag
+	_, err = storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplateS3Path: "templates/invoice.html", TemplateHTML: "v3 {{.name}}", IfMatch: content.ETag})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	err = storage.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplateS3Path: "templates/invoice.html", IfMatch: content.ETag})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+	templateFile, err := storage.GetTemplate(ctx, &GetTemplateRequest{TemplateS3Path: "templates/invoice.html"})
+	require.NoError(t, err)
+	assert.Equal(t, "v2 test", execute(t, templateFile))
+
+	require.NoError(t, storage.DeleteTemplate(ctx, &DeleteTemplateRequest{TemplateS3Path: "templates/invoice.html", IfMatch: etag}))
+	_, err = storage.GetTemplateContent(ctx, &GetTemplateContentRequest{TemplateS3Path: "templates/invoice.html"})
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+	_, err = storage.TemplateVersion(ctx, &GetTemplateRequest{TemplateS3Path: "templates/invoice.html"})
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+	// Updates create no templates
+	_, err = storage.UpdateTemplate(ctx, &UpdateTemplateRequest{TemplateS3Path: "templates/invoice.html", TemplateHTML: "v3"})
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	var deleted []string
+	for key, content := range fake.objects {
+		if strings.HasPrefix(key, "templates/invoice.html.deleted.") {
+			deleted = append(deleted, string(content))
+		}
+	}
+	assert.Equal(t, []string{"v2 {{.name}}"}, deleted)
+}
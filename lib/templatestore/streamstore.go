@@ -49,3 +49,23 @@ func (m *StreamStorage) GetTemplateContent(ctx context.Context, req *GetTemplate
 func (m *StreamStorage) CreateTemplate(ctx context.Context, req *CreateTemplateRequest) (string, error) {
 	return "", fmt.Errorf("create template not implemented for stream storage")
 }
+
+func (m *StreamStorage) CreateTemplateVersion(ctx context.Context, req *CreateTemplateVersionRequest) (int, error) {
+	return 0, fmt.Errorf("template versions not implemented for stream storage")
+}
+
+func (m *StreamStorage) ListTemplateVersions(ctx context.Context, req *ListTemplateVersionsRequest) ([]*TemplateVersionInfo, error) {
+	return nil, fmt.Errorf("template versions not implemented for stream storage")
+}
+
+func (m *StreamStorage) PublishTemplateVersion(ctx context.Context, req *PublishTemplateVersionRequest) error {
+	return fmt.Errorf("template versions not implemented for stream storage")
+}
+
+func (m *StreamStorage) UpdateTemplate(ctx context.Context, req *UpdateTemplateRequest) (string, error) {
+	return "", fmt.Errorf("update template not implemented for stream storage")
+}
+
+func (m *StreamStorage) DeleteTemplate(ctx context.Context, req *DeleteTemplateRequest) error {
+	return fmt.Errorf("delete template not implemented for stream storage")
+}
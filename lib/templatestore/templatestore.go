@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"text/template"
+	"time"
 
 	"github.com/rchougule/espresso/lib/s3"
 )
@@ -16,6 +17,15 @@ const (
 	StorageAdapterTypeMySQL  = "mysql"
 )
 
+var (
+	// ErrTemplateNotFound is returned for templates, or template versions, that do not exist
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrPreconditionFailed is returned when a template no longer has the ETag a change was based on
+	ErrPreconditionFailed = errors.New("template was changed since it was read")
+	// ErrInvalidTemplatePath is returned for disk template paths outside the template root
+	ErrInvalidTemplatePath = errors.New("invalid template path")
+)
+
 type StorageAdapter interface {
 	// GetTemplate retrieves a template from storage.
 	GetTemplate(ctx context.Context, req *GetTemplateRequest) (*template.Template, error)
@@ -30,13 +40,28 @@ type StorageAdapter interface {
 	GetTemplateContent(ctx context.Context, req *GetTemplateContentRequest) (*GetTemplateContentResponse, error)
 
 	CreateTemplate(ctx context.Context, req *CreateTemplateRequest) (string, error)
+
+	// CreateTemplateVersion saves a new immutable version of a template and returns its number.
+	CreateTemplateVersion(ctx context.Context, req *CreateTemplateVersionRequest) (int, error)
+
+	// ListTemplateVersions lists the versions of a template, newest first.
+	ListTemplateVersions(ctx context.Context, req *ListTemplateVersionsRequest) ([]*TemplateVersionInfo, error)
+
+	// PublishTemplateVersion makes a version the one used when a template is not pinned to a version.
+	PublishTemplateVersion(ctx context.Context, req *PublishTemplateVersionRequest) error
+
+	// UpdateTemplate changes a template in place and returns its new ETag.
+	UpdateTemplate(ctx context.Context, req *UpdateTemplateRequest) (string, error)
+
+	// DeleteTemplate soft deletes a template, its content is kept aside but it can no longer be used.
+	DeleteTemplate(ctx context.Context, req *DeleteTemplateRequest) error
 }
 
 // TemplateStorageAdapterFactory is a factory function for creating template storage adapters.
 func TemplateStorageAdapterFactory(conf *StorageConfig) (StorageAdapter, error) {
 	switch conf.StorageType {
 	case StorageAdapterTypeDisk:
-		return &DiskTemplateStorage{}, nil
+		return &DiskTemplateStorage{RootDir: conf.DiskRootDir}, nil
 	case StorageAdapterTypeS3:
 		if conf == nil {
 			return nil, errors.New("templateStorageConfig is required")
@@ -80,3 +105,8 @@ func TemplateStorageAdapterFactory(conf *StorageConfig) (StorageAdapter, error)
 		return nil, errors.New("unsupported storage type")
 	}
 }
+
+// deletedTemplateName is the name a template is kept under once deleted, <name>.deleted.<UTC time>
+func deletedTemplateName(name string, deletedAt time.Time) string {
+	return name + ".deleted." + deletedAt.UTC().Format("20060102T150405.000000000Z")
+}
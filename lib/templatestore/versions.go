@@ -0,0 +1,203 @@
+package templatestore
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseTemplateRef splits a template reference of the form uuid@version. The
+// version is zero when the reference has none, i.e. the published version.
+func ParseTemplateRef(ref string) (string, int, error) {
+	templateID, versionPart, pinned := strings.Cut(ref, "@")
+	if !pinned {
+		return ref, 0, nil
+	}
+
+	version, err := strconv.Atoi(versionPart)
+	if err != nil || version < 1 {
+		return "", 0, fmt.Errorf("invalid template version %q in %s", versionPart, ref)
+	}
+	if templateID == "" {
+		return "", 0, fmt.Errorf("template id is missing in %s", ref)
+	}
+
+	return templateID, version, nil
+}
+
+// TemplateRef returns the reference pinning version of a template, or the
+// template id for the published version when version is zero
+func TemplateRef(templateID string, version int) string {
+	if version == 0 {
+		return templateID
+	}
+	return templateID + "@" + strconv.Itoa(version)
+}
+
+const (
+	// diffContext is the number of unchanged lines around the changes of a hunk
+	diffContext = 3
+	// maxDiffEdits bounds the number of changed lines of a diff, the memory of
+	// diffLines grows with its square
+	maxDiffEdits = 1000
+)
+
+// ErrDiffTooLarge is returned by UnifiedDiff when the versions differ in more
+// than maxDiffEdits lines
+var ErrDiffTooLarge = errors.New("too many changes to diff")
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns the line diff of two versions of a template in the
+// unified format, empty when they are equal
+func UnifiedDiff(fromName, toName, from, to string) (string, error) {
+	ops, err := diffLines(splitLines(from), splitLines(to))
+	if err != nil {
+		return "", err
+	}
+
+	// Every change with its context, overlapping or adjacent ranges form one hunk
+	var hunks [][2]int
+	for i, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		hunkStart, hunkEnd := max(i-diffContext, 0), min(i+diffContext+1, len(ops))
+		if len(hunks) > 0 && hunkStart <= hunks[len(hunks)-1][1] {
+			hunks[len(hunks)-1][1] = hunkEnd
+			continue
+		}
+		hunks = append(hunks, [2]int{hunkStart, hunkEnd})
+	}
+	if len(hunks) == 0 {
+		return "", nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for _, hunk := range hunks {
+		writeHunk(&sb, ops, hunk[0], hunk[1])
+	}
+
+	return sb.String(), nil
+}
+
+// writeHunk writes the header and lines of ops[start:end]
+func writeHunk(sb *strings.Builder, ops []diffOp, start, end int) {
+	fromLine, toLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			fromLine++
+		}
+		if op.kind != '-' {
+			toLine++
+		}
+	}
+
+	fromCount, toCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			fromCount++
+		}
+		if op.kind != '-' {
+			toCount++
+		}
+	}
+	// An empty range starts at the line before it
+	if fromCount == 0 {
+		fromLine--
+	}
+	if toCount == 0 {
+		toLine--
+	}
+
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+	for _, op := range ops[start:end] {
+		sb.WriteByte(op.kind)
+		sb.WriteString(op.line)
+		sb.WriteByte('\n')
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns the shortest edit script from a to b, Myers' algorithm.
+// Only the explored diagonals of each step are kept for the backtrack, so
+// memory grows with the square of the number of changes, and the search stops
+// with ErrDiffTooLarge after maxDiffEdits of them.
+func diffLines(a, b []string) ([]diffOp, error) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	steps := -1
+	for d := 0; d <= n+m && steps < 0; d++ {
+		if d > maxDiffEdits {
+			return nil, ErrDiffTooLarge
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				steps = d
+				break
+			}
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := steps; d >= 0; d-- {
+		var prevX, prevY int
+		if d > 0 {
+			snapshot := trace[d]
+			k := x - y
+			prevK := k - 1
+			if k == -d || (k != d && snapshot[d+k-1] < snapshot[d+k+1]) {
+				prevK = k + 1
+			}
+			prevX = snapshot[d+prevK]
+			prevY = prevX - prevK
+		}
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{kind: ' ', line: a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{kind: '+', line: b[prevY]})
+			} else {
+				ops = append(ops, diffOp{kind: '-', line: a[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, nil
+}
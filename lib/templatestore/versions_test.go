@@ -0,0 +1,98 @@
+package templatestore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTemplateRef(t *testing.T) {
+	tests := []struct {
+		ref         string
+		wantID      string
+		wantVersion int
+		wantErr     bool
+	}{
+		{ref: "template-1-uuid", wantID: "template-1-uuid"},
+		{ref: "template-1-uuid@3", wantID: "template-1-uuid", wantVersion: 3},
+		{ref: "template-1-uuid@0", wantErr: true},
+		{ref: "template-1-uuid@latest", wantErr: true},
+		{ref: "@2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			templateID, version, err := ParseTemplateRef(tt.ref)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantID, templateID)
+			assert.Equal(t, tt.wantVersion, version)
+			assert.Equal(t, tt.ref, TemplateRef(templateID, version))
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{
+			name: "equal",
+			from: "<p>{{.name}}</p>\n",
+			to:   "<p>{{.name}}</p>\n",
+			want: "",
+		},
+		{
+			name: "changed",
+			from: "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n",
+			to:   "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n",
+			want: "--- t@1\n+++ t@2\n" +
+				"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+				"@@ -11,3 +11,4 @@\n k\n l\n m\n+n\n",
+		},
+		{
+			name: "close_changes_merged",
+			from: "a\nb\nc\nd\ne\nf\n",
+			to:   "x\nb\nc\nd\ne\ny\n",
+			want: "--- t@1\n+++ t@2\n" +
+				"@@ -1,6 +1,6 @@\n-a\n+x\n b\n c\n d\n e\n-f\n+y\n",
+		},
+		{
+			name: "from_empty",
+			from: "",
+			to:   "a\nb\n",
+			want: "--- t@1\n+++ t@2\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := UnifiedDiff("t@1", "t@2", tt.from, tt.to)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, diff)
+		})
+	}
+}
+
+func TestUnifiedDiffTooLarge(t *testing.T) {
+	var from, to strings.Builder
+	for i := 0; i < maxDiffEdits; i++ {
+		fmt.Fprintf(&from, "a%d\n", i)
+		fmt.Fprintf(&to, "b%d\n", i)
+	}
+
+	_, err := UnifiedDiff("t@1", "t@2", from.String(), to.String())
+	assert.ErrorIs(t, err, ErrDiffTooLarge)
+
+	// Long versions with few changes are still diffed
+	_, err = UnifiedDiff("t@1", "t@2", from.String()+"x\n", from.String()+"y\n")
+	assert.NoError(t, err)
+}
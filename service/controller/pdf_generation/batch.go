@@ -0,0 +1,188 @@
+package pdf_generation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/rchougule/espresso/lib/jobqueue"
+	"github.com/rchougule/espresso/lib/utils"
+	"github.com/rchougule/espresso/service/internal/pkg/httppkg"
+	"github.com/rchougule/espresso/service/internal/service/generateDoc"
+	"github.com/spf13/viper"
+)
+
+// ndjsonContentType is the content type of /generate-pdf-batch requests streamed as one JSON document per line
+const ndjsonContentType = "application/x-ndjson"
+
+// GeneratePDFBatch generates one PDF per item from a single template and stores them in the file storage,
+// responding with a manifest of the result of every item. The body is a JSON request with items or, with
+// Content-Type application/x-ndjson, the request without items on the first line followed by one item per
+// line, which is read while the PDFs are generated. Large batches can run in the background as pdf-batch jobs.
+func (s *EspressoService) GeneratePDFBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	startTime := time.Now()
+
+	reqId := utils.GenerateUniqueID(ctx)
+	fmt.Println("GeneratePDFBatch called, req id :: ", reqId)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchRequestBytes())
+	defer r.Body.Close()
+
+	req := &GeneratePDFBatchRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(req); err != nil {
+		fmt.Println("error parsing batch request :: ", err)
+		httppkg.RespondWithError(w, "Error parsing request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	nextItem := sliceItems(req.Items)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == ndjsonContentType {
+		if len(req.Items) > 0 {
+			httppkg.RespondWithError(w, "items must follow the first line of an NDJSON request", http.StatusBadRequest)
+			return
+		}
+		nextItem = decodeItems(decoder)
+	} else if len(req.Items) == 0 {
+		httppkg.RespondWithError(w, "items are required", http.StatusBadRequest)
+		return
+	}
+
+	batchReq := newPDFBatchDto(reqId, req, nextItem)
+	err := generateDoc.GeneratePDFBatch(ctx, batchReq, s.TemplateStorageAdapter, s.FileStorageAdapter)
+	if err != nil {
+		fmt.Println("error in generating pdf batch :: ", err)
+		statusCode := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			statusCode = http.StatusRequestEntityTooLarge
+		}
+		// Items read before the error were generated, the manifest tells which
+		responseData := batchManifest(batchReq.Results)
+		responseData["status"] = map[string]string{
+			"status":  "failed",
+			"message": "Failed to generate PDF batch: " + err.Error(),
+		}
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(responseData)
+		return
+	}
+
+	responseData := batchManifest(batchReq.Results)
+	responseData["status"] = map[string]string{
+		"status":  "success",
+		"message": "PDF batch generated",
+	}
+
+	fmt.Printf("generated %s pdf batch in :: %s\n", reqId, time.Since(startTime))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// runPDFBatchJob generates a batch queued with the JSON request of /generate-pdf-batch, the manifest is the
+// job result
+func (s *EspressoService) runPDFBatchJob(ctx context.Context, job *jobqueue.Job) error {
+	req := &GeneratePDFBatchRequest{}
+	if err := json.Unmarshal(job.Payload, req); err != nil {
+		return fmt.Errorf("invalid request: %v", err)
+	}
+	if len(req.Items) == 0 {
+		return fmt.Errorf("items are required")
+	}
+
+	batchReq := newPDFBatchDto(job.ID, req, sliceItems(req.Items))
+	if err := generateDoc.GeneratePDFBatch(ctx, batchReq, s.TemplateStorageAdapter, s.FileStorageAdapter); err != nil {
+		return err
+	}
+
+	result, err := json.Marshal(batchManifest(batchReq.Results))
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %v", err)
+	}
+	job.Result = result
+
+	return nil
+}
+
+// newPDFBatchDto maps a /generate-pdf-batch request to the batch params
+func newPDFBatchDto(reqId string, req *GeneratePDFBatchRequest, nextItem func() (*generateDoc.PDFBatchItem, error)) *generateDoc.PDFBatchDto {
+	batchReq := &generateDoc.PDFBatchDto{
+		ReqId:              reqId,
+		InputTemplatePath:  req.InputFilePath,
+		InputTemplateUUID:  req.InputTemplateUuid,
+		InputFileBytes:     req.InputFileBytes,
+		OutputPathTemplate: req.OutputFilePath,
+		ViewPort:           req.Viewport,
+		PdfParams:          req.PdfParams,
+		SignatureFields:    req.SignatureFields,
+		Concurrency:        req.Concurrency,
+		NextItem:           nextItem,
+	}
+
+	if req.SignParams != nil && req.SignParams.SignPdf {
+		batchReq.SignParams = req.SignParams
+	}
+
+	return batchReq
+}
+
+// sliceItems returns the items one after the other, then io.EOF
+func sliceItems(items []generateDoc.PDFBatchItem) func() (*generateDoc.PDFBatchItem, error) {
+	next := 0
+	return func() (*generateDoc.PDFBatchItem, error) {
+		if next >= len(items) {
+			return nil, io.EOF
+		}
+		next++
+		return &items[next-1], nil
+	}
+}
+
+// decodeItems returns the items decoded one after the other from the lines of an NDJSON request, then io.EOF
+func decodeItems(decoder *json.Decoder) func() (*generateDoc.PDFBatchItem, error) {
+	return func() (*generateDoc.PDFBatchItem, error) {
+		item := &generateDoc.PDFBatchItem{}
+		if err := decoder.Decode(item); err != nil {
+			return nil, err
+		}
+		return item, nil
+	}
+}
+
+// batchManifest summarizes the results of the items of a batch
+func batchManifest(results []generateDoc.PDFBatchItemResult) map[string]interface{} {
+	succeeded := 0
+	for _, result := range results {
+		if result.Status == generateDoc.BatchItemSucceeded {
+			succeeded++
+		}
+	}
+
+	return map[string]interface{}{
+		"total":     len(results),
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+		"items":     results,
+	}
+}
+
+// maxBatchRequestBytes is the request size limit of /generate-pdf-batch, batch.max_request_mb in config
+// (512 MB by default)
+func maxBatchRequestBytes() int64 {
+	maxRequestMB := viper.GetInt64("batch.max_request_mb")
+	if maxRequestMB <= 0 {
+		maxRequestMB = 512
+	}
+	return maxRequestMB << 20
+}
@@ -0,0 +1,80 @@
+package pdf_generation
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rchougule/espresso/service/internal/service/generateDoc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeItems(t *testing.T) {
+	body := `{"output_file_path": "statements/{{.ID}}.pdf"}
+{"id": "a", "content": {"name": "A"}}
+
+{"id": "b", "content": {"name": "B"}, "output_file_path": "b.pdf"}
+{"id": "c", "content": `
+
+	decoder := json.NewDecoder(strings.NewReader(body))
+	req := &GeneratePDFBatchRequest{}
+	require.NoError(t, decoder.Decode(req))
+	assert.Equal(t, "statements/{{.ID}}.pdf", req.OutputFilePath)
+
+	nextItem := decodeItems(decoder)
+	item, err := nextItem()
+	require.NoError(t, err)
+	assert.Equal(t, "a", item.ID)
+	assert.JSONEq(t, `{"name": "A"}`, string(item.Content))
+
+	item, err = nextItem()
+	require.NoError(t, err)
+	assert.Equal(t, "b", item.ID)
+	assert.Equal(t, "b.pdf", item.OutputFilePath)
+
+	// A truncated line is an error, not the end of the items
+	_, err = nextItem()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = decodeItems(json.NewDecoder(strings.NewReader("")))()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestSliceItems(t *testing.T) {
+	nextItem := sliceItems([]generateDoc.PDFBatchItem{{ID: "a"}, {ID: "b"}})
+	for _, id := range []string{"a", "b"} {
+		item, err := nextItem()
+		require.NoError(t, err)
+		assert.Equal(t, id, item.ID)
+	}
+	_, err := nextItem()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestGeneratePDFBatchItems(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     string
+	}{
+		{name: "json_without_items", contentType: "application/json", body: `{"output_file_path": "a.pdf"}`, wantErr: "items are required"},
+		{name: "ndjson_items_on_first_line", contentType: "application/x-ndjson", body: `{"items": [{"id": "a"}]}`, wantErr: "items must follow the first line"},
+		{name: "invalid_first_line", contentType: "application/x-ndjson", body: `{"items": `, wantErr: "Error parsing request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/generate-pdf-batch", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			(&EspressoService{}).GeneratePDFBatch(w, r)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.wantErr)
+		})
+	}
+}
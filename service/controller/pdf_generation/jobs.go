@@ -0,0 +1,281 @@
+package pdf_generation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rchougule/espresso/lib/jobqueue"
+	"github.com/rchougule/espresso/lib/templatestore"
+	"github.com/rchougule/espresso/service/internal/pkg/httppkg"
+	"github.com/rchougule/espresso/service/internal/service/generateDoc"
+	"github.com/spf13/viper"
+)
+
+// Job types accepted by /jobs
+const (
+	jobTypePDF      = "pdf"       // the request of /generate-pdf
+	jobTypePDFBatch = "pdf-batch" // the JSON request of /generate-pdf-batch
+)
+
+// pdfJobResult is the result of a pdf job, the PDF itself is the job output when no output_file_path is given
+type pdfJobResult struct {
+	OutputFilePath     string                              `json:"output_file_path,omitempty"`
+	SigningCertificate *generateDoc.SigningCertificateInfo `json:"signing_certificate,omitempty"`
+}
+
+// newJobQueue creates the queue of asynchronous jobs from the jobs section of the config. The queue is
+// in-process; jobqueue.Backend is the extension point for a broker shared by several instances.
+func (s *EspressoService) newJobQueue() *jobqueue.Queue {
+	backend := jobqueue.NewMemoryBackend(viper.GetInt("jobs.queue_size"))
+	backend.Retention = viper.GetDuration("jobs.retention")
+	backend.MaxFinished = viper.GetInt("jobs.max_finished")
+	backend.MaxOutputBytes = viper.GetInt64("jobs.max_output_mb") << 20
+
+	queue := jobqueue.NewQueue(backend)
+	queue.Workers = viper.GetInt("jobs.workers")
+	queue.JobTimeout = viper.GetDuration("jobs.job_timeout")
+	queue.WebhookWorkers = viper.GetInt("jobs.webhook_workers")
+	queue.Webhook = jobqueue.WebhookConfig{
+		Secret:       viper.GetString("jobs.webhook_secret"),
+		AllowedHosts: viper.GetStringSlice("jobs.webhook_allowed_hosts"),
+		MaxAttempts:  viper.GetInt("jobs.webhook_max_attempts"),
+	}
+	if timeout := viper.GetDuration("jobs.webhook_timeout"); timeout > 0 {
+		queue.Webhook.Client = &http.Client{
+			Timeout: timeout,
+			// a redirect could lead to a host that is not allowed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	queue.Handle(jobTypePDF, s.runPDFJob)
+	queue.Handle(jobTypePDFBatch, s.runPDFBatchJob)
+
+	return queue
+}
+
+// runPDFJob generates the PDF of a queued /generate-pdf request
+func (s *EspressoService) runPDFJob(ctx context.Context, job *jobqueue.Job) error {
+	req := &GeneratePDFRequest{}
+	if err := json.Unmarshal(job.Payload, req); err != nil {
+		return fmt.Errorf("invalid request: %v", err)
+	}
+
+	generatePdfReq := newPDFDto(job.ID, req)
+
+	// Without an output path the PDF is kept as the job output
+	fileStorageAdapter := s.FileStorageAdapter
+	if req.OutputFilePath == "" {
+		streamAdapter, err := templatestore.TemplateStorageAdapterFactory(&templatestore.StorageConfig{
+			StorageType: templatestore.StorageAdapterTypeStream,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get file storage adapter: %v", err)
+		}
+		fileStorageAdapter = &streamAdapter
+	}
+
+	if err := generateDoc.GeneratePDF(ctx, generatePdfReq, s.TemplateStorageAdapter, fileStorageAdapter); err != nil {
+		return err
+	}
+
+	result, err := json.Marshal(pdfJobResult{
+		OutputFilePath:     req.OutputFilePath,
+		SigningCertificate: generatePdfReq.SigningCertificate,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %v", err)
+	}
+	job.Result = result
+	job.Output = generatePdfReq.OutputFileBytes
+
+	return nil
+}
+
+// CreateJob queues a generation request and returns the job id right away. The job is polled with
+// /jobs/status and /jobs/result, and webhook_url is called with the outcome when it finishes.
+func (s *EspressoService) CreateJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxJobRequestBytes())
+	defer r.Body.Close()
+	req := &CreateJobRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		fmt.Println("error parsing job request :: ", err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			httppkg.RespondWithError(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		httppkg.RespondWithError(w, "Error parsing request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Type == "" {
+		req.Type = jobTypePDF
+	}
+	if len(req.Request) == 0 {
+		httppkg.RespondWithError(w, "request is required", http.StatusBadRequest)
+		return
+	}
+	// Malformed requests are rejected now rather than failing the job later
+	var validationErr error
+	switch req.Type {
+	case jobTypePDF:
+		validationErr = json.Unmarshal(req.Request, &GeneratePDFRequest{})
+	case jobTypePDFBatch:
+		validationErr = json.Unmarshal(req.Request, &GeneratePDFBatchRequest{})
+	}
+	if validationErr != nil {
+		httppkg.RespondWithError(w, "Invalid "+req.Type+" request: "+validationErr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.JobQueue.Submit(ctx, req.Type, req.Request, req.WebhookURL)
+	if err != nil {
+		fmt.Println("error in queueing job :: ", err)
+		statusCode := http.StatusBadRequest
+		if errors.Is(err, jobqueue.ErrQueueFull) {
+			statusCode = http.StatusServiceUnavailable
+		}
+		httppkg.RespondWithError(w, "Failed to queue job: "+err.Error(), statusCode)
+		return
+	}
+
+	fmt.Println("CreateJob queued, job id :: ", job.ID)
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "Job queued successfully",
+		},
+		"job_id":     job.ID,
+		"job_status": job.Status,
+		"status_url": "/jobs/status?job_id=" + job.ID,
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// GetJobStatus returns the state of a job and, once it succeeded, its result
+func (s *EspressoService) GetJobStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.getJob(w, r)
+	if !ok {
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "Job fetched successfully",
+		},
+		"job_id":     job.ID,
+		"type":       job.Type,
+		"job_status": job.Status,
+		"created_at": job.CreatedAt,
+	}
+	if !job.StartedAt.IsZero() {
+		responseData["started_at"] = job.StartedAt
+	}
+	if job.Finished() {
+		responseData["finished_at"] = job.FinishedAt
+	}
+	if job.Error != "" {
+		responseData["error"] = job.Error
+	}
+	if len(job.Result) > 0 {
+		responseData["result"] = job.Result
+	}
+	if len(job.Output) > 0 {
+		responseData["result_url"] = "/jobs/result?job_id=" + job.ID
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// GetJobResult streams the PDF of a succeeded job. Jobs that stored their PDF at an output_file_path return
+// their result instead.
+func (s *EspressoService) GetJobResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.getJob(w, r)
+	if !ok {
+		return
+	}
+
+	switch job.Status {
+	case jobqueue.StatusFailed:
+		httppkg.RespondWithError(w, "Job failed: "+job.Error, http.StatusUnprocessableEntity)
+		return
+	case jobqueue.StatusSucceeded:
+	default:
+		httppkg.RespondWithError(w, fmt.Sprintf("Job is %s", job.Status), http.StatusConflict)
+		return
+	}
+
+	if len(job.Output) == 0 {
+		responseData := map[string]interface{}{
+			"status": map[string]string{
+				"status":  "success",
+				"message": "Job succeeded",
+			},
+			"job_id": job.ID,
+			"result": job.Result,
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(responseData)
+		return
+	}
+
+	result := &pdfJobResult{}
+	if err := json.Unmarshal(job.Result, result); err == nil {
+		setSigningCertificateHeaders(w, result.SigningCertificate)
+	}
+	if err := writePDF(w, pdfFileName("", job.ID+".pdf"), job.Output); err != nil {
+		fmt.Println("error writing job result stream :: ", err)
+	}
+}
+
+// getJob looks up the job of the job_id query parameter, responding with an error when there is none
+func (s *EspressoService) getJob(w http.ResponseWriter, r *http.Request) (*jobqueue.Job, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	jobID := r.URL.Query().Get("job_id")
+	if jobID == "" {
+		httppkg.RespondWithError(w, "job_id is required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	job, err := s.JobQueue.Get(r.Context(), jobID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, jobqueue.ErrNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		httppkg.RespondWithError(w, "Failed to get job: "+err.Error(), statusCode)
+		return nil, false
+	}
+
+	return job, true
+}
+
+// maxJobRequestBytes is the /jobs request size limit, a pdf-batch job carries a whole batch
+func maxJobRequestBytes() int64 {
+	maxRequestMB := viper.GetInt64("jobs.max_request_mb")
+	if maxRequestMB <= 0 {
+		return maxBatchRequestBytes()
+	}
+	return maxRequestMB << 20
+}
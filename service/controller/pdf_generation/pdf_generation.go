@@ -1,10 +1,13 @@
 package pdf_generation
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -41,20 +44,7 @@ func (s *EspressoService) GeneratePDF(w http.ResponseWriter, r *http.Request) {
 	reqId := utils.GenerateUniqueID(ctx)
 	fmt.Println("GeneratePDF called, req id :: ", reqId)
 
-	generatePdfReq := &generateDoc.PDFDto{
-		ReqId:              reqId,
-		InputTemplatePath:  req.InputFilePath,
-		InputFileBytes:     req.InputFileBytes,
-		InputTemplateUUID:  req.InputTemplateUuid,
-		OutputTemplatePath: req.OutputFilePath,
-		Content:            req.Content,
-		ViewPort:           req.Viewport,
-		PdfParams:          req.PdfParams,
-	}
-
-	if req.SignParams != nil && req.SignParams.SignPdf {
-		generatePdfReq.SignParams = req.SignParams
-	}
+	generatePdfReq := newPDFDto(reqId, req)
 
 	err = generateDoc.GeneratePDF(ctx, generatePdfReq, s.TemplateStorageAdapter, s.FileStorageAdapter)
 	if err != nil {
@@ -71,6 +61,9 @@ func (s *EspressoService) GeneratePDF(w http.ResponseWriter, r *http.Request) {
 		"output_file_path":  req.OutputFilePath,
 		"output_file_bytes": generatePdfReq.OutputFileBytes,
 	}
+	if generatePdfReq.SigningCertificate != nil {
+		responseData["signing_certificate"] = generatePdfReq.SigningCertificate
+	}
 
 	duration := time.Since(startTime)
 	fmt.Printf("generated %s pdf in :: %s\n", reqId, duration)
@@ -79,6 +72,27 @@ func (s *EspressoService) GeneratePDF(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(responseData)
 }
 
+// newPDFDto maps a /generate-pdf request to the generation params
+func newPDFDto(reqId string, req *GeneratePDFRequest) *generateDoc.PDFDto {
+	generatePdfReq := &generateDoc.PDFDto{
+		ReqId:              reqId,
+		InputTemplatePath:  req.InputFilePath,
+		InputFileBytes:     req.InputFileBytes,
+		InputTemplateUUID:  req.InputTemplateUuid,
+		OutputTemplatePath: req.OutputFilePath,
+		Content:            req.Content,
+		ViewPort:           req.Viewport,
+		PdfParams:          req.PdfParams,
+		SignatureFields:    req.SignatureFields,
+	}
+
+	if req.SignParams != nil && req.SignParams.SignPdf {
+		generatePdfReq.SignParams = req.SignParams
+	}
+
+	return generatePdfReq
+}
+
 func (s *EspressoService) GeneratePDFStream(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	startTime := time.Now()
@@ -130,6 +144,10 @@ func (s *EspressoService) GeneratePDFStream(w http.ResponseWriter, r *http.Reque
 		MarginLeft:          margin,
 		MarginRight:         margin,
 		IsSinglePage:        pdfReq.SinglePage,
+		Encryption:          pdfReq.Encryption,
+		PdfA:                pdfReq.PdfA,
+		Metadata:            pdfReq.Metadata,
+		Watermarks:          pdfReq.Watermarks,
 	}
 
 	generatePdfReq := &generateDoc.PDFDto{
@@ -138,13 +156,19 @@ func (s *EspressoService) GeneratePDFStream(w http.ResponseWriter, r *http.Reque
 		Content:           pdfReq.Content,
 		SignParams:        &generateDoc.SignParams{SignPdf: pdfReq.SignPdf},
 		// ViewPort:          req.Viewport,
-		PdfParams: pdfSettings,
+		PdfParams:       pdfSettings,
+		SignatureFields: pdfReq.SignatureFields,
 	}
 	if pdfReq.SignPdf {
-		generatePdfReq.SignParams = &generateDoc.SignParams{
-			SignPdf:       true,
-			CertConfigKey: "digital_certificates.cert1", // certificate details are stored in config file
+		signParams := &generateDoc.SignParams{}
+		if pdfReq.SignParams != nil {
+			signParams = pdfReq.SignParams
+		}
+		signParams.SignPdf = true
+		if signParams.CertConfigKey == "" {
+			signParams.CertConfigKey = "digital_certificates.cert1" // certificate details are stored in config file
 		}
+		generatePdfReq.SignParams = signParams
 	}
 
 	fileStorageAdapter, err := templatestore.TemplateStorageAdapterFactory(&templatestore.StorageConfig{
@@ -170,35 +194,13 @@ func (s *EspressoService) GeneratePDFStream(w http.ResponseWriter, r *http.Reque
 		httppkg.RespondWithError(w, "Failed to generate PDF stream: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// Determine filename for the PDF
-	fileName := "generated.pdf"
-
-	// Use the filename from the request if provided
-	if pdfReq.Filename != "" {
-		fileName = pdfReq.Filename
-		// Ensure it has .pdf extension
-		if !strings.HasSuffix(strings.ToLower(fileName), ".pdf") {
-			fileName += ".pdf"
-		}
-	}
-
-	// Sanitize filename (remove any path elements for security)
-	fileName = filepath.Base(fileName)
+	fileName := pdfFileName(pdfReq.Filename, "generated.pdf")
 
 	// Check if we have PDF data to return
 	if len(generatePdfReq.OutputFileBytes) > 0 {
 		// Always return the PDF file directly for download
-		w.Header().Set("Content-Type", "application/pdf")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(generatePdfReq.OutputFileBytes)))
-		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-		w.Header().Set("Pragma", "no-cache")
-		w.Header().Set("Expires", "0")
-		w.WriteHeader(http.StatusOK)
-
-		// Write the PDF data
-		_, err = w.Write(generatePdfReq.OutputFileBytes)
-		if err != nil {
+		setSigningCertificateHeaders(w, generatePdfReq.SigningCertificate)
+		if err := writePDF(w, fileName, generatePdfReq.OutputFileBytes); err != nil {
 			fmt.Println("error writing pdf stream :: ", err)
 			httppkg.RespondWithError(w, "Failed to write PDF stream: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -215,46 +217,600 @@ func (s *EspressoService) GeneratePDFStream(w http.ResponseWriter, r *http.Reque
 
 }
 
+// SignPDF signs an existing PDF. The PDF is accepted as a multipart upload (file field), a raw application/pdf body,
+// or a JSON request with input_file_path (read from the configured file storage) or base64 input_file_bytes.
+// With output_file_path the signed PDF is stored in the file storage, otherwise it is streamed back.
 func (s *EspressoService) SignPDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	ctx := r.Context()
-	req := &SignPDFRequest{}
+	startTime := time.Now()
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Println("error decoding request body :: ", err)
-		httppkg.RespondWithError(w, "Error decoding request body: "+err.Error(), http.StatusBadRequest)
+	reqId := utils.GenerateUniqueID(ctx)
+	fmt.Println("SignPDF called, req id :: ", reqId)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSignUploadBytes())
+	req, err := parseSignPDFRequest(r)
+	if err != nil {
+		fmt.Println("error parsing sign pdf request :: ", err)
+		respondBodyError(w, err)
 		return
 	}
 
-	reqId := utils.GenerateUniqueID(ctx)
-	fmt.Println("GeneratePDF called, req id :: ", reqId)
+	if req.InputFilePath == "" && len(req.InputFileBytes) == 0 {
+		httppkg.RespondWithError(w, "a PDF file, input_file_path or input_file_bytes is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.InputFileBytes) > 0 && !bytes.HasPrefix(req.InputFileBytes, []byte("%PDF-")) {
+		httppkg.RespondWithError(w, "input file is not a PDF", http.StatusBadRequest)
+		return
+	}
+
+	signParams := req.SignParams
+	if signParams == nil {
+		signParams = &generateDoc.SignParams{}
+	}
+	signParams.SignPdf = true
+	if signParams.CertConfigKey == "" {
+		signParams.CertConfigKey = "digital_certificates.cert1" // certificate details are stored in config file
+	}
+
 	signPDFDto := &generateDoc.SignPDFDto{
 		ReqId:          reqId,
 		InputFilePath:  req.InputFilePath,
 		InputFileBytes: req.InputFileBytes,
 		OutputFilePath: req.OutputFilePath,
+		SignParams:     signParams,
 	}
-	if req.SignParams != nil && req.SignParams.SignPdf {
-		signPDFDto.SignParams = req.SignParams
-	} else {
-		err := fmt.Errorf("signPdf param is not true in the request")
-		fmt.Println("error in signing pdf :: ", err)
-		httppkg.RespondWithError(w, err.Error(), http.StatusBadRequest)
+
+	inputStorageAdapter, outputStorageAdapter, err := s.pdfStorageAdapters(req.InputFileBytes, req.OutputFilePath)
+	if err != nil {
+		fmt.Println("error in getting file storage adapter :: ", err)
+		httppkg.RespondWithError(w, "Failed to get file storage adapter: "+err.Error(), http.StatusExpectationFailed)
 		return
 	}
-	err := generateDoc.SignPDF(ctx, signPDFDto, s.FileStorageAdapter)
+
+	err = generateDoc.SignPDF(ctx, signPDFDto, inputStorageAdapter, outputStorageAdapter)
 	if err != nil {
 		fmt.Println("error in signing pdf :: ", err)
 		httppkg.RespondWithError(w, "Failed to sign PDF: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if req.OutputFilePath == "" {
+		setSigningCertificateHeaders(w, signPDFDto.SigningCertificate)
+		if err := writePDF(w, pdfFileName(req.Filename, "signed.pdf"), signPDFDto.OutputFileBytes); err != nil {
+			fmt.Println("error writing signed pdf stream :: ", err)
+			return
+		}
+		fmt.Printf("signed %s pdf stream in :: %s\n", reqId, time.Since(startTime))
+		return
+	}
+
 	responseData := map[string]interface{}{
 		"status": map[string]string{
 			"status":  "success",
 			"message": "PDF signed successfully",
 		},
-		"output_file_path":  req.OutputFilePath,
-		"output_file_bytes": signPDFDto.OutputFileBytes,
+		"output_file_path":    req.OutputFilePath,
+		"signing_certificate": signPDFDto.SigningCertificate,
+	}
+
+	fmt.Printf("signed %s pdf in :: %s\n", reqId, time.Since(startTime))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// TimestampPDF appends an RFC 3161 document timestamp to an existing, possibly signed PDF. The request formats
+// are the ones of SignPDF; of sign_params only cert_config_key (whose tsa_* settings are used), tsa and
+// digest_algorithm apply.
+func (s *EspressoService) TimestampPDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	startTime := time.Now()
+
+	reqId := utils.GenerateUniqueID(ctx)
+	fmt.Println("TimestampPDF called, req id :: ", reqId)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSignUploadBytes())
+	req, err := parseSignPDFRequest(r)
+	if err != nil {
+		fmt.Println("error parsing timestamp pdf request :: ", err)
+		respondBodyError(w, err)
+		return
+	}
+
+	if req.InputFilePath == "" && len(req.InputFileBytes) == 0 {
+		httppkg.RespondWithError(w, "a PDF file, input_file_path or input_file_bytes is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.InputFileBytes) > 0 && !bytes.HasPrefix(req.InputFileBytes, []byte("%PDF-")) {
+		httppkg.RespondWithError(w, "input file is not a PDF", http.StatusBadRequest)
+		return
+	}
+
+	timestampPDFDto := &generateDoc.TimestampPDFDto{
+		ReqId:          reqId,
+		InputFilePath:  req.InputFilePath,
+		InputFileBytes: req.InputFileBytes,
+		OutputFilePath: req.OutputFilePath,
+		CertConfigKey:  "digital_certificates.cert1", // tsa details are stored in config file
+	}
+	if req.SignParams != nil {
+		if req.SignParams.CertConfigKey != "" {
+			timestampPDFDto.CertConfigKey = req.SignParams.CertConfigKey
+		}
+		timestampPDFDto.TSA = req.SignParams.TSA
+		timestampPDFDto.DigestAlgorithm = req.SignParams.DigestAlgorithm
+	}
+
+	inputStorageAdapter, outputStorageAdapter, err := s.pdfStorageAdapters(req.InputFileBytes, req.OutputFilePath)
+	if err != nil {
+		fmt.Println("error in getting file storage adapter :: ", err)
+		httppkg.RespondWithError(w, "Failed to get file storage adapter: "+err.Error(), http.StatusExpectationFailed)
+		return
+	}
+
+	err = generateDoc.TimestampPDF(ctx, timestampPDFDto, inputStorageAdapter, outputStorageAdapter)
+	if err != nil {
+		fmt.Println("error in timestamping pdf :: ", err)
+		httppkg.RespondWithError(w, "Failed to timestamp PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if req.OutputFilePath == "" {
+		if err := writePDF(w, pdfFileName(req.Filename, "timestamped.pdf"), timestampPDFDto.OutputFileBytes); err != nil {
+			fmt.Println("error writing timestamped pdf stream :: ", err)
+			return
+		}
+		fmt.Printf("timestamped %s pdf stream in :: %s\n", reqId, time.Since(startTime))
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "PDF timestamped successfully",
+		},
+		"output_file_path": req.OutputFilePath,
+	}
+
+	fmt.Printf("timestamped %s pdf in :: %s\n", reqId, time.Since(startTime))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// MergePDF concatenates rendered templates and stored PDFs into one PDF, optionally signed. Documents are
+// rendered from template_uuid, template_path or template_html with content, or read from the file storage
+// (file_path) or base64 file_bytes. With output_file_path the merged PDF is stored in the file storage,
+// otherwise it is streamed back.
+func (s *EspressoService) MergePDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	startTime := time.Now()
+
+	reqId := utils.GenerateUniqueID(ctx)
+	fmt.Println("MergePDF called, req id :: ", reqId)
+
+	req := &MergePDFRequest{}
+	if err := decodeLimitedJSON(w, r, maxPDFUploadBytes(), req); err != nil {
+		fmt.Println("error parsing merge pdf request :: ", err)
+		return
+	}
+
+	if len(req.Documents) == 0 {
+		httppkg.RespondWithError(w, "documents are required", http.StatusBadRequest)
+		return
+	}
+	for i, document := range req.Documents {
+		if len(document.FileBytes) > 0 && !bytes.HasPrefix(document.FileBytes, []byte("%PDF-")) {
+			httppkg.RespondWithError(w, fmt.Sprintf("document %d is not a PDF", i+1), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.SignParams != nil && req.SignParams.SignPdf && req.SignParams.CertConfigKey == "" {
+		req.SignParams.CertConfigKey = "digital_certificates.cert1" // certificate details are stored in config file
+	}
+
+	mergePDFDto := &generateDoc.MergePDFDto{
+		ReqId:          reqId,
+		Documents:      req.Documents,
+		OutputFilePath: req.OutputFilePath,
+		SignParams:     req.SignParams,
+	}
+
+	_, outputStorageAdapter, err := s.pdfStorageAdapters(nil, req.OutputFilePath)
+	if err != nil {
+		fmt.Println("error in getting file storage adapter :: ", err)
+		httppkg.RespondWithError(w, "Failed to get file storage adapter: "+err.Error(), http.StatusExpectationFailed)
+		return
+	}
+
+	err = generateDoc.MergePDF(ctx, mergePDFDto, s.TemplateStorageAdapter, s.FileStorageAdapter, outputStorageAdapter)
+	if err != nil {
+		fmt.Println("error in merging pdf :: ", err)
+		httppkg.RespondWithError(w, "Failed to merge PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if req.OutputFilePath == "" {
+		setSigningCertificateHeaders(w, mergePDFDto.SigningCertificate)
+		if err := writePDF(w, pdfFileName(req.Filename, "merged.pdf"), mergePDFDto.OutputFileBytes); err != nil {
+			fmt.Println("error writing merged pdf stream :: ", err)
+			return
+		}
+		fmt.Printf("merged %s pdf stream in :: %s\n", reqId, time.Since(startTime))
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "PDF merged successfully",
+		},
+		"output_file_path": req.OutputFilePath,
+	}
+	if mergePDFDto.SigningCertificate != nil {
+		responseData["signing_certificate"] = mergePDFDto.SigningCertificate
+	}
+
+	fmt.Printf("merged %s pdf in :: %s\n", reqId, time.Since(startTime))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// SplitPDF extracts page ranges of a stored (input_file_path) or uploaded (base64 input_file_bytes) PDF into
+// separate PDFs. With output_file_path the parts are stored in the file storage as <name>_<part>.pdf, otherwise
+// they are returned base64 encoded in the response.
+func (s *EspressoService) SplitPDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	startTime := time.Now()
+
+	reqId := utils.GenerateUniqueID(ctx)
+	fmt.Println("SplitPDF called, req id :: ", reqId)
+
+	req := &SplitPDFRequest{}
+	if err := decodeLimitedJSON(w, r, maxPDFUploadBytes(), req); err != nil {
+		fmt.Println("error parsing split pdf request :: ", err)
+		return
+	}
+
+	if req.InputFilePath == "" && len(req.InputFileBytes) == 0 {
+		httppkg.RespondWithError(w, "input_file_path or input_file_bytes is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.InputFileBytes) > 0 && !bytes.HasPrefix(req.InputFileBytes, []byte("%PDF-")) {
+		httppkg.RespondWithError(w, "input file is not a PDF", http.StatusBadRequest)
+		return
+	}
+
+	splitPDFDto := &generateDoc.SplitPDFDto{
+		ReqId:          reqId,
+		InputFilePath:  req.InputFilePath,
+		InputFileBytes: req.InputFileBytes,
+		Ranges:         req.Ranges,
+		OutputFilePath: req.OutputFilePath,
+	}
+
+	inputStorageAdapter, outputStorageAdapter, err := s.pdfStorageAdapters(req.InputFileBytes, req.OutputFilePath)
+	if err != nil {
+		fmt.Println("error in getting file storage adapter :: ", err)
+		httppkg.RespondWithError(w, "Failed to get file storage adapter: "+err.Error(), http.StatusExpectationFailed)
+		return
+	}
+
+	err = generateDoc.SplitPDF(ctx, splitPDFDto, inputStorageAdapter, outputStorageAdapter)
+	if err != nil {
+		fmt.Println("error in splitting pdf :: ", err)
+		httppkg.RespondWithError(w, "Failed to split PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "PDF split successfully",
+		},
+		"parts": splitPDFDto.Parts,
+	}
+
+	fmt.Printf("split %s pdf into %d parts in :: %s\n", reqId, len(splitPDFDto.Parts), time.Since(startTime))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// WatermarkPDF draws watermarks over a stored (input_file_path) or uploaded (base64 input_file_bytes) PDF, e.g.
+// to mark internal copies. With output_file_path the result is stored in the file storage, otherwise it is
+// streamed back.
+func (s *EspressoService) WatermarkPDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	startTime := time.Now()
+
+	reqId := utils.GenerateUniqueID(ctx)
+	fmt.Println("WatermarkPDF called, req id :: ", reqId)
+
+	req := &WatermarkPDFRequest{}
+	if err := decodeLimitedJSON(w, r, maxPDFUploadBytes(), req); err != nil {
+		fmt.Println("error parsing watermark pdf request :: ", err)
+		return
+	}
+
+	if req.InputFilePath == "" && len(req.InputFileBytes) == 0 {
+		httppkg.RespondWithError(w, "input_file_path or input_file_bytes is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.InputFileBytes) > 0 && !bytes.HasPrefix(req.InputFileBytes, []byte("%PDF-")) {
+		httppkg.RespondWithError(w, "input file is not a PDF", http.StatusBadRequest)
+		return
+	}
+	if len(req.Watermarks) == 0 {
+		httppkg.RespondWithError(w, "watermarks are required", http.StatusBadRequest)
+		return
+	}
+
+	watermarkPDFDto := &generateDoc.WatermarkPDFDto{
+		ReqId:          reqId,
+		InputFilePath:  req.InputFilePath,
+		InputFileBytes: req.InputFileBytes,
+		Watermarks:     req.Watermarks,
+		OutputFilePath: req.OutputFilePath,
+	}
+
+	inputStorageAdapter, outputStorageAdapter, err := s.pdfStorageAdapters(req.InputFileBytes, req.OutputFilePath)
+	if err != nil {
+		fmt.Println("error in getting file storage adapter :: ", err)
+		httppkg.RespondWithError(w, "Failed to get file storage adapter: "+err.Error(), http.StatusExpectationFailed)
+		return
+	}
+
+	err = generateDoc.WatermarkPDF(ctx, watermarkPDFDto, inputStorageAdapter, outputStorageAdapter)
+	if err != nil {
+		fmt.Println("error in watermarking pdf :: ", err)
+		httppkg.RespondWithError(w, "Failed to watermark PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if req.OutputFilePath == "" {
+		if err := writePDF(w, pdfFileName(req.Filename, "watermarked.pdf"), watermarkPDFDto.OutputFileBytes); err != nil {
+			fmt.Println("error writing watermarked pdf stream :: ", err)
+			return
+		}
+		fmt.Printf("watermarked %s pdf stream in :: %s\n", reqId, time.Since(startTime))
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "PDF watermarked successfully",
+		},
+		"output_file_path": req.OutputFilePath,
+	}
+
+	fmt.Printf("watermarked %s pdf in :: %s\n", reqId, time.Since(startTime))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// parseSignPDFRequest reads a sign request from a multipart form, a raw application/pdf body or JSON.
+// For multipart and raw bodies the remaining fields are read from form values or the query string,
+// sign_params being a JSON encoded generateDoc.SignParams.
+func parseSignPDFRequest(r *http.Request) (*SignPDFRequest, error) {
+	req := &SignPDFRequest{}
+	defer r.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(multipartMemoryBytes); err != nil {
+			return nil, err
+		}
+		if file, header, err := r.FormFile("file"); err == nil {
+			defer file.Close()
+			if req.InputFileBytes, err = io.ReadAll(file); err != nil {
+				return nil, err
+			}
+			req.Filename = header.Filename
+		} else if err != http.ErrMissingFile {
+			return nil, err
+		}
+	case "application/pdf":
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.InputFileBytes = bodyBytes
+	default:
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+
+	if inputFilePath := r.FormValue("input_file_path"); inputFilePath != "" && len(req.InputFileBytes) == 0 {
+		req.InputFilePath = inputFilePath
+	}
+	req.OutputFilePath = r.FormValue("output_file_path")
+	if filename := r.FormValue("filename"); filename != "" {
+		req.Filename = filename
+	}
+	if signParams := r.FormValue("sign_params"); signParams != "" {
+		req.SignParams = &generateDoc.SignParams{}
+		if err := json.Unmarshal([]byte(signParams), req.SignParams); err != nil {
+			return nil, fmt.Errorf("invalid sign_params: %v", err)
+		}
+	}
+
+	return req, nil
+}
+
+// maxSignUploadBytes is the request size limit of /sign-pdf, sign_pdf.max_upload_mb in config (50 MB by default)
+func maxSignUploadBytes() int64 {
+	maxUploadMB := viper.GetInt64("sign_pdf.max_upload_mb")
+	if maxUploadMB <= 0 {
+		maxUploadMB = 50
+	}
+	return maxUploadMB << 20
+}
+
+// maxPDFUploadBytes is the request size limit of /merge-pdf, /split-pdf and /watermark-pdf,
+// pdf_operations.max_upload_mb in config (sign_pdf.max_upload_mb when unset)
+func maxPDFUploadBytes() int64 {
+	maxUploadMB := viper.GetInt64("pdf_operations.max_upload_mb")
+	if maxUploadMB <= 0 {
+		return maxSignUploadBytes()
+	}
+	return maxUploadMB << 20
+}
+
+// decodeLimitedJSON decodes a JSON request body of at most maxBytes into v, answering the request when it fails
+func decodeLimitedJSON(w http.ResponseWriter, r *http.Request, maxBytes int64, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		respondBodyError(w, err)
+	}
+	return err
+}
+
+// respondBodyError answers a request whose body could not be read, with 413 when it exceeded its size limit
+func respondBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		httppkg.RespondWithError(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	httppkg.RespondWithError(w, "Error parsing request: "+err.Error(), http.StatusBadRequest)
+}
+
+// pdfStorageAdapters returns the storages a PDF operation reads its input from and writes its output to: the
+// stream adapter for uploaded bytes and for PDFs streamed back (no output path), the file storage otherwise
+func (s *EspressoService) pdfStorageAdapters(inputFileBytes []byte, outputFilePath string) (*templatestore.StorageAdapter, *templatestore.StorageAdapter, error) {
+	streamAdapter, err := templatestore.TemplateStorageAdapterFactory(&templatestore.StorageConfig{
+		StorageType: templatestore.StorageAdapterTypeStream,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inputStorageAdapter := s.FileStorageAdapter
+	if len(inputFileBytes) > 0 {
+		inputStorageAdapter = &streamAdapter
+	}
+	outputStorageAdapter := s.FileStorageAdapter
+	if outputFilePath == "" {
+		outputStorageAdapter = &streamAdapter
+	}
+	return inputStorageAdapter, outputStorageAdapter, nil
+}
+
+// pdfFileName sanitizes a user supplied download name, falling back to defaultName
+func pdfFileName(name, defaultName string) string {
+	fileName := defaultName
+
+	// Use the filename from the request if provided
+	if name != "" {
+		fileName = name
+		// Ensure it has .pdf extension
+		if !strings.HasSuffix(strings.ToLower(fileName), ".pdf") {
+			fileName += ".pdf"
+		}
+	}
+
+	// Sanitize filename (remove any path elements for security)
+	return filepath.Base(fileName)
+}
+
+// writePDF writes pdfBytes as a downloadable attachment
+func writePDF(w http.ResponseWriter, fileName string, pdfBytes []byte) error {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(pdfBytes)))
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.WriteHeader(http.StatusOK)
+
+	// Write the PDF data
+	_, err := w.Write(pdfBytes)
+	return err
+}
+
+// setSigningCertificateHeaders tells which credentials signed a streamed PDF, nothing is set for unsigned PDFs
+func setSigningCertificateHeaders(w http.ResponseWriter, info *generateDoc.SigningCertificateInfo) {
+	if info == nil {
+		return
+	}
+	w.Header().Set("X-Signing-Cert-Config-Key", info.CertConfigKey)
+	w.Header().Set("X-Signing-Cert-Serial", info.SerialNumber)
+}
+
+func (s *EspressoService) VerifyPDF(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := &VerifyPDFRequest{}
+
+	if err := decodeLimitedJSON(w, r, maxSignUploadBytes(), req); err != nil {
+		fmt.Println("error decoding request body :: ", err)
+		return
+	}
+
+	if req.InputFilePath == "" && len(req.InputFileBytes) == 0 {
+		httppkg.RespondWithError(w, "input_file_path or input_file_bytes is required", http.StatusBadRequest)
+		return
+	}
+
+	reqId := utils.GenerateUniqueID(ctx)
+	fmt.Println("VerifyPDF called, req id :: ", reqId)
+
+	verifyPDFDto := &generateDoc.VerifyPDFDto{
+		ReqId:          reqId,
+		InputFilePath:  req.InputFilePath,
+		InputFileBytes: req.InputFileBytes,
+	}
+
+	inputStorageAdapter, _, err := s.pdfStorageAdapters(req.InputFileBytes, "")
+	if err != nil {
+		fmt.Println("error in getting file storage adapter :: ", err)
+		httppkg.RespondWithError(w, "Failed to get file storage adapter: "+err.Error(), http.StatusExpectationFailed)
+		return
+	}
+
+	report, err := generateDoc.VerifyPDF(ctx, verifyPDFDto, inputStorageAdapter)
+	if err != nil {
+		fmt.Println("error in verifying pdf :: ", err)
+		httppkg.RespondWithError(w, "Failed to verify PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "PDF verified successfully",
+		},
+		"report": report,
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -289,10 +845,11 @@ func (s *EspressoService) GetAllTemplates(w http.ResponseWriter, r *http.Request
 			updatedAt = tmpl.UpdatedAt.Format(time.RFC3339)
 		}
 		templateData := &generateDoc.TemplateListData{
-			TemplateId:   tmpl.TemplateID,
-			TemplateName: tmpl.TemplateName,
-			CreatedAt:    createdAt,
-			UpdatedAt:    updatedAt,
+			TemplateId:       tmpl.TemplateID,
+			TemplateName:     tmpl.TemplateName,
+			CreatedAt:        createdAt,
+			UpdatedAt:        updatedAt,
+			PublishedVersion: tmpl.PublishedVersion,
 		}
 
 		templateDataList = append(templateDataList, templateData)
@@ -317,18 +874,21 @@ func (s *EspressoService) GetTemplateById(w http.ResponseWriter, r *http.Request
 	ctx := r.Context()
 
 	templateID := r.URL.Query().Get("template_id")
-	if templateID == "" {
+	templatePath := r.URL.Query().Get("template_path")
+	if templateID == "" && templatePath == "" {
 		fmt.Println("template id is required")
-		httppkg.RespondWithError(w, "Template ID is required", http.StatusBadRequest)
+		httppkg.RespondWithError(w, "Template ID or template path is required", http.StatusBadRequest)
 		return
 	}
 
 	templateData, err := (*s.TemplateStorageAdapter).GetTemplateContent(ctx, &templatestore.GetTemplateContentRequest{
-		TemplateUUID: templateID,
+		TemplateUUID:   templateID,
+		TemplatePath:   templatePath,
+		TemplateS3Path: templatePath,
 	})
 	if err != nil {
 		fmt.Println("error getting template content :: ", err)
-		httppkg.RespondWithError(w, "Failed to get template content: "+err.Error(), http.StatusInternalServerError)
+		httppkg.RespondWithError(w, "Failed to get template content: "+err.Error(), templateErrorStatus(err))
 		return
 	}
 
@@ -340,8 +900,13 @@ func (s *EspressoService) GetTemplateById(w http.ResponseWriter, r *http.Request
 		"template_html": templateData.TemplateContent,
 		"template_name": templateData.TemplateName,
 		"json":          templateData.TemplateJsonSchema,
+		"version":       templateData.Version,
+		"etag":          templateData.ETag,
 	}
 
+	if templateData.ETag != "" {
+		w.Header().Set("ETag", `"`+templateData.ETag+`"`)
+	}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(responseData)
 }
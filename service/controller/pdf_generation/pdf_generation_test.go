@@ -0,0 +1,192 @@
+package pdf_generation
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rchougule/espresso/service/internal/service/generateDoc"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// multipartBody encodes fields and, unless fileName is empty, a file part named "file"
+func multipartBody(t *testing.T, fields map[string]string, fileName string, file []byte) (io.Reader, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		require.NoError(t, writer.WriteField(name, value))
+	}
+	if fileName != "" {
+		part, err := writer.CreateFormFile("file", fileName)
+		require.NoError(t, err)
+		part.Write(file)
+	}
+	require.NoError(t, writer.Close())
+	return &body, writer.FormDataContentType()
+}
+
+func TestParseSignPDFRequest(t *testing.T) {
+	pdf := []byte("%PDF-1.7 test")
+	signParams := `{"sign_pdf": true, "cert_config_key": "digital_certificates.cert1"}`
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        func(t *testing.T) (io.Reader, string)
+		want        *SignPDFRequest
+		wantErr     string
+	}{
+		{
+			name:        "json",
+			contentType: "application/json",
+			body: func(t *testing.T) (io.Reader, string) {
+				return strings.NewReader(`{"input_file_path": "in.pdf", "output_file_path": "out.pdf", "sign_params": ` + signParams + `}`), ""
+			},
+			want: &SignPDFRequest{
+				InputFilePath:  "in.pdf",
+				OutputFilePath: "out.pdf",
+				SignParams:     &generateDoc.SignParams{SignPdf: true, CertConfigKey: "digital_certificates.cert1"},
+			},
+		},
+		{
+			name:   "raw_pdf_with_query",
+			target: "/sign-pdf?filename=report&output_file_path=out.pdf&input_file_path=ignored.pdf&sign_params=" + strings.ReplaceAll(signParams, " ", "%20"),
+			body: func(t *testing.T) (io.Reader, string) {
+				return bytes.NewReader(pdf), "application/pdf"
+			},
+			want: &SignPDFRequest{
+				InputFileBytes: pdf,
+				OutputFilePath: "out.pdf",
+				Filename:       "report",
+				SignParams:     &generateDoc.SignParams{SignPdf: true, CertConfigKey: "digital_certificates.cert1"},
+			},
+		},
+		{
+			name: "multipart_upload",
+			body: func(t *testing.T) (io.Reader, string) {
+				return multipartBody(t, map[string]string{"sign_params": signParams}, "contract.pdf", pdf)
+			},
+			want: &SignPDFRequest{
+				InputFileBytes: pdf,
+				Filename:       "contract.pdf",
+				SignParams:     &generateDoc.SignParams{SignPdf: true, CertConfigKey: "digital_certificates.cert1"},
+			},
+		},
+		{
+			name: "multipart_filename_field",
+			body: func(t *testing.T) (io.Reader, string) {
+				return multipartBody(t, map[string]string{"filename": "renamed.pdf"}, "contract.pdf", pdf)
+			},
+			want: &SignPDFRequest{InputFileBytes: pdf, Filename: "renamed.pdf"},
+		},
+		{
+			name: "multipart_without_file",
+			body: func(t *testing.T) (io.Reader, string) {
+				return multipartBody(t, map[string]string{"input_file_path": "in.pdf"}, "", nil)
+			},
+			want: &SignPDFRequest{InputFilePath: "in.pdf"},
+		},
+		{
+			name: "invalid_sign_params",
+			body: func(t *testing.T) (io.Reader, string) {
+				return multipartBody(t, map[string]string{"sign_params": "{"}, "contract.pdf", pdf)
+			},
+			wantErr: "invalid sign_params",
+		},
+		{
+			name:        "invalid_json",
+			contentType: "application/json",
+			body: func(t *testing.T) (io.Reader, string) {
+				return strings.NewReader(`{"input_file_path": `), ""
+			},
+			wantErr: "unexpected EOF",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/sign-pdf"
+			}
+			body, contentType := tt.body(t)
+			if contentType == "" {
+				contentType = tt.contentType
+			}
+			r := httptest.NewRequest(http.MethodPost, target, body)
+			r.Header.Set("Content-Type", contentType)
+
+			req, err := parseSignPDFRequest(r)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, req)
+		})
+	}
+}
+
+func TestPDFFileName(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultName string
+		want        string
+	}{
+		{name: "", defaultName: "signed.pdf", want: "signed.pdf"},
+		{name: "report", defaultName: "signed.pdf", want: "report.pdf"},
+		{name: "report.PDF", defaultName: "signed.pdf", want: "report.PDF"},
+		{name: "../../etc/passwd", defaultName: "signed.pdf", want: "passwd.pdf"},
+		{name: "/tmp/statements/march.pdf", defaultName: "signed.pdf", want: "march.pdf"},
+		{name: "invoice 42.pdf", defaultName: "signed.pdf", want: "invoice 42.pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, pdfFileName(tt.name, tt.defaultName))
+		})
+	}
+}
+
+func TestVerifyPDFBodyLimit(t *testing.T) {
+	viper.Set("sign_pdf.max_upload_mb", 1)
+	defer viper.Set("sign_pdf.max_upload_mb", nil)
+
+	body := `{"input_file_bytes": "` + strings.Repeat("A", 2<<20) + `"}`
+	w := httptest.NewRecorder()
+	(&EspressoService{}).VerifyPDF(w, httptest.NewRequest(http.MethodPost, "/verify-pdf", strings.NewReader(body)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestPDFOperationsBodyLimit(t *testing.T) {
+	viper.Set("pdf_operations.max_upload_mb", 1)
+	defer viper.Set("pdf_operations.max_upload_mb", nil)
+
+	s := &EspressoService{}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		want    int
+	}{
+		{name: "merge_too_large", handler: s.MergePDF, body: `{"documents": [{"file_bytes": "` + strings.Repeat("A", 2<<20) + `"}]}`, want: http.StatusRequestEntityTooLarge},
+		{name: "merge_invalid", handler: s.MergePDF, body: `{"documents": `, want: http.StatusBadRequest},
+		{name: "split_too_large", handler: s.SplitPDF, body: `{"input_file_bytes": "` + strings.Repeat("A", 2<<20) + `"}`, want: http.StatusRequestEntityTooLarge},
+		{name: "watermark_too_large", handler: s.WatermarkPDF, body: `{"input_file_bytes": "` + strings.Repeat("A", 2<<20) + `"}`, want: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
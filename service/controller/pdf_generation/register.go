@@ -1,19 +1,25 @@
 package pdf_generation
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/rchougule/espresso/lib/jobqueue"
 	"github.com/rchougule/espresso/lib/s3"
 	"github.com/rchougule/espresso/lib/templatestore"
 	"github.com/spf13/viper"
 )
 
+// multipartMemoryBytes is how much of a multipart upload is kept in memory before spilling to temp files
+const multipartMemoryBytes = 32 << 20
+
 type EspressoService struct {
 	TemplateStorageAdapter *templatestore.StorageAdapter
 	FileStorageAdapter     *templatestore.StorageAdapter
+	JobQueue               *jobqueue.Queue
 }
 
 func NewEspressoService() (*EspressoService, error) {
@@ -43,11 +49,19 @@ func NewEspressoService() (*EspressoService, error) {
 			SecretAccessKey: viper.GetString("aws.secretAccessKey"),
 			SessionToken:    viper.GetString("aws.sessionToken"),
 		},
-		MysqlDSN: viper.GetString("mysql.dsn"), // for mysql adapter
+		MysqlDSN:    viper.GetString("mysql.dsn"),                      // for mysql adapter
+		DiskRootDir: viper.GetString("template_storage.disk_root_dir"), // for disk adapter
 	})
 	if err != nil {
 		return nil, err
 	}
+	if viper.GetBool("template_cache.enabled") {
+		cachedStorage := templatestore.NewCachedStorage(templateStorageAdapter)
+		cachedStorage.TTL = viper.GetDuration("template_cache.ttl")
+		cachedStorage.MaxEntries = viper.GetInt("template_cache.max_entries")
+		cachedStorage.RevalidateInterval = viper.GetDuration("template_cache.revalidate_interval")
+		templateStorageAdapter = cachedStorage
+	}
 
 	fileStorageAdapter, err := templatestore.TemplateStorageAdapterFactory(&templatestore.StorageConfig{
 		StorageType: viper.GetString("file_storage.storage_type"),
@@ -56,13 +70,17 @@ func NewEspressoService() (*EspressoService, error) {
 		return nil, err
 	}
 
-	return &EspressoService{TemplateStorageAdapter: &templateStorageAdapter, FileStorageAdapter: &fileStorageAdapter}, nil
+	espressoService := &EspressoService{TemplateStorageAdapter: &templateStorageAdapter, FileStorageAdapter: &fileStorageAdapter}
+	espressoService.JobQueue = espressoService.newJobQueue()
+
+	return espressoService, nil
 }
 func Register(mux *http.ServeMux) {
 	espressoService, err := NewEspressoService()
 	if err != nil {
 		log.Fatalf("Failed to initialize PDF service: %v", err)
 	}
+	espressoService.JobQueue.Start(context.Background())
 
 	// Register HTTP routes
 	// Register handlers with the mux
@@ -75,6 +93,23 @@ func Register(mux *http.ServeMux) {
 	mux.HandleFunc("/create-template", espressoService.CreateTemplate)
 	mux.HandleFunc("/list-templates", espressoService.GetAllTemplates)
 	mux.HandleFunc("/get-template", espressoService.GetTemplateById)
+	mux.HandleFunc("/update-template", espressoService.UpdateTemplate)
+	mux.HandleFunc("/delete-template", espressoService.DeleteTemplate)
+	mux.HandleFunc("/create-template-version", espressoService.CreateTemplateVersion)
+	mux.HandleFunc("/list-template-versions", espressoService.ListTemplateVersions)
+	mux.HandleFunc("/diff-template-versions", espressoService.DiffTemplateVersions)
+	mux.HandleFunc("/publish-template-version", espressoService.PublishTemplateVersion)
+	mux.HandleFunc("/rollback-template", espressoService.RollbackTemplate)
 	mux.HandleFunc("/generate-pdf", espressoService.GeneratePDF)
+	mux.HandleFunc("/generate-pdf-batch", espressoService.GeneratePDFBatch)
+	mux.HandleFunc("/sign-pdf", espressoService.SignPDF)
+	mux.HandleFunc("/timestamp-pdf", espressoService.TimestampPDF)
+	mux.HandleFunc("/verify-pdf", espressoService.VerifyPDF)
+	mux.HandleFunc("/merge-pdf", espressoService.MergePDF)
+	mux.HandleFunc("/split-pdf", espressoService.SplitPDF)
+	mux.HandleFunc("/watermark-pdf", espressoService.WatermarkPDF)
+	mux.HandleFunc("/jobs", espressoService.CreateJob)
+	mux.HandleFunc("/jobs/status", espressoService.GetJobStatus)
+	mux.HandleFunc("/jobs/result", espressoService.GetJobResult)
 
 }
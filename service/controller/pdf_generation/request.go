@@ -3,6 +3,7 @@ package pdf_generation
 import (
 	"encoding/json"
 
+	"github.com/rchougule/espresso/lib/signer"
 	"github.com/rchougule/espresso/service/internal/service/generateDoc"
 )
 
@@ -15,6 +16,25 @@ type GeneratePDFRequest struct {
 	Viewport          *generateDoc.ViewportConfig `json:"viewport"`
 	PdfParams         *generateDoc.PDFParams      `json:"pdf_params,omitempty"`
 	SignParams        *generateDoc.SignParams     `json:"sign_params,omitempty"`
+	// SignatureFields adds empty signature fields to the PDF, to be signed later by name
+	SignatureFields []generateDoc.SignatureFieldParams `json:"signature_fields,omitempty"`
+}
+
+// GeneratePDFBatchRequest generates one PDF per item from a single template. Sent as NDJSON, the first line
+// holds these fields without items and every following line is an item.
+type GeneratePDFBatchRequest struct {
+	InputFilePath     string `json:"input_file_path,omitempty"`
+	InputFileBytes    []byte `json:"input_file_bytes,omitempty"`
+	InputTemplateUuid string `json:"input_template_uuid,omitempty"`
+	// OutputFilePath is a text/template of the output path with .Index, .ID and the item content as .Data,
+	// e.g. "statements/{{.Data.customer_id}}.pdf"
+	OutputFilePath  string                             `json:"output_file_path,omitempty"`
+	Viewport        *generateDoc.ViewportConfig        `json:"viewport"`
+	PdfParams       *generateDoc.PDFParams             `json:"pdf_params,omitempty"`
+	SignParams      *generateDoc.SignParams            `json:"sign_params,omitempty"`
+	SignatureFields []generateDoc.SignatureFieldParams `json:"signature_fields,omitempty"`
+	Concurrency     int                                `json:"concurrency,omitempty"` // capped at the browser tab pool size
+	Items           []generateDoc.PDFBatchItem         `json:"items,omitempty"`
 }
 
 type GeneratePDFResponse struct {
@@ -30,6 +50,18 @@ type PDFRequest struct {
 	MarginInch   float64         `json:"margin_inch,omitempty"`
 	Filename     string          `json:"filename,omitempty"` // Optional filename for download
 	SignPdf      bool            `json:"sign_pdf,omitempty"`
+	// Optional signature metadata, only used when sign_pdf is true
+	SignParams *generateDoc.SignParams `json:"sign_params,omitempty"`
+	// Optional empty signature fields, to be signed later by name
+	SignatureFields []generateDoc.SignatureFieldParams `json:"signature_fields,omitempty"`
+	// Optional password protection, cannot be combined with sign_pdf
+	Encryption *generateDoc.EncryptionParams `json:"encryption,omitempty"`
+	// Optional PDF/A level for archiving, "2b" or "3b"
+	PdfA string `json:"pdf_a,omitempty"`
+	// Optional document properties and custom metadata
+	Metadata *generateDoc.MetadataParams `json:"metadata,omitempty"`
+	// Optional text or image watermarks drawn over the pages
+	Watermarks []generateDoc.WatermarkParams `json:"watermarks,omitempty"`
 }
 
 // PDFResponse represents the structure for successful responses
@@ -46,6 +78,7 @@ type SignPDFRequest struct {
 	InputFilePath  string                  `json:"input_file_path,omitempty"`
 	InputFileBytes []byte                  `json:"input_file_bytes,omitempty"`
 	OutputFilePath string                  `json:"output_file_path,omitempty"`
+	Filename       string                  `json:"filename,omitempty"` // Optional filename for download
 	SignParams     *generateDoc.SignParams `json:"sign_params,omitempty"`
 }
 
@@ -55,6 +88,46 @@ type SignPDFResponse struct {
 	Error           string `json:"error,omitempty"`
 }
 
+type MergePDFRequest struct {
+	Documents      []generateDoc.MergeDocumentParams `json:"documents"`
+	OutputFilePath string                            `json:"output_file_path,omitempty"`
+	Filename       string                            `json:"filename,omitempty"` // Optional filename for download
+	// Optional, signs the merged PDF when sign_pdf is true
+	SignParams *generateDoc.SignParams `json:"sign_params,omitempty"`
+}
+
+type WatermarkPDFRequest struct {
+	InputFilePath  string                        `json:"input_file_path,omitempty"`
+	InputFileBytes []byte                        `json:"input_file_bytes,omitempty"`
+	Watermarks     []generateDoc.WatermarkParams `json:"watermarks"`
+	OutputFilePath string                        `json:"output_file_path,omitempty"`
+	Filename       string                        `json:"filename,omitempty"` // Optional filename for download
+}
+
+type CreateJobRequest struct {
+	Type string `json:"type,omitempty"` // "pdf" by default
+	// Request is the body of the synchronous endpoint, e.g. of /generate-pdf for pdf jobs
+	Request    json.RawMessage `json:"request"`
+	WebhookURL string          `json:"webhook_url,omitempty"` // called with the outcome when the job finishes
+}
+
+type SplitPDFRequest struct {
+	InputFilePath  string   `json:"input_file_path,omitempty"`
+	InputFileBytes []byte   `json:"input_file_bytes,omitempty"`
+	Ranges         []string `json:"ranges,omitempty"` // e.g. ["1-2", "3-"], every page on its own when empty
+	OutputFilePath string   `json:"output_file_path,omitempty"`
+}
+
+type VerifyPDFRequest struct {
+	InputFilePath  string `json:"input_file_path,omitempty"`
+	InputFileBytes []byte `json:"input_file_bytes,omitempty"`
+}
+
+type VerifyPDFResponse struct {
+	Report *signer.VerifyReport `json:"report,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
 type GetAllTemplatesResponse struct {
 	TotalRecords int32                           `json:"total_records,omitempty"`
 	Data         []*generateDoc.TemplateListData `json:"data,omitempty"`
@@ -69,6 +142,8 @@ type GetTemplateByIdResponse struct {
 	TemplateHtml string `json:"template_html"`
 	Json         string `json:"json"`
 	TemplateName string `json:"template_name"`
+	Version      int    `json:"version,omitempty"`
+	ETag         string `json:"etag,omitempty"`
 	Error        string `json:"error,omitempty"`
 }
 
@@ -82,3 +157,38 @@ type CreateTemplateResponse struct {
 	TemplateId string `json:"template_id"`
 	Error      string `json:"error,omitempty"`
 }
+
+// CreateTemplateVersionRequest saves new content for a template as its next version
+type CreateTemplateVersionRequest struct {
+	TemplateId   string `json:"template_id"`
+	TemplateHtml string `json:"template_html"`
+	Json         string `json:"json"`
+	// Publish makes the new version the one used by requests that do not pin a version
+	Publish bool `json:"publish,omitempty"`
+}
+
+// PublishTemplateVersionRequest publishes a version of a template, for a rollback the version is optional
+type PublishTemplateVersionRequest struct {
+	TemplateId string `json:"template_id"`
+	Version    int    `json:"version,omitempty"`
+}
+
+// UpdateTemplateRequest changes a template by template_id, or by template_path with disk and S3 storage. Empty
+// fields keep their value. ETag is the etag the template was read with, or "*", the If-Match header works as
+// well. One of them is required.
+type UpdateTemplateRequest struct {
+	TemplateId   string `json:"template_id,omitempty"`
+	TemplatePath string `json:"template_path,omitempty"`
+	TemplateName string `json:"template_name,omitempty"`
+	TemplateHtml string `json:"template_html,omitempty"`
+	Json         string `json:"json,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+}
+
+// DeleteTemplateRequest soft deletes a template by template_id, or by template_path with disk and S3 storage.
+// Like for updates an etag is required.
+type DeleteTemplateRequest struct {
+	TemplateId   string `json:"template_id,omitempty"`
+	TemplatePath string `json:"template_path,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+}
@@ -0,0 +1,307 @@
+package pdf_generation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rchougule/espresso/lib/templatestore"
+	"github.com/rchougule/espresso/service/internal/pkg/httppkg"
+	"github.com/rchougule/espresso/service/internal/service/generateDoc"
+)
+
+// CreateTemplateVersion saves new content for a template as its next version. Versions never change, the
+// template keeps using its published version unless publish is set.
+func (s *EspressoService) CreateTemplateVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+
+	req := &CreateTemplateVersionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		fmt.Println("error decoding request body :: ", err)
+		httppkg.RespondWithError(w, "Error decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.TemplateId == "" {
+		httppkg.RespondWithError(w, "Template ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.TemplateHtml == "" {
+		httppkg.RespondWithError(w, "Template HTML is required", http.StatusBadRequest)
+		return
+	}
+
+	jsonSchema := req.Json
+	if jsonSchema == "" {
+		jsonSchema = "{}"
+	}
+
+	version, err := (*s.TemplateStorageAdapter).CreateTemplateVersion(ctx, &templatestore.CreateTemplateVersionRequest{
+		TemplateUUID: req.TemplateId,
+		TemplateHTML: req.TemplateHtml,
+		TemplateJSON: jsonSchema,
+		Publish:      req.Publish,
+	})
+	if err != nil {
+		fmt.Println("error creating template version :: ", err)
+		httppkg.RespondWithError(w, "Failed to create template version: "+err.Error(), templateErrorStatus(err))
+		return
+	}
+
+	fmt.Printf("created version %d of template %s, published :: %t\n", version, req.TemplateId, req.Publish)
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "Template version created successfully",
+		},
+		"template_id": req.TemplateId,
+		"version":     version,
+		"published":   req.Publish,
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// ListTemplateVersions lists the versions of the template of the template_id query parameter, newest first
+func (s *EspressoService) ListTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	templateID := r.URL.Query().Get("template_id")
+	if templateID == "" {
+		httppkg.RespondWithError(w, "Template ID is required", http.StatusBadRequest)
+		return
+	}
+
+	versions, err := (*s.TemplateStorageAdapter).ListTemplateVersions(ctx, &templatestore.ListTemplateVersionsRequest{
+		TemplateUUID: templateID,
+	})
+	if err != nil {
+		fmt.Println("error listing template versions :: ", err)
+		httppkg.RespondWithError(w, "Failed to list template versions: "+err.Error(), templateErrorStatus(err))
+		return
+	}
+
+	var versionDataList []*generateDoc.TemplateVersionData
+	for _, version := range versions {
+		versionData := &generateDoc.TemplateVersionData{
+			Version:   version.Version,
+			Published: version.Published,
+		}
+		if !version.CreatedAt.IsZero() {
+			versionData.CreatedAt = version.CreatedAt.Format(time.RFC3339)
+		}
+		if !version.PublishedAt.IsZero() {
+			versionData.PublishedAt = version.PublishedAt.Format(time.RFC3339)
+		}
+
+		versionDataList = append(versionDataList, versionData)
+	}
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "Template versions retrieved successfully",
+		},
+		"template_id":   templateID,
+		"total_records": len(versionDataList),
+		"data":          versionDataList,
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// DiffTemplateVersions returns the unified diff of the HTML and JSON of two versions of a template. The
+// from query parameter is required, to defaults to the published version.
+func (s *EspressoService) DiffTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	templateID := query.Get("template_id")
+	if templateID == "" {
+		httppkg.RespondWithError(w, "Template ID is required", http.StatusBadRequest)
+		return
+	}
+	fromVersion, err := strconv.Atoi(query.Get("from"))
+	if err != nil || fromVersion < 1 {
+		httppkg.RespondWithError(w, "from must be a template version", http.StatusBadRequest)
+		return
+	}
+	toVersion := 0
+	if to := query.Get("to"); to != "" {
+		toVersion, err = strconv.Atoi(to)
+		if err != nil || toVersion < 1 {
+			httppkg.RespondWithError(w, "to must be a template version", http.StatusBadRequest)
+			return
+		}
+	}
+
+	from, err := (*s.TemplateStorageAdapter).GetTemplateContent(ctx, &templatestore.GetTemplateContentRequest{
+		TemplateUUID: templatestore.TemplateRef(templateID, fromVersion),
+	})
+	if err != nil {
+		fmt.Println("error getting template content :: ", err)
+		httppkg.RespondWithError(w, "Failed to get template content: "+err.Error(), templateErrorStatus(err))
+		return
+	}
+	to, err := (*s.TemplateStorageAdapter).GetTemplateContent(ctx, &templatestore.GetTemplateContentRequest{
+		TemplateUUID: templatestore.TemplateRef(templateID, toVersion),
+	})
+	if err != nil {
+		fmt.Println("error getting template content :: ", err)
+		httppkg.RespondWithError(w, "Failed to get template content: "+err.Error(), templateErrorStatus(err))
+		return
+	}
+
+	fromName := templatestore.TemplateRef(templateID, from.Version)
+	toName := templatestore.TemplateRef(templateID, to.Version)
+	htmlDiff, err := templatestore.UnifiedDiff(fromName, toName, from.TemplateContent, to.TemplateContent)
+	if err != nil {
+		fmt.Println("error diffing template html :: ", err)
+		httppkg.RespondWithError(w, "Failed to diff template versions: "+err.Error(), templateErrorStatus(err))
+		return
+	}
+	jsonDiff, err := templatestore.UnifiedDiff(fromName, toName, from.TemplateJsonSchema, to.TemplateJsonSchema)
+	if err != nil {
+		fmt.Println("error diffing template json :: ", err)
+		httppkg.RespondWithError(w, "Failed to diff template versions: "+err.Error(), templateErrorStatus(err))
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "Template versions compared successfully",
+		},
+		"template_id":  templateID,
+		"from_version": from.Version,
+		"to_version":   to.Version,
+		"html_diff":    htmlDiff,
+		"json_diff":    jsonDiff,
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// PublishTemplateVersion makes a version the one used by requests that do not pin a version
+func (s *EspressoService) PublishTemplateVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &PublishTemplateVersionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		fmt.Println("error decoding request body :: ", err)
+		httppkg.RespondWithError(w, "Error decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.TemplateId == "" {
+		httppkg.RespondWithError(w, "Template ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.Version < 1 {
+		httppkg.RespondWithError(w, "version is required", http.StatusBadRequest)
+		return
+	}
+
+	s.publishTemplateVersion(w, r, req.TemplateId, req.Version, "Template version published successfully")
+}
+
+// RollbackTemplate publishes an older version of a template: the given version, or without one the newest
+// version older than the published one
+func (s *EspressoService) RollbackTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+
+	req := &PublishTemplateVersionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		fmt.Println("error decoding request body :: ", err)
+		httppkg.RespondWithError(w, "Error decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.TemplateId == "" {
+		httppkg.RespondWithError(w, "Template ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Version == 0 {
+		versions, err := (*s.TemplateStorageAdapter).ListTemplateVersions(ctx, &templatestore.ListTemplateVersionsRequest{
+			TemplateUUID: req.TemplateId,
+		})
+		if err != nil {
+			fmt.Println("error listing template versions :: ", err)
+			httppkg.RespondWithError(w, "Failed to list template versions: "+err.Error(), templateErrorStatus(err))
+			return
+		}
+
+		// Versions are listed newest first
+		for i, version := range versions {
+			if version.Published && i+1 < len(versions) {
+				req.Version = versions[i+1].Version
+				break
+			}
+		}
+		if req.Version == 0 {
+			httppkg.RespondWithError(w, "No version older than the published one", http.StatusConflict)
+			return
+		}
+	}
+
+	s.publishTemplateVersion(w, r, req.TemplateId, req.Version, "Template rolled back successfully")
+}
+
+func (s *EspressoService) publishTemplateVersion(w http.ResponseWriter, r *http.Request, templateID string, version int, message string) {
+	err := (*s.TemplateStorageAdapter).PublishTemplateVersion(r.Context(), &templatestore.PublishTemplateVersionRequest{
+		TemplateUUID: templateID,
+		Version:      version,
+	})
+	if err != nil {
+		fmt.Println("error publishing template version :: ", err)
+		httppkg.RespondWithError(w, "Failed to publish template version: "+err.Error(), templateErrorStatus(err))
+		return
+	}
+
+	fmt.Printf("published version %d of template %s\n", version, templateID)
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": message,
+		},
+		"template_id":       templateID,
+		"published_version": version,
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// templateErrorStatus is the response status of a template storage error
+func templateErrorStatus(err error) int {
+	if errors.Is(err, templatestore.ErrTemplateNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, templatestore.ErrPreconditionFailed) {
+		return http.StatusPreconditionFailed
+	}
+	if errors.Is(err, templatestore.ErrInvalidTemplatePath) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, templatestore.ErrDiffTooLarge) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
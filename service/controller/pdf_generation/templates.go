@@ -0,0 +1,145 @@
+package pdf_generation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rchougule/espresso/lib/templatestore"
+	"github.com/rchougule/espresso/service/internal/pkg/httppkg"
+)
+
+// UpdateTemplate changes the content, name or JSON schema of a template. It requires the etag the template was
+// read with, from the body or the If-Match header, and fails with 412 when the template changed since. Without
+// one it fails with 428, "*" updates whatever the current version is.
+func (s *EspressoService) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &UpdateTemplateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		fmt.Println("error decoding request body :: ", err)
+		httppkg.RespondWithError(w, "Error decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.TemplateId == "" && req.TemplatePath == "" {
+		httppkg.RespondWithError(w, "Template ID or template path is required", http.StatusBadRequest)
+		return
+	}
+	if req.TemplateHtml == "" && req.TemplateName == "" && req.Json == "" {
+		httppkg.RespondWithError(w, "Nothing to update, template_html, template_name or json is required", http.StatusBadRequest)
+		return
+	}
+	etag, ok := ifMatch(r, req.ETag)
+	if !ok {
+		httppkg.RespondWithError(w, etagRequiredMessage, http.StatusPreconditionRequired)
+		return
+	}
+
+	newETag, err := (*s.TemplateStorageAdapter).UpdateTemplate(r.Context(), &templatestore.UpdateTemplateRequest{
+		TemplateUUID:   req.TemplateId,
+		TemplatePath:   req.TemplatePath,
+		TemplateS3Path: req.TemplatePath,
+		TemplateName:   req.TemplateName,
+		TemplateHTML:   req.TemplateHtml,
+		TemplateJSON:   req.Json,
+		IfMatch:        etag,
+	})
+	if err != nil {
+		fmt.Println("error updating template :: ", err)
+		httppkg.RespondWithError(w, "Failed to update template: "+err.Error(), templateErrorStatus(err))
+		return
+	}
+
+	fmt.Printf("updated template %s%s, etag :: %s\n", req.TemplateId, req.TemplatePath, newETag)
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "Template updated successfully",
+		},
+		"template_id":   req.TemplateId,
+		"template_path": req.TemplatePath,
+		"etag":          newETag,
+	}
+
+	w.Header().Set("ETag", `"`+newETag+`"`)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+// DeleteTemplate soft deletes a template, it is no longer found but kept in the storage. Like UpdateTemplate it
+// requires an etag, and fails with 412 when the template changed since it was read.
+func (s *EspressoService) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &DeleteTemplateRequest{}
+	if r.Method == http.MethodDelete && r.ContentLength <= 0 {
+		query := r.URL.Query()
+		req.TemplateId = query.Get("template_id")
+		req.TemplatePath = query.Get("template_path")
+	} else if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		fmt.Println("error decoding request body :: ", err)
+		httppkg.RespondWithError(w, "Error decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.TemplateId == "" && req.TemplatePath == "" {
+		httppkg.RespondWithError(w, "Template ID or template path is required", http.StatusBadRequest)
+		return
+	}
+	etag, ok := ifMatch(r, req.ETag)
+	if !ok {
+		httppkg.RespondWithError(w, etagRequiredMessage, http.StatusPreconditionRequired)
+		return
+	}
+
+	err := (*s.TemplateStorageAdapter).DeleteTemplate(r.Context(), &templatestore.DeleteTemplateRequest{
+		TemplateUUID:   req.TemplateId,
+		TemplatePath:   req.TemplatePath,
+		TemplateS3Path: req.TemplatePath,
+		IfMatch:        etag,
+	})
+	if err != nil {
+		fmt.Println("error deleting template :: ", err)
+		httppkg.RespondWithError(w, "Failed to delete template: "+err.Error(), templateErrorStatus(err))
+		return
+	}
+
+	fmt.Printf("deleted template %s%s\n", req.TemplateId, req.TemplatePath)
+
+	responseData := map[string]interface{}{
+		"status": map[string]string{
+			"status":  "success",
+			"message": "Template deleted successfully",
+		},
+		"template_id":   req.TemplateId,
+		"template_path": req.TemplatePath,
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responseData)
+}
+
+const etagRequiredMessage = "etag or If-Match header is required, read the template for its etag or send * to change it unconditionally"
+
+// ifMatch returns the etag of the body, or else of the If-Match header without its quotes, and whether either
+// was sent. A weak etag is compared as a strong one, "*" matches any existing template and is returned empty.
+func ifMatch(r *http.Request, etag string) (string, bool) {
+	if etag == "" {
+		etag = r.Header.Get("If-Match")
+	}
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if etag == "" {
+		return "", false
+	}
+	if etag == "*" {
+		return "", true
+	}
+	return strings.Trim(etag, `"`), true
+}
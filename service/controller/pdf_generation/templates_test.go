@@ -0,0 +1,51 @@
+package pdf_generation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateChangesRequireETag(t *testing.T) {
+	s := &EspressoService{}
+
+	w := httptest.NewRecorder()
+	s.UpdateTemplate(w, httptest.NewRequest(http.MethodPut, "/update-template", strings.NewReader(`{"template_id": "a", "template_html": "<p></p>"}`)))
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	w = httptest.NewRecorder()
+	s.DeleteTemplate(w, httptest.NewRequest(http.MethodDelete, "/delete-template?template_id=a", nil))
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		bodyETag string
+		header   string
+		want     string
+		wantOK   bool
+	}{
+		{name: "missing"},
+		{name: "body", bodyETag: "3", want: "3", wantOK: true},
+		{name: "body_wins", bodyETag: "3", header: `"4"`, want: "3", wantOK: true},
+		{name: "quoted_header", header: `"abc"`, want: "abc", wantOK: true},
+		{name: "weak_header", header: `W/"abc"`, want: "abc", wantOK: true},
+		{name: "any", header: "*", want: "", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/update-template", nil)
+			if tt.header != "" {
+				r.Header.Set("If-Match", tt.header)
+			}
+			got, ok := ifMatch(r, tt.bodyETag)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package generateDoc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rchougule/espresso/lib/renderer"
+	"github.com/rchougule/espresso/lib/templatestore"
+	"github.com/spf13/viper"
+)
+
+// Batch item statuses
+const (
+	BatchItemSucceeded = "succeeded"
+	BatchItemFailed    = "failed"
+)
+
+// GeneratePDFBatch generates one PDF per item of the batch from a single template, which is fetched and parsed
+// once. Items are rendered concurrently across the browser tab pool and stored in the file store at the path
+// of the output path template or of the item. A failing item does not stop the batch, every item gets a
+// result in req.Results, ordered by index. An error is returned when the batch cannot start or the items
+// cannot be read, items read until then are still generated.
+func GeneratePDFBatch(ctx context.Context, req *PDFBatchDto, templateStoreAdapter *templatestore.StorageAdapter, fileStoreAdapter *templatestore.StorageAdapter) error {
+	startTime := time.Now()
+	fmt.Println("GeneratePDFBatch called, req id :: ", req.ReqId)
+
+	var pathTemplate *template.Template
+	if req.OutputPathTemplate != "" {
+		var err error
+		pathTemplate, err = template.New("output_path").Option("missingkey=error").Parse(req.OutputPathTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid output path template: %v", err)
+		}
+	}
+
+	templateFile, err := renderer.GetTemplate(ctx, &templatestore.GetTemplateRequest{
+		TemplatePath:   req.InputTemplatePath,
+		TemplateS3Path: req.InputTemplatePath,
+		TemplateBytes:  req.InputFileBytes,
+		TemplateUUID:   req.InputTemplateUUID,
+	}, templateStoreAdapter)
+	if err != nil {
+		return fmt.Errorf("failed to get template: %v", err)
+	}
+
+	pdfParams := req.PdfParams
+	if pdfParams == nil {
+		pdfParams = &PDFParams{}
+	}
+
+	// More concurrent renders than tabs would only wait for a tab
+	tabPool := viper.GetInt("browser.tab_pool")
+	concurrency := req.Concurrency
+	if concurrency <= 0 || (tabPool > 0 && concurrency > tabPool) {
+		concurrency = tabPool
+	}
+	concurrency = max(concurrency, 1)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	addResult := func(result PDFBatchItemResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		req.Results = append(req.Results, result)
+	}
+
+	slots := make(chan struct{}, concurrency)
+	usedPaths := map[string]int{}
+	var readErr error
+
+	for index := 0; ; index++ {
+		item, err := req.NextItem()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("failed to read item %d: %v", index, err)
+			break
+		}
+
+		result := PDFBatchItemResult{Index: index, ID: item.ID, Status: BatchItemFailed}
+		outputPath, err := batchOutputPath(pathTemplate, index, item, usedPaths)
+		if err != nil {
+			result.Error = err.Error()
+			addResult(result)
+			continue
+		}
+		result.OutputFilePath = outputPath
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			result.Error = ctx.Err().Error()
+			addResult(result)
+			readErr = ctx.Err()
+		}
+		if readErr != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(result PDFBatchItemResult, content json.RawMessage) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			itemStartTime := time.Now()
+			if len(content) == 0 {
+				content = json.RawMessage(`{}`)
+			}
+			err := GeneratePDF(ctx, &PDFDto{
+				ReqId:              fmt.Sprintf("%s-%d", req.ReqId, result.Index),
+				Template:           templateFile,
+				OutputTemplatePath: result.OutputFilePath,
+				Content:            content,
+				ViewPort:           req.ViewPort,
+				PdfParams:          pdfParams,
+				SignParams:         req.SignParams,
+				SignatureFields:    req.SignatureFields,
+			}, templateStoreAdapter, fileStoreAdapter)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Status = BatchItemSucceeded
+			}
+			result.DurationMs = time.Since(itemStartTime).Milliseconds()
+			addResult(result)
+		}(result, item.Content)
+	}
+
+	wg.Wait()
+	sort.Slice(req.Results, func(i, j int) bool { return req.Results[i].Index < req.Results[j].Index })
+
+	fmt.Printf("batch %s generated %d pdfs in :: %s\n", req.ReqId, len(req.Results), time.Since(startTime))
+
+	return readErr
+}
+
+// batchOutputPath returns the output path of an item, its own or the one of the path template, and records it
+// in usedPaths. Paths cannot leave the directory they are in with "..", as parts of them come from the item
+// content, and a path used by an earlier item is refused.
+func batchOutputPath(pathTemplate *template.Template, index int, item *PDFBatchItem, usedPaths map[string]int) (string, error) {
+	outputPath := item.OutputFilePath
+	if outputPath == "" {
+		if pathTemplate == nil {
+			return "", fmt.Errorf("output_file_path is required without an output path template")
+		}
+
+		var data map[string]interface{}
+		if len(item.Content) > 0 {
+			if err := json.Unmarshal(item.Content, &data); err != nil {
+				return "", fmt.Errorf("invalid content: %v", err)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := pathTemplate.Execute(&buf, PDFBatchPathData{Index: index, ID: item.ID, Data: data}); err != nil {
+			return "", fmt.Errorf("failed to execute output path template: %v", err)
+		}
+		outputPath = strings.TrimSpace(buf.String())
+	}
+
+	if outputPath == "" {
+		return "", fmt.Errorf("output path is empty")
+	}
+	for _, element := range strings.Split(outputPath, "/") {
+		if element == ".." {
+			return "", fmt.Errorf("output path %s must not contain ..", outputPath)
+		}
+	}
+
+	outputPath = path.Clean(outputPath)
+	if previous, ok := usedPaths[outputPath]; ok {
+		return "", fmt.Errorf("output path %s is already used by item %d", outputPath, previous)
+	}
+	usedPaths[outputPath] = index
+
+	return outputPath, nil
+}
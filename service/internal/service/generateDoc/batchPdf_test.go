@@ -0,0 +1,88 @@
+package generateDoc
+
+import (
+	"encoding/json"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchOutputPath(t *testing.T) {
+	pathTemplate, err := template.New("output_path").Option("missingkey=error").Parse("statements/{{.Data.customer_id}}-{{.ID}}-{{.Index}}.pdf")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		pathTemplate *template.Template
+		item         PDFBatchItem
+		want         string
+		wantErr      string
+	}{
+		{
+			name:         "template",
+			pathTemplate: pathTemplate,
+			item:         PDFBatchItem{ID: "a", Content: json.RawMessage(`{"customer_id": 42}`)},
+			want:         "statements/42-a-3.pdf",
+		},
+		{
+			name:         "item_path_overrides_template",
+			pathTemplate: pathTemplate,
+			item:         PDFBatchItem{OutputFilePath: "out//b.pdf"},
+			want:         "out/b.pdf",
+		},
+		{
+			name: "item_path_without_template",
+			item: PDFBatchItem{OutputFilePath: "out/c.pdf"},
+			want: "out/c.pdf",
+		},
+		{
+			name:    "no_path",
+			item:    PDFBatchItem{Content: json.RawMessage(`{}`)},
+			wantErr: "output_file_path is required",
+		},
+		{
+			name:         "missing_key",
+			pathTemplate: pathTemplate,
+			item:         PDFBatchItem{ID: "a", Content: json.RawMessage(`{"name": "x"}`)},
+			wantErr:      "failed to execute output path template",
+		},
+		{
+			name:         "invalid_content",
+			pathTemplate: pathTemplate,
+			item:         PDFBatchItem{Content: json.RawMessage(`[1]`)},
+			wantErr:      "invalid content",
+		},
+		{
+			name:         "parent_from_content",
+			pathTemplate: pathTemplate,
+			item:         PDFBatchItem{ID: "a", Content: json.RawMessage(`{"customer_id": "../../etc"}`)},
+			wantErr:      "must not contain ..",
+		},
+		{
+			name:    "parent_from_item",
+			item:    PDFBatchItem{OutputFilePath: "out/../../d.pdf"},
+			wantErr: "must not contain ..",
+		},
+		{
+			name:    "duplicate",
+			item:    PDFBatchItem{OutputFilePath: "out/./used.pdf"},
+			wantErr: "already used by item 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usedPaths := map[string]int{"out/used.pdf": 0}
+			got, err := batchOutputPath(tt.pathTemplate, 3, &tt.item, usedPaths)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, 3, usedPaths[got])
+		})
+	}
+}
@@ -1,5 +1,10 @@
 package generateDoc
 
+import (
+	"encoding/json"
+	"text/template"
+)
+
 type PDFDto struct {
 	ReqId              string
 	InputTemplatePath  string
@@ -10,7 +15,53 @@ type PDFDto struct {
 	ViewPort           *ViewportConfig
 	PdfParams          *PDFParams
 	SignParams         *SignParams
+	SignatureFields    []SignatureFieldParams
 	OutputFileBytes    []byte
+	SigningCertificate *SigningCertificateInfo
+	// Template is the parsed template when it is shared, e.g. by a batch, the input template is ignored then
+	Template *template.Template
+}
+
+// PDFBatchDto generates one PDF per item from a single template
+type PDFBatchDto struct {
+	ReqId             string
+	InputTemplatePath string
+	InputTemplateUUID string
+	InputFileBytes    []byte
+	// OutputPathTemplate is a text/template of the output path executed with PDFBatchPathData,
+	// e.g. "statements/{{.Data.customer_id}}.pdf"
+	OutputPathTemplate string
+	ViewPort           *ViewportConfig
+	PdfParams          *PDFParams
+	SignParams         *SignParams
+	SignatureFields    []SignatureFieldParams
+	// Concurrency is the number of PDFs rendered at the same time, at most and by default the browser tab pool size
+	Concurrency int
+	// NextItem returns the items in order and io.EOF after the last one
+	NextItem func() (*PDFBatchItem, error)
+	Results  []PDFBatchItemResult
+}
+
+type PDFBatchItem struct {
+	ID             string          `json:"id,omitempty"`
+	Content        json.RawMessage `json:"content"`
+	OutputFilePath string          `json:"output_file_path,omitempty"` // overrides the output path template
+}
+
+// PDFBatchPathData is what the output path template of a batch is executed with
+type PDFBatchPathData struct {
+	Index int // of the item, from 0
+	ID    string
+	Data  map[string]interface{} // the content of the item
+}
+
+type PDFBatchItemResult struct {
+	Index          int    `json:"index"`
+	ID             string `json:"id,omitempty"`
+	Status         string `json:"status"` // succeeded or failed
+	OutputFilePath string `json:"output_file_path,omitempty"`
+	Error          string `json:"error,omitempty"`
+	DurationMs     int64  `json:"duration_ms"`
 }
 
 type PDFMessageData struct {
@@ -38,12 +89,76 @@ type ImageMessage struct {
 	Data    ImageMessageData
 }
 type SignPDFDto struct {
+	ReqId              string
+	InputFilePath      string
+	InputFileBytes     []byte
+	OutputFilePath     string
+	OutputFileBytes    []byte
+	SignParams         *SignParams
+	SigningCertificate *SigningCertificateInfo
+}
+
+type MergePDFDto struct {
+	ReqId              string
+	Documents          []MergeDocumentParams
+	OutputFilePath     string
+	OutputFileBytes    []byte
+	SignParams         *SignParams
+	SigningCertificate *SigningCertificateInfo
+}
+
+// MergeDocumentParams is one input of a merge: a template rendered with content, like in
+// GeneratePDF, or a stored PDF read through the file storage
+type MergeDocumentParams struct {
+	TemplateUUID string          `json:"template_uuid,omitempty"`
+	TemplatePath string          `json:"template_path,omitempty"`
+	TemplateHtml string          `json:"template_html,omitempty"`
+	Content      json.RawMessage `json:"content,omitempty"`
+	ViewPort     *ViewportConfig `json:"viewport,omitempty"`
+	PdfParams    *PDFParams      `json:"pdf_params,omitempty"` // only the rendering settings apply
+	FilePath     string          `json:"file_path,omitempty"`
+	FileBytes    []byte          `json:"file_bytes,omitempty"`
+}
+
+type WatermarkPDFDto struct {
+	ReqId           string
+	InputFilePath   string
+	InputFileBytes  []byte
+	Watermarks      []WatermarkParams
+	OutputFilePath  string
+	OutputFileBytes []byte
+}
+
+type SplitPDFDto struct {
+	ReqId          string
+	InputFilePath  string
+	InputFileBytes []byte
+	Ranges         []string // page ranges like "1-3,5", every page on its own when empty
+	OutputFilePath string   // parts are stored as <name>_<part>.pdf next to it
+	Parts          []SplitPDFPart
+}
+
+type SplitPDFPart struct {
+	Pages           string `json:"pages"`
+	OutputFilePath  string `json:"output_file_path,omitempty"`
+	OutputFileBytes []byte `json:"output_file_bytes,omitempty"`
+}
+
+type TimestampPDFDto struct {
 	ReqId           string
 	InputFilePath   string
 	InputFileBytes  []byte
 	OutputFilePath  string
 	OutputFileBytes []byte
-	SignParams      *SignParams
+	CertConfigKey   string     // config section holding the tsa_* settings
+	TSA             *TSAParams // overrides the configured TSA
+	DigestAlgorithm string
+}
+
+type VerifyPDFDto struct {
+	ReqId          string
+	InputFilePath  string
+	InputFileBytes []byte
 }
 
 type PDFParams struct {
@@ -61,6 +176,46 @@ type PDFParams struct {
 	PaperWidth          float64 `json:"paper_width,omitempty"`
 	PaperHeight         float64 `json:"paper_height,omitempty"`
 	IsSinglePage        bool    `json:"is_single_page,omitempty"`
+	// Encryption password-protects the PDF, it cannot be combined with signing
+	Encryption *EncryptionParams `json:"encryption,omitempty"`
+	// PdfA converts the PDF to PDF/A for archiving, "2b" or "3b"
+	PdfA string `json:"pdf_a,omitempty"`
+	// Metadata replaces the document properties Chrome sets from the HTML
+	Metadata *MetadataParams `json:"metadata,omitempty"`
+	// Watermarks are drawn over the pages in order, e.g. "DRAFT" and a recipient id
+	Watermarks []WatermarkParams `json:"watermarks,omitempty"`
+}
+
+// WatermarkParams is a text and/or image watermark drawn over the pages of a PDF
+type WatermarkParams struct {
+	Text       string  `json:"text,omitempty"`        // lines separated by \n, Latin-1 only
+	Image      []byte  `json:"image,omitempty"`       // base64 JPEG or PNG, drawn above the text
+	FontSize   float64 `json:"font_size,omitempty"`   // points, 48 by default
+	Color      string  `json:"color,omitempty"`       // #RRGGBB, gray by default
+	Opacity    float64 `json:"opacity,omitempty"`     // 0 to 1, 0.3 by default
+	Rotation   float64 `json:"rotation,omitempty"`    // degrees counterclockwise, e.g. 45
+	Position   string  `json:"position,omitempty"`    // center, top, bottom, top-left, top-right, bottom-left, bottom-right
+	Margin     float64 `json:"margin,omitempty"`      // distance to the page edges in points, 36 by default
+	ImageWidth float64 `json:"image_width,omitempty"` // points, one point per pixel by default
+	Pages      string  `json:"pages,omitempty"`       // e.g. "1-3,5", every page when empty
+}
+
+// MetadataParams are written to the Info dictionary and the XMP metadata of a generated PDF
+type MetadataParams struct {
+	Title    string            `json:"title,omitempty"`
+	Author   string            `json:"author,omitempty"`
+	Subject  string            `json:"subject,omitempty"`
+	Keywords string            `json:"keywords,omitempty"`
+	Creator  string            `json:"creator,omitempty"`
+	Producer string            `json:"producer,omitempty"`
+	Custom   map[string]string `json:"custom,omitempty"` // e.g. customer_id and document_type for indexing
+}
+
+// EncryptionParams encrypts a generated PDF with AES-256
+type EncryptionParams struct {
+	UserPassword  string   `json:"user_password,omitempty"`  // needed to open the PDF, e.g. the customer's date of birth or PAN
+	OwnerPassword string   `json:"owner_password,omitempty"` // lifts the permissions, random when empty
+	Permissions   []string `json:"permissions,omitempty"`    // print, print_high_quality, copy, modify, annotate, fill_forms, extract, assemble; omitted allows all
 }
 type ViewportConfig struct {
 	Width             int32   `json:"width,omitempty"`
@@ -70,13 +225,71 @@ type ViewportConfig struct {
 }
 
 type SignParams struct {
-	SignPdf       bool   `json:"sign_pdf,omitempty"`
-	CertConfigKey string `json:"cert_config_key,omitempty"`
+	SignPdf         bool       `json:"sign_pdf,omitempty"`
+	CertConfigKey   string     `json:"cert_config_key,omitempty"`
+	Name            string     `json:"name,omitempty"`
+	Location        string     `json:"location,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+	ContactInfo     string     `json:"contact_info,omitempty"`
+	CertType        string     `json:"cert_type,omitempty"`        // certification (default), approval or timestamp
+	DocMDPPerm      int        `json:"docmdp_perm,omitempty"`      // 1: no changes, 2: form filling (default), 3: form filling and annotations
+	DigestAlgorithm string     `json:"digest_algorithm,omitempty"` // sha256 (default), sha384, sha512 or sha1
+	TSA             *TSAParams `json:"tsa,omitempty"`
+	Profile         string     `json:"profile,omitempty"` // pkcs7 (default), pades-b-b, pades-b-t, pades-b-lt or pades-b-lta
+	// FieldName signs the empty signature field of that name, e.g. one added with signature_fields
+	FieldName string `json:"field_name,omitempty"`
+	// Appearance makes the signature visible, omit it for an invisible signature
+	Appearance *AppearanceParams `json:"appearance,omitempty"`
+}
+
+type AppearanceParams struct {
+	Page       uint32    `json:"page,omitempty"`   // 1 based, defaults to the first page
+	Rect       []float64 `json:"rect,omitempty"`   // [lower-left x, lower-left y, upper-right x, upper-right y] in points
+	Anchor     string    `json:"anchor,omitempty"` // top-left, top-right, bottom-left, bottom-right or center, used instead of rect
+	Width      float64   `json:"width,omitempty"`  // stamp size for anchor placement, in points
+	Height     float64   `json:"height,omitempty"`
+	Margin     float64   `json:"margin,omitempty"`
+	Text       []string  `json:"text,omitempty"` // defaults to signer name, date and reason
+	Font       string    `json:"font,omitempty"` // standard 14 font name, defaults to Helvetica
+	FontSize   float64   `json:"font_size,omitempty"`
+	ImageBytes []byte    `json:"image_bytes,omitempty"` // JPEG or PNG logo, defaults to the stamp_image_filepath of the certificate config
+}
+
+// SignatureFieldParams is an empty signature field added to a generated PDF, to be signed later by name
+type SignatureFieldParams struct {
+	Name string    `json:"name"`
+	Page uint32    `json:"page,omitempty"` // 1 based, defaults to the first page
+	Rect []float64 `json:"rect,omitempty"` // [lower-left x, lower-left y, upper-right x, upper-right y] in points, omit for an invisible field
+}
+
+// SigningCertificateInfo identifies the credentials a document was signed with
+type SigningCertificateInfo struct {
+	CertConfigKey string `json:"cert_config_key"`
+	SerialNumber  string `json:"serial_number"` // hex
+	Subject       string `json:"subject"`
+	NotAfter      string `json:"not_after"` // RFC 3339
+}
+
+// TSAParams chooses the timestamp authority of a request, its URLs must be allowed, see requestTSA
+type TSAParams struct {
+	URL          string   `json:"url,omitempty"`
+	FallbackURLs []string `json:"fallback_urls,omitempty"` // tried in order when url fails
+	Username     string   `json:"username,omitempty"`
+	Password     string   `json:"password,omitempty"`
+	BearerToken  string   `json:"bearer_token,omitempty"`
 }
 
 type TemplateListData struct {
-	TemplateId   string `json:"template_id,omitempty"`
-	TemplateName string `json:"template_name,omitempty"`
-	CreatedAt    string `json:"created_at,omitempty"`
-	UpdatedAt    string `json:"updated_at,omitempty"`
+	TemplateId       string `json:"template_id,omitempty"`
+	TemplateName     string `json:"template_name,omitempty"`
+	CreatedAt        string `json:"created_at,omitempty"`
+	UpdatedAt        string `json:"updated_at,omitempty"`
+	PublishedVersion int    `json:"published_version,omitempty"`
+}
+
+type TemplateVersionData struct {
+	Version     int    `json:"version"`
+	Published   bool   `json:"published"`
+	CreatedAt   string `json:"created_at,omitempty"`
+	PublishedAt string `json:"published_at,omitempty"`
 }
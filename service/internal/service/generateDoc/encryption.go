@@ -0,0 +1,46 @@
+package generateDoc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rchougule/espresso/lib/pdfops"
+)
+
+var permissionNames = map[string]pdfops.Permission{
+	"print":              pdfops.PermissionPrint,
+	"print_high_quality": pdfops.PermissionPrint | pdfops.PermissionPrintHighQuality,
+	"copy":               pdfops.PermissionCopy,
+	"modify":             pdfops.PermissionModify,
+	"annotate":           pdfops.PermissionAnnotate,
+	"fill_forms":         pdfops.PermissionFillForms,
+	"extract":            pdfops.PermissionExtract,
+	"assemble":           pdfops.PermissionAssemble,
+}
+
+// buildEncryption maps the request params to pdfops encryption settings. No
+// permissions allows everything, the passwords then only protect opening.
+func buildEncryption(params *EncryptionParams) (*pdfops.Encryption, error) {
+	if params.UserPassword == "" && params.OwnerPassword == "" && params.Permissions == nil {
+		return nil, fmt.Errorf("a user password, owner password or permissions are required")
+	}
+
+	encryption := &pdfops.Encryption{
+		UserPassword:  params.UserPassword,
+		OwnerPassword: params.OwnerPassword,
+		Permissions:   pdfops.PermissionAll,
+	}
+
+	if params.Permissions != nil {
+		encryption.Permissions = 0
+		for _, name := range params.Permissions {
+			permission, ok := permissionNames[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown permission %q", name)
+			}
+			encryption.Permissions |= permission
+		}
+	}
+
+	return encryption, nil
+}
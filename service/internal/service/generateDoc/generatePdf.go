@@ -3,26 +3,27 @@ package generateDoc
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/rchougule/espresso/lib/browser_manager"
 	"github.com/rchougule/espresso/lib/certmanager"
+	"github.com/rchougule/espresso/lib/pdfops"
 	"github.com/rchougule/espresso/lib/renderer"
 	"github.com/rchougule/espresso/lib/signer"
 	"github.com/rchougule/espresso/lib/templatestore"
 	"github.com/rchougule/espresso/lib/workerpool"
-	"github.com/spf13/viper"
 
 	"github.com/go-rod/rod/lib/proto"
 )
 
-// GeneratePDF generates a PDF from the provided content and stores it in the provided file store.
-// If signing is enabled, it will load the signing credentials in parallel and sign the PDF before storing it.
-// The generated PDF is stored in the file store with the provided output file path.
-// The function returns an error if anything goes wrong during generation, signing, or storage of the PDF.
+// GeneratePDF renders the template with the provided content, applies the requested steps in the order
+// metadata → watermarks → PDF/A → signature fields → encryption → sign, and stores the PDF at the output path.
 func GeneratePDF(ctx context.Context, req *PDFDto, templateStoreAdapter *templatestore.StorageAdapter, fileStoreAdapter *templatestore.StorageAdapter) error {
 
 	startTime := time.Now()
@@ -43,18 +44,66 @@ func GeneratePDF(ctx context.Context, req *PDFDto, templateStoreAdapter *templat
 	if req.SignParams != nil && req.SignParams.SignPdf {
 		toBeSigned = true
 	}
+	signatureFields, err := buildSignatureFields(req.SignatureFields)
+	if err != nil {
+		return fmt.Errorf("invalid signature fields: %v", err)
+	}
+
+	var encryption *pdfops.Encryption
+	if pdfParams != nil && pdfParams.Encryption != nil {
+		// The signature dictionary would have to be encrypted as well
+		if toBeSigned {
+			return fmt.Errorf("encryption cannot be combined with signing")
+		}
+		encryption, err = buildEncryption(pdfParams.Encryption)
+		if err != nil {
+			return fmt.Errorf("invalid encryption params: %v", err)
+		}
+	}
+
+	var pdfA pdfops.PDFA
+	if pdfParams != nil && pdfParams.PdfA != "" {
+		pdfA = pdfops.PDFA(strings.ToLower(pdfParams.PdfA))
+		if pdfA != pdfops.PDFA2B && pdfA != pdfops.PDFA3B {
+			return fmt.Errorf("invalid pdf_a level %q, expected 2b or 3b", pdfParams.PdfA)
+		}
+		if encryption != nil {
+			return fmt.Errorf("pdf_a cannot be combined with encryption")
+		}
+	}
+
+	var watermarks []pdfops.Watermark
+	if pdfParams != nil && len(pdfParams.Watermarks) > 0 {
+		watermarks, err = buildWatermarks(pdfParams.Watermarks)
+		if err != nil {
+			return fmt.Errorf("invalid watermarks: %v", err)
+		}
+		for _, watermark := range watermarks {
+			// Helvetica is not embedded, which PDF/A requires
+			if pdfA != "" && watermark.Text != "" {
+				return fmt.Errorf("pdf_a cannot be combined with text watermarks")
+			}
+		}
+	}
+
+	var signData signer.SignData
 	if toBeSigned {
-		certConfig := &certmanager.CertificateConfig{
-			CertFilePath: viper.GetString(req.SignParams.CertConfigKey + ".cert_filepath"),
-			KeyFilePath:  viper.GetString(req.SignParams.CertConfigKey + ".key_filepath"),
-			KeyPassword:  viper.GetString(req.SignParams.CertConfigKey + ".key_password"),
+		signData, err = buildSignData(req.SignParams)
+		if err != nil {
+			return fmt.Errorf("invalid sign params: %v", err)
+		}
+		// The stamp text is drawn in a standard 14 font, which is not embedded either
+		if pdfA != "" && signData.Appearance.Visible {
+			return fmt.Errorf("pdf_a cannot be combined with a visible signature appearance")
 		}
+
+		certConfig := certificateConfig(req.SignParams.CertConfigKey)
 		credWg.Add(1)
-		err := workerpool.Pool().SubmitTask(
+		err = workerpool.Pool().SubmitTask(
 			func(args ...interface{}) {
 				defer credWg.Done()
 				ctxArg := args[0].(context.Context)
-				credentials, credErr = certmanager.LoadSigningCredentials(ctxArg, certConfig)
+				credentials, credErr = getCredentialCache().Get(ctxArg, req.SignParams.CertConfigKey, certConfig)
 			},
 			ctx,
 		)
@@ -78,6 +127,7 @@ func GeneratePDF(ctx context.Context, req *PDFDto, templateStoreAdapter *templat
 			TemplateBytes:  req.InputFileBytes,
 			TemplateUUID:   req.InputTemplateUUID,
 		},
+		Template:     req.Template,
 		Data:         content,
 		ViewPort:     viewPort,
 		PdfParams:    pdfSettings,
@@ -93,6 +143,47 @@ func GeneratePDF(ctx context.Context, req *PDFDto, templateStoreAdapter *templat
 	duration := time.Since(startTime)
 	fmt.Println("pdf stream received at :: ", duration)
 
+	var renderedPDF io.Reader = pdf
+	if pdfParams != nil && pdfParams.Metadata != nil {
+		withMetadata, err := pdfops.SetMetadata(ctx, renderedPDF, buildMetadata(pdfParams.Metadata))
+		if err != nil {
+			return fmt.Errorf("failed to set pdf metadata: %v", err)
+		}
+		renderedPDF = bytes.NewReader(withMetadata)
+	}
+
+	if len(watermarks) > 0 {
+		watermarkedPDF, err := pdfops.AddWatermarks(ctx, renderedPDF, watermarks...)
+		if err != nil {
+			return fmt.Errorf("failed to add watermarks: %v", err)
+		}
+		renderedPDF = bytes.NewReader(watermarkedPDF)
+	}
+
+	if pdfA != "" {
+		archivePDF, err := pdfops.ConvertToPDFA(ctx, renderedPDF, pdfA)
+		if err != nil {
+			return fmt.Errorf("failed to convert pdf to PDF/A: %v", err)
+		}
+		renderedPDF = bytes.NewReader(archivePDF)
+	}
+
+	if len(signatureFields) > 0 {
+		withFields, err := signer.AddSignatureFields(ctx, renderedPDF, signatureFields)
+		if err != nil {
+			return fmt.Errorf("failed to add signature fields: %v", err)
+		}
+		renderedPDF = bytes.NewReader(withFields)
+	}
+
+	if encryption != nil {
+		encryptedPDF, err := pdfops.Encrypt(ctx, renderedPDF, *encryption)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt pdf: %v", err)
+		}
+		renderedPDF = bytes.NewReader(encryptedPDF)
+	}
+
 	duration = time.Since(startTime)
 
 	if toBeSigned {
@@ -102,14 +193,21 @@ func GeneratePDF(ctx context.Context, req *PDFDto, templateStoreAdapter *templat
 			return fmt.Errorf("failed to load signing credentials: %v", credErr)
 		}
 
-		signedPDF, err := signer.SignPdfStream(ctx, pdf, credentials.Certificate, credentials.PrivateKey)
+		signData.Certificate = credentials.Certificate
+		signData.CertificateChains = [][]*x509.Certificate{credentials.CertificateChain}
+		signData.Signer = credentials.PrivateKey
+		req.SigningCertificate = signingCertificateInfo(req.SignParams.CertConfigKey, credentials)
+
+		signedFile, err := signToTempFile(ctx, renderedPDF, signData)
 		if err != nil {
-			return fmt.Errorf("failed to sign pdf using SignPdfStream: %v", err)
+			return fmt.Errorf("failed to sign pdf: %v", err)
 		}
+		defer os.Remove(signedFile.Name())
+		defer signedFile.Close()
 
-		pdfReader = bytes.NewReader(signedPDF)
+		pdfReader = signedFile
 	} else {
-		pdfReader = pdf
+		pdfReader = renderedPDF
 	}
 	fmt.Println("starting upload :: ", duration)
 	// Use the storage adapter to store the PDF
@@ -166,6 +264,18 @@ func createPdfSettingsFromParams(pdfParams *PDFParams) *proto.PagePrintToPDF {
 	return pdfSettings
 }
 
+func buildMetadata(params *MetadataParams) pdfops.Metadata {
+	return pdfops.Metadata{
+		Title:    params.Title,
+		Author:   params.Author,
+		Subject:  params.Subject,
+		Keywords: params.Keywords,
+		Creator:  params.Creator,
+		Producer: params.Producer,
+		Custom:   params.Custom,
+	}
+}
+
 func getViewPort(viewPort *ViewportConfig) *browser_manager.ViewportConfig {
 
 	viewSettings := &browser_manager.ViewportConfig{ // default viewport settings for A4 page
@@ -189,12 +299,14 @@ func getViewPort(viewPort *ViewportConfig) *browser_manager.ViewportConfig {
 	return viewSettings
 }
 
-func SignPDF(ctx context.Context, req *SignPDFDto, fileStoreAdapter *templatestore.StorageAdapter) error {
+// SignPDF reads the input PDF through inputStoreAdapter, signs it and stores the result through outputStoreAdapter.
+// The adapters can differ, e.g. an uploaded PDF (stream adapter) can be stored on disk or S3 and vice versa.
+func SignPDF(ctx context.Context, req *SignPDFDto, inputStoreAdapter *templatestore.StorageAdapter, outputStoreAdapter *templatestore.StorageAdapter) error {
 
 	reqId := req.ReqId
 	fmt.Println("SignPDF called, req id :: ", reqId)
 	// get input file stream
-	freader, err := (*fileStoreAdapter).GetDocument(ctx, &templatestore.GetDocumentRequest{
+	freader, err := (*inputStoreAdapter).GetDocument(ctx, &templatestore.GetDocumentRequest{
 		FilePath:       req.InputFilePath,
 		FileS3Path:     req.InputFilePath,
 		InputFileBytes: req.InputFileBytes,
@@ -202,24 +314,29 @@ func SignPDF(ctx context.Context, req *SignPDFDto, fileStoreAdapter *templatesto
 	if err != nil {
 		return fmt.Errorf("failed to get input file: %v", err)
 	}
+	if closer, ok := freader.(io.Closer); ok {
+		defer closer.Close()
+	}
 	// Start loading credentials in parallel if signing is enabled
 	var credWg sync.WaitGroup
 	var credErr error
 	var credentials *certmanager.SigningCredentials
 	var pdfReader io.Reader
 
+	var signData signer.SignData
 	if req.SignParams.SignPdf {
-		credWg.Add(1)
-		certConfig := &certmanager.CertificateConfig{
-			CertFilePath: viper.GetString(req.SignParams.CertConfigKey + ".cert_filepath"),
-			KeyFilePath:  viper.GetString(req.SignParams.CertConfigKey + ".key_filepath"),
-			KeyPassword:  viper.GetString(req.SignParams.CertConfigKey + ".key_password"),
+		signData, err = buildSignData(req.SignParams)
+		if err != nil {
+			return fmt.Errorf("invalid sign params: %v", err)
 		}
+
+		credWg.Add(1)
+		certConfig := certificateConfig(req.SignParams.CertConfigKey)
 		err := workerpool.Pool().SubmitTask(
 			func(args ...interface{}) {
 				defer credWg.Done()
 				ctxArg := args[0].(context.Context)
-				credentials, credErr = certmanager.LoadSigningCredentials(ctxArg, certConfig)
+				credentials, credErr = getCredentialCache().Get(ctxArg, req.SignParams.CertConfigKey, certConfig)
 			},
 			ctx,
 		)
@@ -234,13 +351,19 @@ func SignPDF(ctx context.Context, req *SignPDFDto, fileStoreAdapter *templatesto
 		if credErr != nil {
 			return fmt.Errorf("failed to load signing credentials: %v", credErr)
 		}
-		// convert pdfreader to *rod.StreamReader
-		signedPDF, err := signer.SignPdfStream(ctx, freader, credentials.Certificate, credentials.PrivateKey)
+		signData.Certificate = credentials.Certificate
+		signData.CertificateChains = [][]*x509.Certificate{credentials.CertificateChain}
+		signData.Signer = credentials.PrivateKey
+		req.SigningCertificate = signingCertificateInfo(req.SignParams.CertConfigKey, credentials)
+
+		signedFile, err := signToTempFile(ctx, freader, signData)
 		if err != nil {
-			return fmt.Errorf("failed to sign pdf using SignPdfStream: %v", err)
+			return fmt.Errorf("failed to sign pdf: %v", err)
 		}
+		defer os.Remove(signedFile.Name())
+		defer signedFile.Close()
 
-		pdfReader = bytes.NewReader(signedPDF)
+		pdfReader = signedFile
 	} else {
 		pdfReader = freader
 	}
@@ -251,7 +374,7 @@ func SignPDF(ctx context.Context, req *SignPDFDto, fileStoreAdapter *templatesto
 	}
 
 	// Upload the streaming data
-	resp, err := (*fileStoreAdapter).PutDocument(ctx, docReq, &pdfReader)
+	resp, err := (*outputStoreAdapter).PutDocument(ctx, docReq, &pdfReader)
 	if err != nil {
 		return fmt.Errorf("failed to store PDF: %v", err)
 	}
@@ -261,3 +384,53 @@ func SignPDF(ctx context.Context, req *SignPDFDto, fileStoreAdapter *templatesto
 
 	return nil
 }
+
+// signToTempFile signs input into a temporary file positioned at its start,
+// which the caller closes and removes. Inputs that cannot be read in place,
+// e.g. S3 streams, are spooled to a temporary file first, so large documents
+// are never held in memory.
+func signToTempFile(ctx context.Context, input io.Reader, signData signer.SignData) (*os.File, error) {
+	file, ok := input.(*os.File)
+	if !ok {
+		spooled, err := os.CreateTemp("", "espresso-sign-input-*.pdf")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %v", err)
+		}
+		defer os.Remove(spooled.Name())
+		defer spooled.Close()
+
+		if _, err := io.Copy(spooled, input); err != nil {
+			return nil, fmt.Errorf("failed to spool input: %v", err)
+		}
+		if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		file = spooled
+	}
+
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := os.CreateTemp("", "espresso-signed-*.pdf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+
+	err = signer.SignPdf(ctx, io.NewSectionReader(file, start, end-start), end-start, signed, signData)
+	if err == nil {
+		_, err = signed.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		signed.Close()
+		os.Remove(signed.Name())
+		return nil, err
+	}
+
+	return signed, nil
+}
@@ -0,0 +1,50 @@
+package generateDoc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGeneratePDFInvalidParams covers the combinations refused before anything is rendered
+func TestGeneratePDFInvalidParams(t *testing.T) {
+	viper.Set("digital_certificates.test.cert_filepath", "cert.pem")
+	defer viper.Set("digital_certificates.test", nil)
+
+	tests := []struct {
+		name    string
+		req     *PDFDto
+		wantErr string
+	}{
+		{
+			name: "encryption_signing",
+			req: &PDFDto{
+				PdfParams:  &PDFParams{Encryption: &EncryptionParams{UserPassword: "secret"}},
+				SignParams: &SignParams{SignPdf: true},
+			},
+			wantErr: "encryption cannot be combined with signing",
+		},
+		{
+			name:    "pdf_a_text_watermark",
+			req:     &PDFDto{PdfParams: &PDFParams{PdfA: "2b", Watermarks: []WatermarkParams{{Text: "DRAFT"}}}},
+			wantErr: "pdf_a cannot be combined with text watermarks",
+		},
+		{
+			name: "pdf_a_visible_signature",
+			req: &PDFDto{
+				PdfParams:  &PDFParams{PdfA: "3b"},
+				SignParams: &SignParams{SignPdf: true, CertConfigKey: "digital_certificates.test", Appearance: &AppearanceParams{Anchor: "bottom-right"}},
+			},
+			wantErr: "pdf_a cannot be combined with a visible signature appearance",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := GeneratePDF(context.Background(), tt.req, nil, nil)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package generateDoc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rchougule/espresso/lib/pdfops"
+	"github.com/rchougule/espresso/lib/renderer"
+	"github.com/rchougule/espresso/lib/signer"
+	"github.com/rchougule/espresso/lib/templatestore"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// MergePDF concatenates the request documents in order, rendering templates and reading stored PDFs through
+// fileStoreAdapter, and stores the result through outputStoreAdapter. Bookmarks and links of the inputs are kept.
+// With sign_params the merged PDF is signed as a whole, signatures of the inputs are dropped by the merge.
+func MergePDF(ctx context.Context, req *MergePDFDto, templateStoreAdapter *templatestore.StorageAdapter, fileStoreAdapter *templatestore.StorageAdapter, outputStoreAdapter *templatestore.StorageAdapter) error {
+	fmt.Println("MergePDF called, req id :: ", req.ReqId)
+
+	if len(req.Documents) == 0 {
+		return fmt.Errorf("no documents to merge")
+	}
+
+	toBeSigned := req.SignParams != nil && req.SignParams.SignPdf
+	var signData signer.SignData
+	var err error
+	if toBeSigned {
+		// Fail before rendering when the credentials are not usable
+		signData, req.SigningCertificate, err = loadSignData(ctx, req.SignParams)
+		if err != nil {
+			return err
+		}
+	}
+
+	inputs := make([]io.Reader, len(req.Documents))
+	for i, document := range req.Documents {
+		pdfBytes, err := mergeInput(ctx, &document, templateStoreAdapter, fileStoreAdapter)
+		if err != nil {
+			return fmt.Errorf("document %d: %v", i+1, err)
+		}
+		inputs[i] = bytes.NewReader(pdfBytes)
+	}
+
+	mergedPDF, err := pdfops.Merge(ctx, inputs...)
+	if err != nil {
+		return err
+	}
+
+	var pdfReader io.Reader = bytes.NewReader(mergedPDF)
+	if toBeSigned {
+		signedFile, err := signToTempFile(ctx, pdfReader, signData)
+		if err != nil {
+			return fmt.Errorf("failed to sign pdf: %v", err)
+		}
+		defer os.Remove(signedFile.Name())
+		defer signedFile.Close()
+
+		pdfReader = signedFile
+	}
+
+	docReq := &templatestore.PostDocumentRequest{
+		FilePath:   req.OutputFilePath,
+		FileS3Path: req.OutputFilePath,
+	}
+	resp, err := (*outputStoreAdapter).PutDocument(ctx, docReq, &pdfReader)
+	if err != nil {
+		return fmt.Errorf("failed to store PDF: %v", err)
+	}
+	if resp == "stream" {
+		req.OutputFileBytes = docReq.OutputFileBytes
+	}
+
+	return nil
+}
+
+// mergeInput returns the PDF of one merge input, rendered from its template or read from storage
+func mergeInput(ctx context.Context, document *MergeDocumentParams, templateStoreAdapter *templatestore.StorageAdapter, fileStoreAdapter *templatestore.StorageAdapter) ([]byte, error) {
+	if len(document.FileBytes) > 0 {
+		return document.FileBytes, nil
+	}
+
+	if document.FilePath != "" {
+		freader, err := (*fileStoreAdapter).GetDocument(ctx, &templatestore.GetDocumentRequest{
+			FilePath:   document.FilePath,
+			FileS3Path: document.FilePath,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get input file: %v", err)
+		}
+		if closer, ok := freader.(io.Closer); ok {
+			defer closer.Close()
+		}
+		return io.ReadAll(freader)
+	}
+
+	if document.TemplateUUID == "" && document.TemplatePath == "" && document.TemplateHtml == "" {
+		return nil, fmt.Errorf("a template or a file is required")
+	}
+
+	pdfSettings := &proto.PagePrintToPDF{}
+	isSinglePage := false
+	if document.PdfParams != nil {
+		pdfSettings = createPdfSettingsFromParams(document.PdfParams)
+		isSinglePage = document.PdfParams.IsSinglePage
+	}
+	content := document.Content
+	if len(content) == 0 {
+		content = []byte(`{}`)
+	}
+
+	pdf, err := renderer.GetHtmlPdf(ctx, &renderer.GetHtmlPdfInput{
+		TemplateRequest: templatestore.GetTemplateRequest{
+			TemplatePath:   document.TemplatePath,
+			TemplateS3Path: document.TemplatePath,
+			TemplateBytes:  []byte(document.TemplateHtml),
+			TemplateUUID:   document.TemplateUUID,
+		},
+		Data:         content,
+		ViewPort:     getViewPort(document.ViewPort),
+		PdfParams:    pdfSettings,
+		IsSinglePage: isSinglePage,
+	}, templateStoreAdapter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate pdf: %v", err)
+	}
+	defer pdf.Close()
+
+	return io.ReadAll(pdf)
+}
+
+// SplitPDF extracts the request page ranges of the input PDF into separate PDFs stored through outputStoreAdapter.
+// Bookmarks and links are kept for the pages of each part.
+func SplitPDF(ctx context.Context, req *SplitPDFDto, inputStoreAdapter *templatestore.StorageAdapter, outputStoreAdapter *templatestore.StorageAdapter) error {
+	fmt.Println("SplitPDF called, req id :: ", req.ReqId)
+
+	freader, err := (*inputStoreAdapter).GetDocument(ctx, &templatestore.GetDocumentRequest{
+		FilePath:       req.InputFilePath,
+		FileS3Path:     req.InputFilePath,
+		InputFileBytes: req.InputFileBytes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get input file: %v", err)
+	}
+	if closer, ok := freader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	parts, err := pdfops.Split(ctx, freader, req.Ranges)
+	if err != nil {
+		return err
+	}
+
+	outputBase := strings.TrimSuffix(req.OutputFilePath, path.Ext(req.OutputFilePath))
+	req.Parts = make([]SplitPDFPart, len(parts))
+	for i, part := range parts {
+		pages := fmt.Sprint(i + 1)
+		if len(req.Ranges) > 0 {
+			pages = req.Ranges[i]
+		}
+		req.Parts[i].Pages = pages
+
+		docReq := &templatestore.PostDocumentRequest{}
+		if req.OutputFilePath != "" {
+			docReq.FilePath = fmt.Sprintf("%s_%d.pdf", outputBase, i+1)
+			docReq.FileS3Path = docReq.FilePath
+		}
+
+		var pdfReader io.Reader = bytes.NewReader(part)
+		resp, err := (*outputStoreAdapter).PutDocument(ctx, docReq, &pdfReader)
+		if err != nil {
+			return fmt.Errorf("failed to store part %d: %v", i+1, err)
+		}
+		if resp == "stream" {
+			req.Parts[i].OutputFileBytes = docReq.OutputFileBytes
+		} else {
+			req.Parts[i].OutputFilePath = docReq.FilePath
+		}
+	}
+
+	return nil
+}
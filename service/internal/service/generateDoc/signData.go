@@ -0,0 +1,383 @@
+package generateDoc
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rchougule/espresso/lib/certmanager"
+	"github.com/rchougule/espresso/lib/signer"
+	"github.com/spf13/viper"
+)
+
+// certConfigKeyPrefix is the config section holding the signing certificates, cert_config_key must point inside it
+const certConfigKeyPrefix = "digital_certificates."
+
+var (
+	credentialCacheOnce sync.Once
+	credentialCache     *certmanager.CredentialCache
+
+	revocationClientOnce sync.Once
+	revocationClient     *signer.RevocationClient
+	revocationClientErr  error
+)
+
+// getCredentialCache returns the signing credentials cached per cert_config_key, built on first use. Rotated
+// certificate files are reloaded on use, and credentials older than credentials.max_age are refetched, e.g. the
+// certificate of a remote signer.
+func getCredentialCache() *certmanager.CredentialCache {
+	credentialCacheOnce.Do(func() {
+		credentialCache = certmanager.NewCredentialCache()
+		credentialCache.MaxAge = viper.GetDuration("credentials.max_age")
+	})
+	return credentialCache
+}
+
+// getRevocationClient builds the OCSP/CRL client shared by all requests from the revocation config
+// (timeout, proxy, cache_dir, max_age) on first use.
+func getRevocationClient() (*signer.RevocationClient, error) {
+	revocationClientOnce.Do(func() {
+		revocationClient, revocationClientErr = signer.NewRevocationClient(signer.RevocationClientConfig{
+			Timeout:  viper.GetDuration("revocation.timeout"),
+			Proxy:    viper.GetString("revocation.proxy"),
+			CacheDir: viper.GetString("revocation.cache_dir"),
+			MaxAge:   viper.GetDuration("revocation.max_age"),
+		})
+	})
+	return revocationClient, revocationClientErr
+}
+
+// buildSignData maps the request sign params to the signer input, the certificate and key are set once loaded.
+// TSA settings not present in the request are read from the certificate config, see tsaConfig, a TSA of the
+// request must be allowed by requestTSA.
+func buildSignData(params *SignParams) (signer.SignData, error) {
+	if !strings.HasPrefix(params.CertConfigKey, certConfigKeyPrefix) || !viper.IsSet(params.CertConfigKey) {
+		return signer.SignData{}, fmt.Errorf("unknown cert_config_key %q", params.CertConfigKey)
+	}
+
+	certType, err := parseCertType(params.CertType)
+	if err != nil {
+		return signer.SignData{}, err
+	}
+
+	docMDPPerm := signer.AllowFillingExistingFormFieldsAndSignaturesPerms
+	if params.DocMDPPerm != 0 {
+		docMDPPerm = signer.DocMDPPerm(params.DocMDPPerm)
+		if docMDPPerm < signer.DoNotAllowAnyChangesPerms || docMDPPerm > signer.AllowFillingExistingFormFieldsAndSignaturesAndCRUDAnnotationsPerms {
+			return signer.SignData{}, fmt.Errorf("invalid docmdp_perm %d, expected 1, 2 or 3", params.DocMDPPerm)
+		}
+	}
+
+	digestAlgorithm, err := parseDigestAlgorithm(params.DigestAlgorithm)
+	if err != nil {
+		return signer.SignData{}, err
+	}
+
+	profile, err := parseProfile(params.Profile)
+	if err != nil {
+		return signer.SignData{}, err
+	}
+
+	tsa, err := tsaConfig(params.CertConfigKey)
+	if err != nil {
+		return signer.SignData{}, err
+	}
+	if params.TSA != nil && params.TSA.URL != "" {
+		tsa, err = requestTSA(params.TSA, tsa)
+		if err != nil {
+			return signer.SignData{}, err
+		}
+	}
+
+	signData := signer.SignData{
+		Signature: signer.SignDataSignature{
+			Info: signer.SignDataSignatureInfo{
+				Name:        params.Name,
+				Location:    params.Location,
+				Reason:      params.Reason,
+				ContactInfo: params.ContactInfo,
+				Date:        time.Now().Local(),
+			},
+			CertType:   certType,
+			DocMDPPerm: docMDPPerm,
+		},
+		DigestAlgorithm: digestAlgorithm,
+		TSA:             tsa,
+		Profile:         profile,
+		FieldName:       params.FieldName,
+	}
+
+	// LT and LTA signatures embed revocation data for the whole chain
+	if profile >= signer.ProfilePAdESBLT {
+		client, err := getRevocationClient()
+		if err != nil {
+			return signer.SignData{}, err
+		}
+		signData.RevocationFunction = client.EmbedRevocationStatus
+	}
+
+	if params.Appearance != nil {
+		appearance, err := buildAppearance(params.Appearance, params.CertConfigKey, params.FieldName)
+		if err != nil {
+			return signer.SignData{}, err
+		}
+		signData.Appearance = appearance
+	}
+
+	return signData, nil
+}
+
+// tsaConfig reads the timestamp authority stored under certConfigKey: tsa_url and tsa_fallback_urls,
+// tsa_username/tsa_password or tsa_bearer_token, tsa_client_cert_filepath/tsa_client_key_filepath for
+// mutual TLS, tsa_timeout per attempt and tsa_max_attempts per URL.
+func tsaConfig(certConfigKey string) (signer.TSA, error) {
+	tsa := signer.TSA{
+		URL:          viper.GetString(certConfigKey + ".tsa_url"),
+		FallbackURLs: viper.GetStringSlice(certConfigKey + ".tsa_fallback_urls"),
+		Username:     viper.GetString(certConfigKey + ".tsa_username"),
+		Password:     viper.GetString(certConfigKey + ".tsa_password"),
+		BearerToken:  viper.GetString(certConfigKey + ".tsa_bearer_token"),
+		Timeout:      viper.GetDuration(certConfigKey + ".tsa_timeout"),
+		MaxAttempts:  viper.GetInt(certConfigKey + ".tsa_max_attempts"),
+	}
+
+	if certFile := viper.GetString(certConfigKey + ".tsa_client_cert_filepath"); certFile != "" {
+		client, err := tsaClient(certFile, viper.GetString(certConfigKey+".tsa_client_key_filepath"))
+		if err != nil {
+			return signer.TSA{}, err
+		}
+		tsa.HTTPClient = client
+	}
+
+	return tsa, nil
+}
+
+// tsaClients holds the mutual TLS clients of the TSAs, keyed by the client certificate files and their modification
+// times, so connections are reused across requests and rotated files are reloaded
+var tsaClients sync.Map
+
+// tsaClient returns the client authenticating to a TSA with the certificate and key in certFile and keyFile
+func tsaClient(certFile, keyFile string) (*http.Client, error) {
+	var key strings.Builder
+	for _, file := range []string{certFile, keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TSA client certificate: %v", err)
+		}
+		fmt.Fprintf(&key, "%s@%d/%d;", file, info.ModTime().UnixNano(), info.Size())
+	}
+	if client, ok := tsaClients.Load(key.String()); ok {
+		return client.(*http.Client), nil
+	}
+
+	clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TSA client certificate: %v", err)
+	}
+	client, _ := tsaClients.LoadOrStore(key.String(), signer.TSA{ClientCertificate: &clientCert}.NewHTTPClient())
+	return client.(*http.Client), nil
+}
+
+// requestTSA returns the TSA chosen by a request. Every URL must be one of the configured TSA, tsa_url and
+// tsa_fallback_urls of the certificate config, or be listed in tsa.allowed_urls, so callers cannot make the
+// service post to hosts of their choice. The configured credentials and client certificate are not sent to a
+// TSA chosen by the caller, timeout and attempts are kept.
+func requestTSA(params *TSAParams, configured signer.TSA) (signer.TSA, error) {
+	allowed := map[string]bool{}
+	for _, tsaURL := range append(append([]string{configured.URL}, configured.FallbackURLs...), viper.GetStringSlice("tsa.allowed_urls")...) {
+		if tsaURL != "" {
+			allowed[normalizeTSAURL(tsaURL)] = true
+		}
+	}
+	for _, tsaURL := range append([]string{params.URL}, params.FallbackURLs...) {
+		if !allowed[normalizeTSAURL(tsaURL)] {
+			return signer.TSA{}, fmt.Errorf("TSA %q is not allowed, it must be configured for the certificate or listed in tsa.allowed_urls", tsaURL)
+		}
+	}
+
+	return signer.TSA{
+		URL:          params.URL,
+		FallbackURLs: params.FallbackURLs,
+		Username:     params.Username,
+		Password:     params.Password,
+		BearerToken:  params.BearerToken,
+		Timeout:      configured.Timeout,
+		MaxAttempts:  configured.MaxAttempts,
+	}, nil
+}
+
+func normalizeTSAURL(tsaURL string) string {
+	return strings.TrimSuffix(strings.TrimSpace(tsaURL), "/")
+}
+
+// certificateConfig reads the key provider settings stored under certConfigKey. provider selects
+// pem (default, cert_filepath/key_filepath/key_password), pkcs12 (p12_filepath/p12_password)
+// or remote (remote.url, remote.key_id, remote.auth_token, remote.cert_filepath, remote.timeout).
+// chain_filepaths, root_filepaths and expiry_warning configure the certificate chain for all providers.
+func certificateConfig(certConfigKey string) *certmanager.CertificateConfig {
+	certConfig := &certmanager.CertificateConfig{
+		ProviderType:   viper.GetString(certConfigKey + ".provider"),
+		CertFilePath:   viper.GetString(certConfigKey + ".cert_filepath"),
+		KeyFilePath:    viper.GetString(certConfigKey + ".key_filepath"),
+		KeyPassword:    viper.GetString(certConfigKey + ".key_password"),
+		PKCS12FilePath: viper.GetString(certConfigKey + ".p12_filepath"),
+		PKCS12Password: viper.GetString(certConfigKey + ".p12_password"),
+		ChainFilePaths: viper.GetStringSlice(certConfigKey + ".chain_filepaths"),
+		RootFilePaths:  viper.GetStringSlice(certConfigKey + ".root_filepaths"),
+		ExpiryWarning:  viper.GetDuration(certConfigKey + ".expiry_warning"),
+	}
+
+	if viper.IsSet(certConfigKey + ".remote") {
+		certConfig.Remote = &certmanager.RemoteSignerConfig{
+			URL:          viper.GetString(certConfigKey + ".remote.url"),
+			KeyID:        viper.GetString(certConfigKey + ".remote.key_id"),
+			AuthToken:    viper.GetString(certConfigKey + ".remote.auth_token"),
+			CertFilePath: viper.GetString(certConfigKey + ".remote.cert_filepath"),
+			Timeout:      viper.GetDuration(certConfigKey + ".remote.timeout"),
+		}
+	}
+
+	return certConfig
+}
+
+// loadSignData builds the sign data of params with the credentials of its cert_config_key, for callers
+// that have nothing to do in parallel to loading them
+func loadSignData(ctx context.Context, params *SignParams) (signer.SignData, *SigningCertificateInfo, error) {
+	signData, err := buildSignData(params)
+	if err != nil {
+		return signer.SignData{}, nil, fmt.Errorf("invalid sign params: %v", err)
+	}
+
+	credentials, err := getCredentialCache().Get(ctx, params.CertConfigKey, certificateConfig(params.CertConfigKey))
+	if err != nil {
+		return signer.SignData{}, nil, fmt.Errorf("failed to load signing credentials: %v", err)
+	}
+
+	signData.Certificate = credentials.Certificate
+	signData.CertificateChains = [][]*x509.Certificate{credentials.CertificateChain}
+	signData.Signer = credentials.PrivateKey
+
+	return signData, signingCertificateInfo(params.CertConfigKey, credentials), nil
+}
+
+// signingCertificateInfo describes the credentials a document was signed with for the response
+func signingCertificateInfo(certConfigKey string, credentials *certmanager.SigningCredentials) *SigningCertificateInfo {
+	cert := credentials.Certificate
+	return &SigningCertificateInfo{
+		CertConfigKey: certConfigKey,
+		SerialNumber:  fmt.Sprintf("%X", cert.SerialNumber),
+		Subject:       cert.Subject.String(),
+		NotAfter:      cert.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
+
+func buildAppearance(params *AppearanceParams, certConfigKey string, fieldName string) (signer.Appearance, error) {
+	appearance := signer.Appearance{
+		Visible:  true,
+		Page:     params.Page,
+		Anchor:   params.Anchor,
+		Width:    params.Width,
+		Height:   params.Height,
+		Margin:   params.Margin,
+		Text:     params.Text,
+		Font:     params.Font,
+		FontSize: params.FontSize,
+		Image:    params.ImageBytes,
+	}
+
+	// a pre-created signature field brings its own rectangle
+	if params.Anchor == "" && (fieldName == "" || len(params.Rect) > 0) {
+		if len(params.Rect) != 4 {
+			return signer.Appearance{}, fmt.Errorf("appearance requires either an anchor or a rect with 4 values")
+		}
+		appearance.LowerLeftX = params.Rect[0]
+		appearance.LowerLeftY = params.Rect[1]
+		appearance.UpperRightX = params.Rect[2]
+		appearance.UpperRightY = params.Rect[3]
+	}
+
+	if len(appearance.Image) == 0 {
+		if imagePath := viper.GetString(certConfigKey + ".stamp_image_filepath"); imagePath != "" {
+			image, err := os.ReadFile(imagePath)
+			if err != nil {
+				return signer.Appearance{}, fmt.Errorf("failed to read stamp image: %v", err)
+			}
+			appearance.Image = image
+		}
+	}
+
+	return appearance, nil
+}
+
+// buildSignatureFields maps the requested empty signature fields to the signer input
+func buildSignatureFields(params []SignatureFieldParams) ([]signer.SignatureField, error) {
+	fields := make([]signer.SignatureField, 0, len(params))
+	for _, param := range params {
+		field := signer.SignatureField{
+			Name: param.Name,
+			Page: param.Page,
+		}
+		switch len(param.Rect) {
+		case 0:
+		case 4:
+			field.Rect = [4]float64{param.Rect[0], param.Rect[1], param.Rect[2], param.Rect[3]}
+		default:
+			return nil, fmt.Errorf("signature field %q rect needs 4 values", param.Name)
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+func parseCertType(certType string) (signer.CertType, error) {
+	switch strings.ToLower(certType) {
+	case "", "certification":
+		return signer.CertificationSignature, nil
+	case "approval":
+		return signer.ApprovalSignature, nil
+	case "timestamp":
+		return signer.TimeStampSignature, nil
+	default:
+		return 0, fmt.Errorf("invalid cert_type %q, expected certification, approval or timestamp", certType)
+	}
+}
+
+func parseDigestAlgorithm(digestAlgorithm string) (crypto.Hash, error) {
+	switch strings.ToLower(strings.ReplaceAll(digestAlgorithm, "-", "")) {
+	case "", "sha256":
+		return crypto.SHA256, nil
+	case "sha384":
+		return crypto.SHA384, nil
+	case "sha512":
+		return crypto.SHA512, nil
+	case "sha1":
+		return crypto.SHA1, nil
+	default:
+		return 0, fmt.Errorf("invalid digest_algorithm %q, expected sha256, sha384, sha512 or sha1", digestAlgorithm)
+	}
+}
+
+func parseProfile(profile string) (signer.Profile, error) {
+	switch strings.ToLower(profile) {
+	case "", "pkcs7":
+		return signer.ProfilePKCS7, nil
+	case "pades-b-b":
+		return signer.ProfilePAdESBB, nil
+	case "pades-b-t":
+		return signer.ProfilePAdESBT, nil
+	case "pades-b-lt":
+		return signer.ProfilePAdESBLT, nil
+	case "pades-b-lta":
+		return signer.ProfilePAdESBLTA, nil
+	default:
+		return 0, fmt.Errorf("invalid profile %q, expected pkcs7, pades-b-b, pades-b-t, pades-b-lt or pades-b-lta", profile)
+	}
+}
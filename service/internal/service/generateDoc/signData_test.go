@@ -0,0 +1,93 @@
+package generateDoc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rchougule/espresso/lib/signer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTSA(t *testing.T) {
+	viper.Set("tsa.allowed_urls", []string{"https://freetsa.org/tsr"})
+	defer viper.Set("tsa.allowed_urls", nil)
+
+	configured := signer.TSA{
+		URL:          "https://tsa.example.com/tsr",
+		FallbackURLs: []string{"https://tsa2.example.com/tsr"},
+		BearerToken:  "configured",
+		MaxAttempts:  3,
+	}
+
+	tests := []struct {
+		name    string
+		params  *TSAParams
+		wantErr bool
+	}{
+		{name: "allowed", params: &TSAParams{URL: "https://freetsa.org/tsr/"}},
+		{name: "configured", params: &TSAParams{URL: "https://tsa2.example.com/tsr", FallbackURLs: []string{"https://tsa.example.com/tsr"}}},
+		{name: "internal", params: &TSAParams{URL: "http://169.254.169.254/latest/meta-data"}, wantErr: true},
+		{name: "internal_fallback", params: &TSAParams{URL: "https://freetsa.org/tsr", FallbackURLs: []string{"http://localhost:8081/jobs"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsa, err := requestTSA(tt.params, configured)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "not allowed")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.params.URL, tsa.URL)
+			assert.Empty(t, tsa.BearerToken, "configured credentials are not sent to a requested TSA")
+			assert.Equal(t, 3, tsa.MaxAttempts)
+		})
+	}
+
+	// Without a certificate config only the allow-list applies
+	_, err := timestampTSA(&TimestampPDFDto{TSA: &TSAParams{URL: "https://freetsa.org/tsr"}})
+	assert.NoError(t, err)
+	_, err = timestampTSA(&TimestampPDFDto{TSA: &TSAParams{URL: "http://127.0.0.1:3306"}})
+	assert.ErrorContains(t, err, "not allowed")
+}
+
+func TestTSAClient(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "TSA client"}, NotAfter: time.Now().Add(time.Hour)}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	client, err := tsaClient(certFile, keyFile)
+	require.NoError(t, err)
+	again, err := tsaClient(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Same(t, client, again, "the client is built once")
+
+	// A rotated certificate gets a new client
+	require.NoError(t, os.Chtimes(certFile, time.Now(), time.Now().Add(time.Minute)))
+	rotated, err := tsaClient(certFile, keyFile)
+	require.NoError(t, err)
+	assert.NotSame(t, client, rotated)
+
+	_, err = tsaClient(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package generateDoc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rchougule/espresso/lib/signer"
+	"github.com/rchougule/espresso/lib/templatestore"
+	"github.com/spf13/viper"
+)
+
+// TimestampPDF appends an RFC 3161 document timestamp to the input PDF and stores the result through
+// outputStoreAdapter. The TSA comes from the request or from the tsa_* settings under cert_config_key,
+// no signing certificate is needed. Existing signatures are kept, which is how archived documents get
+// their validity extended.
+func TimestampPDF(ctx context.Context, req *TimestampPDFDto, inputStoreAdapter *templatestore.StorageAdapter, outputStoreAdapter *templatestore.StorageAdapter) error {
+	fmt.Println("TimestampPDF called, req id :: ", req.ReqId)
+
+	tsa, err := timestampTSA(req)
+	if err != nil {
+		return err
+	}
+
+	digestAlgorithm, err := parseDigestAlgorithm(req.DigestAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	freader, err := (*inputStoreAdapter).GetDocument(ctx, &templatestore.GetDocumentRequest{
+		FilePath:       req.InputFilePath,
+		FileS3Path:     req.InputFilePath,
+		InputFileBytes: req.InputFileBytes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get input file: %v", err)
+	}
+	if closer, ok := freader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	timestampedPDF, err := signer.TimestampPdfStream(ctx, freader, tsa, digestAlgorithm)
+	if err != nil {
+		return fmt.Errorf("failed to timestamp pdf using TimestampPdfStream: %v", err)
+	}
+
+	var pdfReader io.Reader = bytes.NewReader(timestampedPDF)
+	docReq := &templatestore.PostDocumentRequest{
+		FilePath:   req.OutputFilePath,
+		FileS3Path: req.OutputFilePath,
+	}
+
+	resp, err := (*outputStoreAdapter).PutDocument(ctx, docReq, &pdfReader)
+	if err != nil {
+		return fmt.Errorf("failed to store PDF: %v", err)
+	}
+	if resp == "stream" {
+		req.OutputFileBytes = docReq.OutputFileBytes
+	}
+
+	return nil
+}
+
+// timestampTSA prefers a TSA given in the request if requestTSA allows it, the configured credentials are only
+// used with the configured TSA
+func timestampTSA(req *TimestampPDFDto) (signer.TSA, error) {
+	knownConfigKey := strings.HasPrefix(req.CertConfigKey, certConfigKeyPrefix) && viper.IsSet(req.CertConfigKey)
+	requested := req.TSA != nil && req.TSA.URL != ""
+	if !knownConfigKey && !requested {
+		return signer.TSA{}, fmt.Errorf("unknown cert_config_key %q", req.CertConfigKey)
+	}
+
+	var tsa signer.TSA
+	if knownConfigKey {
+		var err error
+		tsa, err = tsaConfig(req.CertConfigKey)
+		if err != nil {
+			return signer.TSA{}, err
+		}
+	}
+	if requested {
+		return requestTSA(req.TSA, tsa)
+	}
+	if tsa.URL == "" && len(tsa.FallbackURLs) == 0 {
+		return signer.TSA{}, fmt.Errorf("no TSA configured under %s, set tsa_url or pass a tsa", req.CertConfigKey)
+	}
+
+	return tsa, nil
+}
@@ -0,0 +1,64 @@
+package generateDoc
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rchougule/espresso/lib/signer"
+	"github.com/rchougule/espresso/lib/templatestore"
+	"github.com/spf13/viper"
+)
+
+// VerifyPDF reads the input PDF from the file store and verifies every signature in it.
+// Certificate chains are validated against the roots configured under pdf_verification.trusted_roots,
+// falling back to the system roots when none are configured.
+func VerifyPDF(ctx context.Context, req *VerifyPDFDto, fileStoreAdapter *templatestore.StorageAdapter) (*signer.VerifyReport, error) {
+	reqId := req.ReqId
+	fmt.Println("VerifyPDF called, req id :: ", reqId)
+
+	freader, err := (*fileStoreAdapter).GetDocument(ctx, &templatestore.GetDocumentRequest{
+		FilePath:       req.InputFilePath,
+		FileS3Path:     req.InputFilePath,
+		InputFileBytes: req.InputFileBytes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get input file: %v", err)
+	}
+	if closer, ok := freader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	roots, err := loadTrustedRoots(viper.GetStringSlice("pdf_verification.trusted_roots"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trusted roots: %v", err)
+	}
+
+	report, err := signer.VerifyPdfStream(ctx, freader, signer.VerifyOptions{Roots: roots})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify pdf using VerifyPdfStream: %v", err)
+	}
+
+	return report, nil
+}
+
+func loadTrustedRoots(paths []string) (*x509.CertPool, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	roots := x509.NewCertPool()
+	for _, path := range paths {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root certificate %s: %v", path, err)
+		}
+		if !roots.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in %s", path)
+		}
+	}
+
+	return roots, nil
+}
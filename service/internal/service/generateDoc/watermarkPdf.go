@@ -0,0 +1,87 @@
+package generateDoc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rchougule/espresso/lib/pdfops"
+	"github.com/rchougule/espresso/lib/templatestore"
+)
+
+// WatermarkPDF draws the requested watermarks over an existing PDF read from the input store, e.g. to mark
+// internal copies, and stores the result in the output store. Signatures of the input are lost.
+func WatermarkPDF(ctx context.Context, req *WatermarkPDFDto, inputStoreAdapter *templatestore.StorageAdapter, outputStoreAdapter *templatestore.StorageAdapter) error {
+	startTime := time.Now()
+	fmt.Println("WatermarkPDF called, req id :: ", req.ReqId)
+
+	watermarks, err := buildWatermarks(req.Watermarks)
+	if err != nil {
+		return fmt.Errorf("invalid watermarks: %v", err)
+	}
+
+	freader, err := (*inputStoreAdapter).GetDocument(ctx, &templatestore.GetDocumentRequest{
+		FilePath:       req.InputFilePath,
+		FileS3Path:     req.InputFilePath,
+		InputFileBytes: req.InputFileBytes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get input file: %v", err)
+	}
+	if closer, ok := freader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	watermarkedPDF, err := pdfops.AddWatermarks(ctx, freader, watermarks...)
+	if err != nil {
+		return err
+	}
+
+	docReq := &templatestore.PostDocumentRequest{
+		FilePath:   req.OutputFilePath,
+		FileS3Path: req.OutputFilePath,
+	}
+	var pdfReader io.Reader = bytes.NewReader(watermarkedPDF)
+	resp, err := (*outputStoreAdapter).PutDocument(ctx, docReq, &pdfReader)
+	if err != nil {
+		return fmt.Errorf("failed to store PDF: %v", err)
+	}
+	if resp == "stream" {
+		req.OutputFileBytes = docReq.OutputFileBytes
+	}
+
+	fmt.Println("watermarked pdf stored at :: ", time.Since(startTime))
+
+	return nil
+}
+
+// buildWatermarks maps the request params to pdfops watermarks
+func buildWatermarks(params []WatermarkParams) ([]pdfops.Watermark, error) {
+	if len(params) == 0 {
+		return nil, fmt.Errorf("at least one watermark is required")
+	}
+
+	watermarks := make([]pdfops.Watermark, len(params))
+	for i, param := range params {
+		if param.Text == "" && len(param.Image) == 0 {
+			return nil, fmt.Errorf("watermark %d needs a text or an image", i+1)
+		}
+		watermarks[i] = pdfops.Watermark{
+			Text:       param.Text,
+			Image:      param.Image,
+			FontSize:   param.FontSize,
+			Color:      param.Color,
+			Opacity:    param.Opacity,
+			Rotation:   param.Rotation,
+			Position:   strings.ToLower(param.Position),
+			Margin:     param.Margin,
+			ImageWidth: param.ImageWidth,
+			Pages:      param.Pages,
+		}
+	}
+
+	return watermarks, nil
+}
@@ -67,6 +67,7 @@ func enableCORS(handler http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, X-Signing-Cert-Config-Key, X-Signing-Cert-Serial")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
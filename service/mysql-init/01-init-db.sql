@@ -5,7 +5,25 @@ CREATE TABLE IF NOT EXISTS templates (
     template_content TEXT NOT NULL,
     json_schema TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    -- the version used when a request does not pin one, its content is copied to template_content
+    published_version INT NOT NULL DEFAULT 1,
+    -- counted up by every change, the ETag checked by /update-template and /delete-template
+    revision INT NOT NULL DEFAULT 1,
+    -- set by /delete-template, deleted templates are kept but no longer found
+    deleted_at TIMESTAMP NULL
+);
+
+-- Create template versions table, versions are never changed once saved.
+-- Templates inserted below get their content saved as version 1 when the service starts.
+CREATE TABLE IF NOT EXISTS template_versions (
+    template_id VARCHAR(255) NOT NULL,
+    version INT NOT NULL,
+    template_content TEXT NOT NULL,
+    json_schema TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMP NULL,
+    PRIMARY KEY (template_id, version)
 );
 
 -- Insert a basic sample template